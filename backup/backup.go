@@ -32,6 +32,7 @@ func DoInit(cmd *cobra.Command) {
 	SetCmdFlags(cmd.Flags())
 	_ = cmd.MarkFlagRequired(options.DBNAME)
 	utils.InitializeSignalHandler(DoCleanup, "backup process", &wasTerminated)
+	utils.InitializeSoftStopHandler("backup process", &wasSoftStopped)
 	objectCounts = make(map[string]int)
 }
 
@@ -47,8 +48,17 @@ func DoSetup() {
 	gplog.Info("gpbackup version = %s", GetVersion())
 
 	utils.CheckGpexpandRunning(utils.BackupPreventedByGpexpandMessage)
+	// The timestamp is taken once the lock is, as it may be waited for
+	createDatabaseLockFile(MustGetFlagString(options.DBNAME))
 	timestamp := history.CurrentTimestamp()
 	createBackupLockFile(timestamp)
+	var err error
+	sessionGUCProfile, err = utils.GetSessionGUCProfile(MustGetFlagString(options.SESSION_GUC_FILE), MustGetFlagStringArray(options.SESSION_GUC))
+	gplog.FatalOnError(err)
+	rowFilters, err = utils.ReadRowFilterFile(MustGetFlagString(options.ROW_FILTER_FILE))
+	gplog.FatalOnError(err)
+	maskingRules, err = utils.ReadMaskingRulesFile(MustGetFlagString(options.MASK_FILE))
+	gplog.FatalOnError(err)
 	initializeConnectionPool(timestamp)
 	gplog.Info("Greenplum Database Version = %s", connectionPool.Version.VersionString)
 
@@ -69,7 +79,15 @@ func DoSetup() {
 	segPrefix := filepath.GetSegPrefix(clusterConfigConn)
 	clusterConfigConn.Close()
 
+	// Segment data goes to --data-dir as it would to --backup-dir, so it is recorded as the backup directory
+	if dataDir := MustGetFlagString(options.DATA_DIR); dataDir != "" {
+		_ = cmdFlags.Set(options.BACKUP_DIR, dataDir)
+	}
 	globalFPInfo = filepath.NewFilePathInfo(globalCluster, MustGetFlagString(options.BACKUP_DIR), timestamp, segPrefix)
+	globalFPInfo.UserSpecifiedMetadataDir = MustGetFlagString(options.METADATA_DIR)
+	globalFPInfo.SetLayoutTemplate(MustGetFlagString(options.LAYOUT_TEMPLATE), connectionPool.DBName)
+	err = globalFPInfo.ValidateLayoutDatabaseName()
+	gplog.FatalOnError(err)
 	if MustGetFlagBool(options.METADATA_ONLY) {
 		_, err = globalCluster.ExecuteLocalCommand(fmt.Sprintf("mkdir -p %s", globalFPInfo.GetDirForContent(-1)))
 		gplog.FatalOnError(err)
@@ -92,6 +110,15 @@ func DoSetup() {
 		_ = cmdFlags.Set(options.PLUGIN_CONFIG, pluginConfig.ConfigPath)
 		gplog.Debug("Plugin config path: %s", pluginConfig.ConfigPath)
 	}
+	if metadataPluginConfigFlag := MustGetFlagString(options.METADATA_PLUGIN_CONFIG); metadataPluginConfigFlag != "" {
+		metadataPluginConfig, err = utils.ReadPluginConfig(metadataPluginConfigFlag)
+		gplog.FatalOnError(err)
+		// Named apart from the copy of the --plugin-config file, which may have the same name
+		configFilename := path.Base(metadataPluginConfig.ConfigPath)
+		configDirname := path.Dir(metadataPluginConfig.ConfigPath)
+		metadataPluginConfig.ConfigPath = path.Join(configDirname, timestamp+"_metadata_"+configFilename)
+		gplog.Debug("Metadata plugin config path: %s", metadataPluginConfig.ConfigPath)
+	}
 
 	initializeBackupReport(*opts)
 
@@ -100,6 +127,12 @@ func DoSetup() {
 		pluginConfig.CopyPluginConfigToAllHosts(globalCluster)
 		pluginConfig.SetupPluginForBackup(globalCluster, globalFPInfo)
 	}
+	if metadataPluginConfig != nil {
+		coordinatorCluster := utils.CoordinatorCluster(globalCluster)
+		metadataPluginConfig.CheckPluginExistsOnAllHosts(coordinatorCluster)
+		metadataPluginConfig.CopyPluginConfigToAllHosts(coordinatorCluster)
+		metadataPluginConfig.SetupPluginForBackup(coordinatorCluster, globalFPInfo)
+	}
 }
 
 func DoBackup() {
@@ -114,18 +147,26 @@ func DoBackup() {
 		targetBackupTimestamp = GetTargetBackupTimestamp()
 		targetBackupFPInfo = filepath.NewFilePathInfo(globalCluster, globalFPInfo.UserSpecifiedBackupDir,
 			targetBackupTimestamp, globalFPInfo.UserSpecifiedSegPrefix)
+		targetBackupFPInfo.SetLayoutTemplate(globalFPInfo.LayoutTemplate, globalFPInfo.DatabaseName)
+		targetBackupFPInfo.UserSpecifiedMetadataDir = globalFPInfo.UserSpecifiedMetadataDir
 
-		if pluginConfigFlag != "" {
+		if plugin := metadataPlugin(); plugin != nil {
 			// These files need to be downloaded from the remote system into the local filesystem
-			pluginConfig.MustRestoreFile(targetBackupFPInfo.GetConfigFilePath())
-			pluginConfig.MustRestoreFile(targetBackupFPInfo.GetTOCFilePath())
-			pluginConfig.MustRestoreFile(targetBackupFPInfo.GetPluginConfigPath())
+			plugin.MustRestoreFile(targetBackupFPInfo.GetConfigFilePath())
+			plugin.MustRestoreFile(targetBackupFPInfo.GetTOCFilePath())
+			if pluginConfigFlag != "" {
+				plugin.MustRestoreFile(targetBackupFPInfo.GetPluginConfigPath())
+			}
 		}
 	}
 
 	gplog.Info("Gathering table state information")
 	metadataTables, dataTables := RetrieveAndProcessTables()
 	dataTables, numExtOrForeignTables := GetBackupDataSet(dataTables)
+	validateRowFiltersAndMaskingRules(dataTables)
+	if len(rowFilters) > 0 || len(maskingRules) > 0 || MustGetFlagString(options.SAMPLE) != "" {
+		internalLeafPartitions = GetInternalLeafPartitions(connectionPool)
+	}
 	if len(dataTables) == 0 {
 		gplog.Warn("No tables in backup set contain data. Performing metadata-only backup instead.")
 		backupReport.MetadataOnly = true
@@ -171,6 +212,9 @@ func DoBackup() {
 		}
 
 		backupReport.RestorePlan = PopulateRestorePlan(backupSetTables, targetBackupRestorePlan, dataTables)
+		if MustGetFlagString(options.SAMPLE) != "" {
+			samplePredicates = planSample(backupSetTables)
+		}
 		backupData(backupSetTables)
 	}
 	printDataBackupWarnings(numExtOrForeignTables)
@@ -189,13 +233,17 @@ func DoBackup() {
 	}
 	metadataFile.Close()
 	if pluginConfigFlag != "" {
-		pluginConfig.MustBackupFile(metadataFilename)
-		pluginConfig.MustBackupFile(globalFPInfo.GetTOCFilePath())
+		_ = utils.CopyFile(pluginConfigFlag, globalFPInfo.GetPluginConfigPath())
+	}
+	if plugin := metadataPlugin(); plugin != nil {
+		plugin.MustBackupFile(metadataFilename)
+		plugin.MustBackupFile(globalFPInfo.GetTOCFilePath())
 		if MustGetFlagBool(options.WITH_STATS) {
-			pluginConfig.MustBackupFile(globalFPInfo.GetStatisticsFilePath())
+			plugin.MustBackupFile(globalFPInfo.GetStatisticsFilePath())
+		}
+		if pluginConfigFlag != "" {
+			plugin.MustBackupFile(globalFPInfo.GetPluginConfigPath())
 		}
-		_ = utils.CopyFile(pluginConfigFlag, globalFPInfo.GetPluginConfigPath())
-		pluginConfig.MustBackupFile(globalFPInfo.GetPluginConfigPath())
 	}
 }
 
@@ -272,6 +320,17 @@ func backupData(tables []Table) {
 		gplog.Info("Data backup complete")
 		return
 	}
+	tableSizes := GetTableSizes(connectionPool, tables)
+	// The helper writes a single data file in oid order, so tables must be
+	// copied in that order; a single data file backup also uses one worker.
+	if !MustGetFlagBool(options.SINGLE_DATA_FILE) {
+		tables = SortTablesBySize(tables, tableSizes, MustGetFlagStringArray(options.PRIORITIZE_TABLE))
+	}
+	maxBandwidth := getMaxBandwidth()
+	if maxBandwidth > 0 {
+		stopBandwidthControl := utils.StartBandwidthControl(globalCluster, globalFPInfo, []filepath.FilePathInfo{globalFPInfo}, maxBandwidth, 5*time.Second)
+		defer stopBandwidthControl()
+	}
 	if MustGetFlagBool(options.SINGLE_DATA_FILE) {
 		gplog.Verbose("Initializing pipes and gpbackup_helper on segments for single data file backup")
 		utils.VerifyHelperVersionOnSegments(version, globalCluster)
@@ -285,13 +344,34 @@ func backupData(tables []Table) {
 			compressStr = " --compression-level 0"
 		}
 		initialPipes := CreateInitialSegmentPipes(oidList, globalCluster, connectionPool, globalFPInfo)
+		utils.StartHelperControlServer(globalCluster)
+		tableNames := make(map[int]string, len(tables))
+		for _, table := range tables {
+			tableNames[int(table.Oid)] = table.FQN()
+		}
+		utils.SetHelperTableNames(tableNames)
+		if MustGetFlagBool(options.OFFLOAD_TO_MIRRORS) {
+			offloadHosts := utils.GetMirrorOffloadHosts(connectionPool, globalCluster)
+			gplog.Info("Offloading the compression and writing of data to the hosts of %d mirrors", len(offloadHosts))
+			utils.SetHelperOffloadHosts(offloadHosts)
+		}
 		// Do not pass through the --on-error-continue flag or the resizeClusterMap because neither apply to gpbackup
 		utils.StartGpbackupHelpers(globalCluster, globalFPInfo, "--backup-agent",
-			MustGetFlagString(options.PLUGIN_CONFIG), compressStr, false, false, &wasTerminated, initialPipes, true, false, 0, 0)
+			MustGetFlagString(options.PLUGIN_CONFIG), compressStr, false, false, &wasTerminated, initialPipes, true, false, 0, 0, maxBandwidth)
+		stallTimeout, _ := utils.ParseStallTimeout(MustGetFlagString(options.STALL_TIMEOUT))
+		var onStall func(utils.HelperStall)
+		// --retries cannot be used with --single-data-file, so a canceled table fails the backup
+		if MustGetFlagBool(options.CANCEL_STALLED) {
+			onStall = func(stall utils.HelperStall) { utils.CancelStalledCopy(connectionPool.DBName, stall) }
+		}
+		utils.StartHelperStallMonitor(stallTimeout, onStall)
 	}
 	gplog.Info("Writing data to file")
 	rowsCopiedMaps := backupDataForAllTables(tables)
-	AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+	if softStopRequested() {
+		tables = finishStoppedBackup(tables, rowsCopiedMaps)
+	}
+	AddTableDataEntriesToTOC(tables, rowsCopiedMaps, tableSizes)
 	if MustGetFlagBool(options.SINGLE_DATA_FILE) && MustGetFlagString(options.PLUGIN_CONFIG) != "" {
 		pluginConfig.BackupSegmentTOCs(globalCluster, globalFPInfo)
 	}
@@ -396,7 +476,14 @@ func DoTeardown() {
 		}
 
 		if backupReport != nil {
-			if !backupFailed {
+			if !backupFailed && globalTOC != nil && globalTOC.StoppedEarly {
+				backupReport.BackupConfig.Status = history.BackupStatusPartial
+				backupReport.SkippedTables = globalTOC.SkippedTables
+				gplog.Warn("Backup was stopped early, so the data of %d table(s) was not backed up. See %s for the list of tables.",
+					len(globalTOC.SkippedTables), globalFPInfo.GetTOCFilePath())
+				// Scripts must not mistake a backup that stopped early for a complete one
+				gplog.SetErrorCode(1)
+			} else if !backupFailed {
 				backupReport.BackupConfig.Status = history.BackupStatusSucceed
 			}
 			backupReport.ConstructBackupParamsString()
@@ -420,15 +507,23 @@ func DoTeardown() {
 				backupReport.BackupConfig.EndTime = history.CurrentTimestamp()
 			}
 			endtime, _ := time.ParseInLocation("20060102150405", backupReport.BackupConfig.EndTime, operating.System.Local)
+			if backupFailed && MustGetFlagBool(options.SINGLE_DATA_FILE) && globalCluster != nil {
+				// Collect errors of agents that failed after the last check, so they are in the report
+				_ = utils.CheckAgentErrorsOnSegments(globalCluster, globalFPInfo)
+			}
+			backupReport.HelperErrors = utils.GetAgentErrors()
+			tableRetriesMutex.Lock()
+			backupReport.TableRetries = tableRetries
+			tableRetriesMutex.Unlock()
 			backupReport.WriteBackupReportFile(reportFilename, globalFPInfo.Timestamp, endtime, objectCounts, errMsg)
 			report.EmailReport(globalCluster, globalFPInfo.Timestamp, reportFilename, "gpbackup", !backupFailed)
-			if pluginConfig != nil {
-				err = pluginConfig.BackupFile(configFilename)
+			if plugin := metadataPlugin(); plugin != nil {
+				err = plugin.BackupFile(configFilename)
 				if err != nil {
 					gplog.Error(fmt.Sprintf("%v", err))
 					return
 				}
-				err = pluginConfig.BackupFile(reportFilename)
+				err = plugin.BackupFile(reportFilename)
 				if err != nil {
 					gplog.Error(fmt.Sprintf("%v", err))
 					return
@@ -439,6 +534,11 @@ func DoTeardown() {
 			pluginConfig.CleanupPluginForBackup(globalCluster, globalFPInfo)
 			pluginConfig.DeletePluginConfigWhenEncrypting(globalCluster)
 		}
+		if metadataPluginConfig != nil {
+			coordinatorCluster := utils.CoordinatorCluster(globalCluster)
+			metadataPluginConfig.CleanupPluginForBackup(coordinatorCluster, globalFPInfo)
+			metadataPluginConfig.DeletePluginConfigWhenEncrypting(coordinatorCluster)
+		}
 	}
 }
 
@@ -459,14 +559,14 @@ func DoCleanup(backupFailed bool) {
 		cancelBlockedQueries(globalFPInfo.Timestamp)
 	}
 	if globalFPInfo.Timestamp != "" {
+		// Copy sessions must be terminated before cleaning up gpbackup_helper processes to avoid a potential deadlock
+		// If the terminate query is sent via a connection with an active COPY command, and the COPY's pipe is cleaned up, the COPY query will hang.
+		// This results in the DoCleanup function passed to the signal handler to never return, blocking the os.Exit call
+		if wasTerminated && connectionPool != nil {
+			// It is possible for the COPY command to become orphaned if an agent process or a plugin is stopped
+			utils.TerminateCopySessions(connectionPool)
+		}
 		if MustGetFlagBool(options.SINGLE_DATA_FILE) {
-			// Copy sessions must be terminated before cleaning up gpbackup_helper processes to avoid a potential deadlock
-			// If the terminate query is sent via a connection with an active COPY command, and the COPY's pipe is cleaned up, the COPY query will hang.
-			// This results in the DoCleanup function passed to the signal handler to never return, blocking the os.Exit call
-			if wasTerminated {
-				// It is possible for the COPY command to become orphaned if an agent process is stopped
-				utils.TerminateHangingCopySessions(connectionPool, globalFPInfo, fmt.Sprintf("gpbackup_%s", globalFPInfo.Timestamp))
-			}
 			if backupFailed {
 				// Cleanup only if terminated or fataled
 				utils.CleanUpSegmentHelperProcesses(globalCluster, globalFPInfo, "backup")
@@ -474,10 +574,15 @@ func DoCleanup(backupFailed bool) {
 			utils.CleanUpHelperFilesOnAllHosts(globalCluster, globalFPInfo)
 		}
 	}
+	utils.StopHelperControlServer()
 	err := backupLockFile.Unlock()
 	if err != nil && backupLockFile != "" {
 		gplog.Warn("Failed to remove lock file %s.", backupLockFile)
 	}
+	err = databaseLockFile.Unlock()
+	if err != nil && databaseLockFile != "" {
+		gplog.Warn("Failed to remove lock file %s.", databaseLockFile)
+	}
 }
 
 // Cancel blocked gpbackup queries waiting for locks.
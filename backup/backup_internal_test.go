@@ -1,7 +1,20 @@
 package backup
 
 import (
+	"regexp"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jackc/pgconn"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -22,4 +35,184 @@ var _ = Describe("backup internal tests", func() {
 			Expect(string(log.Contents())).To(ContainSubstring("Data backup complete"))
 		})
 	})
+	Describe("finishStoppedBackup", func() {
+		fooTable := Table{Relation: Relation{Oid: 1, Schema: "public", Name: "foo"}}
+		barTable := Table{Relation: Relation{Oid: 2, Schema: "public", Name: "bar"}}
+		BeforeEach(func() {
+			globalTOC = &toc.TOC{}
+			globalFPInfo = filepath.FilePathInfo{Timestamp: "20170101010101"}
+			backupReport = &report.Report{}
+			backupReport.RestorePlan = []history.RestorePlanEntry{{Timestamp: "20170101010101", TableFQNs: []string{"public.foo", "public.bar"}}}
+		})
+		It("marks the TOC stopped early and lists the tables whose data was not backed up", func() {
+			rowsCopiedMaps := []map[uint32]int64{{}, {1: 10}}
+
+			tables := finishStoppedBackup([]Table{fooTable, barTable}, rowsCopiedMaps)
+
+			Expect(tables).To(Equal([]Table{fooTable}))
+			Expect(globalTOC.StoppedEarly).To(BeTrue())
+			Expect(globalTOC.SkippedTables).To(Equal([]string{"public.bar"}))
+			Expect(backupReport.RestorePlan[0].TableFQNs).To(Equal([]string{"public.foo"}))
+			Expect(string(log.Contents())).To(ContainSubstring("Backup stopped early: backed up the data of 1 of 2 tables"))
+		})
+		It("does not mark the TOC stopped early if every table was backed up", func() {
+			rowsCopiedMaps := []map[uint32]int64{{2: 0}, {1: 10}}
+
+			tables := finishStoppedBackup([]Table{fooTable, barTable}, rowsCopiedMaps)
+
+			Expect(tables).To(Equal([]Table{fooTable, barTable}))
+			Expect(globalTOC.StoppedEarly).To(BeFalse())
+			Expect(backupReport.RestorePlan[0].TableFQNs).To(Equal([]string{"public.foo", "public.bar"}))
+		})
+	})
+	Describe("retrying the data backup of a table", func() {
+		var (
+			mock         sqlmock.Sqlmock
+			testExecutor *testhelper.TestExecutor
+			counters     BackupProgressCounters
+			rowsCopied   map[uint32]int64
+		)
+		table := Table{Relation: Relation{Oid: 16384, Schema: "public", Name: "foo"}}
+		copyQuery := regexp.QuoteMeta("COPY public.foo TO PROGRAM")
+		deadlock := &pgconn.PgError{Code: "40P01", Message: "deadlock detected"}
+		canceled := &pgconn.PgError{Code: "57014", Message: "canceling statement due to user request"}
+		BeforeEach(func() {
+			originalConnectionPool, originalExecutor, originalDelay := connectionPool, globalCluster.Executor, utils.RetryBaseDelay
+			DeferCleanup(func() {
+				connectionPool, globalCluster.Executor, utils.RetryBaseDelay = originalConnectionPool, originalExecutor, originalDelay
+				backupSnapshot = ""
+				tableRetries = nil
+			})
+			var conn *dbconn.DBConn
+			conn, mock = testhelper.CreateAndConnectMockDB(1)
+			connectionPool = conn
+			testExecutor = &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{}}
+			globalCluster.Executor = testExecutor
+			utils.RetryBaseDelay = time.Millisecond
+			_ = cmdFlags.Set(options.RETRIES, "2")
+			counters = BackupProgressCounters{TotalRegTables: 1, ProgressBar: utils.NewProgressBar(1, "Tables backed up: ", utils.PB_NONE)}
+			rowsCopied = make(map[uint32]int64)
+		})
+		Describe("backupTableDataWithRetries", func() {
+			It("retries a table after a transient error from a savepoint and deletes its partial files", func() {
+				mock.ExpectExec("SAVEPOINT gpbackup_table_retry").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(copyQuery).WillReturnError(deadlock)
+				mock.ExpectExec("ROLLBACK TO SAVEPOINT gpbackup_table_retry").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(copyQuery).WillReturnResult(sqlmock.NewResult(0, 10))
+				mock.ExpectExec("RELEASE SAVEPOINT gpbackup_table_retry").WillReturnResult(sqlmock.NewResult(0, 0))
+
+				Expect(backupTableDataWithRetries(table, rowsCopied, &counters, 0)).To(Succeed())
+
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+				Expect(rowsCopied[16384]).To(Equal(int64(10)))
+				Expect(tableRetries).To(Equal(map[string]int{"public.foo": 1}))
+				Expect(testExecutor.NumExecutions).To(Equal(1))
+				Expect(testExecutor.ClusterCommands[0][0].CommandString).To(ContainSubstring("rm -f"))
+			})
+			It("gives up after --retries attempts", func() {
+				mock.ExpectExec("SAVEPOINT gpbackup_table_retry").WillReturnResult(sqlmock.NewResult(0, 0))
+				for i := 0; i < 2; i++ {
+					mock.ExpectExec(copyQuery).WillReturnError(deadlock)
+					mock.ExpectExec("ROLLBACK TO SAVEPOINT gpbackup_table_retry").WillReturnResult(sqlmock.NewResult(0, 0))
+				}
+				mock.ExpectExec(copyQuery).WillReturnError(deadlock)
+
+				Expect(backupTableDataWithRetries(table, rowsCopied, &counters, 0)).To(MatchError(deadlock))
+
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+				Expect(tableRetries).To(Equal(map[string]int{"public.foo": 2}))
+			})
+			It("does not retry a table after an error that is not transient", func() {
+				missing := &pgconn.PgError{Code: "42P01", Message: "relation \"public.foo\" does not exist"}
+				mock.ExpectExec("SAVEPOINT gpbackup_table_retry").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(copyQuery).WillReturnError(missing)
+
+				Expect(backupTableDataWithRetries(table, rowsCopied, &counters, 0)).To(MatchError(missing))
+
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+				Expect(tableRetries).To(BeEmpty())
+			})
+			It("does not retry a table whose COPY was canceled", func() {
+				mock.ExpectExec("SAVEPOINT gpbackup_table_retry").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(copyQuery).WillReturnError(canceled)
+
+				Expect(backupTableDataWithRetries(table, rowsCopied, &counters, 0)).To(MatchError(canceled))
+
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			})
+			It("does not use a savepoint without --retries", func() {
+				_ = cmdFlags.Set(options.RETRIES, "0")
+				mock.ExpectExec(copyQuery).WillReturnError(deadlock)
+
+				Expect(backupTableDataWithRetries(table, rowsCopied, &counters, 0)).To(MatchError(deadlock))
+
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			})
+		})
+		Describe("prepareTableRetry", func() {
+			It("rolls back to the savepoint", func() {
+				mock.ExpectExec("ROLLBACK TO SAVEPOINT gpbackup_table_retry").WillReturnResult(sqlmock.NewResult(0, 0))
+
+				Expect(prepareTableRetry(table, 0, true)).To(Succeed())
+
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+				Expect(testExecutor.NumExecutions).To(Equal(1))
+			})
+			It("starts a new transaction with the synchronized snapshot and locks the table again", func() {
+				backupSnapshot = "00000003-00000002-1"
+				mock.ExpectBegin()
+				mock.ExpectExec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+				Expect(connectionPool.Begin(0)).To(Succeed())
+				mock.ExpectRollback()
+				mock.ExpectBegin()
+				mock.ExpectExec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta("SET TRANSACTION SNAPSHOT '00000003-00000002-1'")).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta("LOCK TABLE public.foo IN ACCESS SHARE MODE NOWAIT;")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+				Expect(prepareTableRetry(table, 0, false)).To(Succeed())
+
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+				Expect(testExecutor.NumExecutions).To(Equal(1))
+			})
+			It("returns the error of a lock it cannot take again without deleting any files", func() {
+				backupSnapshot = "00000003-00000002-1"
+				lockError := &pgconn.PgError{Code: "55P03", Message: "could not obtain lock on relation \"public.foo\""}
+				mock.ExpectBegin()
+				mock.ExpectExec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta("SET TRANSACTION SNAPSHOT '00000003-00000002-1'")).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("LOCK TABLE public.foo").WillReturnError(lockError)
+
+				Expect(prepareTableRetry(table, 0, false)).To(MatchError(lockError))
+
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+				Expect(testExecutor.NumExecutions).To(Equal(0))
+			})
+		})
+	})
+	Describe("metadataPlugin", func() {
+		dataPlugin := &utils.PluginConfig{ExecutablePath: "/tmp/data_plugin"}
+		metadataPluginOnly := &utils.PluginConfig{ExecutablePath: "/tmp/metadata_plugin"}
+		BeforeEach(func() {
+			DeferCleanup(func() {
+				pluginConfig, metadataPluginConfig = nil, nil
+				_ = cmdFlags.Set(options.METADATA_DIR, "")
+			})
+		})
+		It("sends the coordinator files to the plugin of the data", func() {
+			pluginConfig = dataPlugin
+			Expect(metadataPlugin()).To(Equal(dataPlugin))
+		})
+		It("keeps the coordinator files in the metadata directory", func() {
+			pluginConfig = dataPlugin
+			_ = cmdFlags.Set(options.METADATA_DIR, "/tmp/metadata")
+			Expect(metadataPlugin()).To(BeNil())
+		})
+		It("sends the coordinator files to the metadata plugin", func() {
+			pluginConfig, metadataPluginConfig = dataPlugin, metadataPluginOnly
+			Expect(metadataPlugin()).To(Equal(metadataPluginOnly))
+		})
+		It("writes the coordinator files only locally without a plugin", func() {
+			Expect(metadataPlugin()).To(BeNil())
+		})
+	})
 })
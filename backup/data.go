@@ -12,6 +12,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gpbackup/options"
@@ -27,6 +28,25 @@ var (
 func ConstructTableAttributesList(columnDefs []ColumnDefinition) string {
 	// this attribute list used ONLY by CopyTableIn on the restore side
 	// columns where data should not be copied out and back in are excluded from this string.
+	names := getDataColumnNames(columnDefs)
+	if len(names) > 0 {
+		return fmt.Sprintf("(%s)", strings.Join(names, ","))
+	}
+	return ""
+}
+
+// Recorded in the TOC so that the data can be exported with its types without reading the metadata
+func getDataColumnTypes(columnDefs []ColumnDefinition) []string {
+	types := make([]string, 0)
+	for _, col := range columnDefs {
+		if col.AttGenerated == "" {
+			types = append(types, col.Type)
+		}
+	}
+	return types
+}
+
+func getDataColumnNames(columnDefs []ColumnDefinition) []string {
 	names := make([]string, 0)
 	for _, col := range columnDefs {
 		// data in generated columns should not be backed up or restored.
@@ -34,13 +54,19 @@ func ConstructTableAttributesList(columnDefs []ColumnDefinition) string {
 			names = append(names, col.Name)
 		}
 	}
-	if len(names) > 0 {
-		return fmt.Sprintf("(%s)", strings.Join(names, ","))
-	}
-	return ""
+	return names
+}
+
+/*
+ * Returns the predicate that rows of the table must satisfy to be backed up,
+ * combining its --where-file predicate with its sample, or "" if all of its
+ * rows are backed up.
+ */
+func getRowFilter(table Table) string {
+	return joinConditions(rowFilters[utils.UnquotedFQN(table.Schema, table.Name)], samplePredicates[table.Oid])
 }
 
-func AddTableDataEntriesToTOC(tables []Table, rowsCopiedMaps []map[uint32]int64) {
+func AddTableDataEntriesToTOC(tables []Table, rowsCopiedMaps []map[uint32]int64, tableSizes map[uint32]int64) {
 	for _, table := range tables {
 		if !table.SkipDataBackup() {
 			var rowsCopied int64
@@ -51,11 +77,58 @@ func AddTableDataEntriesToTOC(tables []Table, rowsCopiedMaps []map[uint32]int64)
 				}
 			}
 			attributes := ConstructTableAttributesList(table.ColumnDefs)
-			globalTOC.AddCoordinatorDataEntry(table.Schema, table.Name, table.Oid, attributes, rowsCopied, table.PartitionLevelInfo.RootName, table.DistPolicy)
+			globalTOC.AddCoordinatorDataEntry(table.Schema, table.Name, table.Oid, attributes, rowsCopied, tableSizes[table.Oid], table.PartitionLevelInfo.RootName, table.DistPolicy)
+			globalTOC.DataEntries[len(globalTOC.DataEntries)-1].RowFilter = getRowFilter(table)
+			globalTOC.DataEntries[len(globalTOC.DataEntries)-1].ColumnTypes = getDataColumnTypes(table.ColumnDefs)
 		}
 	}
 }
 
+/*
+ * Order tables so that the largest are handed to workers first, as a large
+ * table picked up near the end of the backup would otherwise dominate the
+ * total runtime.  Tables passed with --prioritize-table come before all
+ * others, in the order in which they were given.  The sort is stable, so
+ * tables of equal size keep their catalog order.
+ */
+func SortTablesBySize(tables []Table, tableSizes map[uint32]int64, prioritizedTables []string) []Table {
+	sortedTables := make([]Table, len(tables))
+	copy(sortedTables, tables)
+	utils.SortByPriorityAndSize(sortedTables, prioritizedTables,
+		func(i int) string { return utils.UnquotedFQN(sortedTables[i].Schema, sortedTables[i].Name) },
+		func(i int) int64 { return tableSizes[sortedTables[i].Oid] })
+	return sortedTables
+}
+
+// Validated in validateFlagValues, so the error can be ignored here
+func getMaxBandwidth() int64 {
+	maxBandwidth, _ := utils.ParseBandwidth(MustGetFlagString(options.MAX_BANDWIDTH))
+	return maxBandwidth
+}
+
+/*
+ * IGNORE EXTERNAL PARTITIONS only applies to COPY of a table, not of a query,
+ * so a filtered or masked partition table with external leaves is read from
+ * a union of its other leaves instead.  The union is given the name of the
+ * table so that predicates referring to its columns by table name still work.
+ */
+func getFilteredCopySource(table Table) string {
+	leafPartitions, hasExternalLeaves := internalLeafPartitions[table.Oid]
+	if !hasExternalLeaves {
+		return table.FQN()
+	}
+	columnList := strings.Join(getDataColumnNames(table.ColumnDefs), ", ")
+	selects := make([]string, 0, len(leafPartitions))
+	for _, leafPartition := range leafPartitions {
+		selects = append(selects, fmt.Sprintf("SELECT %s FROM %s", columnList, leafPartition))
+	}
+	if len(selects) == 0 {
+		// The root itself holds no rows, so this reads none without scanning the external leaves
+		selects = append(selects, fmt.Sprintf("SELECT %s FROM ONLY %s", columnList, table.FQN()))
+	}
+	return fmt.Sprintf("(%s) AS %s", strings.Join(selects, " UNION ALL "), table.Name)
+}
+
 type BackupProgressCounters struct {
 	NumRegTables   int64
 	TotalRegTables int64
@@ -77,17 +150,50 @@ func CopyTableOut(connectionPool *dbconn.DBConn, table Table, destinationToWrite
 		customPipeThroughCommand = "cat -"
 	} else if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
 		sendToDestinationCommand = fmt.Sprintf("| %s backup_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+		// With --retries, a plugin failure worth retrying is marked in the error of the COPY
+		if MustGetFlagInt(options.RETRIES) > 0 {
+			sendToDestinationCommand = fmt.Sprintf("| %s", utils.WrapPluginCommandForRetry(fmt.Sprintf("%s backup_data %s %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath, destinationToWrite)))
+			destinationToWrite = ""
+		}
+	}
+	// The helper throttles single data file backups itself
+	if maxBandwidth := getMaxBandwidth(); maxBandwidth > 0 && !MustGetFlagBool(options.SINGLE_DATA_FILE) {
+		throttleCommand := utils.GetThrottleCommandForCopy(globalFPInfo, maxBandwidth, utils.GetBandwidthShare(globalCluster, connectionPool.NumConns))
+		customPipeThroughCommand = fmt.Sprintf("%s | %s", customPipeThroughCommand, throttleCommand)
 	}
 
 	copyCommand := fmt.Sprintf("PROGRAM '%s%s %s %s'", checkPipeExistsCommand, customPipeThroughCommand, sendToDestinationCommand, destinationToWrite)
 
-	columnNames := ""
-	if connectionPool.Version.AtLeast("7") {
-		// process column names to exclude generated columns from data copy out
-		columnNames = ConstructTableAttributesList(table.ColumnDefs)
+	query := ""
+	tableFQN := utils.UnquotedFQN(table.Schema, table.Name)
+	rowFilter := getRowFilter(table)
+	isFiltered := rowFilter != ""
+	columnRules, isMasked := maskingRules[tableFQN]
+	if isFiltered || isMasked {
+		selectList := "*"
+		if isMasked {
+			// The rules were validated against the table before the data backup started
+			maskedSelectList, _ := GetMaskedSelectList(table, columnRules)
+			selectList = strings.Join(maskedSelectList, ", ")
+		} else if connectionPool.Version.AtLeast("7") {
+			selectList = strings.Join(getDataColumnNames(table.ColumnDefs), ", ")
+		}
+		whereClause := ""
+		if isFiltered {
+			whereClause = fmt.Sprintf(" WHERE %s", rowFilter)
+		}
+		query = fmt.Sprintf("COPY (SELECT %s FROM %s%s) TO %s WITH CSV DELIMITER '%s' ON SEGMENT;", selectList, getFilteredCopySource(table), whereClause, copyCommand, tableDelim)
+	} else {
+		columnNames := ""
+		if connectionPool.Version.AtLeast("7") {
+			// process column names to exclude generated columns from data copy out
+			columnNames = ConstructTableAttributesList(table.ColumnDefs)
+		}
+		query = fmt.Sprintf("COPY %s%s TO %s WITH CSV DELIMITER '%s' ON SEGMENT IGNORE EXTERNAL PARTITIONS;", table.FQN(), columnNames, copyCommand, tableDelim)
 	}
-
-	query := fmt.Sprintf("COPY %s%s TO %s WITH CSV DELIMITER '%s' ON SEGMENT IGNORE EXTERNAL PARTITIONS;", table.FQN(), columnNames, copyCommand, tableDelim)
+	// The marker identifies the COPY in pg_stat_activity, so that it can be canceled precisely
+	query = fmt.Sprintf("%s %s", utils.StartCopySession(connNum, table.Oid), query)
+	defer utils.EndCopySession(connNum)
 	gplog.Verbose("Worker %d: %s", connNum, query)
 	result, err := connectionPool.Exec(query, connNum)
 	if err != nil {
@@ -123,6 +229,93 @@ func BackupSingleTableData(table Table, rowsCopiedMap map[uint32]int64, counters
 	return nil
 }
 
+const retrySavepoint = "gpbackup_table_retry"
+
+/*
+ * Backs up the data of the table, retrying it up to --retries times after
+ * transient errors.  A worker with a synchronized snapshot rolls back its
+ * transaction and sets the snapshot again before each retry.  Worker 0, whose
+ * transaction holds the locks of the backup, and workers without a
+ * synchronized snapshot cannot start a new transaction, so they roll back to
+ * a savepoint instead.
+ */
+func backupTableDataWithRetries(table Table, rowsCopiedMap map[uint32]int64, counters *BackupProgressCounters, whichConn int) error {
+	maxRetries := MustGetFlagInt(options.RETRIES)
+	useSavepoint := maxRetries > 0 && (backupSnapshot == "" || whichConn == 0)
+	if useSavepoint {
+		_, err := connectionPool.Exec(fmt.Sprintf("SAVEPOINT %s", retrySavepoint), whichConn)
+		if err != nil {
+			return err
+		}
+	}
+	err := BackupSingleTableData(table, rowsCopiedMap, counters, whichConn)
+	for retry := 1; err != nil && retry <= maxRetries && !wasTerminated && utils.IsTransientError(err); retry++ {
+		delay := utils.RetryDelay(retry)
+		gplog.Warn("Worker %d: Transient error backing up data for table %s, retrying in %s (retry %d of %d): %v", whichConn, table.FQN(), delay, retry, maxRetries, err)
+		time.Sleep(delay)
+		err = prepareTableRetry(table, whichConn, useSavepoint)
+		if err == nil {
+			recordTableRetry(table)
+			err = BackupSingleTableData(table, rowsCopiedMap, counters, whichConn)
+		}
+	}
+	if err == nil && useSavepoint {
+		_, err = connectionPool.Exec(fmt.Sprintf("RELEASE SAVEPOINT %s", retrySavepoint), whichConn)
+	}
+	return err
+}
+
+// Returns the worker to the state it was in before the failed attempt to back up the table
+func prepareTableRetry(table Table, whichConn int, useSavepoint bool) error {
+	if useSavepoint {
+		_, err := connectionPool.Exec(fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", retrySavepoint), whichConn)
+		if err != nil {
+			return err
+		}
+	} else {
+		if connectionPool.Tx[whichConn] != nil {
+			err := connectionPool.Rollback(whichConn)
+			if err != nil {
+				gplog.Warn("Worker %d: %s", whichConn, err)
+			}
+		}
+		err := SetSynchronizedSnapshot(connectionPool, whichConn, backupSnapshot)
+		if err != nil {
+			return err
+		}
+		err = LockTableNoWait(table, whichConn)
+		if err != nil {
+			return err
+		}
+	}
+	deletePartialTableFiles(table)
+	return nil
+}
+
+// Deletes the data files that a failed COPY of the table left on the segments
+func deletePartialTableFiles(table Table) {
+	// Plugins replace the file when it is backed up again
+	if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
+		return
+	}
+	extension := utils.GetPipeThroughProgram().Extension
+	remoteOutput := globalCluster.GenerateAndExecuteCommand(fmt.Sprintf("Deleting partial data files of table %s", table.FQN()), cluster.ON_SEGMENTS, func(contentID int) string {
+		return fmt.Sprintf("rm -f %s", globalFPInfo.GetTableBackupFilePath(contentID, table.Oid, extension, false))
+	})
+	globalCluster.CheckClusterError(remoteOutput, "Unable to delete partial data files", func(contentID int) string {
+		return fmt.Sprintf("Unable to delete partial data file of table %s on segment %d", table.FQN(), contentID)
+	}, true)
+}
+
+func recordTableRetry(table Table) {
+	tableRetriesMutex.Lock()
+	defer tableRetriesMutex.Unlock()
+	if tableRetries == nil {
+		tableRetries = make(map[string]int)
+	}
+	tableRetries[table.FQN()]++
+}
+
 /*
 * Iterate through tables, backup data from each table in set.
 * If supported by the database, a synchronized database snapshot is used to sync
@@ -142,9 +335,16 @@ func backupDataForAllTables(tables []Table) []map[uint32]int64 {
 	counters.ProgressBar = utils.NewProgressBar(int(counters.TotalRegTables), "Tables backed up: ", utils.PB_INFO)
 	counters.ProgressBar.Start()
 	rowsCopiedMaps := make([]map[uint32]int64, connectionPool.NumConns)
+	// Worker 0 only backs up deferred tables when there are other workers
+	numWorkers := connectionPool.NumConns - 1
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	timer := utils.NewParallelTimer(numWorkers)
+	dataStartTime := time.Now()
 	/*
 	 * We break when an interrupt is received and rely on
-	 * TerminateHangingCopySessions to halt any COPY statements
+	 * TerminateCopySessions to halt any COPY statements
 	 * in progress if they don't finish on their own.
 	 */
 	tasks := make(chan Table, len(tables))
@@ -181,6 +381,10 @@ func backupDataForAllTables(tables []Table) []map[uint32]int64 {
 					counters.ProgressBar.(*pb.ProgressBar).NotPrint = true
 					return
 				}
+				if softStopRequested() {
+					oidMap.Store(table.Oid, Skipped)
+					continue
+				}
 				if backupSnapshot != "" && connectionPool.Tx[whichConn] == nil {
 					err := SetSynchronizedSnapshot(connectionPool, whichConn, backupSnapshot)
 					if err != nil {
@@ -227,7 +431,9 @@ func backupDataForAllTables(tables []Table) []map[uint32]int64 {
 						break
 					}
 				}
-				err = BackupSingleTableData(table, rowsCopiedMaps[whichConn], &counters, whichConn)
+				tableStartTime := time.Now()
+				err = backupTableDataWithRetries(table, rowsCopiedMaps[whichConn], &counters, whichConn)
+				timer.AddBusyTime(time.Since(tableStartTime))
 				if err != nil {
 					copyErr = err
 					break
@@ -254,14 +460,19 @@ func backupDataForAllTables(tables []Table) []map[uint32]int64 {
 				state, _ := oidMap.Load(table.Oid)
 				if state.(int) == Unknown {
 					time.Sleep(time.Millisecond * 50)
+				} else if state.(int) == Deferred && softStopRequested() {
+					oidMap.Store(table.Oid, Skipped)
+					break
 				} else if state.(int) == Deferred {
-					err := BackupSingleTableData(table, rowsCopiedMaps[0], &counters, 0)
+					tableStartTime := time.Now()
+					err := backupTableDataWithRetries(table, rowsCopiedMaps[0], &counters, 0)
+					timer.AddBusyTime(time.Since(tableStartTime))
 					if err != nil {
 						copyErr = err
 					}
 					oidMap.Store(table.Oid, Complete)
 					break
-				} else if state.(int) == Complete {
+				} else if state.(int) == Complete || state.(int) == Skipped {
 					break
 				} else {
 					gplog.Fatal(errors.New("Encountered unknown table state"), "")
@@ -305,9 +516,82 @@ func backupDataForAllTables(tables []Table) []map[uint32]int64 {
 	}
 
 	counters.ProgressBar.Finish()
+	backupReport.DataParallelEfficiency = timer.EfficiencyString(time.Since(dataStartTime))
+	gplog.Info("Data backup parallel efficiency: %s", backupReport.DataParallelEfficiency)
 	return rowsCopiedMaps
 }
 
+/*
+ * Returns whether a stop was requested with SIGUSR2 and is honored.  The
+ * agents of a single data file backup wait for every table in turn, so the
+ * stop is only honored if they can all be told to stop early over the control
+ * channel.  The decision is made once, so that every worker agrees on it.
+ */
+func softStopRequested() bool {
+	if !wasSoftStopped {
+		return false
+	}
+	softStopOnce.Do(func() {
+		softStopHonored = !MustGetFlagBool(options.SINGLE_DATA_FILE) || utils.AllHelpersConnected(globalCluster, globalFPInfo)
+		if softStopHonored {
+			gplog.Info("Stopping the backup once the tables in progress are backed up")
+		} else {
+			gplog.Warn("Ignoring the stop signal, as not all gpbackup_helper agents can be told to stop early")
+		}
+	})
+	return softStopHonored
+}
+
+/*
+ * Marks the backup as stopped early once its workers stopped, listing the
+ * tables whose data was not backed up in the TOC, and returns the tables
+ * whose data was backed up.  Agents of a single data file backup are told to
+ * stop, as they would otherwise wait for the skipped tables.
+ */
+func finishStoppedBackup(tables []Table, rowsCopiedMaps []map[uint32]int64) []Table {
+	if MustGetFlagBool(options.SINGLE_DATA_FILE) {
+		err := utils.StopGpbackupHelpers(globalCluster, globalFPInfo)
+		gplog.FatalOnError(err)
+	}
+	completedTables := make([]Table, 0, len(tables))
+	completedFQNs := make(map[string]bool, len(tables))
+	skippedTables := make([]string, 0)
+	for _, table := range tables {
+		completed := false
+		for _, rowsCopiedMap := range rowsCopiedMaps {
+			if _, ok := rowsCopiedMap[table.Oid]; ok {
+				completed = true
+				break
+			}
+		}
+		if completed {
+			completedTables = append(completedTables, table)
+			completedFQNs[table.FQN()] = true
+		} else {
+			skippedTables = append(skippedTables, table.FQN())
+		}
+	}
+	if len(skippedTables) > 0 {
+		globalTOC.StoppedEarly = true
+		globalTOC.SkippedTables = skippedTables
+		// The restore plan must not point to data that this backup does not have
+		for i, entry := range backupReport.RestorePlan {
+			if entry.Timestamp != globalFPInfo.Timestamp {
+				continue
+			}
+			tableFQNs := make([]string, 0, len(completedFQNs))
+			for _, tableFQN := range entry.TableFQNs {
+				if completedFQNs[tableFQN] {
+					tableFQNs = append(tableFQNs, tableFQN)
+				}
+			}
+			backupReport.RestorePlan[i].TableFQNs = tableFQNs
+		}
+		gplog.Warn("Backup stopped early: backed up the data of %d of %d tables", len(completedTables), len(tables))
+	}
+	return completedTables
+}
+
 func printDataBackupWarnings(numExtTables int64) {
 	if numExtTables > 0 {
 		gplog.Info("Skipped data backup of %d external/foreign table(s).", numExtTables)
@@ -45,7 +45,7 @@ var _ = Describe("backup/data tests", func() {
 			tocfile = &toc.TOC{}
 			backup.SetTOC(tocfile)
 			rowsCopiedMaps = make([]map[uint32]int64, connectionPool.NumConns)
-			columnDefs := []backup.ColumnDefinition{{Oid: 1, Name: "a"}}
+			columnDefs := []backup.ColumnDefinition{{Oid: 1, Name: "a", Type: "integer"}}
 			table = backup.Table{
 				Relation:        backup.Relation{Oid: 1, Schema: "public", Name: "table"},
 				TableDefinition: backup.TableDefinition{ColumnDefs: columnDefs},
@@ -53,24 +53,56 @@ var _ = Describe("backup/data tests", func() {
 		})
 		It("adds an entry for a regular table to the TOC", func() {
 			tables := []backup.Table{table}
-			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
-			expectedDataEntries := []toc.CoordinatorDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)"}}
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps, map[uint32]int64{})
+			expectedDataEntries := []toc.CoordinatorDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)", ColumnTypes: []string{"integer"}}}
+			Expect(tocfile.DataEntries).To(Equal(expectedDataEntries))
+		})
+		It("records the relation size of a table in the TOC", func() {
+			tables := []backup.Table{table}
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps, map[uint32]int64{1: 8192})
+			expectedDataEntries := []toc.CoordinatorDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)", RelationSize: 8192, ColumnTypes: []string{"integer"}}}
 			Expect(tocfile.DataEntries).To(Equal(expectedDataEntries))
 		})
 		It("does not add an entry for an external table to the TOC", func() {
 			table.IsExternal = true
 			tables := []backup.Table{table}
-			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps, map[uint32]int64{})
 			Expect(tocfile.DataEntries).To(BeNil())
 		})
 		It("does not add an entry for a foreign table to the TOC", func() {
 			foreignDef := backup.ForeignTableDefinition{Oid: 23, Options: "", Server: "fs"}
 			table.ForeignDef = foreignDef
 			tables := []backup.Table{table}
-			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps, map[uint32]int64{})
 			Expect(tocfile.DataEntries).To(BeNil())
 		})
 	})
+	Describe("SortTablesBySize", func() {
+		tableA := backup.Table{Relation: backup.Relation{Oid: 1, Schema: "public", Name: "a"}}
+		tableB := backup.Table{Relation: backup.Relation{Oid: 2, Schema: "public", Name: "b"}}
+		tableC := backup.Table{Relation: backup.Relation{Oid: 3, Schema: "public", Name: `"C"`}}
+		tables := []backup.Table{tableA, tableB, tableC}
+
+		It("orders tables from largest to smallest", func() {
+			tableSizes := map[uint32]int64{1: 10, 2: 30, 3: 20}
+			sortedTables := backup.SortTablesBySize(tables, tableSizes, []string{})
+			Expect(sortedTables).To(Equal([]backup.Table{tableB, tableC, tableA}))
+		})
+		It("keeps catalog order for tables of equal or unknown size", func() {
+			sortedTables := backup.SortTablesBySize(tables, map[uint32]int64{}, []string{})
+			Expect(sortedTables).To(Equal(tables))
+		})
+		It("places prioritized tables first in the order given", func() {
+			tableSizes := map[uint32]int64{1: 10, 2: 30, 3: 20}
+			sortedTables := backup.SortTablesBySize(tables, tableSizes, []string{"public.C", "public.a"})
+			Expect(sortedTables).To(Equal([]backup.Table{tableC, tableA, tableB}))
+		})
+		It("does not modify the original slice", func() {
+			tableSizes := map[uint32]int64{1: 10, 2: 30, 3: 20}
+			_ = backup.SortTablesBySize(tables, tableSizes, []string{})
+			Expect(tables).To(Equal([]backup.Table{tableA, tableB, tableC}))
+		})
+	})
 	Describe("CopyTableOut", func() {
 		testTable := backup.Table{Relation: backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo"}}
 		It("will back up a table to its own file with gzip compression", func() {
@@ -129,6 +161,80 @@ var _ = Describe("backup/data tests", func() {
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
+		It("will back up only the rows of a table matching its row filter", func() {
+			backup.SetRowFilters(map[string]string{"public.foo": "id < 10 AND name <> 'x'"})
+			defer backup.SetRowFilters(nil)
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			execStr := regexp.QuoteMeta("COPY (SELECT * FROM public.foo WHERE id < 10 AND name <> 'x') TO PROGRAM 'cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+
+			_, err := backup.CopyTableOut(connectionPool, testTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will back up only the sampled rows of a filtered table", func() {
+			backup.SetRowFilters(map[string]string{"public.foo": "id < 10 OR id > 20"})
+			defer backup.SetRowFilters(nil)
+			backup.SetSamplePredicates(map[uint32]string{3456: "(hashtext(ROW(id)::text) & 2147483647) % 1000000 < 100000"})
+			defer backup.SetSamplePredicates(nil)
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			execStr := regexp.QuoteMeta("COPY (SELECT * FROM public.foo WHERE (id < 10 OR id > 20) AND ((hashtext(ROW(id)::text) & 2147483647) % 1000000 < 100000)) TO PROGRAM 'cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+
+			_, err := backup.CopyTableOut(connectionPool, testTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will back up masked values of a filtered table", func() {
+			backup.SetRowFilters(map[string]string{"public.foo": "id < 10"})
+			defer backup.SetRowFilters(nil)
+			backup.SetMaskingRules(map[string]map[string]utils.MaskingRule{"public.foo": {"name": {Kind: utils.MaskNull}}})
+			defer backup.SetMaskingRules(nil)
+			maskedTable := testTable
+			maskedTable.ColumnDefs = []backup.ColumnDefinition{{Name: "id", Type: "integer"}, {Name: "name", Type: "text"}}
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			execStr := regexp.QuoteMeta("COPY (SELECT id, NULL AS name FROM public.foo WHERE id < 10) TO PROGRAM 'cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+
+			_, err := backup.CopyTableOut(connectionPool, maskedTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will back up the filtered rows of a partition table from its leaves that are not external", func() {
+			backup.SetRowFilters(map[string]string{"public.foo": "foo.id < 10"})
+			defer backup.SetRowFilters(nil)
+			backup.SetInternalLeafPartitions(map[uint32][]string{3456: {"public.foo_1_prt_1", "public.foo_1_prt_2"}})
+			defer backup.SetInternalLeafPartitions(nil)
+			partitionTable := testTable
+			partitionTable.ColumnDefs = []backup.ColumnDefinition{{Name: "id", Type: "integer"}, {Name: "name", Type: "text"}}
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			execStr := regexp.QuoteMeta("COPY (SELECT * FROM (SELECT id, name FROM public.foo_1_prt_1 UNION ALL SELECT id, name FROM public.foo_1_prt_2) AS foo WHERE foo.id < 10) TO PROGRAM 'cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+
+			_, err := backup.CopyTableOut(connectionPool, partitionTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will back up no rows of a masked partition table whose leaves are all external", func() {
+			backup.SetMaskingRules(map[string]map[string]utils.MaskingRule{"public.foo": {"name": {Kind: utils.MaskNull}}})
+			defer backup.SetMaskingRules(nil)
+			backup.SetInternalLeafPartitions(map[uint32][]string{3456: {}})
+			defer backup.SetInternalLeafPartitions(nil)
+			partitionTable := testTable
+			partitionTable.ColumnDefs = []backup.ColumnDefinition{{Name: "id", Type: "integer"}, {Name: "name", Type: "text"}}
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			execStr := regexp.QuoteMeta("COPY (SELECT id, NULL AS name FROM (SELECT id, name FROM ONLY public.foo) AS foo) TO PROGRAM 'cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+
+			_, err := backup.CopyTableOut(connectionPool, partitionTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
 		It("will back up a table to its own file without compression using a plugin", func() {
 			_ = cmdFlags.Set(options.PLUGIN_CONFIG, "/tmp/plugin_config")
 			pluginConfig := utils.PluginConfig{ExecutablePath: "/tmp/fake-plugin.sh", ConfigPath: "/tmp/plugin_config"}
@@ -142,6 +248,21 @@ var _ = Describe("backup/data tests", func() {
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
+		It("marks plugin failures worth retrying with --retries", func() {
+			_ = cmdFlags.Set(options.PLUGIN_CONFIG, "/tmp/plugin_config")
+			_ = cmdFlags.Set(options.RETRIES, "2")
+			pluginConfig := utils.PluginConfig{ExecutablePath: "/tmp/fake-plugin.sh", ConfigPath: "/tmp/plugin_config"}
+			backup.SetPluginConfig(&pluginConfig)
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			pluginCommand := utils.WrapPluginCommandForRetry("/tmp/fake-plugin.sh backup_data /tmp/plugin_config <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456")
+			execStr := regexp.QuoteMeta(fmt.Sprintf("COPY public.foo TO PROGRAM 'cat - | %s ' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;", pluginCommand))
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			_, err := backup.CopyTableOut(connectionPool, testTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
 		It("will back up a table to a single file", func() {
 			_ = cmdFlags.Set(options.SINGLE_DATA_FILE, "true")
 			execStr := regexp.QuoteMeta(`COPY public.foo TO PROGRAM '(test -p "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456" || (echo "Pipe not found <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456">&2; exit 1)) && cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;`)
@@ -150,6 +271,17 @@ var _ = Describe("backup/data tests", func() {
 
 			_, err := backup.CopyTableOut(connectionPool, testTable, filename, defaultConnNum)
 
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("starts the COPY with a marker identifying the worker and the table", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_backend_pid()")).WillReturnRows(sqlmock.NewRows([]string{"pg_backend_pid"}).AddRow(4321))
+			Expect(utils.TrackCopyWorkers(connectionPool, "gpbackup_20170101010101")).To(Succeed())
+			execStr := "^" + regexp.QuoteMeta("/* gpbackup_20170101010101 worker 0 table 3456 */ COPY public.foo TO PROGRAM")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+
+			_, err := backup.CopyTableOut(connectionPool, testTable, filename, defaultConnNum)
+
 			Expect(err).ShouldNot(HaveOccurred())
 		})
 	})
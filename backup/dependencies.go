@@ -2,6 +2,7 @@ package backup
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
@@ -145,6 +146,10 @@ type UniqueID struct {
 	Oid     uint32
 }
 
+func tocObjectID(uniqueID UniqueID) toc.UniqueID {
+	return toc.UniqueID(uniqueID)
+}
+
 type SortableDependency struct {
 	ClassID    uint32
 	ObjID      uint32
@@ -273,6 +278,79 @@ func breakCircularDependencies(depMap DependencyMap) {
 	}
 }
 
+/*
+ * Column defaults are stored as pg_attrdef objects with an auto dependency on
+ * their table, so GetDependencies does not see that a table depends on the
+ * functions its defaults call.  The sort does not need these edges, as such
+ * functions already precede the table in the sorted order, but a parallel
+ * restore does, so they are queried separately and only recorded in the TOC.
+ */
+func GetColumnDefaultDependencies(connectionPool *dbconn.DBConn, backupSet map[UniqueID]bool) DependencyMap {
+	query := `SELECT
+	'pg_class'::regclass::oid AS classid,
+	ad.adrelid AS objid,
+	d.refclassid,
+	d.refobjid
+FROM pg_depend d
+JOIN pg_attrdef ad ON d.objid = ad.oid
+WHERE d.classid = 'pg_attrdef'::regclass::oid
+AND d.deptype = 'n'`
+
+	defaultDeps := make([]SortableDependency, 0)
+	err := connectionPool.Select(&defaultDeps, query)
+	gplog.FatalOnError(err)
+
+	dependencyMap := make(DependencyMap)
+	for _, dep := range defaultDeps {
+		object := UniqueID{ClassID: dep.ClassID, Oid: dep.ObjID}
+		referenceObject := UniqueID{ClassID: dep.RefClassID, Oid: dep.RefObjID}
+		if object == referenceObject || !backupSet[object] || !backupSet[referenceObject] {
+			continue
+		}
+		if _, ok := dependencyMap[object]; !ok {
+			dependencyMap[object] = make(map[UniqueID]bool)
+		}
+		dependencyMap[object][referenceObject] = true
+	}
+	return dependencyMap
+}
+
+/*
+ * Record the edges between sorted objects in the TOC so that gprestore can
+ * create independent objects in parallel.  Edges to objects that come later
+ * in the sorted order are dropped, since a restore in file order would never
+ * have honored them either.
+ */
+func AddDependenciesToTOC(tocfile *toc.TOC, sortedObjects []Sortable, dependencyMaps ...DependencyMap) {
+	sortedIndex := make(map[UniqueID]int, len(sortedObjects))
+	for i, object := range sortedObjects {
+		sortedIndex[object.GetUniqueID()] = i
+	}
+	for i, object := range sortedObjects {
+		uniqueID := object.GetUniqueID()
+		dependsOn := make([]toc.UniqueID, 0)
+		seen := make(map[UniqueID]bool)
+		for _, dependencyMap := range dependencyMaps {
+			for dep := range dependencyMap[uniqueID] {
+				if depIndex, ok := sortedIndex[dep]; ok && depIndex < i && !seen[dep] {
+					seen[dep] = true
+					dependsOn = append(dependsOn, toc.UniqueID(dep))
+				}
+			}
+		}
+		if len(dependsOn) == 0 {
+			continue
+		}
+		sort.Slice(dependsOn, func(i int, j int) bool {
+			if dependsOn[i].ClassID != dependsOn[j].ClassID {
+				return dependsOn[i].ClassID < dependsOn[j].ClassID
+			}
+			return dependsOn[i].Oid < dependsOn[j].Oid
+		})
+		tocfile.AddPredataDependency(toc.UniqueID(uniqueID), dependsOn)
+	}
+}
+
 func PrintDependentObjectStatements(metadataFile *utils.FileWithByteCount, toc *toc.TOC, objects []Sortable, metadataMap MetadataMap, domainConstraints []Constraint, funcInfoMap map[uint32]FunctionInfo) {
 	domainConMap := make(map[string][]Constraint)
 	for _, constraint := range domainConstraints {
@@ -280,6 +358,7 @@ func PrintDependentObjectStatements(metadataFile *utils.FileWithByteCount, toc *
 	}
 	for _, object := range objects {
 		objMetadata := metadataMap[object.GetUniqueID()]
+		firstEntryIndex := len(toc.PredataEntries)
 		switch obj := object.(type) {
 		case BaseType:
 			PrintCreateBaseTypeStatement(metadataFile, toc, obj, objMetadata)
@@ -324,6 +403,7 @@ func PrintDependentObjectStatements(metadataFile *utils.FileWithByteCount, toc *
 		case Transform:
 			PrintCreateTransformStatement(metadataFile, toc, obj, funcInfoMap, objMetadata)
 		}
+		toc.SetObjectIDForPredataEntries(firstEntryIndex, tocObjectID(object.GetUniqueID()))
 		// Remove ACLs from metadataMap for the current object since they have been processed
 		delete(metadataMap, object.GetUniqueID())
 	}
@@ -7,6 +7,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
 	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/toc"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -240,5 +241,43 @@ CREATE TRUSTED PROTOCOL ext_protocol (readfunc = public.read_from_s3, writefunc
 COMMENT ON PROTOCOL ext_protocol IS 'protocol';
 `, default_parallel))
 		})
+		It("tags the TOC entries printed for each object with its unique ID", func() {
+			constraints := make([]backup.Constraint, 0)
+			backup.PrintDependentObjectStatements(backupfile, tocfile, objects[:2], metadataMap, constraints, funcInfoMap)
+
+			// Each object has one entry for its CREATE statement and one for its comment
+			Expect(tocfile.PredataEntries).To(HaveLen(4))
+			Expect(tocfile.PredataEntries[0].ObjectID).To(Equal(toc.UniqueID{ClassID: backup.PG_PROC_OID, Oid: 1}))
+			Expect(tocfile.PredataEntries[1].ObjectID).To(Equal(toc.UniqueID{ClassID: backup.PG_PROC_OID, Oid: 1}))
+			Expect(tocfile.PredataEntries[2].ObjectID).To(Equal(toc.UniqueID{ClassID: backup.PG_TYPE_OID, Oid: 2}))
+			Expect(tocfile.PredataEntries[3].ObjectID).To(Equal(toc.UniqueID{ClassID: backup.PG_TYPE_OID, Oid: 2}))
+		})
+	})
+	Describe("AddDependenciesToTOC", func() {
+		It("records dependencies on objects earlier in the sorted order", func() {
+			depMap[backup.UniqueID{ClassID: backup.PG_CLASS_OID, Oid: 3}] = map[backup.UniqueID]bool{{ClassID: backup.PG_CLASS_OID, Oid: 2}: true, {ClassID: backup.PG_CLASS_OID, Oid: 1}: true}
+			relations := []backup.Sortable{relation1, relation2, relation3}
+
+			backup.AddDependenciesToTOC(tocfile, relations, depMap)
+
+			Expect(tocfile.PredataDependencies).To(Equal([]toc.DependencyEntry{
+				{Object: toc.UniqueID{ClassID: backup.PG_CLASS_OID, Oid: 3}, DependsOn: []toc.UniqueID{{ClassID: backup.PG_CLASS_OID, Oid: 1}, {ClassID: backup.PG_CLASS_OID, Oid: 2}}},
+			}))
+		})
+		It("merges multiple dependency maps and drops edges that point forward in the sorted order", func() {
+			depMap[backup.UniqueID{ClassID: backup.PG_CLASS_OID, Oid: 2}] = map[backup.UniqueID]bool{{ClassID: backup.PG_CLASS_OID, Oid: 1}: true}
+			defaultDepMap := backup.DependencyMap{
+				backup.UniqueID{ClassID: backup.PG_CLASS_OID, Oid: 1}: {{ClassID: backup.PG_CLASS_OID, Oid: 3}: true},
+				backup.UniqueID{ClassID: backup.PG_CLASS_OID, Oid: 3}: {{ClassID: backup.PG_CLASS_OID, Oid: 2}: true},
+			}
+			relations := []backup.Sortable{relation1, relation2, relation3}
+
+			backup.AddDependenciesToTOC(tocfile, relations, depMap, defaultDepMap)
+
+			Expect(tocfile.PredataDependencies).To(Equal([]toc.DependencyEntry{
+				{Object: toc.UniqueID{ClassID: backup.PG_CLASS_OID, Oid: 2}, DependsOn: []toc.UniqueID{{ClassID: backup.PG_CLASS_OID, Oid: 1}}},
+				{Object: toc.UniqueID{ClassID: backup.PG_CLASS_OID, Oid: 3}, DependsOn: []toc.UniqueID{{ClassID: backup.PG_CLASS_OID, Oid: 2}}},
+			}))
+		})
 	})
 })
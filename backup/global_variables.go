@@ -26,6 +26,7 @@ const (
 	Unknown int = iota
 	Deferred
 	Complete
+	Skipped
 	PG_LOCK_NOT_AVAILABLE = "55P03"
 )
 
@@ -40,12 +41,31 @@ var (
 	globalTOC            *toc.TOC
 	objectCounts         map[string]int
 	pluginConfig         *utils.PluginConfig
+	metadataPluginConfig *utils.PluginConfig
 	version              string
 	wasTerminated        bool
 	backupLockFile       lockfile.Lockfile
+	databaseLockFile     lockfile.Lockfile
 	filterRelationClause string
 	quotedRoleNames      map[string]string
 	backupSnapshot       string
+	sessionGUCProfile    utils.SessionGUCProfile
+	// Predicates from --where-file, keyed by unquoted table FQN
+	rowFilters map[string]string
+	// Rules from --mask-file, keyed by unquoted table FQN and then column name
+	maskingRules map[string]map[string]utils.MaskingRule
+	// Predicates that select the rows sampled with --sample, keyed by table oid
+	samplePredicates map[uint32]string
+	// Leaves that are not external, keyed by the oid of a partition root that also has external leaves
+	internalLeafPartitions map[uint32][]string
+	// Number of times the data backup of each table was retried, keyed by table FQN
+	tableRetries      map[string]int
+	tableRetriesMutex sync.Mutex
+	// Set by SIGUSR2 to stop dispatching tables and write a backup of the tables done so far
+	wasSoftStopped bool
+	// Whether the stop is honored, decided once when it is first seen
+	softStopOnce    sync.Once
+	softStopHonored bool
 	/*
 	 * Used for synchronizing DoCleanup.  In DoInit() we increment the group
 	 * and then wait for at least one DoCleanup to finish, either in DoTeardown
@@ -84,6 +104,10 @@ func SetPluginConfig(config *utils.PluginConfig) {
 	pluginConfig = config
 }
 
+func SetMetadataPluginConfig(config *utils.PluginConfig) {
+	metadataPluginConfig = config
+}
+
 func SetReport(report *report.Report) {
 	backupReport = report
 }
@@ -108,6 +132,22 @@ func SetQuotedRoleNames(quotedRoles map[string]string) {
 	quotedRoleNames = quotedRoles
 }
 
+func SetRowFilters(filters map[string]string) {
+	rowFilters = filters
+}
+
+func SetMaskingRules(rules map[string]map[string]utils.MaskingRule) {
+	maskingRules = rules
+}
+
+func SetSamplePredicates(predicates map[uint32]string) {
+	samplePredicates = predicates
+}
+
+func SetInternalLeafPartitions(leafPartitions map[uint32][]string) {
+	internalLeafPartitions = leafPartitions
+}
+
 // Util functions to enable ease of access to global flag values
 
 func FlagChanged(flagName string) bool {
@@ -107,7 +107,7 @@ func GetLatestMatchingBackupConfig(historyDBPath string, currentBackupConfig *hi
 			gplog.Error(err.Error())
 			return nil
 		}
-		if !backupConfig.Failed() && matchesIncrementalFlags(backupConfig, currentBackupConfig) {
+		if !backupConfig.Failed() && !backupConfig.StoppedEarly() && !backupConfig.Filtered && matchesIncrementalFlags(backupConfig, currentBackupConfig) {
 			return backupConfig
 		}
 	}
@@ -123,6 +123,8 @@ func matchesIncrementalFlags(backupConfig *history.BackupConfig, currentBackupCo
 		pluginBinaryName == currentBackupConfig.Plugin &&
 		backupConfig.SingleDataFile == MustGetFlagBool(options.SINGLE_DATA_FILE) &&
 		backupConfig.Compressed == currentBackupConfig.Compressed &&
+		backupConfig.LayoutTemplate == currentBackupConfig.LayoutTemplate &&
+		backupConfig.MetadataDir == currentBackupConfig.MetadataDir &&
 		// Expanding of the include list happens before this now so we must compare again current backup config
 		utils.NewIncludeSet(backupConfig.IncludeRelations).Equals(utils.NewIncludeSet(currentBackupConfig.IncludeRelations)) &&
 		utils.NewIncludeSet(backupConfig.IncludeSchemas).Equals(utils.NewIncludeSet(MustGetFlagStringArray(options.INCLUDE_SCHEMA))) &&
@@ -0,0 +1,149 @@
+package backup
+
+/*
+ * This file contains functions for masking column values as table data is
+ * copied out, by replacing masked columns in the COPY select list.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	maskTextTypeRE = regexp.MustCompile(`^(text|character varying|character|citext)(?:\((\d+)\))?$`)
+	// Hashes of integer columns are reduced modulo these to fit the column
+	maskIntegerTypeModuli = map[string]string{
+		"smallint": "32767",
+		"integer":  "2147483647",
+		"bigint":   "9223372036854775807",
+	}
+)
+
+/*
+ * A non-negative integer derived from the md5 of the value, from 15 hex
+ * digits so that it always fits in a bigint.  The same value always hashes to
+ * the same integer, so masked keys still join to masked foreign keys.
+ */
+func maskHashInteger(valueText string) string {
+	return fmt.Sprintf("('x' || substr(md5(%s), 1, 15))::bit(60)::bigint", valueText)
+}
+
+/*
+ * Returns the SQL expression that replaces a masked column in the COPY
+ * select list.  Except for null rules, NULL values stay NULL, and every
+ * result is cast back to the column's type so that the data restores into
+ * the original table definition.
+ */
+func GetMaskedColumnExpression(column ColumnDefinition, rule utils.MaskingRule) (string, error) {
+	valueText := fmt.Sprintf("%s::text", column.Name)
+	isText := maskTextTypeRE.MatchString(column.Type)
+	modulus, isInteger := maskIntegerTypeModuli[column.Type]
+	expression := ""
+	switch rule.Kind {
+	case utils.MaskNull:
+		if column.NotNull {
+			return "", errors.Errorf("column %s is NOT NULL and cannot be masked with null", column.Name)
+		}
+		return "NULL", nil
+	case utils.MaskFixed:
+		return fmt.Sprintf("CASE WHEN %s IS NULL THEN NULL ELSE CAST('%s' AS %s) END", column.Name, utils.EscapeSingleQuotes(rule.Argument), column.Type), nil
+	case utils.MaskHash:
+		if isInteger {
+			expression = fmt.Sprintf("%s %% %s", maskHashInteger(valueText), modulus)
+		} else if isText {
+			expression = fmt.Sprintf("md5(%s)", valueText)
+		} else {
+			return "", errors.Errorf("column %s of type %s cannot be hashed; only text and integer columns can", column.Name, column.Type)
+		}
+	case utils.MaskFaker:
+		if !isText {
+			return "", errors.Errorf("column %s of type %s cannot be masked with faker:%s; only text columns can", column.Name, column.Type, rule.Argument)
+		}
+		switch rule.Argument {
+		case "email":
+			expression = fmt.Sprintf("'user_' || substr(md5(%s), 1, 12) || '@example.com'", valueText)
+		case "name":
+			expression = fmt.Sprintf("initcap(translate(substr(md5(%s), 1, 10), '0123456789', 'ghijklmnop'))", valueText)
+		case "phone":
+			expression = fmt.Sprintf("'555-' || lpad((%s %% 10000000)::text, 7, '0')", maskHashInteger(valueText))
+		}
+	}
+	return fmt.Sprintf("CAST(%s AS %s)", expression, column.Type), nil
+}
+
+// The length of an md5 hash in hex, which a text column must hold for its hashes not to be truncated
+const maskHashLength = 32
+
+/*
+ * Integer hashes are reduced to fit the column, text hashes are truncated to
+ * the column's length, and fixed and faker values repeat, so masking a column
+ * of a unique key could give several rows the same value and keep the data
+ * from restoring.  Only NULL, which never conflicts, and a full md5 hash of a
+ * text column keep the values of such a column distinct.
+ */
+func ValidateMaskedUniqueKeyColumns(table Table, columnRules map[string]utils.MaskingRule, uniqueKeyColumns []string) error {
+	columnTypes := make(map[string]string, len(table.ColumnDefs))
+	for _, column := range table.ColumnDefs {
+		columnTypes[column.Name] = column.Type
+	}
+	for _, columnName := range uniqueKeyColumns {
+		rule, isMasked := columnRules[utils.UnquoteIdent(columnName)]
+		if !isMasked || rule.Kind == utils.MaskNull {
+			continue
+		}
+		if rule.Kind == utils.MaskHash {
+			match := maskTextTypeRE.FindStringSubmatch(columnTypes[columnName])
+			if match != nil {
+				length, _ := strconv.Atoi(match[2])
+				if match[2] == "" || length >= maskHashLength {
+					continue
+				}
+			}
+		}
+		return errors.Errorf("Cannot mask table %s: column %s is part of a unique key and masking it with %s could give several rows the same value; only null, or hash on a text column holding at least %d characters, can be used", table.FQN(), columnName, rule.Kind, maskHashLength)
+	}
+	return nil
+}
+
+/*
+ * Returns the select list that copies out the data columns of a table, in
+ * the order of its TOC attribute list, with masked columns replaced.
+ */
+func GetMaskedSelectList(table Table, columnRules map[string]utils.MaskingRule) ([]string, error) {
+	dataColumns := make(map[string]bool)
+	selectList := make([]string, 0)
+	for _, column := range table.ColumnDefs {
+		if column.AttGenerated != "" {
+			continue
+		}
+		unquotedName := utils.UnquoteIdent(column.Name)
+		dataColumns[unquotedName] = true
+		rule, isMasked := columnRules[unquotedName]
+		if !isMasked {
+			selectList = append(selectList, column.Name)
+			continue
+		}
+		expression, err := GetMaskedColumnExpression(column, rule)
+		if err != nil {
+			return nil, errors.Errorf("Cannot mask table %s: %v", table.FQN(), err)
+		}
+		selectList = append(selectList, fmt.Sprintf("%s AS %s", expression, column.Name))
+	}
+	maskedColumns := make([]string, 0, len(columnRules))
+	for columnName := range columnRules {
+		maskedColumns = append(maskedColumns, columnName)
+	}
+	sort.Strings(maskedColumns)
+	for _, columnName := range maskedColumns {
+		if !dataColumns[columnName] {
+			return nil, errors.Errorf("Cannot mask table %s: it has no column %s whose data is backed up", table.FQN(), columnName)
+		}
+	}
+	return selectList, nil
+}
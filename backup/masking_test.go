@@ -0,0 +1,86 @@
+package backup_test
+
+import (
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backup/masking tests", func() {
+	Describe("GetMaskedColumnExpression", func() {
+		DescribeTable("masks columns of supported types",
+			func(column backup.ColumnDefinition, rule utils.MaskingRule, expected string) {
+				Expect(backup.GetMaskedColumnExpression(column, rule)).To(Equal(expected))
+			},
+			Entry("hashes a text column", backup.ColumnDefinition{Name: "ssn", Type: "character varying(11)"}, utils.MaskingRule{Kind: utils.MaskHash},
+				"CAST(md5(ssn::text) AS character varying(11))"),
+			Entry("hashes an integer column", backup.ColumnDefinition{Name: "id", Type: "integer"}, utils.MaskingRule{Kind: utils.MaskHash},
+				"CAST(('x' || substr(md5(id::text), 1, 15))::bit(60)::bigint % 2147483647 AS integer)"),
+			Entry("nulls a nullable column", backup.ColumnDefinition{Name: "notes", Type: "text"}, utils.MaskingRule{Kind: utils.MaskNull},
+				"NULL"),
+			Entry("replaces a column with a fixed value", backup.ColumnDefinition{Name: "notes", Type: "text"}, utils.MaskingRule{Kind: utils.MaskFixed, Argument: "it's hidden"},
+				"CASE WHEN notes IS NULL THEN NULL ELSE CAST('it''s hidden' AS text) END"),
+			Entry("generates an email", backup.ColumnDefinition{Name: "email", Type: "text"}, utils.MaskingRule{Kind: utils.MaskFaker, Argument: "email"},
+				"CAST('user_' || substr(md5(email::text), 1, 12) || '@example.com' AS text)"),
+		)
+		It("refuses to null a NOT NULL column", func() {
+			_, err := backup.GetMaskedColumnExpression(backup.ColumnDefinition{Name: "id", Type: "integer", NotNull: true}, utils.MaskingRule{Kind: utils.MaskNull})
+			Expect(err).To(MatchError("column id is NOT NULL and cannot be masked with null"))
+		})
+		It("refuses to hash a column of an unsupported type", func() {
+			_, err := backup.GetMaskedColumnExpression(backup.ColumnDefinition{Name: "born", Type: "date"}, utils.MaskingRule{Kind: utils.MaskHash})
+			Expect(err).To(MatchError("column born of type date cannot be hashed; only text and integer columns can"))
+		})
+	})
+	Describe("GetMaskedSelectList", func() {
+		table := backup.Table{
+			Relation: backup.Relation{Schema: "public", Name: "users"},
+			TableDefinition: backup.TableDefinition{ColumnDefs: []backup.ColumnDefinition{
+				{Name: "id", Type: "integer"},
+				{Name: `"Email"`, Type: "text"},
+				{Name: "total", Type: "integer", AttGenerated: "STORED"},
+			}},
+		}
+		It("replaces masked columns and skips generated columns", func() {
+			selectList, err := backup.GetMaskedSelectList(table, map[string]utils.MaskingRule{"Email": {Kind: utils.MaskNull}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(selectList).To(Equal([]string{"id", `NULL AS "Email"`}))
+		})
+		It("returns an error for a column that is not backed up", func() {
+			_, err := backup.GetMaskedSelectList(table, map[string]utils.MaskingRule{"total": {Kind: utils.MaskNull}})
+			Expect(err).To(MatchError("Cannot mask table public.users: it has no column total whose data is backed up"))
+		})
+	})
+	Describe("ValidateMaskedUniqueKeyColumns", func() {
+		table := backup.Table{
+			Relation: backup.Relation{Schema: "public", Name: "customers"},
+			TableDefinition: backup.TableDefinition{ColumnDefs: []backup.ColumnDefinition{
+				{Name: "id", Type: "integer"},
+				{Name: "email", Type: "text"},
+				{Name: "code", Type: "character varying(8)"},
+				{Name: "notes", Type: "text"},
+			}},
+		}
+		DescribeTable("allows rules that keep the values of a unique key distinct",
+			func(columnRules map[string]utils.MaskingRule) {
+				Expect(backup.ValidateMaskedUniqueKeyColumns(table, columnRules, []string{"id", "email"})).To(Succeed())
+			},
+			Entry("hash of a text column", map[string]utils.MaskingRule{"email": {Kind: utils.MaskHash}}),
+			Entry("null", map[string]utils.MaskingRule{"email": {Kind: utils.MaskNull}}),
+			Entry("any rule on a column outside of the key", map[string]utils.MaskingRule{"notes": {Kind: utils.MaskFixed, Argument: "n/a"}}),
+		)
+		DescribeTable("rejects rules that could give rows of a unique key the same value",
+			func(uniqueKeyColumn string, columnRules map[string]utils.MaskingRule) {
+				err := backup.ValidateMaskedUniqueKeyColumns(table, columnRules, []string{uniqueKeyColumn})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("column %s is part of a unique key", uniqueKeyColumn))
+			},
+			Entry("hash of an integer column", "id", map[string]utils.MaskingRule{"id": {Kind: utils.MaskHash}}),
+			Entry("hash of a text column too short for the hash", "code", map[string]utils.MaskingRule{"code": {Kind: utils.MaskHash}}),
+			Entry("fixed", "email", map[string]utils.MaskingRule{"email": {Kind: utils.MaskFixed, Argument: "x"}}),
+			Entry("faker", "email", map[string]utils.MaskingRule{"email": {Kind: utils.MaskFaker, Argument: "email"}}),
+		)
+	})
+})
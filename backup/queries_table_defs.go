@@ -204,6 +204,100 @@ func GetPartitionTableMap(connectionPool *dbconn.DBConn) map[uint32]PartitionLev
 	return resultMap
 }
 
+/*
+ * Returns the leaf partitions that are not external tables, as FQNs, for each
+ * partition root that has at least one external leaf partition.  A root whose
+ * leaves are all external maps to an empty list.
+ */
+func GetInternalLeafPartitions(connectionPool *dbconn.DBConn) map[uint32][]string {
+	before7Query := `
+	SELECT p.parrelid AS rootoid,
+		quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS name,
+		e.reloid IS NOT NULL AS isexternal
+	FROM pg_partition p
+		JOIN pg_partition_rule r ON p.oid = r.paroid
+		JOIN pg_class c ON c.oid = r.parchildrelid
+		JOIN pg_namespace n ON c.relnamespace = n.oid
+		JOIN (SELECT parrelid AS relid, max(parlevel) AS pl
+			FROM pg_partition GROUP BY parrelid) AS levels ON p.parrelid = levels.relid AND p.parlevel = levels.pl
+		LEFT JOIN pg_exttable e ON e.reloid = c.oid
+	WHERE p.paristemplate = false
+		AND r.parchildrelid != 0
+	ORDER BY c.oid`
+
+	atLeast7Query := `
+	SELECT pg_partition_root(c.oid) AS rootoid,
+		quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS name,
+		c.relkind = 'f' AS isexternal
+	FROM pg_class c
+		JOIN pg_namespace n ON c.relnamespace = n.oid
+	WHERE c.relispartition = true
+		AND c.relkind IN ('r', 'f')
+	ORDER BY c.oid`
+
+	query := ""
+	if connectionPool.Version.Before("7") {
+		query = before7Query
+	} else {
+		query = atLeast7Query
+	}
+
+	results := make([]struct {
+		RootOid    uint32
+		Name       string
+		IsExternal bool
+	}, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+
+	leafPartitions := make(map[uint32][]string)
+	hasExternalLeaves := make(map[uint32]bool)
+	for _, result := range results {
+		if result.IsExternal {
+			hasExternalLeaves[result.RootOid] = true
+		} else {
+			leafPartitions[result.RootOid] = append(leafPartitions[result.RootOid], result.Name)
+		}
+	}
+	internalLeafPartitions := make(map[uint32][]string, len(hasExternalLeaves))
+	for rootOid := range hasExternalLeaves {
+		internalLeafPartitions[rootOid] = leafPartitions[rootOid]
+		if internalLeafPartitions[rootOid] == nil {
+			internalLeafPartitions[rootOid] = []string{}
+		}
+	}
+	return internalLeafPartitions
+}
+
+/*
+ * Returns the columns of each table that are part of a primary key, a unique
+ * constraint or a unique index, keyed by table oid.
+ */
+func GetUniqueKeyColumns(connectionPool *dbconn.DBConn) map[uint32][]string {
+	query := `
+	SELECT DISTINCT i.indrelid AS oid,
+		a.attnum,
+		quote_ident(a.attname) AS name
+	FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+	WHERE i.indisunique
+	ORDER BY i.indrelid, a.attnum`
+
+	results := make([]struct {
+		Oid    uint32
+		AttNum int
+		Name   string
+	}, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+
+	uniqueKeyColumns := make(map[uint32][]string)
+	for _, result := range results {
+		uniqueKeyColumns[result.Oid] = append(uniqueKeyColumns[result.Oid], result.Name)
+	}
+	return uniqueKeyColumns
+}
+
 type ColumnDefinition struct {
 	Oid                   uint32 `db:"attrelid"`
 	Num                   int    `db:"attnum"`
@@ -738,6 +832,62 @@ func GetForceRowSecurity(connectionPool *dbconn.DBConn) map[uint32]bool {
 	return resultMap
 }
 
+/*
+ * Returns the on-disk size in bytes of each table whose data is being backed
+ * up, summed across all segments.  When leaf partition data is not backed up
+ * separately, a partition root is copied as a single table, so its size is
+ * the sum of the sizes of all of its leaf partitions.
+ */
+func GetTableSizes(connectionPool *dbconn.DBConn, tables []Table) map[uint32]int64 {
+	resultMap := make(map[uint32]int64)
+	if len(tables) == 0 {
+		return resultMap
+	}
+	oidList := make([]string, 0, len(tables))
+	for _, table := range tables {
+		oidList = append(oidList, fmt.Sprintf("%d", table.Oid))
+	}
+	// Passed as a single array parameter, so the query text does not grow with the number of tables
+	oidArray := fmt.Sprintf("{%s}", strings.Join(oidList, ","))
+
+	before7Query := `
+	SELECT c.oid,
+		pg_relation_size(c.oid) + coalesce((SELECT sum(pg_relation_size(r.parchildrelid))
+			FROM pg_partition p
+				JOIN pg_partition_rule r ON p.oid = r.paroid
+			WHERE p.parrelid = c.oid), 0)::bigint AS size
+	FROM pg_class c
+	WHERE c.oid = ANY($1::oid[])`
+
+	atLeast7Query := `
+	SELECT c.oid,
+		CASE WHEN c.relkind = 'p' THEN coalesce((SELECT sum(pg_relation_size(t.relid))
+				FROM pg_partition_tree(c.oid) t
+				WHERE t.isleaf), 0)::bigint
+			ELSE pg_relation_size(c.oid)
+		END AS size
+	FROM pg_class c
+	WHERE c.oid = ANY($1::oid[])`
+
+	query := ""
+	if connectionPool.Version.Before("7") {
+		query = before7Query
+	} else {
+		query = atLeast7Query
+	}
+
+	var results []struct {
+		Oid  uint32
+		Size int64
+	}
+	err := connectionPool.SelectWithArgs(&results, query, oidArray)
+	gplog.FatalOnError(err)
+	for _, result := range results {
+		resultMap[result.Oid] = result.Size
+	}
+	return resultMap
+}
+
 func selectAsOidToStringMap(connectionPool *dbconn.DBConn, query string) map[uint32]string {
 	var results []struct {
 		Oid   uint32
@@ -0,0 +1,300 @@
+package backup
+
+/*
+ * This file contains functions for sampling backups, which copy out a
+ * consistent subset of the rows of each table.
+ *
+ * Rows are sampled by hashing them into one of sampleBuckets buckets and
+ * keeping the rows in the lowest buckets, so the sample is deterministic and
+ * each segment filters its own rows without moving any between segments.
+ * When foreign keys are followed, a referencing table keeps the rows whose
+ * foreign key is among the sampled keys of its parent, so that every sampled
+ * child row refers to a sampled parent row through any number of levels.
+ * A parent sampled only on the hash of its referenced key is tested with the
+ * same hash; any other parent is tested with a subquery of its sampled keys.
+ */
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
+)
+
+const sampleBuckets = 1000000
+
+type ForeignKey struct {
+	// Quoted FQNs of the referencing and referenced tables
+	Table             string
+	Columns           []string
+	ReferencedTable   string
+	ReferencedColumns []string
+}
+
+/*
+ * Returns the foreign keys of the database, in a stable order.  The columns
+ * of each key are read from conkey and confkey, in the order in which the
+ * key pairs them.  In GPDB 7+, a key referencing a partition table is copied
+ * to each of its partitions, and those copies are left out.
+ */
+func GetForeignKeys(connectionPool *dbconn.DBConn) []ForeignKey {
+	cloneFilter := ""
+	if connectionPool.Version.AtLeast("7") {
+		cloneFilter = `
+		AND NOT EXISTS (SELECT 1 FROM pg_constraint p WHERE p.oid = con.conparentid AND p.conrelid = con.conrelid)`
+	}
+	query := fmt.Sprintf(`
+	SELECT con.oid,
+		quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS tablename,
+		quote_ident(a.attname) AS columnname,
+		quote_ident(rn.nspname) || '.' || quote_ident(rc.relname) AS referencedtablename,
+		quote_ident(ra.attname) AS referencedcolumnname
+	FROM (SELECT oid, conrelid, confrelid, conkey, confkey, generate_series(1, array_upper(conkey, 1)) AS position
+			FROM pg_constraint con
+			WHERE contype = 'f'%s) con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_class rc ON rc.oid = con.confrelid
+		JOIN pg_namespace rn ON rn.oid = rc.relnamespace
+		JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = con.conkey[con.position]
+		JOIN pg_attribute ra ON ra.attrelid = con.confrelid AND ra.attnum = con.confkey[con.position]
+	ORDER BY tablename, con.oid, con.position`, cloneFilter)
+
+	results := make([]struct {
+		Oid                  uint32
+		TableName            string
+		ColumnName           string
+		ReferencedTableName  string
+		ReferencedColumnName string
+	}, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+
+	foreignKeys := make([]ForeignKey, 0)
+	var lastOid uint32
+	for _, result := range results {
+		if len(foreignKeys) == 0 || result.Oid != lastOid {
+			foreignKeys = append(foreignKeys, ForeignKey{Table: result.TableName, ReferencedTable: result.ReferencedTableName})
+			lastOid = result.Oid
+		}
+		foreignKey := &foreignKeys[len(foreignKeys)-1]
+		foreignKey.Columns = append(foreignKey.Columns, result.ColumnName)
+		foreignKey.ReferencedColumns = append(foreignKey.ReferencedColumns, result.ReferencedColumnName)
+	}
+	return foreignKeys
+}
+
+/*
+ * Returns the estimated number of rows of each table, keyed by oid.  Tables
+ * that have never been analyzed are counted instead.
+ */
+func GetTableRowCounts(connectionPool *dbconn.DBConn, tables []Table) map[uint32]float64 {
+	tableRows := make(map[uint32]float64, len(tables))
+	if len(tables) == 0 {
+		return tableRows
+	}
+	oidList := make([]string, 0, len(tables))
+	for _, table := range tables {
+		oidList = append(oidList, fmt.Sprintf("%d", table.Oid))
+	}
+	query := fmt.Sprintf(`
+	SELECT oid, reltuples AS rows
+	FROM pg_class
+	WHERE oid IN (%s)`, strings.Join(oidList, ","))
+	results := make([]struct {
+		Oid  uint32
+		Rows float64
+	}, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	for _, result := range results {
+		tableRows[result.Oid] = result.Rows
+	}
+	for _, table := range tables {
+		if tableRows[table.Oid] > 0 {
+			continue
+		}
+		var count int64
+		err = connectionPool.Get(&count, fmt.Sprintf("SELECT count(*) FROM %s", table.FQN()))
+		gplog.FatalOnError(err)
+		tableRows[table.Oid] = float64(count)
+	}
+	return tableRows
+}
+
+// The number of the lowest buckets that hold the given fraction of rows
+func getSampleThreshold(fraction float64) int {
+	return int(math.Ceil(fraction * sampleBuckets))
+}
+
+func getSampleCondition(columns []string, threshold int) string {
+	return fmt.Sprintf("(hashtext(ROW(%s)::text) & 2147483647) %% %d < %d", strings.Join(columns, ", "), sampleBuckets, threshold)
+}
+
+// Joins conditions with AND, in parentheses when there are several of them
+func joinConditions(conditions ...string) string {
+	nonEmpty := make([]string, 0, len(conditions))
+	for _, condition := range conditions {
+		if condition != "" {
+			nonEmpty = append(nonEmpty, condition)
+		}
+	}
+	if len(nonEmpty) == 1 {
+		return nonEmpty[0]
+	}
+	for i, condition := range nonEmpty {
+		nonEmpty[i] = fmt.Sprintf("(%s)", condition)
+	}
+	return strings.Join(nonEmpty, " AND ")
+}
+
+/*
+ * Returns the predicate that selects the sampled rows of each table, keyed
+ * by oid, for the given number of rows of each table.  Tables that are
+ * backed up in full have no predicate.
+ *
+ * A table referenced by other tables is sampled on the first key that
+ * references it.  Parents are planned before the tables referencing them, so
+ * that a key is followed through the predicate of its parent, and a key that
+ * would close a cycle is not followed.  Rows whose foreign key is NULL refer
+ * to no parent, so they are sampled as if the key were not followed.  Keys
+ * keep the percentage of rows of their parents, but a number of rows also
+ * limits the rows of each referencing table.
+ */
+func GetSamplePredicates(tables []Table, size utils.SampleSize, tableRows map[uint32]float64, foreignKeys []ForeignKey) map[uint32]string {
+	tablesByFQN := make(map[string]Table, len(tables))
+	for _, table := range tables {
+		tablesByFQN[table.FQN()] = table
+	}
+	sampleKeys := make(map[string][]string)
+	tableForeignKeys := make(map[string][]ForeignKey)
+	for _, foreignKey := range foreignKeys {
+		_, hasTable := tablesByFQN[foreignKey.Table]
+		_, hasReferencedTable := tablesByFQN[foreignKey.ReferencedTable]
+		if !hasTable || !hasReferencedTable || foreignKey.Table == foreignKey.ReferencedTable {
+			continue
+		}
+		if _, ok := sampleKeys[foreignKey.ReferencedTable]; !ok {
+			sampleKeys[foreignKey.ReferencedTable] = foreignKey.ReferencedColumns
+		}
+		tableForeignKeys[foreignKey.Table] = append(tableForeignKeys[foreignKey.Table], foreignKey)
+	}
+
+	predicates := make(map[string]string, len(tables))
+	// The expected fraction of the rows of each table that are sampled
+	fractions := make(map[string]float64, len(tables))
+	// The thresholds of tables sampled only on the hash of their sample key
+	keyThresholds := make(map[string]int)
+	planning := make(map[string]bool)
+	var planTable func(table Table)
+	planTable = func(table Table) {
+		tableFQN := table.FQN()
+		if _, isPlanned := fractions[tableFQN]; isPlanned {
+			return
+		}
+		planning[tableFQN] = true
+		defer delete(planning, tableFQN)
+
+		sampleKey, isReferenced := sampleKeys[tableFQN]
+		if !isReferenced {
+			sampleKey = getDataColumnNames(table.ColumnDefs)
+		}
+		ownFraction := size.Fraction(tableRows[table.Oid])
+		ownCondition := "true"
+		if ownFraction < 1 {
+			ownCondition = getSampleCondition(sampleKey, getSampleThreshold(ownFraction))
+		}
+
+		conditions := make([]string, 0)
+		followedFraction := 1.0
+		for _, foreignKey := range tableForeignKeys[tableFQN] {
+			if planning[foreignKey.ReferencedTable] {
+				gplog.Warn("Foreign keys between %s and %s form a cycle, so some rows of %s may refer to rows that are not in the sample", tableFQN, foreignKey.ReferencedTable, tableFQN)
+				continue
+			}
+			parent := tablesByFQN[foreignKey.ReferencedTable]
+			planTable(parent)
+			parentFilter := joinConditions(rowFilters[utils.UnquotedFQN(parent.Schema, parent.Name)], predicates[foreignKey.ReferencedTable])
+			if parentFilter == "" {
+				continue
+			}
+			membership := ""
+			threshold, isHashSampled := keyThresholds[foreignKey.ReferencedTable]
+			if isHashSampled && parentFilter == predicates[foreignKey.ReferencedTable] &&
+				strings.Join(sampleKeys[foreignKey.ReferencedTable], ", ") == strings.Join(foreignKey.ReferencedColumns, ", ") {
+				membership = getSampleCondition(foreignKey.Columns, threshold)
+			} else {
+				membership = fmt.Sprintf("(%s) IN (SELECT %s FROM %s WHERE %s)", strings.Join(foreignKey.Columns, ", "),
+					strings.Join(foreignKey.ReferencedColumns, ", "), getFilteredCopySource(parent), parentFilter)
+			}
+			nullChecks := make([]string, 0, len(foreignKey.Columns))
+			for _, column := range foreignKey.Columns {
+				nullChecks = append(nullChecks, fmt.Sprintf("%s IS NULL", column))
+			}
+			conditions = append(conditions, fmt.Sprintf("CASE WHEN %s THEN %s ELSE %s END", strings.Join(nullChecks, " OR "), ownCondition, membership))
+			followedFraction *= fractions[foreignKey.ReferencedTable]
+		}
+
+		fraction := ownFraction
+		if len(conditions) == 0 {
+			if ownFraction < 1 {
+				conditions = append(conditions, ownCondition)
+				keyThresholds[tableFQN] = getSampleThreshold(ownFraction)
+			}
+		} else {
+			fraction = followedFraction
+			if size.Rows > 0 {
+				if limitFraction := size.Fraction(tableRows[table.Oid] * followedFraction); limitFraction < 1 {
+					conditions = append(conditions, getSampleCondition(sampleKey, getSampleThreshold(limitFraction)))
+					fraction *= limitFraction
+				}
+			}
+		}
+		fractions[tableFQN] = fraction
+		predicates[tableFQN] = strings.Join(conditions, " AND ")
+	}
+
+	tablePredicates := make(map[uint32]string)
+	for _, table := range tables {
+		planTable(table)
+		if predicate := predicates[table.FQN()]; predicate != "" {
+			tablePredicates[table.Oid] = predicate
+		}
+	}
+	return tablePredicates
+}
+
+/*
+ * Plans the sample of the tables whose data is backed up, following their
+ * foreign keys if --sample-follow-fks is passed.
+ */
+func planSample(tables []Table) map[uint32]string {
+	// Validated in validateFlagValues, so the error can be ignored here
+	size, _ := utils.ParseSampleSize(MustGetFlagString(options.SAMPLE))
+	tableRows := make(map[uint32]float64)
+	if size.Rows > 0 {
+		tableRows = GetTableRowCounts(connectionPool, tables)
+	}
+	sampleForeignKeys := make([]ForeignKey, 0)
+	if MustGetFlagBool(options.SAMPLE_FOLLOW_FKS) {
+		sampleForeignKeys = GetForeignKeys(connectionPool)
+	}
+	gplog.Info("Sampling %s", getSampleDescription())
+	return GetSamplePredicates(tables, size, tableRows, sampleForeignKeys)
+}
+
+// Describes the sample in the backup config and report
+func getSampleDescription() string {
+	if MustGetFlagString(options.SAMPLE) == "" {
+		return ""
+	}
+	size, _ := utils.ParseSampleSize(MustGetFlagString(options.SAMPLE))
+	if MustGetFlagBool(options.SAMPLE_FOLLOW_FKS) {
+		return fmt.Sprintf("%s, following foreign keys", size)
+	}
+	return size.String()
+}
@@ -0,0 +1,106 @@
+package backup_test
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backup/sample tests", func() {
+	Describe("GetForeignKeys", func() {
+		It("groups the column pairs of each foreign key in key order", func() {
+			header := []string{"oid", "tablename", "columnname", "referencedtablename", "referencedcolumnname"}
+			fakeRows := sqlmock.NewRows(header).
+				AddRow(1, "public.orders", "region", "public.customers", "region").
+				AddRow(1, "public.orders", "customer_id", "public.customers", "id").
+				AddRow(2, "public.orders", "product_id", "public.products", "id")
+			mock.ExpectQuery(`SELECT (.*)`).WillReturnRows(fakeRows)
+
+			Expect(backup.GetForeignKeys(connectionPool)).To(Equal([]backup.ForeignKey{
+				{Table: "public.orders", Columns: []string{"region", "customer_id"}, ReferencedTable: "public.customers", ReferencedColumns: []string{"region", "id"}},
+				{Table: "public.orders", Columns: []string{"product_id"}, ReferencedTable: "public.products", ReferencedColumns: []string{"id"}},
+			}))
+		})
+	})
+	Describe("GetSamplePredicates", func() {
+		customers := backup.Table{
+			Relation:        backup.Relation{Oid: 1, Schema: "public", Name: "customers"},
+			TableDefinition: backup.TableDefinition{ColumnDefs: []backup.ColumnDefinition{{Name: "id"}, {Name: "name"}}},
+		}
+		orders := backup.Table{
+			Relation:        backup.Relation{Oid: 2, Schema: "public", Name: "orders"},
+			TableDefinition: backup.TableDefinition{ColumnDefs: []backup.ColumnDefinition{{Name: "id"}, {Name: "customer_id"}}},
+		}
+		orderKey := backup.ForeignKey{Table: "public.orders", Columns: []string{"customer_id"}, ReferencedTable: "public.customers", ReferencedColumns: []string{"id"}}
+
+		It("samples a percentage of the rows of each table", func() {
+			predicates := backup.GetSamplePredicates([]backup.Table{customers, orders}, utils.SampleSize{Percent: 10}, nil, nil)
+			Expect(predicates).To(Equal(map[uint32]string{
+				1: "(hashtext(ROW(id, name)::text) & 2147483647) % 1000000 < 100000",
+				2: "(hashtext(ROW(id, customer_id)::text) & 2147483647) % 1000000 < 100000",
+			}))
+		})
+		It("backs up tables with no more than the number of rows in full", func() {
+			tableRows := map[uint32]float64{1: 500, 2: 4000}
+			predicates := backup.GetSamplePredicates([]backup.Table{customers, orders}, utils.SampleSize{Rows: 1000}, tableRows, nil)
+			Expect(predicates).To(Equal(map[uint32]string{
+				2: "(hashtext(ROW(id, customer_id)::text) & 2147483647) % 1000000 < 250000",
+			}))
+		})
+		It("samples referencing tables on the sampled keys of the tables they reference", func() {
+			predicates := backup.GetSamplePredicates([]backup.Table{customers, orders}, utils.SampleSize{Percent: 10}, nil, []backup.ForeignKey{orderKey})
+			Expect(predicates).To(Equal(map[uint32]string{
+				1: "(hashtext(ROW(id)::text) & 2147483647) % 1000000 < 100000",
+				2: "CASE WHEN customer_id IS NULL THEN (hashtext(ROW(id, customer_id)::text) & 2147483647) % 1000000 < 100000 ELSE (hashtext(ROW(customer_id)::text) & 2147483647) % 1000000 < 100000 END",
+			}))
+		})
+		It("follows foreign keys through several levels with subqueries of the sampled keys", func() {
+			items := backup.Table{
+				Relation:        backup.Relation{Oid: 3, Schema: "public", Name: "items"},
+				TableDefinition: backup.TableDefinition{ColumnDefs: []backup.ColumnDefinition{{Name: "id"}, {Name: "order_id"}}},
+			}
+			itemKey := backup.ForeignKey{Table: "public.items", Columns: []string{"order_id"}, ReferencedTable: "public.orders", ReferencedColumns: []string{"id"}}
+			predicates := backup.GetSamplePredicates([]backup.Table{items, orders, customers}, utils.SampleSize{Percent: 10}, nil, []backup.ForeignKey{itemKey, orderKey})
+			ordersPredicate := "CASE WHEN customer_id IS NULL THEN (hashtext(ROW(id)::text) & 2147483647) % 1000000 < 100000 ELSE (hashtext(ROW(customer_id)::text) & 2147483647) % 1000000 < 100000 END"
+			Expect(predicates).To(Equal(map[uint32]string{
+				1: "(hashtext(ROW(id)::text) & 2147483647) % 1000000 < 100000",
+				2: ordersPredicate,
+				3: "CASE WHEN order_id IS NULL THEN (hashtext(ROW(id, order_id)::text) & 2147483647) % 1000000 < 100000 ELSE (order_id) IN (SELECT id FROM public.orders WHERE " + ordersPredicate + ") END",
+			}))
+		})
+		It("limits the rows of referencing tables to the number of rows", func() {
+			tableRows := map[uint32]float64{1: 10000, 2: 100000}
+			predicates := backup.GetSamplePredicates([]backup.Table{customers, orders}, utils.SampleSize{Rows: 1000}, tableRows, []backup.ForeignKey{orderKey})
+			Expect(predicates).To(Equal(map[uint32]string{
+				1: "(hashtext(ROW(id)::text) & 2147483647) % 1000000 < 100000",
+				2: "CASE WHEN customer_id IS NULL THEN (hashtext(ROW(id, customer_id)::text) & 2147483647) % 1000000 < 10000 ELSE (hashtext(ROW(customer_id)::text) & 2147483647) % 1000000 < 100000 END AND (hashtext(ROW(id, customer_id)::text) & 2147483647) % 1000000 < 100000",
+			}))
+		})
+		It("does not follow a foreign key that closes a cycle", func() {
+			customerKey := backup.ForeignKey{Table: "public.customers", Columns: []string{"id"}, ReferencedTable: "public.orders", ReferencedColumns: []string{"customer_id"}}
+			predicates := backup.GetSamplePredicates([]backup.Table{customers, orders}, utils.SampleSize{Percent: 10}, nil, []backup.ForeignKey{customerKey, orderKey})
+			Expect(predicates).To(Equal(map[uint32]string{
+				1: "CASE WHEN id IS NULL THEN (hashtext(ROW(id)::text) & 2147483647) % 1000000 < 100000 ELSE (hashtext(ROW(id)::text) & 2147483647) % 1000000 < 100000 END",
+				2: "(hashtext(ROW(customer_id)::text) & 2147483647) % 1000000 < 100000",
+			}))
+		})
+		It("follows foreign keys to the filtered rows of a table backed up in full", func() {
+			backup.SetRowFilters(map[string]string{"public.customers": "region = 'EU'"})
+			defer backup.SetRowFilters(nil)
+			tableRows := map[uint32]float64{1: 500, 2: 500}
+			predicates := backup.GetSamplePredicates([]backup.Table{customers, orders}, utils.SampleSize{Rows: 1000}, tableRows, []backup.ForeignKey{orderKey})
+			Expect(predicates).To(Equal(map[uint32]string{
+				2: "CASE WHEN customer_id IS NULL THEN true ELSE (customer_id) IN (SELECT id FROM public.customers WHERE region = 'EU') END",
+			}))
+		})
+		It("does not follow foreign keys to tables whose data is not backed up", func() {
+			predicates := backup.GetSamplePredicates([]backup.Table{orders}, utils.SampleSize{Percent: 10}, nil, []backup.ForeignKey{orderKey})
+			Expect(predicates).To(Equal(map[uint32]string{
+				2: "(hashtext(ROW(id, customer_id)::text) & 2147483647) % 1000000 < 100000",
+			}))
+		})
+	})
+})
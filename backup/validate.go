@@ -2,6 +2,8 @@ package backup
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
@@ -105,24 +107,96 @@ func validateFlagCombinations(flags *pflag.FlagSet) {
 	options.CheckExclusiveFlags(flags, options.NO_COMPRESSION, options.COMPRESSION_TYPE)
 	options.CheckExclusiveFlags(flags, options.NO_COMPRESSION, options.COMPRESSION_LEVEL)
 	options.CheckExclusiveFlags(flags, options.PLUGIN_CONFIG, options.BACKUP_DIR)
+	options.CheckExclusiveFlags(flags, options.PLUGIN_CONFIG, options.DATA_DIR)
+	options.CheckExclusiveFlags(flags, options.BACKUP_DIR, options.DATA_DIR)
+	options.CheckExclusiveFlags(flags, options.METADATA_DIR, options.METADATA_PLUGIN_CONFIG)
+	// Incremental backups reuse data from earlier backups, which may not match the row filters or masking rules
+	options.CheckExclusiveFlags(flags, options.ROW_FILTER_FILE, options.INCREMENTAL)
+	options.CheckExclusiveFlags(flags, options.ROW_FILTER_FILE, options.METADATA_ONLY)
+	options.CheckExclusiveFlags(flags, options.MASK_FILE, options.INCREMENTAL)
+	options.CheckExclusiveFlags(flags, options.MASK_FILE, options.METADATA_ONLY)
+	options.CheckExclusiveFlags(flags, options.SAMPLE, options.INCREMENTAL)
+	options.CheckExclusiveFlags(flags, options.SAMPLE, options.METADATA_ONLY)
+	// Statistics contain sample values of columns, which would not be masked
+	options.CheckExclusiveFlags(flags, options.MASK_FILE, options.WITH_STATS)
+	if MustGetFlagBool(options.SAMPLE_FOLLOW_FKS) && MustGetFlagString(options.SAMPLE) == "" {
+		gplog.Fatal(errors.Errorf("--sample-follow-fks must be specified with --sample"), "")
+	}
 	if FlagChanged(options.COPY_QUEUE_SIZE) && !MustGetFlagBool(options.SINGLE_DATA_FILE) {
 		gplog.Fatal(errors.Errorf("--copy-queue-size must be specified with --single-data-file"), "")
 	}
+	// A table that failed part way through cannot be removed from the single data file to be retried
+	if MustGetFlagInt(options.RETRIES) > 0 && MustGetFlagBool(options.SINGLE_DATA_FILE) {
+		gplog.Fatal(errors.Errorf("--retries cannot be used with --single-data-file"), "")
+	}
+	if MustGetFlagBool(options.OFFLOAD_TO_MIRRORS) {
+		// Only the gpbackup_helper agents of single data file backups consume the data of the COPY commands
+		if !MustGetFlagBool(options.SINGLE_DATA_FILE) {
+			gplog.Fatal(errors.Errorf("--offload-to-mirrors must be specified with --single-data-file"), "")
+		}
+		/*
+		 * Restores read the data file of each segment on the host of its primary,
+		 * which would not have a file written to a directory on the host of its
+		 * mirror, so the data must go to a plugin.
+		 */
+		if MustGetFlagString(options.PLUGIN_CONFIG) == "" {
+			gplog.Fatal(errors.Errorf("--offload-to-mirrors must be specified with --plugin-config"), "")
+		}
+		// The bandwidth of each host is shared out among the segments whose data it writes, which are the primaries
+		if MustGetFlagString(options.MAX_BANDWIDTH) != "" {
+			gplog.Fatal(errors.Errorf("--offload-to-mirrors cannot be used with --max-bandwidth"), "")
+		}
+	}
+	if MustGetFlagString(options.STALL_TIMEOUT) != "" && !MustGetFlagBool(options.SINGLE_DATA_FILE) {
+		gplog.Fatal(errors.Errorf("--stall-timeout must be specified with --single-data-file"), "")
+	}
+	if MustGetFlagBool(options.CANCEL_STALLED) && MustGetFlagString(options.STALL_TIMEOUT) == "" {
+		gplog.Fatal(errors.Errorf("--cancel-stalled must be specified with --stall-timeout"), "")
+	}
 	if MustGetFlagString(options.FROM_TIMESTAMP) != "" && !MustGetFlagBool(options.INCREMENTAL) {
 		gplog.Fatal(errors.Errorf("--from-timestamp must be specified with --incremental"), "")
 	}
 	if MustGetFlagBool(options.INCREMENTAL) && !MustGetFlagBool(options.LEAF_PARTITION_DATA) {
 		gplog.Fatal(errors.Errorf("--leaf-partition-data must be specified with --incremental"), "")
 	}
+	layoutTemplate := MustGetFlagString(options.LAYOUT_TEMPLATE)
+	// Segments on the same host would otherwise write to the same directory of the shared backup directory
+	if layoutTemplate != "" && (MustGetFlagString(options.BACKUP_DIR) != "" || MustGetFlagString(options.DATA_DIR) != "") && !filepath.LayoutTemplateIsPerSegment(layoutTemplate) {
+		gplog.Fatal(errors.Errorf("--layout-template must contain {content} or {dbid} when specified with --backup-dir or --data-dir"), "")
+	}
+	// COPY ... ON SEGMENT can only substitute the content id of each segment into the path of its data file
+	if filepath.LayoutTemplateNeedsSegmentConfig(layoutTemplate) && !MustGetFlagBool(options.SINGLE_DATA_FILE) && !MustGetFlagBool(options.METADATA_ONLY) {
+		gplog.Fatal(errors.Errorf("--layout-template can only contain {host} or {dbid} when specified with --single-data-file or --metadata-only"), "")
+	}
 }
 
 func validateFlagValues() {
 	err := utils.ValidateFullPath(MustGetFlagString(options.BACKUP_DIR))
 	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.DATA_DIR))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.METADATA_DIR))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.METADATA_PLUGIN_CONFIG))
+	gplog.FatalOnError(err)
 	err = utils.ValidateFullPath(MustGetFlagString(options.PLUGIN_CONFIG))
 	gplog.FatalOnError(err)
 	err = utils.ValidateCompressionTypeAndLevel(MustGetFlagString(options.COMPRESSION_TYPE), MustGetFlagInt(options.COMPRESSION_LEVEL))
 	gplog.FatalOnError(err)
+	_, err = utils.GetSessionGUCProfile(MustGetFlagString(options.SESSION_GUC_FILE), MustGetFlagStringArray(options.SESSION_GUC))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.ROW_FILTER_FILE))
+	gplog.FatalOnError(err)
+	_, err = utils.ReadRowFilterFile(MustGetFlagString(options.ROW_FILTER_FILE))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.MASK_FILE))
+	gplog.FatalOnError(err)
+	_, err = utils.ReadMaskingRulesFile(MustGetFlagString(options.MASK_FILE))
+	gplog.FatalOnError(err)
+	if MustGetFlagString(options.SAMPLE) != "" {
+		_, err = utils.ParseSampleSize(MustGetFlagString(options.SAMPLE))
+		gplog.FatalOnError(err)
+	}
 	if MustGetFlagString(options.FROM_TIMESTAMP) != "" && !filepath.IsValidTimestamp(MustGetFlagString(options.FROM_TIMESTAMP)) {
 		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.",
 			MustGetFlagString(options.FROM_TIMESTAMP)), "")
@@ -131,20 +205,96 @@ func validateFlagValues() {
 		gplog.Fatal(errors.Errorf("--copy-queue-size %d is invalid. Must be at least 2",
 			MustGetFlagInt(options.COPY_QUEUE_SIZE)), "")
 	}
+	err = utils.ValidateFQNs(MustGetFlagStringArray(options.PRIORITIZE_TABLE))
+	gplog.FatalOnError(err)
+	_, err = utils.ParseBandwidth(MustGetFlagString(options.MAX_BANDWIDTH))
+	gplog.FatalOnError(err)
+	if MustGetFlagString(options.LOCK_WAIT) != "" {
+		lockWait, err := time.ParseDuration(MustGetFlagString(options.LOCK_WAIT))
+		if err != nil || lockWait < 0 {
+			gplog.Fatal(errors.Errorf("--lock-wait %s is invalid. Must be a duration of at least 0, e.g. '30m'", MustGetFlagString(options.LOCK_WAIT)), "")
+		}
+	}
+	if MustGetFlagInt(options.RETRIES) < 0 {
+		gplog.Fatal(errors.Errorf("--retries %d is invalid. Must be at least 0", MustGetFlagInt(options.RETRIES)), "")
+	}
+	_, err = utils.ParseStallTimeout(MustGetFlagString(options.STALL_TIMEOUT))
+	gplog.FatalOnError(err)
+	err = filepath.ValidateLayoutTemplate(MustGetFlagString(options.LAYOUT_TEMPLATE))
+	gplog.FatalOnError(err)
+	if len(MustGetFlagStringArray(options.PRIORITIZE_TABLE)) > 0 && MustGetFlagBool(options.SINGLE_DATA_FILE) {
+		gplog.Fatal(errors.Errorf("--prioritize-table cannot be used with --single-data-file"), "")
+	}
 }
 
 func validateFromTimestamp(fromTimestamp string) {
 	fromTimestampFPInfo := filepath.NewFilePathInfo(globalCluster, globalFPInfo.UserSpecifiedBackupDir,
 		fromTimestamp, globalFPInfo.UserSpecifiedSegPrefix)
-	if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
+	// A backup with a different layout does not match the flags of the current one
+	fromTimestampFPInfo.SetLayoutTemplate(globalFPInfo.LayoutTemplate, globalFPInfo.DatabaseName)
+	fromTimestampFPInfo.UserSpecifiedMetadataDir = globalFPInfo.UserSpecifiedMetadataDir
+	if plugin := metadataPlugin(); plugin != nil {
 		// The config file needs to be downloaded from the remote system into the local filesystem
-		pluginConfig.MustRestoreFile(fromTimestampFPInfo.GetConfigFilePath())
+		plugin.MustRestoreFile(fromTimestampFPInfo.GetConfigFilePath())
 	}
 	fromBackupConfig := history.ReadConfigFile(fromTimestampFPInfo.GetConfigFilePath())
 
+	if fromBackupConfig.StoppedEarly() {
+		gplog.Fatal(errors.Errorf("The backup with timestamp = %s was stopped before the data of all of its tables was backed up "+
+			"and cannot be used as the base of an incremental backup.", fromTimestamp), "")
+	}
+
+	if fromBackupConfig.Filtered {
+		gplog.Fatal(errors.Errorf("The backup with timestamp = %s was taken with --%s, --%s, or --%s, so it does not contain "+
+			"all of the original data of its tables and cannot be used as the base of an incremental backup.", fromTimestamp, options.ROW_FILTER_FILE, options.MASK_FILE, options.SAMPLE), "")
+	}
+
 	if !matchesIncrementalFlags(fromBackupConfig, &backupReport.BackupConfig) {
 		gplog.Fatal(errors.Errorf("The flags of the backup with timestamp = %s does not match "+
 			"that of the current one. Please refer to the report to view the flags supplied for the "+
 			"previous backup.", fromTimestampFPInfo.Timestamp), "")
 	}
 }
+
+/*
+ * Every table in --where-file or --mask-file must have its data backed up, so
+ * that a typo in a table name does not silently produce an unfiltered or
+ * unmasked extract, and every masking rule must apply to its column.
+ */
+func validateRowFiltersAndMaskingRules(dataTables []Table) {
+	dataTablesByFQN := make(map[string]Table, len(dataTables))
+	for _, table := range dataTables {
+		dataTablesByFQN[utils.UnquotedFQN(table.Schema, table.Name)] = table
+	}
+	validateTablesBackedUp := func(tableFQNs []string, flagName string) {
+		sort.Strings(tableFQNs)
+		for _, tableFQN := range tableFQNs {
+			if _, ok := dataTablesByFQN[tableFQN]; !ok {
+				gplog.Fatal(errors.Errorf("Table %s in --%s is not among the tables whose data is being backed up", tableFQN, flagName), "")
+			}
+		}
+	}
+
+	filteredFQNs := make([]string, 0, len(rowFilters))
+	for tableFQN := range rowFilters {
+		filteredFQNs = append(filteredFQNs, tableFQN)
+	}
+	validateTablesBackedUp(filteredFQNs, options.ROW_FILTER_FILE)
+
+	maskedFQNs := make([]string, 0, len(maskingRules))
+	for tableFQN := range maskingRules {
+		maskedFQNs = append(maskedFQNs, tableFQN)
+	}
+	validateTablesBackedUp(maskedFQNs, options.MASK_FILE)
+	if len(maskedFQNs) == 0 {
+		return
+	}
+	uniqueKeyColumns := GetUniqueKeyColumns(connectionPool)
+	for _, tableFQN := range maskedFQNs {
+		table := dataTablesByFQN[tableFQN]
+		_, err := GetMaskedSelectList(table, maskingRules[tableFQN])
+		gplog.FatalOnError(err)
+		err = ValidateMaskedUniqueKeyColumns(table, maskingRules[tableFQN], uniqueKeyColumns[table.Oid])
+		gplog.FatalOnError(err)
+	}
+}
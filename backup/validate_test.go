@@ -216,6 +216,16 @@ var _ = Describe("backup/validate tests", func() {
 				}
 			},
 			Entry("--backup-dir combo", "--backup-dir /tmp --plugin-config /tmp/config", false),
+			Entry("--data-dir combo", "--data-dir /tmp --backup-dir /tmp", false),
+			Entry("--data-dir combo", "--data-dir /tmp --plugin-config /tmp/config", false),
+			Entry("--data-dir combo", "--data-dir tmp", false),
+			Entry("--metadata-dir combo", "--metadata-dir /tmp/metadata --backup-dir /tmp/data", true),
+			Entry("--metadata-dir combo", "--metadata-dir /tmp/metadata --data-dir /tmp/data", true),
+			Entry("--metadata-dir combo", "--metadata-dir /tmp --plugin-config /tmp/config", true),
+			Entry("--metadata-dir combo", "--metadata-dir tmp", false),
+			Entry("--metadata-plugin-config combo", "--metadata-plugin-config /tmp/metadata_config --backup-dir /tmp", true),
+			Entry("--metadata-plugin-config combo", "--metadata-plugin-config /tmp/metadata_config --plugin-config /tmp/config", true),
+			Entry("--metadata-plugin-config combo", "--metadata-plugin-config /tmp/metadata_config --metadata-dir /tmp", false),
 
 			/*
 			 * Below are all the different filter combinations
@@ -283,6 +293,42 @@ var _ = Describe("backup/validate tests", func() {
 			Entry("jobs combos", "--jobs 2 --single-data-file", false),
 			Entry("jobs combos", "--jobs 2 --plugin-config /tmp/file", true),
 			Entry("jobs combos", "--jobs 2 --data-only", true),
+
+			/*
+			 * Below are various different stall detection combinations
+			 */
+			Entry("stall combos", "--stall-timeout 10m", false),
+			Entry("stall combos", "--stall-timeout 10m --single-data-file", true),
+			Entry("stall combos", "--stall-timeout 1s --single-data-file", false),
+			Entry("stall combos", "--cancel-stalled --single-data-file", false),
+			Entry("stall combos", "--stall-timeout 10m --cancel-stalled --single-data-file", true),
+
+			/*
+			 * Below are various different retry combinations
+			 */
+			Entry("retries combos", "--retries 3", true),
+			Entry("retries combos", "--retries 3 --single-data-file", false),
+			Entry("retries combos", "--retries -1", false),
+
+			/*
+			 * Below are various different layout template combinations
+			 */
+			Entry("layout combos", "--layout-template {database}/{date}/{timestamp}", true),
+			Entry("layout combos", "--layout-template {database}/{date}", false),
+			Entry("layout combos", "--layout-template {database}/{timestamp} --backup-dir /tmp", false),
+			Entry("layout combos", "--layout-template {database}/{timestamp} --data-dir /tmp", false),
+			Entry("layout combos", "--layout-template {database}/seg{content}/{timestamp} --backup-dir /tmp", true),
+			Entry("layout combos", "--layout-template {host}/{dbid}/{timestamp} --backup-dir /tmp", false),
+			Entry("layout combos", "--layout-template {host}/{dbid}/{timestamp} --backup-dir /tmp --single-data-file", true),
+
+			/*
+			 * Below are various different mirror offload combinations
+			 */
+			Entry("offload combos", "--offload-to-mirrors --plugin-config /tmp/config.yaml", false),
+			Entry("offload combos", "--offload-to-mirrors --single-data-file", false),
+			Entry("offload combos", "--offload-to-mirrors --single-data-file --backup-dir /tmp", false),
+			Entry("offload combos", "--offload-to-mirrors --single-data-file --plugin-config /tmp/config.yaml", true),
+			Entry("offload combos", "--offload-to-mirrors --single-data-file --plugin-config /tmp/config.yaml --max-bandwidth 10MB", false),
 		)
 	})
 })
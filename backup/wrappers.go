@@ -2,12 +2,15 @@ package backup
 
 import (
 	"fmt"
+	"net/url"
 	"path"
 	"reflect"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/report"
@@ -76,6 +79,8 @@ func initializeConnectionPool(timestamp string) {
 
 		SetSessionGUCs(connNum)
 	}
+	err = utils.TrackCopyWorkers(connectionPool, fmt.Sprintf("gpbackup_%s", timestamp))
+	gplog.FatalOnError(err)
 }
 
 func SetSessionGUCs(connNum int) {
@@ -106,6 +111,11 @@ func SetSessionGUCs(connNum int) {
 		// This is a GPDB7+ GUC that can terminate sessions with open transactions that have been idle for too long, so we disable it.
 		connectionPool.MustExec("SET idle_in_transaction_session_timeout = 0", connNum)
 	}
+
+	// User-specified settings come last so that they can override the defaults above
+	for _, statement := range sessionGUCProfile.SetStatements() {
+		connectionPool.MustExec(statement, connNum)
+	}
 }
 
 func NewBackupConfig(dbName string, dbVersion string, backupVersion string, plugin string, timestamp string, opts options.Options) *history.BackupConfig {
@@ -126,6 +136,8 @@ func NewBackupConfig(dbName string, dbVersion string, backupVersion string, plug
 		IncludeSchemas:        MustGetFlagStringArray(options.INCLUDE_SCHEMA),
 		IncludeTableFiltered:  len(opts.GetOriginalIncludedTables()) > 0,
 		Incremental:           MustGetFlagBool(options.INCREMENTAL),
+		LayoutTemplate:        MustGetFlagString(options.LAYOUT_TEMPLATE),
+		MetadataDir:           MustGetFlagString(options.METADATA_DIR),
 		LeafPartitionData:     MustGetFlagBool(options.LEAF_PARTITION_DATA),
 		MetadataOnly:          MustGetFlagBool(options.METADATA_ONLY),
 		Plugin:                plugin,
@@ -134,6 +146,13 @@ func NewBackupConfig(dbName string, dbVersion string, backupVersion string, plug
 		WithoutGlobals:        MustGetFlagBool(options.WITHOUT_GLOBALS),
 		WithStatistics:        MustGetFlagBool(options.WITH_STATS),
 		Status:                history.BackupStatusFailed,
+		Filtered:              MustGetFlagString(options.ROW_FILTER_FILE) != "" || MustGetFlagString(options.MASK_FILE) != "" || MustGetFlagString(options.SAMPLE) != "",
+		Sample:                getSampleDescription(),
+	}
+	// Restore finds the directories of the backup where they were, even if the segments have since moved
+	if filepath.LayoutTemplateNeedsSegmentConfig(backupConfig.LayoutTemplate) {
+		backupConfig.SegmentHosts = globalFPInfo.SegHostMap
+		backupConfig.SegmentDbIDs = globalFPInfo.SegDbIDMap
 	}
 
 	return &backupConfig
@@ -159,6 +178,7 @@ func initializeBackupReport(opts options.Options) {
 
 	backupReport = &report.Report{
 		DatabaseSize: dbSize,
+		SessionGUCs:  sessionGUCProfile.String(),
 		BackupConfig: *config,
 	}
 	backupReport.ConstructBackupParamsString()
@@ -176,6 +196,51 @@ func createBackupLockFile(timestamp string) {
 	}
 }
 
+/*
+ * Returns the path of the lock file of backups of the given database, which
+ * is scoped to the coordinator port so that clusters sharing a host do not
+ * block each other.
+ */
+func GetDatabaseLockFilePath(dbname string, port int) string {
+	return fmt.Sprintf("/tmp/gpbackup_%d_%s.lck", port, url.PathEscape(dbname))
+}
+
+/*
+ * Keeps two backups of the same database from running at once, waiting up
+ * to --lock-wait for a running backup to finish.
+ */
+func createDatabaseLockFile(dbname string) {
+	// Validated in validateFlagValues, so the error can be ignored here
+	lockWait, _ := time.ParseDuration(MustGetFlagString(options.LOCK_WAIT))
+	var err error
+	// The lock is taken before connecting, so the port is resolved as the connection pool resolves it
+	port := dbconn.NewDBConnFromEnvironment(dbname).Port
+	databaseLockFile, err = utils.AcquireLock(GetDatabaseLockFilePath(dbname, port), "gpbackup", lockWait)
+	if err != nil {
+		gplog.Error(err.Error())
+		if lockWait > 0 {
+			gplog.Fatal(errors.Errorf("A backup of database %s is still in progress after waiting %s.", dbname, lockWait), "")
+		}
+		gplog.Fatal(errors.Errorf("A backup of database %s is already in progress. Use --%s to wait for it to finish.", dbname, options.LOCK_WAIT), "")
+	}
+}
+
+/*
+ * Returns the plugin to which the coordinator files are sent: the one given
+ * with --metadata-plugin-config, or else the one given with --plugin-config
+ * unless the coordinator files stay in --metadata-dir.  Returns nil if they
+ * are only written locally.
+ */
+func metadataPlugin() *utils.PluginConfig {
+	if metadataPluginConfig != nil {
+		return metadataPluginConfig
+	}
+	if MustGetFlagString(options.METADATA_DIR) != "" {
+		return nil
+	}
+	return pluginConfig
+}
+
 func createBackupDirectoriesOnAllHosts() {
 	remoteOutput := globalCluster.GenerateAndExecuteCommand("Creating backup directories",
 		cluster.ON_SEGMENTS|cluster.INCLUDE_COORDINATOR,
@@ -627,6 +692,7 @@ func backupDependentObjects(metadataFile *utils.FileWithByteCount, tables []Tabl
 	sortedSlice := TopologicalSort(sortables, relevantDeps)
 
 	PrintDependentObjectStatements(metadataFile, globalTOC, sortedSlice, filteredMetadata, domainConstraints, funcInfoMap)
+	AddDependenciesToTOC(globalTOC, sortedSlice, relevantDeps, GetColumnDefaultDependencies(connectionPool, backupSet))
 	PrintIdentityColumns(metadataFile, globalTOC, sequences)
 	PrintAlterSequenceStatements(metadataFile, globalTOC, sequences)
 	extPartInfo, partInfoMap := GetExternalPartitionInfo(connectionPool)
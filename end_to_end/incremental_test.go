@@ -692,32 +692,14 @@ EOF1`, backupDir)
 					newSchemaTupleCounts = map[string]int{}
 					assertArtifactsCleaned(restoreConn, timestamp)
 				})
-				It("Does not restore old/new tables and exits gracefully", func() {
-					args := []string{
-						"--timestamp", timestamp,
-						"--incremental", "--data-only",
-						"--redirect-db", "restoredb"}
-					cmd := exec.Command(gprestorePath, args...)
-					output, err := cmd.CombinedOutput()
-					Expect(err).To(HaveOccurred())
-					Expect(string(output)).To(ContainSubstring(
-						"objects are missing from the target database: " +
-							"[new_schema new_schema.new_table1 old_schema.new_table1]"))
-					assertRelationsExistForIncremental(restoreConn, 3)
-					assertDataRestored(restoreConn, oldSchemaTupleCounts)
-					assertDataRestored(restoreConn, newSchemaTupleCounts)
-				})
-				It("Only restores existing tables if --on-error-continue is specified", func() {
-					args := []string{
-						"--timestamp", timestamp,
+				It("Creates the new schemas and tables and restores their data", func() {
+					gprestore(gprestorePath, restoreHelperPath, timestamp,
 						"--incremental", "--data-only",
-						"--on-error-continue",
-						"--redirect-db", "restoredb"}
-					cmd := exec.Command(gprestorePath, args...)
-					_, err := cmd.CombinedOutput()
-					Expect(err).To(HaveOccurred())
+						"--redirect-db", "restoredb")
 					oldSchemaTupleCounts["old_schema.old_table1"] = 20
-					assertRelationsExistForIncremental(restoreConn, 3)
+					oldSchemaTupleCounts["old_schema.new_table1"] = 20
+					newSchemaTupleCounts["new_schema.new_table1"] = 55
+					assertRelationsExistForIncremental(restoreConn, 5)
 					assertDataRestored(restoreConn, oldSchemaTupleCounts)
 					assertDataRestored(restoreConn, newSchemaTupleCounts)
 				})
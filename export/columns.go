@@ -0,0 +1,210 @@
+package export
+
+/*
+ * This file contains functions for determining the types of exported columns
+ * from the column types recorded in a backup's TOC, and for converting
+ * backed-up CSV values to those types.
+ */
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+type ColumnType int
+
+const (
+	TextColumn ColumnType = iota
+	BooleanColumn
+	SmallintColumn
+	IntegerColumn
+	BigintColumn
+	RealColumn
+	DoubleColumn
+	DateColumn
+	TimestampColumn
+	TimestampTZColumn
+)
+
+/*
+ * Types are matched as printed by format_type, without any modifier, so that
+ * a precision still matches but an array of the type does not.  Any type not
+ * listed here, including numeric, is exported as text so that no precision is
+ * lost.
+ */
+var columnTypes = map[string]ColumnType{
+	"boolean":                     BooleanColumn,
+	"smallint":                    SmallintColumn,
+	"integer":                     IntegerColumn,
+	"bigint":                      BigintColumn,
+	"real":                        RealColumn,
+	"double precision":            DoubleColumn,
+	"date":                        DateColumn,
+	"timestamp without time zone": TimestampColumn,
+	"timestamp with time zone":    TimestampTZColumn,
+}
+
+var parquetTypes = map[ColumnType]struct {
+	physicalType  int32
+	convertedType int32
+}{
+	TextColumn:        {parquetByteArray, convertedUTF8},
+	BooleanColumn:     {parquetBoolean, noConvertedType},
+	SmallintColumn:    {parquetInt32, convertedInt16},
+	IntegerColumn:     {parquetInt32, noConvertedType},
+	BigintColumn:      {parquetInt64, noConvertedType},
+	RealColumn:        {parquetFloat, noConvertedType},
+	DoubleColumn:      {parquetDouble, noConvertedType},
+	DateColumn:        {parquetInt32, convertedDate},
+	TimestampColumn:   {parquetInt64, convertedTimestampMics},
+	TimestampTZColumn: {parquetInt64, convertedTimestampMics},
+}
+
+type ExportColumn struct {
+	Name string
+	Type ColumnType
+}
+
+func GetColumnType(formattedType string) ColumnType {
+	// A precision such as timestamp(3) does not change how values are exported
+	if start := strings.Index(formattedType, "("); start >= 0 {
+		if length := strings.Index(formattedType[start:], ")"); length >= 0 {
+			formattedType = formattedType[:start] + formattedType[start+length+1:]
+		}
+	}
+	return columnTypes[formattedType]
+}
+
+/*
+ * Returns the exported columns for a data entry, in the order they appear in
+ * its data files, with the types recorded in the TOC.  Backups taken before
+ * column types were recorded have all of their columns exported as text.
+ */
+func GetExportColumns(entry toc.CoordinatorDataEntry) []ExportColumn {
+	if entry.AttributeString == "" {
+		return []ExportColumn{}
+	}
+	names := utils.SplitAttributeList(entry.AttributeString)
+	columns := make([]ExportColumn, len(names))
+	for i, name := range names {
+		columns[i] = ExportColumn{Name: utils.UnquoteIdent(name), Type: TextColumn}
+		if len(entry.ColumnTypes) == len(names) {
+			columns[i].Type = GetColumnType(entry.ColumnTypes[i])
+		}
+	}
+	return columns
+}
+
+func GetParquetColumns(columns []ExportColumn) []ParquetColumn {
+	parquetColumns := make([]ParquetColumn, len(columns))
+	for i, column := range columns {
+		parquetType := parquetTypes[column.Type]
+		parquetColumns[i] = ParquetColumn{Name: column.Name, PhysicalType: parquetType.physicalType, ConvertedType: parquetType.convertedType}
+	}
+	return parquetColumns
+}
+
+/*
+ * Parquet has no infinite dates or timestamps, so they are written as the
+ * largest and smallest values, as other Parquet writers do.
+ */
+var infiniteValues = map[ColumnType]map[string]interface{}{
+	DateColumn:        {"infinity": int32(math.MaxInt32), "-infinity": int32(-math.MaxInt32)},
+	TimestampColumn:   {"infinity": int64(math.MaxInt64), "-infinity": int64(-math.MaxInt64)},
+	TimestampTZColumn: {"infinity": int64(math.MaxInt64), "-infinity": int64(-math.MaxInt64)},
+}
+
+/*
+ * Parses a date or timestamp with one of the given layouts.  A year before 1
+ * is printed as a positive year followed by " BC", so 1 BC is year 0.
+ */
+func parseTime(value string, layouts ...string) (time.Time, error) {
+	isBC := strings.HasSuffix(value, " BC")
+	value = strings.TrimSuffix(value, " BC")
+	for _, layout := range layouts {
+		parsed, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		if isBC {
+			parsed = time.Date(1-parsed.Year(), parsed.Month(), parsed.Day(), parsed.Hour(), parsed.Minute(), parsed.Second(), parsed.Nanosecond(), parsed.Location())
+		}
+		return parsed, nil
+	}
+	return time.Time{}, errors.Errorf("unsupported value %s", value)
+}
+
+/*
+ * Converts a value as written by COPY, with the DATESTYLE ISO setting used by
+ * gpbackup, to the Go type written by the ParquetWriter for the column type.
+ */
+func ConvertValue(value string, columnType ColumnType) (interface{}, error) {
+	if infiniteValue, ok := infiniteValues[columnType][value]; ok {
+		return infiniteValue, nil
+	}
+	switch columnType {
+	case BooleanColumn:
+		switch value {
+		case "t":
+			return true, nil
+		case "f":
+			return false, nil
+		}
+		return nil, errors.Errorf("invalid boolean value %s", value)
+	case SmallintColumn, IntegerColumn:
+		intValue, err := strconv.ParseInt(value, 10, 32)
+		return int32(intValue), err
+	case BigintColumn:
+		return strconv.ParseInt(value, 10, 64)
+	case RealColumn:
+		floatValue, err := strconv.ParseFloat(value, 32)
+		return float32(floatValue), err
+	case DoubleColumn:
+		return strconv.ParseFloat(value, 64)
+	case DateColumn:
+		date, err := parseTime(value, "2006-01-02")
+		if err != nil {
+			return nil, errors.Errorf("unsupported date value %s", value)
+		}
+		// Dates are parsed as midnight UTC, so this is a whole number of days
+		return int32(date.Unix() / 86400), nil
+	case TimestampColumn:
+		timestamp, err := parseTime(value, "2006-01-02 15:04:05.999999")
+		if err != nil {
+			return nil, errors.Errorf("unsupported timestamp value %s", value)
+		}
+		return timestamp.UnixMicro(), nil
+	case TimestampTZColumn:
+		timestamp, err := parseTime(value, "2006-01-02 15:04:05.999999-07", "2006-01-02 15:04:05.999999-07:00", "2006-01-02 15:04:05.999999-07:00:00")
+		if err != nil {
+			return nil, errors.Errorf("unsupported timestamp value %s", value)
+		}
+		return timestamp.UnixMicro(), nil
+	}
+	return []byte(value), nil
+}
+
+func ConvertRow(fields []*string, columns []ExportColumn) ([]interface{}, error) {
+	if len(fields) != len(columns) {
+		return nil, errors.Errorf("found %d fields in a row of a table with %d columns", len(fields), len(columns))
+	}
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if field == nil {
+			continue
+		}
+		value, err := ConvertValue(*field, columns[i].Type)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("column %s", columns[i].Name))
+		}
+		values[i] = value
+	}
+	return values, nil
+}
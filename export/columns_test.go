@@ -0,0 +1,84 @@
+package export_test
+
+import (
+	"math"
+
+	"github.com/greenplum-db/gpbackup/export"
+	"github.com/greenplum-db/gpbackup/toc"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("export/columns tests", func() {
+	Describe("GetColumnType", func() {
+		DescribeTable("maps types printed by format_type",
+			func(formattedType string, expected export.ColumnType) {
+				Expect(export.GetColumnType(formattedType)).To(Equal(expected))
+			},
+			Entry("integer", "integer", export.IntegerColumn),
+			Entry("bigint", "bigint", export.BigintColumn),
+			Entry("double precision", "double precision", export.DoubleColumn),
+			Entry("timestamp with precision", "timestamp(3) with time zone", export.TimestampTZColumn),
+			Entry("timestamp without time zone", "timestamp without time zone", export.TimestampColumn),
+			Entry("array", "integer[]", export.TextColumn),
+			Entry("numeric", "numeric(10,2)", export.TextColumn),
+			Entry("type with a matching prefix", "dateish", export.TextColumn),
+		)
+	})
+	Describe("GetExportColumns", func() {
+		It("returns unquoted names in attribute order with the types recorded in the TOC", func() {
+			entry := toc.CoordinatorDataEntry{AttributeString: `(i,"B,c",j)`, ColumnTypes: []string{"integer", "boolean", "numeric(10,2)"}}
+			Expect(export.GetExportColumns(entry)).To(Equal([]export.ExportColumn{
+				{Name: "i", Type: export.IntegerColumn},
+				{Name: "B,c", Type: export.BooleanColumn},
+				{Name: "j", Type: export.TextColumn},
+			}))
+		})
+		It("exports every column as text when the TOC has no column types", func() {
+			entry := toc.CoordinatorDataEntry{AttributeString: "(i,j)"}
+			Expect(export.GetExportColumns(entry)).To(Equal([]export.ExportColumn{
+				{Name: "i", Type: export.TextColumn},
+				{Name: "j", Type: export.TextColumn},
+			}))
+		})
+	})
+	Describe("ConvertValue", func() {
+		DescribeTable("converts COPY output to Parquet values",
+			func(value string, columnType export.ColumnType, expected interface{}) {
+				converted, err := export.ConvertValue(value, columnType)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(converted).To(Equal(expected))
+			},
+			Entry("boolean", "t", export.BooleanColumn, true),
+			Entry("smallint", "-3", export.SmallintColumn, int32(-3)),
+			Entry("bigint", "9007199254740993", export.BigintColumn, int64(9007199254740993)),
+			Entry("real", "1.5", export.RealColumn, float32(1.5)),
+			Entry("date", "1970-01-11", export.DateColumn, int32(10)),
+			Entry("timestamp", "1970-01-01 00:00:01.5", export.TimestampColumn, int64(1500000)),
+			Entry("timestamp with time zone", "1970-01-01 05:30:01+05:30", export.TimestampTZColumn, int64(1000000)),
+			Entry("date before 1678", "1000-01-01", export.DateColumn, int32(-354285)),
+			Entry("BC date", "0001-12-31 BC", export.DateColumn, int32(-719163)),
+			Entry("infinite date", "infinity", export.DateColumn, int32(math.MaxInt32)),
+			Entry("BC timestamp", "0001-12-31 23:59:59 BC", export.TimestampColumn, int64(-62135596801000000)),
+			Entry("negatively infinite timestamp", "-infinity", export.TimestampTZColumn, int64(-math.MaxInt64)),
+			Entry("infinite double", "Infinity", export.DoubleColumn, math.Inf(1)),
+			Entry("text", "abc", export.TextColumn, []byte("abc")),
+		)
+		It("returns an error for a value that cannot be converted", func() {
+			_, err := export.ConvertValue("yesterday", export.DateColumn)
+			Expect(err).To(MatchError("unsupported date value yesterday"))
+		})
+	})
+	Describe("ConvertRow", func() {
+		It("leaves NULL fields nil", func() {
+			value := "7"
+			columns := []export.ExportColumn{{Name: "a", Type: export.IntegerColumn}, {Name: "b", Type: export.TextColumn}}
+			Expect(export.ConvertRow([]*string{&value, nil}, columns)).To(Equal([]interface{}{int32(7), nil}))
+		})
+		It("returns an error when the row has the wrong number of fields", func() {
+			_, err := export.ConvertRow([]*string{nil}, []export.ExportColumn{})
+			Expect(err).To(MatchError("found 1 fields in a row of a table with 0 columns"))
+		})
+	})
+})
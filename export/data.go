@@ -0,0 +1,386 @@
+package export
+
+/*
+ * This file contains functions for reading table data from the per-segment
+ * files of a backup and writing it to one CSV or Parquet file per table.
+ */
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	CSVFormat     = "csv"
+	ParquetFormat = "parquet"
+
+	tableDelim = ','
+)
+
+/*
+ * Segment data files
+ */
+
+type segmentFile struct {
+	reader  io.Reader
+	closers []func() error
+}
+
+func (file *segmentFile) Read(p []byte) (int, error) {
+	return file.reader.Read(p)
+}
+
+func (file *segmentFile) Close() error {
+	var firstErr error
+	for i := len(file.closers) - 1; i >= 0; i-- {
+		err := file.closers[i]()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+/*
+ * Opens a data file for reading, from the local filesystem or from the plugin
+ * storing the backup, and decompresses it according to its extension in the
+ * same way that gpbackup_helper does.
+ */
+func openSegmentFile(filename string) (*segmentFile, error) {
+	file := &segmentFile{}
+	if pluginConfig != nil {
+		command := fmt.Sprintf("%s restore_data %s %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath, filename)
+		gplog.Debug("%s", command)
+		cmd := exec.Command("bash", "-c", command)
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		err = cmd.Start()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Unable to start plugin to read %s", filename))
+		}
+		file.reader = stdout
+		file.closers = append(file.closers, func() error {
+			// Any unread data is discarded so that the plugin is not blocked writing it
+			_, _ = io.Copy(io.Discard, stdout)
+			return cmd.Wait()
+		})
+	} else {
+		handle, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		file.reader = handle
+		file.closers = append(file.closers, handle.Close)
+	}
+
+	if strings.HasSuffix(filename, ".gz") {
+		gzipReader, err := gzip.NewReader(file.reader)
+		if err != nil {
+			_ = file.Close()
+			return nil, errors.Wrap(err, fmt.Sprintf("Unable to read %s", filename))
+		}
+		file.reader = gzipReader
+		file.closers = append(file.closers, gzipReader.Close)
+	} else if strings.HasSuffix(filename, ".zst") {
+		zstdReader, err := zstd.NewReader(file.reader)
+		if err != nil {
+			_ = file.Close()
+			return nil, errors.Wrap(err, fmt.Sprintf("Unable to read %s", filename))
+		}
+		file.reader = zstdReader
+		file.closers = append(file.closers, func() error {
+			zstdReader.Close()
+			return nil
+		})
+	}
+	file.reader = bufio.NewReader(file.reader)
+	return file, nil
+}
+
+/*
+ * Backups taken with --single-data-file write every table to one file per
+ * segment, at the offsets recorded in that segment's TOC.  The tables are
+ * exported in oid order, as they were written, so that each file is read
+ * once from start to finish.
+ */
+type singleDataFileReader struct {
+	file     *segmentFile
+	toc      *toc.SegmentTOC
+	position uint64
+}
+
+func (reader *singleDataFileReader) getTableReader(oid uint32) (io.Reader, error) {
+	entry, ok := reader.toc.DataEntries[uint(oid)]
+	if !ok {
+		return strings.NewReader(""), nil
+	}
+	if entry.StartByte < reader.position {
+		return nil, errors.Errorf("Data for oid %d starts at byte %d, before the current position %d", oid, entry.StartByte, reader.position)
+	}
+	_, err := io.CopyN(io.Discard, reader.file, int64(entry.StartByte-reader.position))
+	if err != nil {
+		return nil, err
+	}
+	reader.position = entry.EndByte
+	return io.LimitReader(reader.file, int64(entry.EndByte-entry.StartByte)), nil
+}
+
+func openSingleDataFileReaders(fpInfo filepath.FilePathInfo, extension string) ([]*singleDataFileReader, error) {
+	readers := make([]*singleDataFileReader, 0, segmentCount)
+	for contentID := 0; contentID < segmentCount; contentID++ {
+		tocFilename := fpInfo.GetSegmentTOCFilePath(contentID)
+		if pluginConfig != nil {
+			pluginConfig.MustRestoreFile(tocFilename)
+		}
+		segmentTOC := toc.NewSegmentTOC(tocFilename)
+		file, err := openSegmentFile(fpInfo.GetTableBackupFilePath(contentID, 0, extension, true))
+		if err != nil {
+			closeSingleDataFileReaders(readers)
+			return nil, err
+		}
+		readers = append(readers, &singleDataFileReader{file: file, toc: segmentTOC})
+	}
+	return readers, nil
+}
+
+func closeSingleDataFileReaders(readers []*singleDataFileReader) {
+	for _, reader := range readers {
+		_ = reader.file.Close()
+	}
+}
+
+/*
+ * Every segment holds all of the rows of a replicated table, so its data is
+ * read from the first segment only, as gprestore does.
+ */
+func getNumSegmentsToRead(entry toc.CoordinatorDataEntry) int {
+	if entry.IsReplicated && segmentCount > 1 {
+		return 1
+	}
+	return segmentCount
+}
+
+func ExportSingleDataFileTables(fpInfo filepath.FilePathInfo, entries []toc.CoordinatorDataEntry, extension string) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Oid < entries[j].Oid })
+	readers, err := openSingleDataFileReaders(fpInfo, extension)
+	gplog.FatalOnError(err)
+	defer closeSingleDataFileReaders(readers)
+	for _, entry := range entries {
+		// Readers that are not read from skip past the table when the next one is read
+		tableReaders := make([]io.Reader, getNumSegmentsToRead(entry))
+		for i := range tableReaders {
+			tableReaders[i], err = readers[i].getTableReader(entry.Oid)
+			gplog.FatalOnError(err, fmt.Sprintf("Unable to read data for table %s from segment %d", utils.MakeFQN(entry.Schema, entry.Name), i))
+		}
+		exportTable(entry, io.MultiReader(tableReaders...))
+	}
+}
+
+func ExportMultipleDataFileTables(fpInfo filepath.FilePathInfo, entries []toc.CoordinatorDataEntry, extension string) {
+	for _, entry := range entries {
+		numSegments := getNumSegmentsToRead(entry)
+		files := make([]*segmentFile, 0, numSegments)
+		tableReaders := make([]io.Reader, 0, numSegments)
+		for contentID := 0; contentID < numSegments; contentID++ {
+			file, err := openSegmentFile(fpInfo.GetTableBackupFilePath(contentID, entry.Oid, extension, false))
+			if err != nil {
+				for _, openFile := range files {
+					_ = openFile.Close()
+				}
+				gplog.Fatal(err, fmt.Sprintf("Unable to read data for table %s from segment %d", utils.MakeFQN(entry.Schema, entry.Name), contentID))
+			}
+			files = append(files, file)
+			tableReaders = append(tableReaders, file)
+		}
+		exportTable(entry, io.MultiReader(tableReaders...))
+		for _, file := range files {
+			err := file.Close()
+			gplog.FatalOnError(err)
+		}
+	}
+}
+
+/*
+ * Output files
+ */
+
+func GetExportFilename(outputDir string, schema string, name string, format string) string {
+	filename := fmt.Sprintf("%s.%s", utils.UnquoteIdent(schema), utils.UnquoteIdent(name))
+	filename = strings.Replace(filename, "/", "_", -1)
+	return path.Join(outputDir, fmt.Sprintf("%s.%s", filename, format))
+}
+
+/*
+ * Data is written to a temporary file that is renamed once it is complete,
+ * so that an interrupted export does not leave a truncated file behind.
+ */
+func exportTable(entry toc.CoordinatorDataEntry, data io.Reader) {
+	tableFQN := utils.MakeFQN(entry.Schema, entry.Name)
+	format := MustGetFlagString(options.EXPORT_FORMAT)
+	filename := GetExportFilename(MustGetFlagString(options.OUTPUT_DIR), entry.Schema, entry.Name, format)
+	gplog.Verbose("Exporting table %s to %s", tableFQN, filename)
+	columns := GetExportColumns(entry)
+
+	tempFilename := filename + ".tmp"
+	file, err := os.OpenFile(tempFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	gplog.FatalOnError(err)
+	writer := bufio.NewWriter(file)
+	if format == ParquetFormat {
+		err = WriteParquet(writer, data, columns)
+	} else {
+		err = WriteCSV(writer, data, columns)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFilename, filename)
+	}
+	if err != nil {
+		_ = os.Remove(tempFilename)
+		gplog.Fatal(err, fmt.Sprintf("Unable to export table %s", tableFQN))
+	}
+}
+
+/*
+ * The backed-up data is already CSV, so it is copied as-is after a header
+ * row of column names.
+ */
+func WriteCSV(writer io.Writer, data io.Reader, columns []ExportColumn) error {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	csvWriter := csv.NewWriter(writer)
+	err := csvWriter.Write(names)
+	if err != nil {
+		return err
+	}
+	csvWriter.Flush()
+	err = csvWriter.Error()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, data)
+	return err
+}
+
+func WriteParquet(writer io.Writer, data io.Reader, columns []ExportColumn) error {
+	parquetWriter, err := NewParquetWriter(writer, GetParquetColumns(columns))
+	if err != nil {
+		return err
+	}
+	rowReader := NewCSVRowReader(data)
+	for {
+		fields, err := rowReader.ReadRow()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		values, err := ConvertRow(fields, columns)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("row %d", rowReader.rowNumber))
+		}
+		err = parquetWriter.WriteRow(values)
+		if err != nil {
+			return err
+		}
+	}
+	return parquetWriter.Close()
+}
+
+/*
+ * Reads rows written by COPY ... WITH CSV.  Unlike encoding/csv, it tells an
+ * unquoted empty field, which COPY writes for NULL, apart from a quoted empty
+ * string, returning nil for the former.
+ */
+type CSVRowReader struct {
+	reader    *bufio.Reader
+	rowNumber int
+}
+
+func NewCSVRowReader(reader io.Reader) *CSVRowReader {
+	return &CSVRowReader{reader: bufio.NewReader(reader)}
+}
+
+func (rowReader *CSVRowReader) ReadRow() ([]*string, error) {
+	fields := make([]*string, 0)
+	var field strings.Builder
+	inQuotes := false
+	quoted := false
+	started := false
+	endField := func() {
+		if quoted || field.Len() > 0 {
+			value := field.String()
+			fields = append(fields, &value)
+		} else {
+			fields = append(fields, nil)
+		}
+		field.Reset()
+		quoted = false
+	}
+	for {
+		char, err := rowReader.reader.ReadByte()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, errors.Errorf("unterminated quoted field in row %d", rowReader.rowNumber+1)
+			}
+			if !started {
+				return nil, io.EOF
+			}
+			endField()
+			rowReader.rowNumber++
+			return fields, nil
+		} else if err != nil {
+			return nil, err
+		}
+		started = true
+		switch {
+		case inQuotes && char == '"':
+			next, err := rowReader.reader.Peek(1)
+			if err == nil && next[0] == '"' {
+				_, _ = rowReader.reader.ReadByte()
+				field.WriteByte('"')
+			} else {
+				inQuotes = false
+			}
+		case inQuotes:
+			field.WriteByte(char)
+		case char == '"':
+			inQuotes = true
+			quoted = true
+		case char == tableDelim:
+			endField()
+		case char == '\n':
+			endField()
+			rowReader.rowNumber++
+			return fields, nil
+		default:
+			field.WriteByte(char)
+		}
+	}
+}
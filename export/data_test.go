@@ -0,0 +1,135 @@
+package export_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/export"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/spf13/pflag"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func readAllRows(data string) [][]*string {
+	reader := export.NewCSVRowReader(strings.NewReader(data))
+	rows := make([][]*string, 0)
+	for {
+		row, err := reader.ReadRow()
+		if err == io.EOF {
+			return rows
+		}
+		Expect(err).ToNot(HaveOccurred())
+		rows = append(rows, row)
+	}
+}
+
+func str(value string) *string {
+	return &value
+}
+
+var _ = Describe("export/data tests", func() {
+	Describe("CSVRowReader", func() {
+		It("distinguishes NULL from an empty string", func() {
+			Expect(readAllRows("1,,\"\"\n")).To(Equal([][]*string{{str("1"), nil, str("")}}))
+		})
+		It("reads quoted fields containing delimiters, quotes, and newlines", func() {
+			Expect(readAllRows("\"a,b\",\"say \"\"hi\"\"\"\n\"line1\nline2\",x\n")).To(Equal([][]*string{
+				{str("a,b"), str(`say "hi"`)},
+				{str("line1\nline2"), str("x")},
+			}))
+		})
+		It("reads a final row without a trailing newline", func() {
+			Expect(readAllRows("1,2\n3,4")).To(Equal([][]*string{{str("1"), str("2")}, {str("3"), str("4")}}))
+		})
+		It("returns an error for an unterminated quoted field", func() {
+			reader := export.NewCSVRowReader(strings.NewReader("\"abc\n"))
+			_, err := reader.ReadRow()
+			Expect(err).To(MatchError("unterminated quoted field in row 1"))
+		})
+	})
+	Describe("WriteCSV", func() {
+		It("writes a header of column names followed by the data", func() {
+			var output bytes.Buffer
+			columns := []export.ExportColumn{{Name: "i"}, {Name: "a,b"}}
+			err := export.WriteCSV(&output, strings.NewReader("1,x\n"), columns)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output.String()).To(Equal("i,\"a,b\"\n1,x\n"))
+		})
+	})
+	Describe("exporting tables", func() {
+		var (
+			backupDir string
+			outputDir string
+			fpInfo    filepath.FilePathInfo
+		)
+		entries := []toc.CoordinatorDataEntry{
+			{Schema: "public", Name: "dist", Oid: 100, AttributeString: "(i)"},
+			{Schema: "public", Name: "rep", Oid: 200, AttributeString: "(i)", IsReplicated: true},
+			{Schema: "public", Name: "last", Oid: 300, AttributeString: "(i)"},
+		}
+		writeFile := func(filename string, contents string) {
+			Expect(os.MkdirAll(path.Dir(filename), 0755)).To(Succeed())
+			Expect(os.WriteFile(filename, []byte(contents), 0644)).To(Succeed())
+		}
+		readExport := func(name string) string {
+			contents, err := os.ReadFile(path.Join(outputDir, "public."+name+".csv"))
+			Expect(err).ToNot(HaveOccurred())
+			return string(contents)
+		}
+		BeforeEach(func() {
+			var err error
+			backupDir, err = os.MkdirTemp("", "export_test")
+			Expect(err).ToNot(HaveOccurred())
+			outputDir = path.Join(backupDir, "output")
+			Expect(os.MkdirAll(outputDir, 0755)).To(Succeed())
+			flagSet := pflag.NewFlagSet("export", pflag.ExitOnError)
+			export.SetCmdFlags(flagSet)
+			Expect(flagSet.Set(options.OUTPUT_DIR, outputDir)).To(Succeed())
+			export.SetSegmentCount(2)
+			export.SetPluginConfig(nil)
+			fpInfo = filepath.FilePathInfo{Timestamp: "20170101010101", UserSpecifiedBackupDir: backupDir, UserSpecifiedSegPrefix: "gpseg", SegDirMap: map[int]string{}}
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(backupDir)
+		})
+		It("reads a replicated table from the first segment only", func() {
+			for contentID, rows := range []string{"1\n", "2\n"} {
+				writeFile(fpInfo.GetTableBackupFilePath(contentID, 100, "", false), rows)
+				writeFile(fpInfo.GetTableBackupFilePath(contentID, 200, "", false), "7\n")
+				writeFile(fpInfo.GetTableBackupFilePath(contentID, 300, "", false), rows)
+			}
+			export.ExportMultipleDataFileTables(fpInfo, entries, "")
+			Expect(readExport("dist")).To(Equal("i\n1\n2\n"))
+			Expect(readExport("rep")).To(Equal("i\n7\n"))
+			Expect(readExport("last")).To(Equal("i\n1\n2\n"))
+		})
+		It("reads a replicated table from the first segment only in a single data file backup", func() {
+			for contentID, rows := range []string{"1\n", "2\n"} {
+				writeFile(fpInfo.GetTableBackupFilePath(contentID, 0, "", true), rows+"7\n"+rows)
+				segmentTOC := toc.SegmentTOC{DataEntries: map[uint]toc.SegmentDataEntry{
+					100: {StartByte: 0, EndByte: 2},
+					200: {StartByte: 2, EndByte: 4},
+					300: {StartByte: 4, EndByte: 6},
+				}}
+				Expect(os.MkdirAll(fpInfo.GetDirForContent(contentID), 0755)).To(Succeed())
+				Expect(segmentTOC.WriteToFileAndMakeReadOnly(fpInfo.GetSegmentTOCFilePath(contentID))).To(Succeed())
+			}
+			export.ExportSingleDataFileTables(fpInfo, entries, "")
+			Expect(readExport("dist")).To(Equal("i\n1\n2\n"))
+			Expect(readExport("rep")).To(Equal("i\n7\n"))
+			Expect(readExport("last")).To(Equal("i\n1\n2\n"))
+		})
+	})
+	Describe("GetExportFilename", func() {
+		It("uses the unquoted schema and table names", func() {
+			Expect(export.GetExportFilename("/out", "public", `"a/B"`, "parquet")).To(Equal("/out/public.a_B.parquet"))
+		})
+	})
+})
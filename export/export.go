@@ -0,0 +1,214 @@
+package export
+
+/*
+ * This file contains the entry points of the gpbackup export command, which
+ * reads a backup without connecting to a database and writes the data of
+ * each table to a CSV or Parquet file.
+ */
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// This function handles setup that can be done before parsing flags.
+func DoInit(cmd *cobra.Command) {
+	SetCmdFlags(cmd.Flags())
+	_ = cmd.MarkFlagRequired(options.TIMESTAMP)
+	_ = cmd.MarkFlagRequired(options.OUTPUT_DIR)
+}
+
+func DoValidation(cmd *cobra.Command) {
+	SetLoggerVerbosity()
+	flags := cmd.Flags()
+	options.CheckExclusiveFlags(flags, options.BACKUP_DIR, options.DATA_DIR_TEMPLATE)
+	options.CheckExclusiveFlags(flags, options.BACKUP_DIR, options.PLUGIN_CONFIG)
+	if MustGetFlagString(options.BACKUP_DIR) == "" && MustGetFlagString(options.DATA_DIR_TEMPLATE) == "" {
+		gplog.Fatal(errors.Errorf("Either --%s or --%s must be specified, to locate the backup files without a database connection", options.BACKUP_DIR, options.DATA_DIR_TEMPLATE), "")
+	}
+	for _, flagName := range []string{options.BACKUP_DIR, options.DATA_DIR_TEMPLATE, options.PLUGIN_CONFIG, options.OUTPUT_DIR} {
+		err := utils.ValidateFullPath(MustGetFlagString(flagName))
+		gplog.FatalOnError(err)
+	}
+	if template := MustGetFlagString(options.DATA_DIR_TEMPLATE); template != "" && !strings.Contains(template, "<SEGID>") {
+		gplog.Fatal(errors.Errorf("--%s %s must contain <SEGID>", options.DATA_DIR_TEMPLATE, template), "")
+	}
+	if !filepath.IsValidTimestamp(MustGetFlagString(options.TIMESTAMP)) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", MustGetFlagString(options.TIMESTAMP)), "")
+	}
+	format := MustGetFlagString(options.EXPORT_FORMAT)
+	if format != CSVFormat && format != ParquetFormat {
+		gplog.Fatal(errors.Errorf("Format %s is invalid.  Valid formats are '%s' and '%s'.", format, CSVFormat, ParquetFormat), "")
+	}
+}
+
+func SetLoggerVerbosity() {
+	if MustGetFlagBool(options.QUIET) {
+		gplog.SetVerbosity(gplog.LOGERROR)
+	} else if MustGetFlagBool(options.DEBUG) {
+		gplog.SetVerbosity(gplog.LOGDEBUG)
+	} else if MustGetFlagBool(options.VERBOSE) {
+		gplog.SetVerbosity(gplog.LOGVERBOSE)
+	}
+}
+
+func DoExport() {
+	timestamp := MustGetFlagString(options.TIMESTAMP)
+	gplog.Info("Export Key = %s", timestamp)
+	if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
+		config, err := utils.ReadPluginConfig(MustGetFlagString(options.PLUGIN_CONFIG))
+		gplog.FatalOnError(err)
+		// The plugin runs on this host only, so it reads the configuration file directly
+		config.ConfigPath = MustGetFlagString(options.PLUGIN_CONFIG)
+		SetPluginConfig(config)
+	}
+
+	fpInfo := NewFilePathInfo(timestamp, 0)
+	restoreMetadataFile(fpInfo.GetConfigFilePath())
+	SetBackupConfig(history.ReadConfigFile(fpInfo.GetConfigFilePath()))
+	if backupConfig.MetadataOnly {
+		gplog.Fatal(errors.Errorf("Backup %s is a metadata-only backup and contains no data to export", timestamp), "")
+	}
+	SetSegmentCount(backupConfig.SegmentCount)
+	if segmentCount == 0 {
+		SetSegmentCount(InferSegmentCount(timestamp))
+	}
+	gplog.Verbose("Exporting data from %d segments", segmentCount)
+	utils.InitializePipeThroughParameters(backupConfig.Compressed, backupConfig.CompressionType, 0)
+	extension := utils.GetPipeThroughProgram().Extension
+
+	err := os.MkdirAll(MustGetFlagString(options.OUTPUT_DIR), 0755)
+	gplog.FatalOnError(err)
+
+	restorePlan := backupConfig.RestorePlan
+	if len(restorePlan) == 0 {
+		restorePlan = []history.RestorePlanEntry{{Timestamp: timestamp}}
+	}
+	format := MustGetFlagString(options.EXPORT_FORMAT)
+	includeSet := utils.NewIncludeSet(MustGetFlagStringArray(options.INCLUDE_RELATION))
+	numTables := 0
+	for _, entry := range restorePlan {
+		planFPInfo := NewFilePathInfo(entry.Timestamp, segmentCount)
+		restoreMetadataFile(planFPInfo.GetTOCFilePath())
+		tocFile := toc.NewTOC(planFPInfo.GetTOCFilePath())
+		dataEntries := tocFile.DataEntries
+		if len(backupConfig.RestorePlan) > 0 {
+			dataEntries = tocFile.GetDataEntriesMatching([]string{}, []string{}, []string{}, []string{}, entry.TableFQNs)
+		}
+		filteredEntries := make([]toc.CoordinatorDataEntry, 0)
+		for _, dataEntry := range dataEntries {
+			if includeSet.MatchesFilter(utils.UnquotedFQN(dataEntry.Schema, dataEntry.Name)) {
+				filteredEntries = append(filteredEntries, dataEntry)
+			}
+		}
+		if len(filteredEntries) == 0 {
+			continue
+		}
+		gplog.Verbose("Exporting %d tables from backup %s", len(filteredEntries), entry.Timestamp)
+		if format == ParquetFormat && !HasColumnTypes(filteredEntries) {
+			gplog.Warn("Backup %s does not record the types of its columns, so all columns will be exported as text", entry.Timestamp)
+		}
+		if backupConfig.SingleDataFile {
+			ExportSingleDataFileTables(planFPInfo, filteredEntries, extension)
+		} else {
+			ExportMultipleDataFileTables(planFPInfo, filteredEntries, extension)
+		}
+		numTables += len(filteredEntries)
+	}
+	gplog.Info("Exported %d tables to %s", numTables, MustGetFlagString(options.OUTPUT_DIR))
+}
+
+/*
+ * Backup files are located using --backup-dir or, for backups written to the
+ * segment data directories, --data-dir-template, as there is no cluster from
+ * which to read the segment configuration.
+ */
+func NewFilePathInfo(timestamp string, numSegments int) filepath.FilePathInfo {
+	fpInfo := filepath.FilePathInfo{
+		PID:       os.Getpid(),
+		SegDirMap: make(map[int]string),
+		Timestamp: timestamp,
+	}
+	if backupDir := MustGetFlagString(options.BACKUP_DIR); backupDir != "" {
+		segPrefix, err := filepath.ParseSegPrefix(backupDir)
+		gplog.FatalOnError(err)
+		fpInfo.UserSpecifiedBackupDir = backupDir
+		fpInfo.UserSpecifiedSegPrefix = segPrefix
+	}
+	template := MustGetFlagString(options.DATA_DIR_TEMPLATE)
+	for contentID := -1; contentID < numSegments; contentID++ {
+		fpInfo.SegDirMap[contentID] = strings.Replace(template, "<SEGID>", strconv.Itoa(contentID), -1)
+	}
+	return fpInfo
+}
+
+// Fetches a coordinator file from the plugin; local files are read in place
+func restoreMetadataFile(filename string) {
+	if pluginConfig != nil {
+		pluginConfig.MustRestoreFile(filename)
+	}
+}
+
+/*
+ * Backups taken before gpbackup recorded the segment count are counted by
+ * their segment backup directories, which are numbered consecutively.
+ */
+func InferSegmentCount(timestamp string) int {
+	if pluginConfig != nil {
+		gplog.Fatal(errors.Errorf("Backup %s does not record its segment count, which cannot be determined for a plugin backup", timestamp), "")
+	}
+	count := 0
+	for {
+		fpInfo := NewFilePathInfo(timestamp, count+1)
+		if _, err := os.Stat(fpInfo.GetDirForContent(count)); err != nil {
+			break
+		}
+		count++
+	}
+	if count == 0 {
+		gplog.Fatal(errors.Errorf("Unable to find any segment backup directories for backup %s", timestamp), "")
+	}
+	return count
+}
+
+// Backups taken before column types were recorded in the TOC have none for any table
+func HasColumnTypes(entries []toc.CoordinatorDataEntry) bool {
+	for _, entry := range entries {
+		if len(entry.ColumnTypes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func DoTeardown() {
+	defer func() {
+		errorCode := gplog.GetErrorCode()
+		if errorCode == 0 {
+			gplog.Info("Export completed successfully")
+		}
+		os.Exit(errorCode)
+	}()
+
+	if err := recover(); err != nil {
+		// Check if gplog.Fatal did not cause the panic
+		if gplog.GetErrorCode() != 2 {
+			gplog.Error(fmt.Sprintf("%v: %s", err, debug.Stack()))
+			gplog.SetErrorCode(2)
+		} else {
+			fmt.Println(fmt.Sprintf("%+v", err))
+		}
+	}
+}
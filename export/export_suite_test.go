@@ -0,0 +1,19 @@
+package export_test
+
+import (
+	"testing"
+
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestExport(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "export tests")
+}
+
+var _ = BeforeSuite(func() {
+	_, _, _ = testhelper.SetupTestLogger()
+})
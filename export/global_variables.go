@@ -0,0 +1,66 @@
+package export
+
+import (
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/pflag"
+)
+
+/*
+ * This file contains global variables and setter functions for those variables
+ * used in testing.
+ */
+
+/*
+ * Non-flag variables
+ */
+
+var (
+	backupConfig *history.BackupConfig
+	pluginConfig *utils.PluginConfig
+	// The number of segments whose data files make up the backup
+	segmentCount int
+)
+
+/*
+ * Command-line flags
+ */
+var cmdFlags *pflag.FlagSet
+
+/*
+ * Setter functions
+ */
+
+func SetCmdFlags(flagSet *pflag.FlagSet) {
+	cmdFlags = flagSet
+	options.SetExportFlagDefaults(cmdFlags)
+}
+
+func SetBackupConfig(config *history.BackupConfig) {
+	backupConfig = config
+}
+
+func SetPluginConfig(config *utils.PluginConfig) {
+	pluginConfig = config
+}
+
+func SetSegmentCount(count int) {
+	segmentCount = count
+}
+
+/*
+ * Functions for accessing command-line flags
+ */
+
+func MustGetFlagString(flagName string) string {
+	return options.MustGetFlagString(cmdFlags, flagName)
+}
+
+func MustGetFlagBool(flagName string) bool {
+	return options.MustGetFlagBool(cmdFlags, flagName)
+}
+
+func MustGetFlagStringArray(flagName string) []string {
+	return options.MustGetFlagStringArray(cmdFlags, flagName)
+}
@@ -0,0 +1,380 @@
+package export
+
+/*
+ * This file contains a minimal Parquet writer for exported table data.  Every
+ * column is optional, values are PLAIN encoded and uncompressed, and each row
+ * group holds a single data page per column, which any Parquet reader can
+ * read.  The file metadata is encoded with the Thrift compact protocol.
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Physical types
+const (
+	parquetBoolean   int32 = 0
+	parquetInt32     int32 = 1
+	parquetInt64     int32 = 2
+	parquetFloat     int32 = 4
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+)
+
+// Converted types, or noConvertedType for columns that need none
+const (
+	noConvertedType        int32 = -1
+	convertedUTF8          int32 = 0
+	convertedDate          int32 = 6
+	convertedTimestampMics int32 = 10
+	convertedInt16         int32 = 16
+)
+
+const (
+	encodingPlain     int32 = 0
+	encodingRLE       int32 = 3
+	fieldOptional     int32 = 1
+	pageTypeData      int32 = 0
+	codecUncompressed int32 = 0
+
+	parquetMagic       = "PAR1"
+	parquetRowGroupMax = 131072
+)
+
+type ParquetColumn struct {
+	Name          string
+	PhysicalType  int32
+	ConvertedType int32
+}
+
+type columnBuffer struct {
+	present  []bool
+	values   bytes.Buffer
+	booleans []bool
+}
+
+type columnChunkMetadata struct {
+	numValues      int64
+	totalSize      int64
+	dataPageOffset int64
+}
+
+type rowGroupMetadata struct {
+	numRows int64
+	columns []columnChunkMetadata
+}
+
+type ParquetWriter struct {
+	writer       io.Writer
+	offset       int64
+	columns      []ParquetColumn
+	buffers      []*columnBuffer
+	bufferedRows int
+	numRows      int64
+	rowGroups    []rowGroupMetadata
+}
+
+func NewParquetWriter(writer io.Writer, columns []ParquetColumn) (*ParquetWriter, error) {
+	parquetWriter := &ParquetWriter{writer: writer, columns: columns}
+	parquetWriter.resetBuffers()
+	err := parquetWriter.write([]byte(parquetMagic))
+	if err != nil {
+		return nil, err
+	}
+	return parquetWriter, nil
+}
+
+func (pw *ParquetWriter) resetBuffers() {
+	pw.buffers = make([]*columnBuffer, len(pw.columns))
+	for i := range pw.columns {
+		pw.buffers[i] = &columnBuffer{}
+	}
+	pw.bufferedRows = 0
+}
+
+func (pw *ParquetWriter) write(data []byte) error {
+	numBytes, err := pw.writer.Write(data)
+	pw.offset += int64(numBytes)
+	return err
+}
+
+/*
+ * Adds a row of already-converted values, which must be in column order and
+ * of the Go type matching each column's physical type: bool, int32, int64,
+ * float32, float64, or []byte.  A nil value is written as NULL.
+ */
+func (pw *ParquetWriter) WriteRow(values []interface{}) error {
+	for i, value := range values {
+		buffer := pw.buffers[i]
+		buffer.present = append(buffer.present, value != nil)
+		switch typedValue := value.(type) {
+		case nil:
+		case bool:
+			buffer.booleans = append(buffer.booleans, typedValue)
+		case int32:
+			_ = binary.Write(&buffer.values, binary.LittleEndian, typedValue)
+		case int64:
+			_ = binary.Write(&buffer.values, binary.LittleEndian, typedValue)
+		case float32:
+			_ = binary.Write(&buffer.values, binary.LittleEndian, math.Float32bits(typedValue))
+		case float64:
+			_ = binary.Write(&buffer.values, binary.LittleEndian, math.Float64bits(typedValue))
+		case []byte:
+			_ = binary.Write(&buffer.values, binary.LittleEndian, uint32(len(typedValue)))
+			buffer.values.Write(typedValue)
+		}
+	}
+	pw.bufferedRows++
+	pw.numRows++
+	if pw.bufferedRows >= parquetRowGroupMax {
+		return pw.flushRowGroup()
+	}
+	return nil
+}
+
+func (pw *ParquetWriter) flushRowGroup() error {
+	if pw.bufferedRows == 0 {
+		return nil
+	}
+	rowGroup := rowGroupMetadata{numRows: int64(pw.bufferedRows)}
+	for _, buffer := range pw.buffers {
+		var page bytes.Buffer
+		definitionLevels := encodeDefinitionLevels(buffer.present)
+		_ = binary.Write(&page, binary.LittleEndian, uint32(len(definitionLevels)))
+		page.Write(definitionLevels)
+		if buffer.booleans != nil {
+			page.Write(encodePlainBooleans(buffer.booleans))
+		} else {
+			page.Write(buffer.values.Bytes())
+		}
+
+		header := encodePageHeader(len(buffer.present), page.Len())
+		chunk := columnChunkMetadata{
+			numValues:      int64(len(buffer.present)),
+			totalSize:      int64(len(header) + page.Len()),
+			dataPageOffset: pw.offset,
+		}
+		err := pw.write(header)
+		if err != nil {
+			return err
+		}
+		err = pw.write(page.Bytes())
+		if err != nil {
+			return err
+		}
+		rowGroup.columns = append(rowGroup.columns, chunk)
+	}
+	pw.rowGroups = append(pw.rowGroups, rowGroup)
+	pw.resetBuffers()
+	return nil
+}
+
+// Writes any buffered rows and the file footer.  The underlying writer is not closed.
+func (pw *ParquetWriter) Close() error {
+	err := pw.flushRowGroup()
+	if err != nil {
+		return err
+	}
+	footer := pw.encodeFileMetadata()
+	err = pw.write(footer)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(pw.writer, binary.LittleEndian, uint32(len(footer)))
+	if err != nil {
+		return err
+	}
+	return pw.write([]byte(parquetMagic))
+}
+
+// Definition levels are 1 for a value and 0 for NULL, written as RLE runs of bit width 1
+func encodeDefinitionLevels(present []bool) []byte {
+	var buffer bytes.Buffer
+	for start := 0; start < len(present); {
+		end := start
+		for end < len(present) && present[end] == present[start] {
+			end++
+		}
+		writeUvarint(&buffer, uint64(end-start)<<1)
+		if present[start] {
+			buffer.WriteByte(1)
+		} else {
+			buffer.WriteByte(0)
+		}
+		start = end
+	}
+	return buffer.Bytes()
+}
+
+func encodePlainBooleans(values []bool) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, value := range values {
+		if value {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return packed
+}
+
+func encodePageHeader(numValues int, pageSize int) []byte {
+	writer := &compactWriter{}
+	writer.writeI32Field(1, pageTypeData)
+	writer.writeI32Field(2, int32(pageSize))
+	writer.writeI32Field(3, int32(pageSize))
+	writer.writeStructField(5, func() {
+		writer.writeI32Field(1, int32(numValues))
+		writer.writeI32Field(2, encodingPlain)
+		writer.writeI32Field(3, encodingRLE)
+		writer.writeI32Field(4, encodingRLE)
+	})
+	writer.writeStop()
+	return writer.buffer.Bytes()
+}
+
+func (pw *ParquetWriter) encodeFileMetadata() []byte {
+	writer := &compactWriter{}
+	writer.writeI32Field(1, 1)
+	writer.writeListField(2, compactStruct, len(pw.columns)+1)
+	writer.writeStruct(func() {
+		writer.writeStringField(4, "schema")
+		writer.writeI32Field(5, int32(len(pw.columns)))
+	})
+	for _, column := range pw.columns {
+		column := column
+		writer.writeStruct(func() {
+			writer.writeI32Field(1, column.PhysicalType)
+			writer.writeI32Field(3, fieldOptional)
+			writer.writeStringField(4, column.Name)
+			if column.ConvertedType != noConvertedType {
+				writer.writeI32Field(6, column.ConvertedType)
+			}
+		})
+	}
+	writer.writeI64Field(3, pw.numRows)
+	writer.writeListField(4, compactStruct, len(pw.rowGroups))
+	for _, rowGroup := range pw.rowGroups {
+		rowGroup := rowGroup
+		writer.writeStruct(func() {
+			var totalSize int64
+			writer.writeListField(1, compactStruct, len(rowGroup.columns))
+			for i, chunk := range rowGroup.columns {
+				column := pw.columns[i]
+				chunk := chunk
+				totalSize += chunk.totalSize
+				writer.writeStruct(func() {
+					writer.writeI64Field(2, chunk.dataPageOffset)
+					writer.writeStructField(3, func() {
+						writer.writeI32Field(1, column.PhysicalType)
+						writer.writeListField(2, compactI32, 2)
+						writer.writeI32(encodingPlain)
+						writer.writeI32(encodingRLE)
+						writer.writeListField(3, compactBinary, 1)
+						writer.writeString(column.Name)
+						writer.writeI32Field(4, codecUncompressed)
+						writer.writeI64Field(5, chunk.numValues)
+						writer.writeI64Field(6, chunk.totalSize)
+						writer.writeI64Field(7, chunk.totalSize)
+						writer.writeI64Field(9, chunk.dataPageOffset)
+					})
+				})
+			}
+			writer.writeI64Field(2, totalSize)
+			writer.writeI64Field(3, rowGroup.numRows)
+		})
+	}
+	writer.writeStringField(6, "gpbackup export")
+	writer.writeStop()
+	return writer.buffer.Bytes()
+}
+
+/*
+ * Thrift compact protocol encoding, limited to the field types used in
+ * Parquet file metadata.
+ */
+
+const (
+	compactI32    byte = 5
+	compactI64    byte = 6
+	compactBinary byte = 8
+	compactList   byte = 9
+	compactStruct byte = 12
+)
+
+type compactWriter struct {
+	buffer     bytes.Buffer
+	lastField  int16
+	fieldStack []int16
+}
+
+func writeUvarint(buffer *bytes.Buffer, value uint64) {
+	varint := make([]byte, binary.MaxVarintLen64)
+	numBytes := binary.PutUvarint(varint, value)
+	buffer.Write(varint[:numBytes])
+}
+
+func (writer *compactWriter) writeFieldHeader(id int16, fieldType byte) {
+	delta := id - writer.lastField
+	if delta > 0 && delta <= 15 {
+		writer.buffer.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		writer.buffer.WriteByte(fieldType)
+		writeUvarint(&writer.buffer, uint64(uint16((id<<1)^(id>>15))))
+	}
+	writer.lastField = id
+}
+
+func (writer *compactWriter) writeI32(value int32) {
+	writeUvarint(&writer.buffer, uint64(uint32((value<<1)^(value>>31))))
+}
+
+func (writer *compactWriter) writeString(value string) {
+	writeUvarint(&writer.buffer, uint64(len(value)))
+	writer.buffer.WriteString(value)
+}
+
+func (writer *compactWriter) writeI32Field(id int16, value int32) {
+	writer.writeFieldHeader(id, compactI32)
+	writer.writeI32(value)
+}
+
+func (writer *compactWriter) writeI64Field(id int16, value int64) {
+	writer.writeFieldHeader(id, compactI64)
+	writeUvarint(&writer.buffer, uint64((value<<1)^(value>>63)))
+}
+
+func (writer *compactWriter) writeStringField(id int16, value string) {
+	writer.writeFieldHeader(id, compactBinary)
+	writer.writeString(value)
+}
+
+func (writer *compactWriter) writeListField(id int16, elementType byte, size int) {
+	writer.writeFieldHeader(id, compactList)
+	if size < 15 {
+		writer.buffer.WriteByte(byte(size)<<4 | elementType)
+	} else {
+		writer.buffer.WriteByte(0xF0 | elementType)
+		writeUvarint(&writer.buffer, uint64(size))
+	}
+}
+
+// Writes a struct, such as a list element, whose fields are written by writeFields
+func (writer *compactWriter) writeStruct(writeFields func()) {
+	writer.fieldStack = append(writer.fieldStack, writer.lastField)
+	writer.lastField = 0
+	writeFields()
+	writer.writeStop()
+	writer.lastField = writer.fieldStack[len(writer.fieldStack)-1]
+	writer.fieldStack = writer.fieldStack[:len(writer.fieldStack)-1]
+}
+
+func (writer *compactWriter) writeStructField(id int16, writeFields func()) {
+	writer.writeFieldHeader(id, compactStruct)
+	writer.writeStruct(writeFields)
+}
+
+func (writer *compactWriter) writeStop() {
+	writer.buffer.WriteByte(0)
+}
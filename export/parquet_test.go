@@ -0,0 +1,356 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/export"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+/*
+ * Decodes a Thrift compact protocol struct into a map of field id to value,
+ * with nested structs as maps and lists as slices, to check the file metadata
+ * written by the ParquetWriter.
+ */
+type compactReader struct {
+	data *bytes.Reader
+}
+
+func (reader compactReader) readUvarint() uint64 {
+	value, err := binary.ReadUvarint(reader.data)
+	Expect(err).ToNot(HaveOccurred())
+	return value
+}
+
+func (reader compactReader) readZigzag() int64 {
+	value := reader.readUvarint()
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+func (reader compactReader) readValue(fieldType byte) interface{} {
+	switch fieldType {
+	case 5, 6:
+		return reader.readZigzag()
+	case 8:
+		value := make([]byte, reader.readUvarint())
+		_, _ = reader.data.Read(value)
+		return string(value)
+	case 9:
+		header, _ := reader.data.ReadByte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(reader.readUvarint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = reader.readValue(header & 0x0F)
+		}
+		return list
+	case 12:
+		return reader.readStruct()
+	}
+	Fail("unexpected compact type")
+	return nil
+}
+
+func (reader compactReader) readStruct() map[int64]interface{} {
+	fields := make(map[int64]interface{})
+	lastField := int64(0)
+	for {
+		header, err := reader.data.ReadByte()
+		Expect(err).ToNot(HaveOccurred())
+		if header == 0 {
+			return fields
+		}
+		fieldID := lastField + int64(header>>4)
+		if header>>4 == 0 {
+			fieldID = reader.readZigzag()
+		}
+		fields[fieldID] = reader.readValue(header & 0x0F)
+		lastField = fieldID
+	}
+}
+
+func readFooter(file []byte) map[int64]interface{} {
+	Expect(string(file[:4])).To(Equal("PAR1"))
+	Expect(string(file[len(file)-4:])).To(Equal("PAR1"))
+	footerLength := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := file[len(file)-8-footerLength : len(file)-8]
+	reader := compactReader{data: bytes.NewReader(footer)}
+	metadata := reader.readStruct()
+	Expect(reader.data.Len()).To(Equal(0))
+	return metadata
+}
+
+// Decodes a run-length/bit-packed hybrid encoded run of bit width 1, as used for definition levels
+func readDefinitionLevels(data []byte, numValues int) []bool {
+	reader := compactReader{data: bytes.NewReader(data)}
+	levels := make([]bool, 0, numValues)
+	for len(levels) < numValues {
+		header := reader.readUvarint()
+		if header&1 == 0 {
+			value, err := reader.data.ReadByte()
+			Expect(err).ToNot(HaveOccurred())
+			for i := uint64(0); i < header>>1; i++ {
+				levels = append(levels, value == 1)
+			}
+			continue
+		}
+		packed := make([]byte, header>>1)
+		_, err := reader.data.Read(packed)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < len(packed)*8; i++ {
+			levels = append(levels, packed[i/8]&(1<<uint(i%8)) != 0)
+		}
+	}
+	Expect(reader.data.Len()).To(Equal(0))
+	return levels[:numValues]
+}
+
+/*
+ * Reads the values of every column from the data pages of every row group, as
+ * a Parquet reader would from the file metadata alone, with NULLs as nil.
+ */
+func readParquetColumns(file []byte) map[string][]interface{} {
+	metadata := readFooter(file)
+	schema := metadata[2].([]interface{})[1:]
+	columns := make(map[string][]interface{}, len(schema))
+	for _, rowGroup := range metadata[4].([]interface{}) {
+		for i, chunk := range rowGroup.(map[int64]interface{})[1].([]interface{}) {
+			element := schema[i].(map[int64]interface{})
+			name, physicalType := element[4].(string), element[1].(int64)
+			offset := chunk.(map[int64]interface{})[3].(map[int64]interface{})[9].(int64)
+			pageReader := compactReader{data: bytes.NewReader(file[offset:])}
+			pageHeader := pageReader.readStruct()
+			numValues := int(pageHeader[5].(map[int64]interface{})[1].(int64))
+			pageStart := int64(len(file)) - int64(pageReader.data.Len())
+			page := file[pageStart : pageStart+pageHeader[3].(int64)]
+
+			levelsLength := binary.LittleEndian.Uint32(page)
+			levels := readDefinitionLevels(page[4:4+levelsLength], numValues)
+			values := bytes.NewReader(page[4+levelsLength:])
+			booleanIndex := 0
+			for _, present := range levels {
+				if !present {
+					columns[name] = append(columns[name], nil)
+					continue
+				}
+				var value interface{}
+				switch physicalType {
+				case 0:
+					packedIndex := int64(len(page)) - int64(values.Len()) + int64(booleanIndex/8)
+					value = page[packedIndex]&(1<<uint(booleanIndex%8)) != 0
+					booleanIndex++
+				case 1:
+					var typedValue int32
+					Expect(binary.Read(values, binary.LittleEndian, &typedValue)).To(Succeed())
+					value = typedValue
+				case 2:
+					var typedValue int64
+					Expect(binary.Read(values, binary.LittleEndian, &typedValue)).To(Succeed())
+					value = typedValue
+				case 4:
+					var typedValue float32
+					Expect(binary.Read(values, binary.LittleEndian, &typedValue)).To(Succeed())
+					value = typedValue
+				case 5:
+					var typedValue float64
+					Expect(binary.Read(values, binary.LittleEndian, &typedValue)).To(Succeed())
+					value = typedValue
+				case 6:
+					var length uint32
+					Expect(binary.Read(values, binary.LittleEndian, &length)).To(Succeed())
+					typedValue := make([]byte, length)
+					_, err := values.Read(typedValue)
+					Expect(err).ToNot(HaveOccurred())
+					value = string(typedValue)
+				default:
+					Fail("unexpected physical type")
+				}
+				columns[name] = append(columns[name], value)
+			}
+			if physicalType == 0 {
+				Expect(values.Len()).To(Equal((booleanIndex + 7) / 8))
+			} else {
+				Expect(values.Len()).To(Equal(0))
+			}
+		}
+	}
+	return columns
+}
+
+var _ = Describe("export/parquet tests", func() {
+	columns := []export.ParquetColumn{
+		{Name: "id", PhysicalType: 1, ConvertedType: -1},
+		{Name: "name", PhysicalType: 6, ConvertedType: 0},
+		{Name: "ok", PhysicalType: 0, ConvertedType: -1},
+	}
+
+	It("writes a schema with an optional column for each column", func() {
+		var output bytes.Buffer
+		writer, err := export.NewParquetWriter(&output, columns)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.Close()).To(Succeed())
+
+		metadata := readFooter(output.Bytes())
+		Expect(metadata[3]).To(Equal(int64(0)))
+		schema := metadata[2].([]interface{})
+		Expect(schema).To(HaveLen(4))
+		Expect(schema[0]).To(Equal(map[int64]interface{}{4: "schema", 5: int64(3)}))
+		Expect(schema[1]).To(Equal(map[int64]interface{}{1: int64(1), 3: int64(1), 4: "id"}))
+		Expect(schema[2]).To(Equal(map[int64]interface{}{1: int64(6), 3: int64(1), 4: "name", 6: int64(0)}))
+	})
+	It("writes a row group whose column chunks point at their data pages", func() {
+		var output bytes.Buffer
+		writer, err := export.NewParquetWriter(&output, columns)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.WriteRow([]interface{}{int32(1), []byte("a"), true})).To(Succeed())
+		Expect(writer.WriteRow([]interface{}{int32(2), nil, false})).To(Succeed())
+		Expect(writer.WriteRow([]interface{}{nil, []byte("bc"), nil})).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		file := output.Bytes()
+		metadata := readFooter(file)
+		Expect(metadata[3]).To(Equal(int64(3)))
+		rowGroups := metadata[4].([]interface{})
+		Expect(rowGroups).To(HaveLen(1))
+		rowGroup := rowGroups[0].(map[int64]interface{})
+		Expect(rowGroup[3]).To(Equal(int64(3)))
+
+		chunks := rowGroup[1].([]interface{})
+		Expect(chunks).To(HaveLen(3))
+		var totalSize int64
+		for i, chunk := range chunks {
+			chunkMetadata := chunk.(map[int64]interface{})[3].(map[int64]interface{})
+			Expect(chunkMetadata[3]).To(Equal([]interface{}{columns[i].Name}))
+			Expect(chunkMetadata[5]).To(Equal(int64(3)))
+
+			offset := chunkMetadata[9].(int64)
+			pageReader := compactReader{data: bytes.NewReader(file[offset:])}
+			pageHeader := pageReader.readStruct()
+			Expect(pageHeader[5].(map[int64]interface{})[1]).To(Equal(int64(3)))
+			headerSize := int64(len(file[offset:]) - pageReader.data.Len())
+			Expect(headerSize + pageHeader[3].(int64)).To(Equal(chunkMetadata[7]))
+			totalSize += chunkMetadata[7].(int64)
+		}
+		Expect(rowGroup[2]).To(Equal(totalSize))
+
+		// The id column has definition levels 1,1,0 as two RLE runs, then two INT32 values
+		idOffset := chunks[0].(map[int64]interface{})[3].(map[int64]interface{})[9].(int64)
+		idPage := file[idOffset+pageHeaderSize(file[idOffset:]):]
+		Expect(idPage[:16]).To(Equal([]byte{4, 0, 0, 0, 4, 1, 2, 0, 1, 0, 0, 0, 2, 0, 0, 0}))
+	})
+	It("writes a file whose data pages decode to the same values", func() {
+		exportColumns := []export.ExportColumn{
+			{Name: "id", Type: export.IntegerColumn},
+			{Name: "small", Type: export.SmallintColumn},
+			{Name: "big", Type: export.BigintColumn},
+			{Name: "r", Type: export.RealColumn},
+			{Name: "dp", Type: export.DoubleColumn},
+			{Name: "name", Type: export.TextColumn},
+			{Name: "ok", Type: export.BooleanColumn},
+			{Name: "d", Type: export.DateColumn},
+			{Name: "ts", Type: export.TimestampColumn},
+		}
+		var output bytes.Buffer
+		data := "1,7,9000000000,1.5,2.25,a,t,2020-01-02,2020-01-02 03:04:05\n2,,,,,,f,,\n,-7,-1,0,-0.5,bc,,1970-01-01,1970-01-01 00:00:00\n"
+		Expect(export.WriteParquet(&output, strings.NewReader(data), exportColumns)).To(Succeed())
+
+		columns := readParquetColumns(output.Bytes())
+		Expect(columns).To(HaveLen(9))
+		Expect(columns["id"]).To(Equal([]interface{}{int32(1), int32(2), nil}))
+		Expect(columns["small"]).To(Equal([]interface{}{int32(7), nil, int32(-7)}))
+		Expect(columns["big"]).To(Equal([]interface{}{int64(9000000000), nil, int64(-1)}))
+		Expect(columns["r"]).To(Equal([]interface{}{float32(1.5), nil, float32(0)}))
+		Expect(columns["dp"]).To(Equal([]interface{}{float64(2.25), nil, float64(-0.5)}))
+		Expect(columns["name"]).To(Equal([]interface{}{"a", nil, "bc"}))
+		Expect(columns["ok"]).To(Equal([]interface{}{true, false, nil}))
+		Expect(columns["d"]).To(Equal([]interface{}{int32(18263), nil, int32(0)}))
+		Expect(columns["ts"]).To(Equal([]interface{}{int64(1577934245000000), nil, int64(0)}))
+	})
+	It("writes rows past the row group size to a second row group that decodes as well", func() {
+		var output bytes.Buffer
+		writer, err := export.NewParquetWriter(&output, columns[:1])
+		Expect(err).ToNot(HaveOccurred())
+		numRows := 131072 + 3
+		for i := 0; i < numRows; i++ {
+			Expect(writer.WriteRow([]interface{}{int32(i)})).To(Succeed())
+		}
+		Expect(writer.Close()).To(Succeed())
+
+		Expect(readFooter(output.Bytes())[4]).To(HaveLen(2))
+		values := readParquetColumns(output.Bytes())["id"]
+		Expect(values).To(HaveLen(numRows))
+		Expect(values[131072]).To(Equal(int32(131072)))
+		Expect(values[numRows-1]).To(Equal(int32(numRows - 1)))
+	})
+	It("writes a file that pyarrow reads back with the same types and values", func() {
+		if exec.Command("python3", "-c", "import pyarrow.parquet").Run() != nil {
+			Skip("pyarrow is not installed")
+		}
+		tempDir, err := os.MkdirTemp("", "parquet_test")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(tempDir)
+		filename := path.Join(tempDir, "table.parquet")
+
+		exportColumns := []export.ExportColumn{
+			{Name: "id", Type: export.IntegerColumn},
+			{Name: "name", Type: export.TextColumn},
+			{Name: "ok", Type: export.BooleanColumn},
+			{Name: "d", Type: export.DateColumn},
+			{Name: "ts", Type: export.TimestampColumn},
+		}
+		var output bytes.Buffer
+		data := "1,a,t,2020-01-02,2020-01-02 03:04:05\n2,,f,,\n"
+		Expect(export.WriteParquet(&output, strings.NewReader(data), exportColumns)).To(Succeed())
+		Expect(os.WriteFile(filename, output.Bytes(), 0644)).To(Succeed())
+
+		// Dates and timestamps are cast to their stored integers so that they compare exactly
+		script := `
+import json, sys
+import pyarrow as pa, pyarrow.parquet as pq
+table = pq.read_table(sys.argv[1])
+columns = {}
+for field in table.schema:
+    column = table.column(field.name)
+    if pa.types.is_date32(field.type):
+        column = column.cast(pa.int32())
+    elif pa.types.is_timestamp(field.type):
+        column = column.cast(pa.int64())
+    columns[field.name] = {"type": str(field.type).split("[")[0], "values": column.to_pylist()}
+print(json.dumps(columns))
+`
+		result, err := exec.Command("python3", "-c", script, filename).Output()
+		Expect(err).ToNot(HaveOccurred())
+		var columns map[string]struct {
+			Type   string
+			Values []interface{}
+		}
+		Expect(json.Unmarshal(result, &columns)).To(Succeed())
+		Expect(columns).To(HaveLen(5))
+		Expect(columns["id"].Type).To(Equal("int32"))
+		Expect(columns["id"].Values).To(Equal([]interface{}{float64(1), float64(2)}))
+		Expect(columns["name"].Type).To(Equal("string"))
+		Expect(columns["name"].Values).To(Equal([]interface{}{"a", nil}))
+		Expect(columns["ok"].Type).To(Equal("bool"))
+		Expect(columns["ok"].Values).To(Equal([]interface{}{true, false}))
+		Expect(columns["d"].Type).To(Equal("date32"))
+		Expect(columns["d"].Values).To(Equal([]interface{}{float64(18263), nil}))
+		Expect(columns["ts"].Type).To(Equal("timestamp"))
+		Expect(columns["ts"].Values).To(Equal([]interface{}{float64(1577934245000000), nil}))
+	})
+})
+
+func pageHeaderSize(data []byte) int64 {
+	reader := compactReader{data: bytes.NewReader(data)}
+	reader.readStruct()
+	return int64(len(data) - reader.data.Len())
+}
@@ -23,9 +23,18 @@ import (
 type FilePathInfo struct {
 	PID                    int
 	SegDirMap              map[int]string
+	SegHostMap             map[int]string
+	SegDbIDMap             map[int]int
 	Timestamp              string
 	UserSpecifiedBackupDir string
 	UserSpecifiedSegPrefix string
+	// Holds the coordinator files instead of the backup directory, if set
+	UserSpecifiedMetadataDir string
+	LayoutTemplate           string
+	DatabaseName             string
+	// The hosts and dbids that {host} and {dbid} stood for when the backup was taken, if recorded
+	LayoutHostMap map[int]string
+	LayoutDbIDMap map[int]int
 }
 
 func NewFilePathInfo(c *cluster.Cluster, userSpecifiedBackupDir string, timestamp string, userSegPrefix string) FilePathInfo {
@@ -35,12 +44,110 @@ func NewFilePathInfo(c *cluster.Cluster, userSpecifiedBackupDir string, timestam
 	backupFPInfo.UserSpecifiedSegPrefix = userSegPrefix
 	backupFPInfo.Timestamp = timestamp
 	backupFPInfo.SegDirMap = make(map[int]string)
+	backupFPInfo.SegHostMap = make(map[int]string)
+	backupFPInfo.SegDbIDMap = make(map[int]int)
 	for _, segment := range c.Segments {
 		backupFPInfo.SegDirMap[segment.ContentID] = segment.DataDir
+		backupFPInfo.SegHostMap[segment.ContentID] = segment.Hostname
+		backupFPInfo.SegDbIDMap[segment.ContentID] = segment.DbID
 	}
 	return backupFPInfo
 }
 
+/*
+ * A layout template replaces the default "<segprefix><content>/backups/<date>/<timestamp>"
+ * layout of the backup directory of each segment, relative to the user specified
+ * backup directory or else to the data directory of the segment.  The database
+ * name is that of the database that was backed up, unquoted.
+ */
+func (backupFPInfo *FilePathInfo) SetLayoutTemplate(layoutTemplate string, databaseName string) {
+	backupFPInfo.LayoutTemplate = layoutTemplate
+	backupFPInfo.DatabaseName = databaseName
+}
+
+/*
+ * Expands {host} and {dbid} in the layout template to the hosts and dbids of the
+ * segments of the cluster that took the backup, which may have been renamed or
+ * moved since, instead of those of the current cluster.
+ */
+func (backupFPInfo *FilePathInfo) SetLayoutSegments(hosts map[int]string, dbids map[int]int) {
+	backupFPInfo.LayoutHostMap = hosts
+	backupFPInfo.LayoutDbIDMap = dbids
+}
+
+var layoutTemplateTokenRegex = regexp.MustCompile(`\{[^{}]*\}`)
+
+var layoutTemplateTokens = map[string]bool{
+	"{host}":      true,
+	"{content}":   true,
+	"{dbid}":      true,
+	"{database}":  true,
+	"{timestamp}": true,
+	"{date}":      true,
+	"{year}":      true,
+	"{month}":     true,
+	"{day}":       true,
+}
+
+func ValidateLayoutTemplate(layoutTemplate string) error {
+	if layoutTemplate == "" {
+		return nil
+	}
+	if path.IsAbs(layoutTemplate) {
+		return fmt.Errorf("Layout template %s must be relative to the backup directory", layoutTemplate)
+	}
+	for _, element := range strings.Split(layoutTemplate, "/") {
+		if element == ".." {
+			return fmt.Errorf("Layout template %s cannot refer to a parent directory", layoutTemplate)
+		}
+	}
+	for _, token := range layoutTemplateTokenRegex.FindAllString(layoutTemplate, -1) {
+		if !layoutTemplateTokens[token] {
+			return fmt.Errorf("Layout template %s contains unknown token %s. Valid tokens are {host}, {content}, {dbid}, {database}, {timestamp}, {date}, {year}, {month}, and {day}", layoutTemplate, token)
+		}
+	}
+	if !strings.Contains(layoutTemplate, "{timestamp}") {
+		return fmt.Errorf("Layout template %s must contain {timestamp}, so that each backup has its own directory", layoutTemplate)
+	}
+	return nil
+}
+
+var layoutDatabaseNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// The database name ends up in paths that are passed unquoted to shell commands on every host
+func (backupFPInfo *FilePathInfo) ValidateLayoutDatabaseName() error {
+	if !strings.Contains(backupFPInfo.LayoutTemplate, "{database}") || layoutDatabaseNameRegex.MatchString(backupFPInfo.DatabaseName) {
+		return nil
+	}
+	return fmt.Errorf("Database name %s cannot be used in a layout template, as it contains characters other than letters, digits, '_', '.', and '-'", backupFPInfo.DatabaseName)
+}
+
+// Whether the template names a directory that differs between segments on the same host
+func LayoutTemplateIsPerSegment(layoutTemplate string) bool {
+	return strings.Contains(layoutTemplate, "{content}") || strings.Contains(layoutTemplate, "{dbid}")
+}
+
+// Whether the template uses tokens that COPY ... ON SEGMENT cannot substitute
+func LayoutTemplateNeedsSegmentConfig(layoutTemplate string) bool {
+	return strings.Contains(layoutTemplate, "{host}") || strings.Contains(layoutTemplate, "{dbid}")
+}
+
+func (backupFPInfo *FilePathInfo) expandLayoutTemplate(content string, host string, dbid string) string {
+	timestamp := backupFPInfo.Timestamp
+	replacer := strings.NewReplacer(
+		"{host}", host,
+		"{content}", content,
+		"{dbid}", dbid,
+		"{database}", backupFPInfo.DatabaseName,
+		"{timestamp}", timestamp,
+		"{date}", timestamp[0:8],
+		"{year}", timestamp[0:4],
+		"{month}", timestamp[4:6],
+		"{day}", timestamp[6:8],
+	)
+	return replacer.Replace(backupFPInfo.LayoutTemplate)
+}
+
 /*
  * Restoring a future-dated backup is allowed (e.g. the backup was taken in a
  * different time zone that is ahead of the restore time zone), so only check
@@ -55,7 +162,29 @@ func (backupFPInfo *FilePathInfo) IsUserSpecifiedBackupDir() bool {
 	return backupFPInfo.UserSpecifiedBackupDir != ""
 }
 
+/*
+ * The coordinator files are laid out in the user specified metadata directory,
+ * if any, as they would be in a user specified backup directory.
+ */
 func (backupFPInfo *FilePathInfo) GetDirForContent(contentID int) string {
+	if contentID == -1 && backupFPInfo.UserSpecifiedMetadataDir != "" {
+		metadataFPInfo := *backupFPInfo
+		metadataFPInfo.UserSpecifiedBackupDir = backupFPInfo.UserSpecifiedMetadataDir
+		metadataFPInfo.UserSpecifiedMetadataDir = ""
+		return metadataFPInfo.GetDirForContent(contentID)
+	}
+	if backupFPInfo.LayoutTemplate != "" {
+		baseDir := backupFPInfo.SegDirMap[contentID]
+		if backupFPInfo.IsUserSpecifiedBackupDir() {
+			baseDir = backupFPInfo.UserSpecifiedBackupDir
+		}
+		host, dbid := backupFPInfo.SegHostMap[contentID], backupFPInfo.SegDbIDMap[contentID]
+		if recordedHost, ok := backupFPInfo.LayoutHostMap[contentID]; ok {
+			host, dbid = recordedHost, backupFPInfo.LayoutDbIDMap[contentID]
+		}
+		layoutDir := backupFPInfo.expandLayoutTemplate(strconv.Itoa(contentID), host, strconv.Itoa(dbid))
+		return path.Join(baseDir, layoutDir)
+	}
 	if backupFPInfo.IsUserSpecifiedBackupDir() {
 		segDir := fmt.Sprintf("%s%d", backupFPInfo.UserSpecifiedSegPrefix, contentID)
 		return path.Join(backupFPInfo.UserSpecifiedBackupDir, segDir, "backups", backupFPInfo.Timestamp[0:8], backupFPInfo.Timestamp)
@@ -78,24 +207,38 @@ func (backupFPInfo *FilePathInfo) GetSegmentPipePathForCopyCommand() string {
 }
 
 func (backupFPInfo *FilePathInfo) GetTableBackupFilePath(contentID int, tableOid uint32, extension string, singleDataFile bool) string {
+	if backupFPInfo.LayoutTemplate != "" {
+		backupFilePath := backupFPInfo.getTableBackupFileName(tableOid, extension, singleDataFile)
+		return path.Join(backupFPInfo.GetDirForContent(contentID), backupFPInfo.replaceCopyFormatStringsInPath(backupFilePath, contentID))
+	}
 	templateFilePath := backupFPInfo.GetTableBackupFilePathForCopyCommand(tableOid, extension, singleDataFile)
 	return backupFPInfo.replaceCopyFormatStringsInPath(templateFilePath, contentID)
 }
 
 func (backupFPInfo *FilePathInfo) GetTableBackupFilePathForCopyCommand(tableOid uint32, extension string, singleDataFile bool) string {
-	backupFilePath := fmt.Sprintf("gpbackup_<SEGID>_%s", backupFPInfo.Timestamp)
-	if !singleDataFile {
-		backupFilePath += fmt.Sprintf("_%d", tableOid)
-	}
-
-	backupFilePath += extension
+	backupFilePath := backupFPInfo.getTableBackupFileName(tableOid, extension, singleDataFile)
 	baseDir := "<SEG_DATA_DIR>"
 	if backupFPInfo.IsUserSpecifiedBackupDir() {
-		baseDir = path.Join(backupFPInfo.UserSpecifiedBackupDir, fmt.Sprintf("%s<SEGID>", backupFPInfo.UserSpecifiedSegPrefix))
+		baseDir = backupFPInfo.UserSpecifiedBackupDir
+	}
+	if backupFPInfo.LayoutTemplate != "" {
+		// {host} and {dbid} are only allowed for backups whose data is not written by COPY ... ON SEGMENT
+		return path.Join(baseDir, backupFPInfo.expandLayoutTemplate("<SEGID>", "", ""), backupFilePath)
+	}
+	if backupFPInfo.IsUserSpecifiedBackupDir() {
+		baseDir = path.Join(baseDir, fmt.Sprintf("%s<SEGID>", backupFPInfo.UserSpecifiedSegPrefix))
 	}
 	return path.Join(baseDir, "backups", backupFPInfo.Timestamp[0:8], backupFPInfo.Timestamp, backupFilePath)
 }
 
+func (backupFPInfo *FilePathInfo) getTableBackupFileName(tableOid uint32, extension string, singleDataFile bool) string {
+	backupFilePath := fmt.Sprintf("gpbackup_<SEGID>_%s", backupFPInfo.Timestamp)
+	if !singleDataFile {
+		backupFilePath += fmt.Sprintf("_%d", tableOid)
+	}
+	return backupFilePath + extension
+}
+
 var metadataFilenameMap = map[string]string{
 	"config":                "config.yaml",
 	"metadata":              "metadata.sql",
@@ -105,6 +248,7 @@ var metadataFilenameMap = map[string]string{
 	"plugin_config":         "plugin_config.yaml",
 	"error_tables_metadata": "error_tables_metadata",
 	"error_tables_data":     "error_tables_data",
+	"max_bandwidth":         "max_bandwidth",
 }
 
 func (backupFPInfo *FilePathInfo) GetBackupFilePath(filetype string) string {
@@ -161,6 +305,10 @@ func (backupFPInfo *FilePathInfo) GetSegmentTOCFilePath(contentID int) string {
 	return fmt.Sprintf("%s/gpbackup_%d_%s_toc.yaml", backupFPInfo.GetDirForContent(contentID), contentID, backupFPInfo.Timestamp)
 }
 
+func (backupFPInfo *FilePathInfo) GetBandwidthControlFilePath() string {
+	return backupFPInfo.GetBackupFilePath("max_bandwidth")
+}
+
 func (backupFPInfo *FilePathInfo) GetPluginConfigPath() string {
 	return backupFPInfo.GetBackupFilePath("plugin_config")
 }
@@ -169,6 +317,10 @@ func (backupFPInfo *FilePathInfo) GetSegmentHelperFilePath(contentID int, suffix
 	return path.Join(backupFPInfo.SegDirMap[contentID], fmt.Sprintf("gpbackup_%d_%s_%s_%d", contentID, backupFPInfo.Timestamp, suffix, backupFPInfo.PID))
 }
 
+func (backupFPInfo *FilePathInfo) GetSegmentHelperFilePathForCopyCommand(suffix string) string {
+	return fmt.Sprintf("<SEG_DATA_DIR>/gpbackup_<SEGID>_%s_%s_%d", backupFPInfo.Timestamp, suffix, backupFPInfo.PID)
+}
+
 func (backupFPInfo *FilePathInfo) GetHelperLogPath() string {
 	currentUser, _ := operating.System.CurrentUser()
 	homeDir := currentUser.HomeDir
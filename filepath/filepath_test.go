@@ -125,6 +125,111 @@ var _ = Describe("filepath tests", func() {
 			Expect(fpInfo.GetTableBackupFilePath(-1, 1234, "", true)).To(Equal("/foo/bar/gpseg-1/backups/20170101/20170101010101/gpbackup_-1_20170101010101"))
 		})
 	})
+	Describe("metadata directory", func() {
+		BeforeEach(func() {
+			c = cluster.NewCluster([]cluster.SegConfig{
+				{ContentID: -1, DataDir: coordinatorDir},
+				{ContentID: 0, DataDir: segDirOne},
+			})
+		})
+		It("puts the coordinator files in the metadata directory and the segment data in the backup directory", func() {
+			fpInfo := NewFilePathInfo(c, "/foo/data", "20170101010101", "gpseg")
+			fpInfo.UserSpecifiedMetadataDir = "/foo/metadata"
+			Expect(fpInfo.GetConfigFilePath()).To(Equal("/foo/metadata/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_config.yaml"))
+			Expect(fpInfo.GetRestoreReportFilePath("20170102010101")).To(Equal("/foo/metadata/gpseg-1/backups/20170101/20170101010101/gprestore_20170101010101_20170102010101_report"))
+			Expect(fpInfo.GetDirForContent(0)).To(Equal("/foo/data/gpseg0/backups/20170101/20170101010101"))
+			Expect(fpInfo.GetTableBackupFilePathForCopyCommand(1234, "", false)).To(Equal("/foo/data/gpseg<SEGID>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_1234"))
+		})
+		It("puts the segment data in the segment data directories without a backup directory", func() {
+			fpInfo := NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			fpInfo.UserSpecifiedMetadataDir = "/foo/metadata"
+			Expect(fpInfo.GetTOCFilePath()).To(Equal("/foo/metadata/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_toc.yaml"))
+			Expect(fpInfo.GetDirForContent(0)).To(Equal("/data/gpseg0/backups/20170101/20170101010101"))
+		})
+		It("lays out the metadata directory with the layout template", func() {
+			fpInfo := NewFilePathInfo(c, "", "20170101010101", "")
+			fpInfo.UserSpecifiedMetadataDir = "/foo/metadata"
+			fpInfo.SetLayoutTemplate("{database}/{timestamp}", "testdb")
+			Expect(fpInfo.GetDirForContent(-1)).To(Equal("/foo/metadata/testdb/20170101010101"))
+			Expect(fpInfo.GetDirForContent(0)).To(Equal("/data/gpseg0/testdb/20170101010101"))
+		})
+	})
+	Describe("layout templates", func() {
+		BeforeEach(func() {
+			c = cluster.NewCluster([]cluster.SegConfig{
+				{ContentID: -1, DbID: 1, Hostname: "cdw", DataDir: coordinatorDir},
+				{ContentID: 0, DbID: 2, Hostname: "sdw1", DataDir: segDirOne},
+				{ContentID: 1, DbID: 3, Hostname: "sdw2", DataDir: segDirTwo},
+			})
+		})
+		It("lays out the content directories with the template under the user specified path", func() {
+			fpInfo := NewFilePathInfo(c, "/foo/bar", "20170101010101", "")
+			fpInfo.SetLayoutTemplate("{database}/{host}/seg{content}-{dbid}/{year}/{month}/{day}/{timestamp}", "testdb")
+			Expect(fpInfo.GetDirForContent(-1)).To(Equal("/foo/bar/testdb/cdw/seg-1-1/2017/01/01/20170101010101"))
+			Expect(fpInfo.GetDirForContent(1)).To(Equal("/foo/bar/testdb/sdw2/seg1-3/2017/01/01/20170101010101"))
+			Expect(fpInfo.GetConfigFilePath()).To(Equal("/foo/bar/testdb/cdw/seg-1-1/2017/01/01/20170101010101/gpbackup_20170101010101_config.yaml"))
+			Expect(fpInfo.GetSegmentTOCFilePath(0)).To(Equal("/foo/bar/testdb/sdw1/seg0-2/2017/01/01/20170101010101/gpbackup_0_20170101010101_toc.yaml"))
+		})
+		It("lays out the content directories with the template under the segment data directories", func() {
+			fpInfo := NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			fpInfo.SetLayoutTemplate("gpbackup/{database}/{date}/{timestamp}", "testdb")
+			Expect(fpInfo.GetDirForContent(0)).To(Equal("/data/gpseg0/gpbackup/testdb/20170101/20170101010101"))
+		})
+		It("returns table file paths in the content directories", func() {
+			fpInfo := NewFilePathInfo(c, "/foo/bar", "20170101010101", "")
+			fpInfo.SetLayoutTemplate("{host}/{dbid}/{timestamp}", "testdb")
+			Expect(fpInfo.GetTableBackupFilePath(1, 0, ".gz", true)).To(Equal("/foo/bar/sdw2/3/20170101010101/gpbackup_1_20170101010101.gz"))
+			Expect(fpInfo.GetTableBackupFilePath(1, 1234, ".gz", false)).To(Equal("/foo/bar/sdw2/3/20170101010101/gpbackup_1_20170101010101_1234.gz"))
+		})
+		It("expands {host} and {dbid} to the hosts and dbids recorded when the backup was taken", func() {
+			fpInfo := NewFilePathInfo(c, "/foo/bar", "20170101010101", "")
+			fpInfo.SetLayoutTemplate("{host}/{dbid}/{timestamp}", "testdb")
+			fpInfo.SetLayoutSegments(map[int]string{-1: "old-cdw", 0: "old-sdw1", 1: "old-sdw2"}, map[int]int{-1: 1, 0: 5, 1: 6})
+			Expect(fpInfo.GetDirForContent(-1)).To(Equal("/foo/bar/old-cdw/1/20170101010101"))
+			Expect(fpInfo.GetDirForContent(1)).To(Equal("/foo/bar/old-sdw2/6/20170101010101"))
+		})
+		It("returns table file path for copy command with the content id substituted by the segment", func() {
+			fpInfo := NewFilePathInfo(c, "/foo/bar", "20170101010101", "")
+			fpInfo.SetLayoutTemplate("{database}/seg{content}/{timestamp}", "testdb")
+			Expect(fpInfo.GetTableBackupFilePathForCopyCommand(1234, ".gz", false)).To(Equal("/foo/bar/testdb/seg<SEGID>/20170101010101/gpbackup_<SEGID>_20170101010101_1234.gz"))
+		})
+		It("returns table file path for copy command under the segment data directories", func() {
+			fpInfo := NewFilePathInfo(c, "", "20170101010101", "")
+			fpInfo.SetLayoutTemplate("gpbackup/{timestamp}", "testdb")
+			Expect(fpInfo.GetTableBackupFilePathForCopyCommand(1234, "", false)).To(Equal("<SEG_DATA_DIR>/gpbackup/20170101010101/gpbackup_<SEGID>_20170101010101_1234"))
+		})
+		It("does not change the helper file paths in the segment data directories", func() {
+			fpInfo := NewFilePathInfo(c, "/foo/bar", "20170101010101", "")
+			fpInfo.SetLayoutTemplate("{database}/seg{content}/{timestamp}", "testdb")
+			Expect(fpInfo.GetSegmentPipeFilePath(0)).To(HavePrefix("/data/gpseg0/gpbackup_0_20170101010101_pipe_"))
+		})
+		DescribeTable("ValidateLayoutTemplate accepts valid templates",
+			func(layoutTemplate string) {
+				Expect(ValidateLayoutTemplate(layoutTemplate)).To(Succeed())
+			},
+			Entry("no template", ""),
+			Entry("per database", "{database}/seg{content}/{date}/{timestamp}"),
+			Entry("per host", "{host}/{dbid}/{year}{month}{day}/{timestamp}"),
+		)
+		DescribeTable("ValidateLayoutTemplate rejects invalid templates",
+			func(layoutTemplate string, expectedError string) {
+				err := ValidateLayoutTemplate(layoutTemplate)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(expectedError))
+			},
+			Entry("no timestamp", "{database}/seg{content}/{date}", "must contain {timestamp}"),
+			Entry("an absolute path", "/backups/seg{content}/{timestamp}", "must be relative to the backup directory"),
+			Entry("a parent directory", "../seg{content}/{timestamp}", "cannot refer to a parent directory"),
+			Entry("an unknown token", "{hostname}/seg{content}/{timestamp}", "contains unknown token {hostname}"),
+		)
+		It("rejects database names that are not safe to use in a path", func() {
+			fpInfo := NewFilePathInfo(c, "/foo/bar", "20170101010101", "")
+			fpInfo.SetLayoutTemplate("{database}/seg{content}/{timestamp}", "test db")
+			Expect(fpInfo.ValidateLayoutDatabaseName()).To(MatchError(ContainSubstring("Database name test db cannot be used in a layout template")))
+			fpInfo.SetLayoutTemplate("seg{content}/{timestamp}", "test db")
+			Expect(fpInfo.ValidateLayoutDatabaseName()).To(Succeed())
+		})
+	})
 	Describe("ParseSegPrefix", func() {
 		AfterEach(func() {
 			operating.System.Glob = path.Glob
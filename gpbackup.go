@@ -6,6 +6,7 @@ import (
 	"os"
 
 	. "github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/export"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/spf13/cobra"
 )
@@ -22,8 +23,20 @@ func main() {
 			DoSetup()
 			DoBackup()
 		}}
+	var exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export the data of a backup to one CSV or Parquet file per table, without a database connection",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			defer export.DoTeardown()
+			export.DoValidation(cmd)
+			export.DoExport()
+		}}
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.AddCommand(exportCmd)
 	rootCmd.SetArgs(options.HandleSingleDashes(os.Args[1:]))
 	DoInit(rootCmd)
+	export.DoInit(exportCmd)
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(2)
 	}
@@ -21,6 +21,7 @@ func main() {
 			DoValidation(cmd)
 			DoSetup()
 			DoRestore()
+			DoFollow()
 		}}
 	rootCmd.SetArgs(options.HandleSingleDashes(os.Args[1:]))
 	DoInit(rootCmd)
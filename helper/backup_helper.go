@@ -3,11 +3,15 @@ package helper
 import (
 	"bufio"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"os"
 	"os/exec"
 	"strings"
 
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/pkg/errors"
@@ -40,11 +44,17 @@ func doBackupAgent() error {
 	 * and properly clean it up if an error occurs while creating the writer.
 	 */
 	for i, oid := range oidList {
+		setCurrentOid(oid)
+		setPhase(utils.PHASE_PIPE)
 		currentPipe = fmt.Sprintf("%s_%d", *pipeFile, oidList[i])
 		if wasTerminated {
 			logError("Terminated due to user request")
 			return errors.New("Terminated due to user request")
 		}
+		if isStopRequested() {
+			log(fmt.Sprintf("Oid %d: Stopping before the table, as requested by the coordinator", oid))
+			break
+		}
 		if i < len(oidList)-*copyQueue {
 			nextPipeToCreate := fmt.Sprintf("%s_%d", *pipeFile, oidList[i+*copyQueue])
 			log(fmt.Sprintf("Oid %d: Creating pipe %s\n", oidList[i+*copyQueue], nextPipeToCreate))
@@ -61,6 +71,13 @@ func doBackupAgent() error {
 			logError(fmt.Sprintf("Oid %d: Error encountered getting backup pipe reader: %v", oid, err))
 			return err
 		}
+		// The pipe was opened by wakeUpPipeReader rather than by a COPY
+		if isStopRequested() {
+			log(fmt.Sprintf("Oid %d: Stopping before the table, as requested by the coordinator", oid))
+			_ = readHandle.Close()
+			deletePipe(currentPipe)
+			break
+		}
 		if i == 0 {
 			pipeWriter, writeCmd, err = getBackupPipeWriter()
 			if err != nil {
@@ -70,12 +87,14 @@ func doBackupAgent() error {
 		}
 
 		log(fmt.Sprintf("Oid %d: Backing up table with pipe %s", oid, currentPipe))
-		numBytes, err := io.Copy(pipeWriter, reader)
+		setPhase(utils.PHASE_COPY)
+		numBytes, err := io.Copy(progressWriter{pipeWriter}, reader)
 		if err != nil {
 			logError(fmt.Sprintf("Oid %d: Error encountered copying bytes from pipeWriter to reader: %v", oid, err))
 			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
 		}
 		log(fmt.Sprintf("Oid %d: Read %d bytes\n", oid, numBytes))
+		reportProgress(oid, numBytes)
 
 		lastProcessed := lastRead + uint64(numBytes)
 		tocfile.AddSegmentDataEntry(uint(oid), lastRead, lastProcessed)
@@ -86,8 +105,12 @@ func doBackupAgent() error {
 		deletePipe(currentPipe)
 	}
 
-	_ = pipeWriter.Close()
-	if *pluginConfigFile != "" {
+	setCurrentOid(0)
+	// An agent stopped before its first table has no data file to write
+	if pipeWriter != nil {
+		_ = pipeWriter.Close()
+	}
+	if writeCmd != nil {
 		/*
 		 * When using a plugin, the agent may take longer to finish than the
 		 * main gpbackup process. We either write the TOC file if the agent finishes
@@ -95,13 +118,26 @@ func doBackupAgent() error {
 		 * finished. We then wait on the gpbackup side until one of those files is
 		 * written to verify the agent completed.
 		 */
-		log("Uploading remaining data to plugin destination")
+		if *offloadHost != "" {
+			log(fmt.Sprintf("Waiting for the offload agent on host %s to write remaining data", *offloadHost))
+		} else {
+			log("Uploading remaining data to plugin destination")
+		}
+		setPhase(utils.PHASE_PLUGIN)
 		err := writeCmd.Wait()
 		if err != nil {
 			logError(fmt.Sprintf("Error encountered writing either TOC file or error file: %v", err))
 			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
 		}
+		if *offloadHost != "" {
+			err = verifyOffloadChecksum(lastRead)
+			if err != nil {
+				logError(fmt.Sprintf("Error encountered verifying the data written by the offload agent: %v", err))
+				return err
+			}
+		}
 	}
+	setPhase(utils.PHASE_TOC)
 	err = tocfile.WriteToFileAndMakeReadOnly(*tocFile)
 	if err != nil {
 		// error logging handled in util.go
@@ -124,8 +160,85 @@ func getBackupPipeReader(currentPipe string) (io.Reader, io.ReadCloser, error) {
 	return reader, readHandle, nil
 }
 
+/*
+ * Runs on the host of the mirror of a segment, for a backup agent started with
+ * --offload-host, so that the data that the backup agent reads from its pipes
+ * is compressed and written or uploaded here rather than on the primary host.
+ * Errors reach the backup agent through stderr and the exit code of SSH, and
+ * once the data is written, the checksum of the data received is printed to
+ * stdout for the backup agent to compare with that of the data it sent.
+ */
+func doOffloadAgent() error {
+	pipeWriter, writeCmd, err := getBackupPipeWriter()
+	if err != nil {
+		logError(fmt.Sprintf("Error encountered getting backup pipe writer: %v", err))
+		return err
+	}
+	checksum := crc32.NewIEEE()
+	numBytes, err := io.Copy(pipeWriter, io.TeeReader(bufio.NewReader(os.Stdin), checksum))
+	if err != nil {
+		logError(fmt.Sprintf("Error encountered copying bytes from stdin to pipeWriter: %v", err))
+		return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
+	}
+	err = pipeWriter.Close()
+	if err != nil {
+		logError(fmt.Sprintf("Error encountered closing pipeWriter: %v", err))
+		return err
+	}
+	if writeCmd != nil {
+		log("Uploading remaining data to plugin destination")
+		err = writeCmd.Wait()
+		if err != nil {
+			logError(fmt.Sprintf("Error encountered uploading data to plugin destination: %v", err))
+			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
+		}
+	}
+	log(fmt.Sprintf("Wrote %d bytes streamed from the backup agent", numBytes))
+	fmt.Printf("%s%d %08x\n", offloadChecksumPrefix, numBytes, checksum.Sum32())
+	return nil
+}
+
+// Prefixes the line with which the offload agent reports the bytes and checksum of the data it received
+const offloadChecksumPrefix = "Offload checksum: "
+
+/*
+ * Compares the bytes and checksum of the data streamed to the offload agent
+ * with those that it reports, so that data lost or corrupted on the way to
+ * the host of the mirror fails the backup rather than being found on restore.
+ */
+func verifyOffloadChecksum(numBytes uint64) error {
+	for _, line := range strings.Split(offloadOutput.String(), "\n") {
+		if !strings.HasPrefix(line, offloadChecksumPrefix) {
+			continue
+		}
+		var receivedBytes uint64
+		var receivedChecksum uint32
+		_, err := fmt.Sscanf(strings.TrimPrefix(line, offloadChecksumPrefix), "%d %x", &receivedBytes, &receivedChecksum)
+		if err != nil {
+			return errors.Wrapf(err, "Could not parse the checksum reported by the offload agent on host %s", *offloadHost)
+		}
+		if receivedBytes != numBytes || receivedChecksum != offloadChecksum.Sum32() {
+			return errors.Errorf("The offload agent on host %s received %d bytes with checksum %08x, but %d bytes with checksum %08x were sent",
+				*offloadHost, receivedBytes, receivedChecksum, numBytes, offloadChecksum.Sum32())
+		}
+		log(fmt.Sprintf("Verified the checksum %08x of the %d bytes received by the offload agent", receivedChecksum, receivedBytes))
+		return nil
+	}
+	return errors.Errorf("The offload agent on host %s reported no checksum for the data it received", *offloadHost)
+}
+
 func getBackupPipeWriter() (pipe BackupPipeWriterCloser, writeCmd *exec.Cmd, err error) {
 	var writeHandle io.WriteCloser
+	if *offloadHost != "" {
+		writeCmd, writeHandle, err = startBackupOffloadCommand()
+		if err != nil {
+			// error logging handled by calling functions
+			return nil, nil, err
+		}
+		// The offload agent compresses the data
+		pipe = NewCommonBackupPipeWriterCloser(writeHandle)
+		return
+	}
 	if *pluginConfigFile != "" {
 		writeCmd, writeHandle, err = startBackupPluginCommand()
 	} else {
@@ -135,6 +248,10 @@ func getBackupPipeWriter() (pipe BackupPipeWriterCloser, writeCmd *exec.Cmd, err
 		// error logging handled by calling functions
 		return nil, nil, err
 	}
+	// Throttle the compressed stream, which is what goes to storage
+	if bandwidthLimiter != nil {
+		writeHandle = utils.NewThrottledWriter(writeHandle, bandwidthLimiter)
+	}
 
 	if *compressionLevel == 0 {
 		pipe = NewCommonBackupPipeWriterCloser(writeHandle)
@@ -163,17 +280,58 @@ func startBackupPluginCommand() (*exec.Cmd, io.WriteCloser, error) {
 	}
 	cmdStr := fmt.Sprintf("%s backup_data %s %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath, *dataFile)
 	writeCmd := exec.Command("bash", "-c", cmdStr)
+	writeHandle, err := startWriteCommand(writeCmd)
+	if err != nil {
+		// error logging handled by calling functions
+		return nil, nil, err
+	}
+	return writeCmd, writeHandle, nil
+}
 
-	writeHandle, err := writeCmd.StdinPipe()
+func startBackupOffloadCommand() (*exec.Cmd, io.WriteCloser, error) {
+	gphomePath := operating.System.Getenv("GPHOME")
+	pluginStr := ""
+	if *pluginConfigFile != "" {
+		pluginStr = fmt.Sprintf(" --plugin-config %s", *pluginConfigFile)
+	}
+	helperCmdStr := fmt.Sprintf(`source %[1]s/greenplum_path.sh && %[1]s/bin/gpbackup_helper --offload-agent --content %d --data-file "%s" --compression-level %d --compression-type %s%s`,
+		gphomePath, *content, *dataFile, *compressionLevel, *compressionType, pluginStr)
+	sshCmd := cluster.ConstructSSHCommand(false, *offloadHost, helperCmdStr)
+	writeCmd := exec.Command(sshCmd[0], sshCmd[1:]...)
+	writeCmd.Stdout = &offloadOutput
+	writeHandle, err := startWriteCommand(writeCmd)
 	if err != nil {
 		// error logging handled by calling functions
 		return nil, nil, err
 	}
+	offloadChecksum = crc32.NewIEEE()
+	return writeCmd, checksumWriteCloser{writeHandle, offloadChecksum}, nil
+}
+
+// Adds the data written to the checksum, as well as writing it on
+type checksumWriteCloser struct {
+	io.WriteCloser
+	checksum hash.Hash32
+}
+
+func (w checksumWriteCloser) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	_, _ = w.checksum.Write(p[:n])
+	return n, err
+}
+
+// Starts a command that writes the data passed to its stdin, collecting its stderr in errBuf
+func startWriteCommand(writeCmd *exec.Cmd) (io.WriteCloser, error) {
+	writeHandle, err := writeCmd.StdinPipe()
+	if err != nil {
+		// error logging handled by calling functions
+		return nil, err
+	}
 	writeCmd.Stderr = &errBuf
 	err = writeCmd.Start()
 	if err != nil {
 		// error logging handled by calling functions
-		return nil, nil, err
+		return nil, err
 	}
-	return writeCmd, writeHandle, nil
+	return writeHandle, nil
 }
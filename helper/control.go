@@ -0,0 +1,208 @@
+package helper
+
+/*
+ * This file contains the agent side of the control channel to gpbackup or
+ * gprestore, which is only used when --control-address is passed, and the
+ * error records that agents report.  Errors are recorded in the error file as
+ * well, for coordinators that check it over SSH.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	controlClient *utils.HelperControlClient
+	// Oids that the coordinator told the agent to skip
+	skippedOids sync.Map
+	// The oid being backed up or restored and what is being done with it,
+	// for heartbeats and error reports
+	progressMutex sync.Mutex
+	currentOid    int
+	currentPhase  = utils.PHASE_SETUP
+	// Total bytes copied between pipes and data files
+	bytesMoved int64
+	// Set when the coordinator told a backup agent to stop after the table in progress
+	stopRequested int32
+)
+
+func setCurrentOid(oid int) {
+	progressMutex.Lock()
+	defer progressMutex.Unlock()
+	currentOid = oid
+}
+
+func setPhase(phase string) {
+	progressMutex.Lock()
+	defer progressMutex.Unlock()
+	currentPhase = phase
+}
+
+func getProgress() (int, string) {
+	progressMutex.Lock()
+	defer progressMutex.Unlock()
+	return currentOid, currentPhase
+}
+
+// Counts the bytes written through it, so that heartbeats show progress within a table
+type progressWriter struct {
+	io.Writer
+}
+
+func (writer progressWriter) Write(p []byte) (int, error) {
+	n, err := writer.Writer.Write(p)
+	atomic.AddInt64(&bytesMoved, int64(n))
+	return n, err
+}
+
+func connectControlChannel() {
+	if *controlAddress == "" {
+		return
+	}
+	secret, err := os.ReadFile(*secretFile)
+	if err != nil {
+		log("Unable to read the secret of the control channel, so only files will be used: %v", err)
+		return
+	}
+	hostname, _ := os.Hostname()
+	start := utils.ControlMessage{Content: *content, Agent: *pipeFile, Host: hostname, Secret: strings.TrimSpace(string(secret))}
+	controlClient, err = utils.DialHelperControl(*controlAddress, start, handleControlMessage)
+	if err != nil {
+		log("Unable to connect to the control channel at %s, so only files will be used: %v", *controlAddress, err)
+		controlClient = nil
+		return
+	}
+	go sendHeartbeats(controlClient)
+}
+
+// Reports what the agent is doing until the control channel is closed, so that gpbackup or gprestore can detect stalls
+func sendHeartbeats(client *utils.HelperControlClient) {
+	ticker := time.NewTicker(utils.HELPER_HEARTBEAT_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		oid, phase := getProgress()
+		heartbeat := utils.ControlMessage{Type: utils.CONTROL_HEARTBEAT, Oid: oid, Phase: phase, Bytes: atomic.LoadInt64(&bytesMoved), Time: time.Now().Unix()}
+		if client.Send(heartbeat) != nil {
+			return
+		}
+	}
+}
+
+func handleControlMessage(message utils.ControlMessage) {
+	switch message.Type {
+	case utils.CONTROL_SKIP:
+		log("Oid %d: Told to skip entry by the coordinator", message.Oid)
+		skippedOids.Store(message.Oid, true)
+	case utils.CONTROL_STOP:
+		log("Told to stop after the table in progress by the coordinator")
+		atomic.StoreInt32(&stopRequested, 1)
+		go wakeUpPipeReader()
+	case utils.CONTROL_CANCEL:
+		// Stop the same way as when the coordinator signals the agent over SSH
+		_ = unix.Kill(os.Getpid(), unix.SIGUSR1)
+	}
+}
+
+func isStopRequested() bool {
+	return atomic.LoadInt32(&stopRequested) == 1
+}
+
+/*
+ * An agent told to stop may be waiting for gpbackup to open the pipe of a
+ * table that will never be backed up, so the pipe is opened for writing here
+ * to let it see the stop request.  The agent may not have opened the pipe for
+ * reading yet, in which case opening it fails and is tried again.
+ */
+func wakeUpPipeReader() {
+	for {
+		oid, phase := getProgress()
+		if phase != utils.PHASE_PIPE || oid == 0 {
+			return
+		}
+		fd, err := unix.Open(fmt.Sprintf("%s_%d", *pipeFile, oid), unix.O_WRONLY|unix.O_NONBLOCK, 0)
+		if err == nil {
+			_ = unix.Close(fd)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func isOidSkipped(oid int) bool {
+	_, skipped := skippedOids.Load(oid)
+	return skipped
+}
+
+func sendControlMessage(message utils.ControlMessage) {
+	if controlClient == nil {
+		return
+	}
+	err := controlClient.Send(message)
+	if err != nil {
+		log("Unable to send %s message to the coordinator: %v", message.Type, err)
+	}
+}
+
+func reportProgress(oid int, numBytes int64) {
+	sendControlMessage(utils.ControlMessage{Type: utils.CONTROL_PROGRESS, Oid: oid, Bytes: numBytes})
+}
+
+func newErrorRecord(oid int, err error) *utils.AgentError {
+	hostname, _ := os.Hostname()
+	_, phase := getProgress()
+	record := &utils.AgentError{Content: *content, Host: hostname, Oid: oid, Phase: phase, Message: err.Error()}
+	// Plugin and compression errors are often only explained by their output
+	detail := strings.TrimSpace(strings.Trim(errBuf.String(), "\x00"))
+	if detail != "" && !strings.Contains(record.Message, detail) {
+		record.Detail = detail
+	}
+	return record
+}
+
+// Appends the record to the error file, whose presence tells the coordinator that the agent failed
+func writeErrorRecord(record *utils.AgentError) {
+	contents, err := json.Marshal(record)
+	if err != nil {
+		contents = []byte{}
+	}
+	handle, err := os.OpenFile(fmt.Sprintf("%s_error", *pipeFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logError("Unable to write error file: %v", err)
+		return
+	}
+	_, _ = handle.Write(append(contents, '\n'))
+	_ = handle.Close()
+}
+
+// Reports an error that the agent continued past with --on-error-continue
+func reportTableError(oid int, err error) {
+	sendControlMessage(utils.ControlMessage{Type: utils.CONTROL_TABLE_ERROR, Record: newErrorRecord(oid, err)})
+}
+
+// Reports the error that stopped the agent, or its completion if err is nil
+func reportResult(err error) {
+	if err == nil {
+		sendControlMessage(utils.ControlMessage{Type: utils.CONTROL_DONE})
+		return
+	}
+	oid, _ := getProgress()
+	record := newErrorRecord(oid, err)
+	writeErrorRecord(record)
+	sendControlMessage(utils.ControlMessage{Type: utils.CONTROL_ERROR, Record: record})
+}
+
+func closeControlChannel() {
+	if controlClient != nil {
+		controlClient.Close()
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -12,12 +14,14 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sys/unix"
 
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 )
 
 /*
@@ -32,6 +36,11 @@ var (
 	writeHandle   *os.File
 	writer        *bufio.Writer
 	pipesMap      map[string]bool
+	// Set when --max-bandwidth is passed, nil otherwise
+	bandwidthLimiter *utils.RateLimiter
+	// Set when --offload-host is passed, to verify the data received by the offload agent
+	offloadChecksum hash.Hash32
+	offloadOutput   bytes.Buffer
 )
 
 /*
@@ -57,6 +66,15 @@ var (
 	origSize         *int
 	destSize         *int
 	replicationFile  *string
+	throttleAgent    *bool
+	maxBandwidth     *int64
+	bandwidthShare   *int
+	bandwidthFile    *string
+	controlAddress   *string
+	secretFile       *string
+	offloadHost      *string
+	offloadAgent     *bool
+	sourceFile       *string
 )
 
 func DoHelper() {
@@ -72,16 +90,23 @@ func DoHelper() {
 
 	InitializeGlobals()
 	go InitializeSignalHandler()
+	initializeBandwidthLimiter()
+	if reportsToCoordinator() {
+		connectControlChannel()
+	}
 
 	if *backupAgent {
 		err = doBackupAgent()
 	} else if *restoreAgent {
 		err = doRestoreAgent()
+	} else if *throttleAgent {
+		err = doThrottleAgent()
+	} else if *offloadAgent {
+		err = doOffloadAgent()
 	}
-	if err != nil {
+	if reportsToCoordinator() {
 		// error logging handled in doBackupAgent and doRestoreAgent
-		handle, _ := utils.OpenFileForWrite(fmt.Sprintf("%s_error", *pipeFile))
-		_ = handle.Close()
+		reportResult(err)
 	}
 }
 
@@ -109,6 +134,15 @@ func InitializeGlobals() {
 	origSize = flag.Int("orig-seg-count", 0, "Used with resize restore.  Gives the segment count of the backup.")
 	destSize = flag.Int("dest-seg-count", 0, "Used with resize restore.  Gives the segment count of the current cluster.")
 	replicationFile = flag.String("replication-file", "", "Used with resize restore.  Gives the list of replicated tables.")
+	throttleAgent = flag.Bool("throttle-agent", false, "Use gpbackup_helper to copy stdin to stdout subject to --max-bandwidth")
+	maxBandwidth = flag.Int64("max-bandwidth", 0, "Maximum data throughput per segment host in bytes per second. 0 indicates no limit")
+	bandwidthShare = flag.Int("bandwidth-share", 1, "Number of data streams per segment host that share --max-bandwidth")
+	bandwidthFile = flag.String("bandwidth-file", "", "Absolute path to a file whose contents override --max-bandwidth while running")
+	controlAddress = flag.String("control-address", "", "Address of the control channel of gpbackup or gprestore, in the format host:port")
+	secretFile = flag.String("control-secret-file", "", "Absolute path to a file, readable only by the user, holding the secret of the run to present to the control channel")
+	offloadHost = flag.String("offload-host", "", "Host to which to stream backup data over SSH, to be compressed and written by a gpbackup_helper offload agent there")
+	sourceFile = flag.String("source-file", "", "Absolute path to a file listing the content, host, and backup directory of each segment of the cluster from which to stream backup files")
+	offloadAgent = flag.Bool("offload-agent", false, "Use gpbackup_helper to compress and write the backup data streamed to stdin by a backup agent on another host")
 
 	if *onErrorContinue && !*restoreAgent {
 		fmt.Printf("--on-error-continue flag can only be used with --restore-agent flag")
@@ -170,6 +204,37 @@ func InitializeSignalHandler() {
  * Shared functions
  */
 
+// The throttle and offload agents are run by other processes, which report their errors instead
+func reportsToCoordinator() bool {
+	return !*throttleAgent && !*offloadAgent
+}
+
+func initializeBandwidthLimiter() {
+	if *maxBandwidth <= 0 && *bandwidthFile == "" {
+		return
+	}
+	bandwidthLimiter = utils.NewRateLimiter(utils.GetBandwidthForShare(*maxBandwidth, *bandwidthShare))
+	if *bandwidthFile != "" {
+		go utils.WatchBandwidthFile(*bandwidthFile, *bandwidthShare, bandwidthLimiter, time.Second, nil)
+	}
+}
+
+/*
+ * Used in the COPY commands of backups and restores that do not otherwise go
+ * through the helper, so that they are throttled the same way.
+ */
+func doThrottleAgent() error {
+	reader := io.Reader(os.Stdin)
+	if bandwidthLimiter != nil {
+		reader = utils.NewThrottledReader(os.Stdin, bandwidthLimiter)
+	}
+	_, err := io.Copy(os.Stdout, reader)
+	if err != nil {
+		logError(fmt.Sprintf("Error encountered copying data: %v", err))
+	}
+	return err
+}
+
 func createPipe(pipe string) error {
 	err := unix.Mkfifo(pipe, 0777)
 	if err != nil {
@@ -242,14 +307,14 @@ func flushAndCloseRestoreWriter(pipeName string, oid int) error {
 
 func DoCleanup() {
 	defer CleanupGroup.Done()
-	if wasTerminated {
+	if wasTerminated && reportsToCoordinator() {
 		/*
 		 * If the agent dies during the last table copy, it can still report
 		 * success, so we create an error file and check for its presence in
 		 * gprestore after the COPYs are finished.
 		 */
-		handle, _ := utils.OpenFileForWrite(fmt.Sprintf("%s_error", *pipeFile))
-		_ = handle.Close()
+		setPhase(utils.PHASE_CLEANUP)
+		reportResult(errors.New("Terminated by a signal or cancel request"))
 	}
 	err := flushAndCloseRestoreWriter("Current writer pipe on cleanup", 0)
 	if err != nil {
@@ -264,13 +329,16 @@ func DoCleanup() {
 		}
 	}
 
-	skipFiles, _ := filepath.Glob(fmt.Sprintf("%s_skip_*", *pipeFile))
-	for _, skipFile := range skipFiles {
-		err = utils.RemoveFileIfExists(skipFile)
-		if err != nil {
-			log("Encountered error during cleanup skip files: %v", err)
+	if *pipeFile != "" {
+		skipFiles, _ := filepath.Glob(fmt.Sprintf("%s_skip_*", *pipeFile))
+		for _, skipFile := range skipFiles {
+			err = utils.RemoveFileIfExists(skipFile)
+			if err != nil {
+				log("Encountered error during cleanup skip files: %v", err)
+			}
 		}
 	}
+	closeControlChannel()
 	log("Cleanup complete")
 }
 
@@ -10,14 +10,18 @@ import (
 	"os/exec"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/klauspost/compress/zstd"
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v2"
 )
 
 /*
@@ -33,8 +37,15 @@ const (
 
 var (
 	contentRE *regexp.Regexp
+	// Where the backup files of each content are on the cluster given by --source-file
+	sourceLocations map[int]sourceLocation
 )
 
+type sourceLocation struct {
+	host string
+	dir  string
+}
+
 /* RestoreReader structure to wrap the underlying reader.
  * readerType identifies how the reader can be used
  * SEEKABLE uses seekReader. Used when restoring from uncompressed data with filters from local filesystem
@@ -75,9 +86,9 @@ func (r *RestoreReader) copyData(num int64) (int64, error) {
 	var err error
 	switch r.readerType {
 	case SEEKABLE:
-		bytesRead, err = io.CopyN(writer, r.seekReader, num)
+		bytesRead, err = io.CopyN(progressWriter{writer}, r.seekReader, num)
 	case NONSEEKABLE, SUBSET:
-		bytesRead, err = io.CopyN(writer, r.bufReader, num)
+		bytesRead, err = io.CopyN(progressWriter{writer}, r.bufReader, num)
 	}
 	return bytesRead, err
 }
@@ -87,9 +98,9 @@ func (r *RestoreReader) copyAllData() (int64, error) {
 	var err error
 	switch r.readerType {
 	case SEEKABLE:
-		bytesRead, err = io.Copy(writer, r.seekReader)
+		bytesRead, err = io.Copy(progressWriter{writer}, r.seekReader)
 	case NONSEEKABLE, SUBSET:
-		bytesRead, err = io.Copy(writer, r.bufReader)
+		bytesRead, err = io.Copy(progressWriter{writer}, r.bufReader)
 	}
 	return bytesRead, err
 }
@@ -112,6 +123,13 @@ func doRestoreAgent() error {
 	if err != nil {
 		return err
 	}
+	if *sourceFile != "" {
+		sourceLocations, err = getSourceLocationsFromFile(*sourceFile)
+		if err != nil {
+			logError(fmt.Sprintf("Error encountered reading source file: %v", err))
+			return err
+		}
+	}
 
 	// During a larger-to-smaller restore, we need to do multiple passes for each oid, so the table
 	// restore goes into another nested for loop below.  In the normal or smaller-to-larger cases,
@@ -139,11 +157,15 @@ func doRestoreAgent() error {
 				break
 			}
 			tocFileForContent := replaceContentInFilename(*tocFile, contentToRestore)
-			segmentTOC[contentToRestore] = toc.NewSegmentTOC(tocFileForContent)
+			segmentTOC[contentToRestore], err = getSegmentTOC(tocFileForContent, contentToRestore)
+			if err != nil {
+				logError(fmt.Sprintf("Error encountered reading segment TOC: %v", err))
+				return err
+			}
 			tocEntries[contentToRestore] = segmentTOC[contentToRestore].DataEntries
 
 			filename := replaceContentInFilename(*dataFile, contentToRestore)
-			readers[contentToRestore], err = getRestoreDataReader(filename, segmentTOC[contentToRestore], oidList)
+			readers[contentToRestore], err = getRestoreDataReader(filename, contentToRestore, segmentTOC[contentToRestore], oidList)
 
 			if err != nil {
 				logError(fmt.Sprintf("Error encountered getting restore data reader for single data file: %v", err))
@@ -181,6 +203,8 @@ func doRestoreAgent() error {
 			return errors.New("Terminated due to user request")
 		}
 
+		setCurrentOid(oid)
+		setPhase(utils.PHASE_PIPE)
 		currentPipe = fmt.Sprintf("%s_%d", *pipeFile, oidList[i])
 		if i < len(oidList)-*copyQueue {
 			nextPipeToCreate := fmt.Sprintf("%s_%d", *pipeFile, oidList[i+*copyQueue])
@@ -224,7 +248,7 @@ func doRestoreAgent() error {
 					// We pre-create readers above for the sake of not re-opening SDF readers.  For MDF we can't
 					// re-use them but still having them in a map simplifies overall code flow.  We repeatedly assign
 					// to a map entry here intentionally.
-					readers[contentToRestore], err = getRestoreDataReader(filename, nil, nil)
+					readers[contentToRestore], err = getRestoreDataReader(filename, contentToRestore, nil, nil)
 					if err != nil {
 						logError(fmt.Sprintf("Error encountered getting restore data reader: %v", err))
 						return err
@@ -233,6 +257,7 @@ func doRestoreAgent() error {
 			}
 
 			log(fmt.Sprintf("Oid %d: Opening pipe %s", oid, currentPipe))
+			setPhase(utils.PHASE_PIPE)
 			retries := 0
 			for {
 				writer, writeHandle, err = getRestorePipeWriter(currentPipe)
@@ -240,13 +265,14 @@ func doRestoreAgent() error {
 					if errors.Is(err, unix.ENXIO) && retries < 100 {
 						// COPY (the pipe reader) has not tried to access the pipe yet so our restore_helper
 						// process will get ENXIO error on its nonblocking open call on the pipe. We loop in
-						// here while looking to see if gprestore has created a skip file for this restore entry.
+						// here while looking to see if gprestore has created a skip file for this restore entry,
+						// or told us to skip it over the control channel.
 						//
 						// TODO: Skip files will only be created when gprestore is run against GPDB 6+ so it
 						// might be good to have a GPDB version check here. However, the restore helper should
 						// not contain a database connection so the version should be passed through the helper
 						// invocation from gprestore (e.g. create a --db-version flag option).
-						if *onErrorContinue && utils.FileExists(fmt.Sprintf("%s_skip_%d", *pipeFile, oid)) {
+						if *onErrorContinue && (isOidSkipped(oid) || utils.FileExists(fmt.Sprintf("%s_skip_%d", *pipeFile, oid))) {
 							log(fmt.Sprintf("Skip file has been discovered for entry %d, skipping it", oid))
 							err = nil
 							goto LoopEnd
@@ -286,6 +312,7 @@ func doRestoreAgent() error {
 			}
 
 			log(fmt.Sprintf("Oid %d: Start table restore", oid))
+			setPhase(utils.PHASE_COPY)
 			if *isResizeRestore {
 				if contentToRestore < *origSize {
 					if *singleDataFile {
@@ -320,6 +347,7 @@ func doRestoreAgent() error {
 				lastByte[contentToRestore] = end[contentToRestore]
 			}
 			log(fmt.Sprintf("Oid %d: Copied %d bytes into the pipe", oid, bytesRead))
+			reportProgress(oid, bytesRead)
 
 			log(fmt.Sprintf("Closing pipe for oid %d: %s", oid, currentPipe))
 			err = flushAndCloseRestoreWriter(currentPipe, oid)
@@ -355,6 +383,7 @@ func doRestoreAgent() error {
 		if err != nil {
 			if *onErrorContinue {
 				logError(fmt.Sprintf("Oid %d: Error encountered: %v", oid, err))
+				reportTableError(oid, err)
 				lastError = err
 				err = nil
 				continue
@@ -385,7 +414,53 @@ func replaceContentInFilename(filename string, content int) string {
 	return contentRE.ReplaceAllString(filename, fmt.Sprintf("gpbackup_%d_", content))
 }
 
-func getRestoreDataReader(fileToRead string, toc *toc.SegmentTOC, oidList []int) (*RestoreReader, error) {
+func getSourceLocationsFromFile(sourceFileName string) (map[int]sourceLocation, error) {
+	contents, err := operating.System.ReadFile(sourceFileName)
+	if err != nil {
+		// error logging handled by calling functions
+		return nil, err
+	}
+	locations := make(map[int]sourceLocation)
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			return nil, errors.Errorf("Invalid line in source file: %s", line)
+		}
+		sourceContent, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, errors.Errorf("Invalid content in source file: %s", line)
+		}
+		locations[sourceContent] = sourceLocation{host: fields[1], dir: fields[2]}
+	}
+	return locations, nil
+}
+
+// Returns the SSH command that writes a backup file of the source cluster to stdout
+func getSourceFileCommand(location sourceLocation, fileToRead string) *exec.Cmd {
+	sourcePath := path.Join(location.dir, path.Base(fileToRead))
+	sshCmd := cluster.ConstructSSHCommand(false, location.host, fmt.Sprintf(`cat "%s"`, sourcePath))
+	log(strings.Join(sshCmd, " "))
+	return exec.Command(sshCmd[0], sshCmd[1:]...)
+}
+
+func getSegmentTOC(tocFileForContent string, contentToRestore int) (*toc.SegmentTOC, error) {
+	location, ok := sourceLocations[contentToRestore]
+	if !ok {
+		return toc.NewSegmentTOC(tocFileForContent), nil
+	}
+	contents, err := getSourceFileCommand(location, tocFileForContent).Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read segment TOC of content %d from host %s", contentToRestore, location.host)
+	}
+	segmentTOC := &toc.SegmentTOC{}
+	err = yaml.Unmarshal(contents, segmentTOC)
+	if err != nil {
+		return nil, err
+	}
+	return segmentTOC, nil
+}
+
+func getRestoreDataReader(fileToRead string, contentToRestore int, toc *toc.SegmentTOC, oidList []int) (*RestoreReader, error) {
 	var readHandle io.Reader
 	var seekHandle io.ReadSeeker
 	var isSubset bool
@@ -401,6 +476,10 @@ func getRestoreDataReader(fileToRead string, toc *toc.SegmentTOC, oidList []int)
 			// Regular reader which doesn't support seek
 			restoreReader.readerType = NONSEEKABLE
 		}
+	} else if location, ok := sourceLocations[contentToRestore]; ok {
+		// The file is streamed from the source cluster, so it cannot be seeked
+		readHandle, err = startRestoreSourceCommand(location, fileToRead)
+		restoreReader.readerType = NONSEEKABLE
 	} else {
 		if *isFiltered && !strings.HasSuffix(fileToRead, ".gz") && !strings.HasSuffix(fileToRead, ".zst") {
 			// Seekable reader if backup is not compressed and filters are set
@@ -416,6 +495,14 @@ func getRestoreDataReader(fileToRead string, toc *toc.SegmentTOC, oidList []int)
 		// error logging handled by calling functions
 		return nil, err
 	}
+	// Throttle the stream as read from storage, before decompression
+	if bandwidthLimiter != nil {
+		if restoreReader.readerType == SEEKABLE {
+			seekHandle = utils.NewThrottledReadSeeker(seekHandle, bandwidthLimiter)
+		} else {
+			readHandle = utils.NewThrottledReader(readHandle, bandwidthLimiter)
+		}
+	}
 
 	// Set the underlying stream reader in restoreReader
 	if restoreReader.readerType == SEEKABLE {
@@ -465,6 +552,18 @@ func getRestorePipeWriter(currentPipe string) (*bufio.Writer, *os.File, error) {
 	return pipeWriter, fileHandle, nil
 }
 
+func startRestoreSourceCommand(location sourceLocation, fileToRead string) (io.Reader, error) {
+	cmd := getSourceFileCommand(location, fileToRead)
+	readHandle, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = &errBuf
+
+	err = cmd.Start()
+	return readHandle, err
+}
+
 func startRestorePluginCommand(fileToRead string, toc *toc.SegmentTOC, oidList []int) (io.Reader, bool, error) {
 	isSubset := false
 	pluginConfig, err := utils.ReadPluginConfig(*pluginConfigFile)
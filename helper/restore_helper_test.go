@@ -0,0 +1,60 @@
+package helper
+
+import (
+	"os"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("helper/restore_helper tests", func() {
+	Describe("getSourceLocationsFromFile", func() {
+		sourceFile := "/data/gpseg0/gpbackup_0_20170101010101_source"
+		readFileReturns := func(contents string, err error) {
+			operating.System.ReadFile = func(filename string) ([]byte, error) {
+				Expect(filename).To(Equal(sourceFile))
+				return []byte(contents), err
+			}
+		}
+		AfterEach(func() {
+			operating.InitializeSystemFunctions()
+		})
+		It("reads the host and backup directory of each content of the source cluster", func() {
+			readFileReturns("0\tsourcesdw1\t/source/srcseg0/backups/20170101/20170101010101\n1\tsourcesdw2\t/source/srcseg1/backups/20170101/20170101010101\n", nil)
+
+			locations, err := getSourceLocationsFromFile(sourceFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locations).To(Equal(map[int]sourceLocation{
+				0: {host: "sourcesdw1", dir: "/source/srcseg0/backups/20170101/20170101010101"},
+				1: {host: "sourcesdw2", dir: "/source/srcseg1/backups/20170101/20170101010101"},
+			}))
+		})
+		It("keeps tabs in the backup directory", func() {
+			readFileReturns("0\tsourcesdw1\t/source/dir\twith tab", nil)
+
+			locations, err := getSourceLocationsFromFile(sourceFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locations[0].dir).To(Equal("/source/dir\twith tab"))
+		})
+		It("returns an error for a line without a backup directory", func() {
+			readFileReturns("0\tsourcesdw1\n", nil)
+
+			_, err := getSourceLocationsFromFile(sourceFile)
+			Expect(err).To(MatchError("Invalid line in source file: 0\tsourcesdw1"))
+		})
+		It("returns an error for a line with an invalid content", func() {
+			readFileReturns("seg0\tsourcesdw1\t/source/srcseg0\n", nil)
+
+			_, err := getSourceLocationsFromFile(sourceFile)
+			Expect(err).To(MatchError("Invalid content in source file: seg0\tsourcesdw1\t/source/srcseg0"))
+		})
+		It("returns an error if the file cannot be read", func() {
+			readFileReturns("", os.ErrNotExist)
+
+			_, err := getSourceLocationsFromFile(sourceFile)
+			Expect(err).To(Equal(os.ErrNotExist))
+		})
+	})
+})
@@ -22,6 +22,8 @@ type RestorePlanEntry struct {
 const (
 	BackupStatusSucceed = "Success"
 	BackupStatusFailed  = "Failure"
+	// The backup was stopped with SIGUSR2 before the data of all of its tables was backed up
+	BackupStatusPartial = "Partial"
 )
 
 type BackupConfig struct {
@@ -54,12 +56,28 @@ type BackupConfig struct {
 	WithoutGlobals        bool
 	WithStatistics        bool
 	Status                string
+	// Set when rows were filtered with --where-file or columns masked with
+	// --mask-file, so that the backup is never used as an incremental base
+	Filtered bool `yaml:",omitempty"`
+	// Describes the sample of rows taken with --sample, if any
+	Sample string `yaml:",omitempty"`
+	// The --layout-template of the backup directories, if any
+	LayoutTemplate string `yaml:",omitempty"`
+	// The directory to which the coordinator files were written with --metadata-dir, if any
+	MetadataDir string `yaml:",omitempty"`
+	// The host and dbid of each content, which {host} and {dbid} in LayoutTemplate stand for
+	SegmentHosts map[int]string `yaml:",omitempty"`
+	SegmentDbIDs map[int]int    `yaml:",omitempty"`
 }
 
 func (backup *BackupConfig) Failed() bool {
 	return backup.Status == BackupStatusFailed
 }
 
+func (backup *BackupConfig) StoppedEarly() bool {
+	return backup.Status == BackupStatusPartial
+}
+
 func ReadConfigFile(filename string) *BackupConfig {
 	config := &BackupConfig{}
 	contents, err := ioutil.ReadFile(filename)
@@ -138,6 +156,13 @@ func InitializeHistoryDatabase(historyDBPath string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	err = addBackupsColumns(tx)
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+
 	createAuxTableQuery := `
 		CREATE TABLE IF NOT EXISTS %s (
 			timestamp TEXT NOT NULL,
@@ -185,6 +210,74 @@ func InitializeHistoryDatabase(historyDBPath string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	createSegmentLocationsTable := `
+		CREATE TABLE IF NOT EXISTS segment_locations (
+			timestamp TEXT NOT NULL,
+			content_id INT NOT NULL,
+			hostname TEXT NOT NULL,
+			dbid INT NOT NULL,
+			FOREIGN KEY(timestamp) REFERENCES backups(timestamp)
+		);`
+	_, err = tx.Exec(createSegmentLocationsTable)
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+
+	// Kept apart from the backups table, as segment_locations is, so that only
+	// the backups that were filtered, sampled, or laid out differently have rows
+	createFilteredBackupsTable := `
+		CREATE TABLE IF NOT EXISTS filtered_backups (
+			timestamp TEXT NOT NULL PRIMARY KEY,
+			FOREIGN KEY(timestamp) REFERENCES backups(timestamp)
+		);`
+	_, err = tx.Exec(createFilteredBackupsTable)
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+
+	createSampledBackupsTable := `
+		CREATE TABLE IF NOT EXISTS sampled_backups (
+			timestamp TEXT NOT NULL PRIMARY KEY,
+			sample TEXT NOT NULL,
+			FOREIGN KEY(timestamp) REFERENCES backups(timestamp)
+		);`
+	_, err = tx.Exec(createSampledBackupsTable)
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+
+	createLayoutTemplatesTable := `
+		CREATE TABLE IF NOT EXISTS layout_templates (
+			timestamp TEXT NOT NULL PRIMARY KEY,
+			layout_template TEXT NOT NULL,
+			FOREIGN KEY(timestamp) REFERENCES backups(timestamp)
+		);`
+	_, err = tx.Exec(createLayoutTemplatesTable)
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+
+	createMetadataDirsTable := `
+		CREATE TABLE IF NOT EXISTS metadata_dirs (
+			timestamp TEXT NOT NULL PRIMARY KEY,
+			metadata_dir TEXT NOT NULL,
+			FOREIGN KEY(timestamp) REFERENCES backups(timestamp)
+		);`
+	_, err = tx.Exec(createMetadataDirsTable)
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		db.Close()
@@ -193,6 +286,47 @@ func InitializeHistoryDatabase(historyDBPath string) (*sql.DB, error) {
 	return db, nil
 }
 
+/*
+ * Columns added to the backups table after it was first released.  They are
+ * added to the backups tables of existing history databases and are nullable,
+ * as backups recorded before they were added have no value for them.
+ */
+var addedBackupsColumns = []struct {
+	name       string
+	definition string
+}{
+	{"segment_count", "INT"},
+}
+
+func addBackupsColumns(tx *sql.Tx) error {
+	columnRows, err := tx.Query("SELECT name FROM pragma_table_info('backups');")
+	if err != nil {
+		return err
+	}
+	existingColumns := make(map[string]bool)
+	for columnRows.Next() {
+		var columnName string
+		err = columnRows.Scan(&columnName)
+		if err != nil {
+			columnRows.Close()
+			return err
+		}
+		existingColumns[columnName] = true
+	}
+	columnRows.Close()
+
+	for _, column := range addedBackupsColumns {
+		if existingColumns[column.name] {
+			continue
+		}
+		_, err = tx.Exec(fmt.Sprintf("ALTER TABLE backups ADD COLUMN %s %s;", column.name, column.definition))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func CurrentTimestamp() string {
 	return operating.System.Now().Format("20060102150405")
 }
@@ -215,7 +349,12 @@ func StoreBackupHistory(db *sql.DB, currentBackupConfig *BackupConfig) error {
 	}
 	tx, _ := db.Begin()
 
-	_, err := tx.Exec("INSERT INTO backups VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+	_, err := tx.Exec(`INSERT INTO backups (timestamp, backup_dir, backup_version, compressed, compression_type,
+			database_name, database_version, data_only, date_deleted, exclude_schema_filtered,
+			exclude_table_filtered, include_schema_filtered, include_table_filtered, incremental,
+			leaf_partition_data, metadata_only, plugin, plugin_version, single_data_file, end_time,
+			without_globals, with_statistics, status, segment_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
 		currentBackupConfig.Timestamp, currentBackupConfig.BackupDir,
 		currentBackupConfig.BackupVersion, currentBackupConfig.Compressed,
 		currentBackupConfig.CompressionType, currentBackupConfig.DatabaseName,
@@ -226,7 +365,8 @@ func StoreBackupHistory(db *sql.DB, currentBackupConfig *BackupConfig) error {
 		currentBackupConfig.LeafPartitionData, currentBackupConfig.MetadataOnly,
 		currentBackupConfig.Plugin, currentBackupConfig.PluginVersion,
 		currentBackupConfig.SingleDataFile, currentBackupConfig.EndTime,
-		currentBackupConfig.WithoutGlobals, currentBackupConfig.WithStatistics, currentBackupConfig.Status)
+		currentBackupConfig.WithoutGlobals, currentBackupConfig.WithStatistics, currentBackupConfig.Status,
+		currentBackupConfig.SegmentCount)
 	if err != nil {
 		goto CleanupError
 	}
@@ -251,6 +391,42 @@ func StoreBackupHistory(db *sql.DB, currentBackupConfig *BackupConfig) error {
 		goto CleanupError
 	}
 
+	if currentBackupConfig.Filtered {
+		_, err = tx.Exec("INSERT INTO filtered_backups VALUES (?);", currentBackupConfig.Timestamp)
+		if err != nil {
+			goto CleanupError
+		}
+	}
+
+	if currentBackupConfig.Sample != "" {
+		_, err = tx.Exec("INSERT INTO sampled_backups VALUES (?, ?);", currentBackupConfig.Timestamp, currentBackupConfig.Sample)
+		if err != nil {
+			goto CleanupError
+		}
+	}
+
+	if currentBackupConfig.LayoutTemplate != "" {
+		_, err = tx.Exec("INSERT INTO layout_templates VALUES (?, ?);", currentBackupConfig.Timestamp, currentBackupConfig.LayoutTemplate)
+		if err != nil {
+			goto CleanupError
+		}
+	}
+
+	if currentBackupConfig.MetadataDir != "" {
+		_, err = tx.Exec("INSERT INTO metadata_dirs VALUES (?, ?);", currentBackupConfig.Timestamp, currentBackupConfig.MetadataDir)
+		if err != nil {
+			goto CleanupError
+		}
+	}
+
+	for contentID, hostname := range currentBackupConfig.SegmentHosts {
+		_, err = tx.Exec("INSERT INTO segment_locations VALUES (?, ?, ?, ?);",
+			currentBackupConfig.Timestamp, contentID, hostname, currentBackupConfig.SegmentDbIDs[contentID])
+		if err != nil {
+			goto CleanupError
+		}
+	}
+
 	// unpack and store restore plan entries
 	for _, restorePlan := range currentBackupConfig.RestorePlan {
 		_, err = tx.Exec("INSERT INTO restore_plans VALUES (?, ?);",
@@ -286,7 +462,7 @@ func GetMainBackupInfo(timestamp string, historyDB *sql.DB) (BackupConfig, error
 			database_name, database_version, data_only, date_deleted, exclude_schema_filtered,
 			exclude_table_filtered, include_schema_filtered, include_table_filtered, incremental,
 			leaf_partition_data, metadata_only, plugin, plugin_version, single_data_file, end_time,
-			without_globals, with_statistics, status
+			without_globals, with_statistics, status, coalesce(segment_count, 0)
 		FROM backups WHERE timestamp = '%s'`,
 		timestamp)
 	backupRow := historyDB.QueryRow(backupQuery)
@@ -310,7 +486,8 @@ func GetMainBackupInfo(timestamp string, historyDB *sql.DB) (BackupConfig, error
 		&isDataOnly, &backupConfig.DateDeleted, &isExclSchemaFiltered, &isExclTableFiltered,
 		&isInclSchemaFiltered, &isInclTableFiltered, &isIncremental, &isLeafPartition,
 		&isMetadataOnly, &backupConfig.Plugin, &backupConfig.PluginVersion, &isSingleDataFile,
-		&backupConfig.EndTime, &isWithoutGlobals, &isWithStatistics, &backupConfig.Status)
+		&backupConfig.EndTime, &isWithoutGlobals, &isWithStatistics, &backupConfig.Status,
+		&backupConfig.SegmentCount)
 	if err == sql.ErrNoRows {
 		return backupConfig, errors.New("timestamp doesn't match any existing backups")
 	} else if err != nil {
@@ -333,6 +510,12 @@ func GetMainBackupInfo(timestamp string, historyDB *sql.DB) (BackupConfig, error
 	return backupConfig, err
 }
 
+// Records the segment count of a backup that was taken before segment counts were recorded
+func UpdateSegmentCount(timestamp string, segmentCount int, historyDB *sql.DB) error {
+	_, err := historyDB.Exec("UPDATE backups SET segment_count = ? WHERE timestamp = ?;", segmentCount, timestamp)
+	return err
+}
+
 func getAuxTable(db *sql.DB, timestamp, tableName string) ([]string, error) {
 	getAuxTableQuery := fmt.Sprintf("SELECT name FROM %s WHERE timestamp = '%s'", tableName, timestamp)
 	auxTableRows, err := db.Query(getAuxTableQuery)
@@ -381,6 +564,48 @@ func GetBackupConfig(timestamp string, historyDB *sql.DB) (*BackupConfig, error)
 		return nil, err
 	}
 
+	var numFiltered int
+	err = historyDB.QueryRow(fmt.Sprintf("SELECT count(*) FROM filtered_backups WHERE timestamp = '%s'", timestamp)).Scan(&numFiltered)
+	if err != nil {
+		return nil, err
+	}
+	backupConfig.Filtered = numFiltered > 0
+
+	err = historyDB.QueryRow(fmt.Sprintf("SELECT coalesce(max(sample), '') FROM sampled_backups WHERE timestamp = '%s'", timestamp)).Scan(&backupConfig.Sample)
+	if err != nil {
+		return nil, err
+	}
+
+	err = historyDB.QueryRow(fmt.Sprintf("SELECT coalesce(max(layout_template), '') FROM layout_templates WHERE timestamp = '%s'", timestamp)).Scan(&backupConfig.LayoutTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	err = historyDB.QueryRow(fmt.Sprintf("SELECT coalesce(max(metadata_dir), '') FROM metadata_dirs WHERE timestamp = '%s'", timestamp)).Scan(&backupConfig.MetadataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	segmentRows, err := historyDB.Query(fmt.Sprintf("SELECT content_id, hostname, dbid FROM segment_locations WHERE timestamp = '%s'", timestamp))
+	if err != nil {
+		return nil, err
+	}
+	defer segmentRows.Close()
+	for segmentRows.Next() {
+		var contentID, dbid int
+		var hostname string
+		err = segmentRows.Scan(&contentID, &hostname, &dbid)
+		if err != nil {
+			return nil, err
+		}
+		if backupConfig.SegmentHosts == nil {
+			backupConfig.SegmentHosts = make(map[int]string)
+			backupConfig.SegmentDbIDs = make(map[int]int)
+		}
+		backupConfig.SegmentHosts[contentID] = hostname
+		backupConfig.SegmentDbIDs[contentID] = dbid
+	}
+
 	// Retrieve restore plan information
 	restorePlanQuery := fmt.Sprintf("SELECT DISTINCT restore_plan_timestamp FROM restore_plans WHERE timestamp = '%s' ORDER BY restore_plan_timestamp", timestamp)
 	restorePlanRows, err := historyDB.Query(restorePlanQuery)
@@ -1,6 +1,7 @@
 package history_test
 
 import (
+	"database/sql"
 	"os"
 	"testing"
 	"time"
@@ -82,13 +83,34 @@ var _ = Describe("backup/history tests", func() {
 			Expect(tableNames[0]).To(Equal("backups"))
 			Expect(tableNames[1]).To(Equal("exclude_relations"))
 			Expect(tableNames[2]).To(Equal("exclude_schemas"))
-			Expect(tableNames[3]).To(Equal("include_relations"))
-			Expect(tableNames[4]).To(Equal("include_schemas"))
-			Expect(tableNames[5]).To(Equal("restore_plan_tables"))
-			Expect(tableNames[6]).To(Equal("restore_plans"))
+			Expect(tableNames[3]).To(Equal("filtered_backups"))
+			Expect(tableNames[4]).To(Equal("include_relations"))
+			Expect(tableNames[5]).To(Equal("include_schemas"))
+			Expect(tableNames[6]).To(Equal("layout_templates"))
+			Expect(tableNames[7]).To(Equal("metadata_dirs"))
+			Expect(tableNames[8]).To(Equal("restore_plan_tables"))
+			Expect(tableNames[9]).To(Equal("restore_plans"))
+			Expect(tableNames[10]).To(Equal("sampled_backups"))
+			Expect(tableNames[11]).To(Equal("segment_locations"))
+			Expect(tableNames).To(HaveLen(12))
 
 		})
 
+		It("adds the columns that the backups table of an existing database is missing", func() {
+			oldDB, err := sql.Open("sqlite3", historyDBPath)
+			Expect(err).To(BeNil())
+			_, err = oldDB.Exec("CREATE TABLE backups (timestamp TEXT NOT NULL PRIMARY KEY, status TEXT);")
+			Expect(err).To(BeNil())
+			oldDB.Close()
+
+			db, err := history.InitializeHistoryDatabase(historyDBPath)
+			Expect(err).To(BeNil())
+			defer db.Close()
+			var numColumns int
+			err = db.QueryRow("SELECT count(*) FROM pragma_table_info('backups') WHERE name = 'segment_count';").Scan(&numColumns)
+			Expect(err).To(BeNil())
+			Expect(numColumns).To(Equal(1))
+		})
 		It("returns a handle to an existing database if one is already present", func() {
 			db, _ := history.InitializeHistoryDatabase(historyDBPath)
 			createDummyTable := "CREATE TABLE IF NOT EXISTS dummy (dummy int);"
@@ -181,5 +203,59 @@ var _ = Describe("backup/history tests", func() {
 			Expect(err).To(BeNil())
 			Expect(config).To(structmatcher.MatchStruct(testConfig2))
 		})
+		It("gets a filtered config from the database", func() {
+			db, _ := history.InitializeHistoryDatabase(historyDBPath)
+			defer db.Close()
+			filteredConfig := testConfig1
+			filteredConfig.Filtered = true
+			err := history.StoreBackupHistory(db, &filteredConfig)
+			Expect(err).To(BeNil())
+
+			config, err := history.GetBackupConfig(filteredConfig.Timestamp, db)
+			Expect(err).To(BeNil())
+			Expect(config.Filtered).To(BeTrue())
+			Expect(config).To(structmatcher.MatchStruct(filteredConfig))
+		})
+		It("gets a sampled config from the database", func() {
+			db, _ := history.InitializeHistoryDatabase(historyDBPath)
+			defer db.Close()
+			sampledConfig := testConfig1
+			sampledConfig.Filtered = true
+			sampledConfig.Sample = "10% of rows, following foreign keys"
+			err := history.StoreBackupHistory(db, &sampledConfig)
+			Expect(err).To(BeNil())
+
+			config, err := history.GetBackupConfig(sampledConfig.Timestamp, db)
+			Expect(err).To(BeNil())
+			Expect(config).To(structmatcher.MatchStruct(sampledConfig))
+		})
+		It("gets the segment count of a config from the database as updated after the backup", func() {
+			db, _ := history.InitializeHistoryDatabase(historyDBPath)
+			defer db.Close()
+			err := history.StoreBackupHistory(db, &testConfig1)
+			Expect(err).To(BeNil())
+
+			err = history.UpdateSegmentCount(testConfig1.Timestamp, 3, db)
+			Expect(err).To(BeNil())
+
+			config, err := history.GetBackupConfig(testConfig1.Timestamp, db)
+			Expect(err).To(BeNil())
+			Expect(config.SegmentCount).To(Equal(3))
+		})
+		It("gets the layout template, segment locations, and metadata directory of a config from the database", func() {
+			db, _ := history.InitializeHistoryDatabase(historyDBPath)
+			defer db.Close()
+			layoutConfig := testConfig1
+			layoutConfig.LayoutTemplate = "{database}/{host}/seg{content}/{timestamp}"
+			layoutConfig.MetadataDir = "/home/gpadmin/backup_metadata"
+			layoutConfig.SegmentHosts = map[int]string{-1: "cdw", 0: "sdw1", 1: "sdw2"}
+			layoutConfig.SegmentDbIDs = map[int]int{-1: 1, 0: 2, 1: 3}
+			err := history.StoreBackupHistory(db, &layoutConfig)
+			Expect(err).To(BeNil())
+
+			config, err := history.GetBackupConfig(layoutConfig.Timestamp, db)
+			Expect(err).To(BeNil())
+			Expect(config).To(structmatcher.MatchStruct(layoutConfig))
+		})
 	})
 })
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"fmt"
+	"hash/crc32"
 	"io/ioutil"
 	"math"
 	"os"
@@ -148,6 +149,25 @@ var _ = Describe("gpbackup_helper end to end integration tests", func() {
 			Expect(err).ToNot(HaveOccurred())
 			assertBackupArtifactsWithCompression("zstd", true)
 		})
+		It("runs backup gpbackup_helper offloaded to another host", func() {
+			/*
+			 * The backup agent runs the offload agent from $GPHOME over SSH, so
+			 * point it at a GPHOME holding the gpbackup_helper under test.
+			 */
+			gphome := filepath.Join(testDir, "gphome")
+			Expect(os.MkdirAll(filepath.Join(gphome, "bin"), 0777)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(gphome, "greenplum_path.sh"), []byte{}, 0777)).To(Succeed())
+			Expect(os.Symlink(gpbackupHelperPath, filepath.Join(gphome, "bin", "gpbackup_helper"))).To(Succeed())
+			DeferCleanup(os.Setenv, "GPHOME", os.Getenv("GPHOME"))
+			Expect(os.Setenv("GPHOME", gphome)).To(Succeed())
+
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-type", "gzip", "--compression-level", "1", "--data-file", dataFileFullPath+".gz", "--offload-host", "localhost")
+			writeToPipes(defaultData)
+			err := helperCmd.Wait()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+			assertBackupArtifactsWithCompression("gzip", false)
+		})
 		It("Generates error file when backup agent interrupted", func() {
 			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "0", "--data-file", dataFileFullPath)
 			waitForPipeCreation()
@@ -158,6 +178,42 @@ var _ = Describe("gpbackup_helper end to end integration tests", func() {
 			assertErrorsHandled()
 		})
 	})
+	Context("offload tests", func() {
+		offloadHelper := func(data string, args ...string) string {
+			args = append([]string{"--offload-agent", "--content", "1"}, args...)
+			command := exec.Command(gpbackupHelperPath, args...)
+			command.Stdin = strings.NewReader(data)
+			output, err := command.Output()
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+			return string(output)
+		}
+		expectedChecksum := fmt.Sprintf("Offload checksum: %d %08x\n", len(expectedData), crc32.ChecksumIEEE([]byte(expectedData)))
+
+		It("runs offload gpbackup_helper without compression", func() {
+			output := offloadHelper(expectedData, "--compression-level", "0", "--data-file", dataFileFullPath)
+			Expect(output).To(Equal(expectedChecksum))
+			contents, err := ioutil.ReadFile(dataFileFullPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal(expectedData))
+		})
+		It("runs offload gpbackup_helper with zstd compression with plugin", func() {
+			output := offloadHelper(expectedData, "--compression-type", "zstd", "--compression-level", "1", "--data-file", dataFileFullPath+".zst", "--plugin-config", pluginConfigPath)
+			Expect(output).To(Equal(expectedChecksum))
+			contents, err := ioutil.ReadFile(pluginBackupPath + ".zst")
+			Expect(err).ToNot(HaveOccurred())
+			r, _ := zstd.NewReader(bytes.NewReader(contents))
+			contents, _ = ioutil.ReadAll(r)
+			Expect(string(contents)).To(Equal(expectedData))
+		})
+		It("reports no checksum when the offload agent fails to write the data", func() {
+			command := exec.Command(gpbackupHelperPath, "--offload-agent", "--content", "1", "--compression-level", "0", "--data-file", "/nonexistent/test_data")
+			command.Stdin = strings.NewReader(expectedData)
+			output, err := command.Output()
+			Expect(err).To(HaveOccurred())
+			Expect(string(output)).ToNot(ContainSubstring("Offload checksum"))
+		})
+	})
 	Context("restore tests", func() {
 		It("runs restore gpbackup_helper without compression", func() {
 			setupRestoreFiles("", false)
@@ -351,6 +351,19 @@ PARTITION BY RANGE (date)
 			structmatcher.ExpectStructsToMatchExcluding(&constraints[0], &expectedConstraint, "Oid")
 		})
 	})
+	Describe("GetForeignKeys", func() {
+		It("returns the column pairs of a multi-column foreign key in key order", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.customers (region text, id int, PRIMARY KEY (id, region)) DISTRIBUTED BY (id)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.customers CASCADE")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.orders (customer_id int, customer_region text, FOREIGN KEY (customer_region, customer_id) REFERENCES public.customers (region, id)) DISTRIBUTED BY (customer_id)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.orders")
+
+			foreignKeys := backup.GetForeignKeys(connectionPool)
+
+			Expect(foreignKeys).To(ContainElement(backup.ForeignKey{Table: "public.orders", Columns: []string{"customer_region", "customer_id"},
+				ReferencedTable: "public.customers", ReferencedColumns: []string{"region", "id"}}))
+		})
+	})
 	Describe("GetAccessMethods", func() {
 		It("returns information for user defined access methods", func() {
 			testutils.SkipIfBefore7(connectionPool)
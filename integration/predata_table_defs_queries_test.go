@@ -61,6 +61,33 @@ PARTITION BY RANGE (year)
 			structmatcher.ExpectStructsToMatch(partTableMap[leaf33], &backup.PartitionLevelInfo{Oid: leaf33, Level: "l", RootName: "summer_sales"})
 		})
 	})
+	Describe("GetInternalLeafPartitions", func() {
+		It("returns the leaves that are not external of a partition table with an external leaf", func() {
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TABLE public.partition_table (id int, gender char(1))
+DISTRIBUTED BY (id)
+PARTITION BY LIST (gender)
+( PARTITION girls VALUES ('F'),
+  PARTITION boys VALUES ('M'),
+  DEFAULT PARTITION other );`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.partition_table")
+			testhelper.AssertQueryRuns(connectionPool, `CREATE EXTERNAL WEB TABLE public.partition_table_ext_part_ (like public.partition_table_1_prt_girls)
+EXECUTE 'echo -e "2\n1"' on host
+FORMAT 'csv';`)
+			testhelper.AssertQueryRuns(connectionPool, `ALTER TABLE public.partition_table EXCHANGE PARTITION girls WITH TABLE public.partition_table_ext_part_ WITHOUT VALIDATION;`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.partition_table_ext_part_")
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TABLE public.other_partition_table (id int, year int)
+DISTRIBUTED BY (id)
+PARTITION BY RANGE (year)
+( START (2015) END (2017) EVERY (1) );`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.other_partition_table")
+
+			parent := testutils.OidFromObjectName(connectionPool, "public", "partition_table", backup.TYPE_RELATION)
+			leafPartitions := backup.GetInternalLeafPartitions(connectionPool)
+
+			Expect(leafPartitions).To(HaveLen(1))
+			Expect(leafPartitions[parent]).To(ConsistOf("public.partition_table_1_prt_boys", "public.partition_table_1_prt_other"))
+		})
+	})
 	Describe("GetColumnDefinitions", func() {
 		It("returns table attribute information for a heap table", func() {
 			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.atttable(a float, b text, c text NOT NULL, d int DEFAULT(5), e text)")
@@ -9,7 +9,6 @@ import (
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
-	fp "github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/utils"
 
@@ -20,7 +19,7 @@ import (
 )
 
 var _ = Describe("utils integration", func() {
-	It("TerminateHangingCopySessions stops hanging COPY sessions", func() {
+	It("TerminateCopySessions stops hanging COPY sessions", func() {
 		tempDir, err := ioutil.TempDir("", "temp")
 		Expect(err).To(Not(HaveOccurred()))
 		defer os.Remove(tempDir)
@@ -28,12 +27,8 @@ var _ = Describe("utils integration", func() {
 		conn := testutils.SetupTestDbConn("testdb")
 		defer conn.Close()
 
-		fpInfo := fp.FilePathInfo{
-			PID:       1,
-			Timestamp: "11223344556677",
-		}
-
 		testhelper.AssertQueryRuns(conn, "SET application_name TO 'hangingApplication'")
+		Expect(utils.TrackCopyWorkers(conn, "hangingApplication")).To(Succeed())
 		testhelper.AssertQueryRuns(conn, "CREATE TABLE public.foo(i int)")
 		// TODO: this works without error in 6, but throws an error in 7.  Still functions, though.  Unclear why the change.
 		// defer testhelper.AssertQueryRuns(conn, "DROP TABLE public.foo")
@@ -42,16 +37,18 @@ var _ = Describe("utils integration", func() {
 		Expect(err).To(Not(HaveOccurred()))
 		defer os.Remove(testPipe)
 		go func() {
-			copyFileName := fpInfo.GetSegmentPipePathForCopyCommand()
+			marker := utils.StartCopySession(0, 1)
+			defer utils.EndCopySession(0)
 			// COPY will blcok because there is no reader for the testPipe
-			_, _ = conn.Exec(fmt.Sprintf("COPY public.foo TO PROGRAM 'echo %s > /dev/null; cat - > %s' WITH CSV DELIMITER ','", copyFileName, testPipe))
+			_, _ = conn.Exec(fmt.Sprintf("%s COPY public.foo TO PROGRAM 'cat - > %s' WITH CSV DELIMITER ','", marker, testPipe))
 		}()
 
-		query := `SELECT count(*) FROM pg_stat_activity WHERE application_name = 'hangingApplication'`
-		Eventually(func() string { return dbconn.MustSelectString(connectionPool, query) }, 5*time.Second, 100*time.Millisecond).Should(Equal("1"))
+		copyQuery := `SELECT count(*) FROM pg_stat_activity WHERE application_name = 'hangingApplication' AND query LIKE '/* hangingApplication%'`
+		Eventually(func() string { return dbconn.MustSelectString(connectionPool, copyQuery) }, 5*time.Second, 100*time.Millisecond).Should(Equal("1"))
 
-		utils.TerminateHangingCopySessions(connectionPool, fpInfo, "hangingApplication")
+		utils.TerminateCopySessions(connectionPool)
 
+		query := `SELECT count(*) FROM pg_stat_activity WHERE application_name = 'hangingApplication'`
 		Eventually(func() string { return dbconn.MustSelectString(connectionPool, query) }, 5*time.Second, 100*time.Millisecond).Should(Equal("0"))
 
 	})
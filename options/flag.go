@@ -51,12 +51,45 @@ const (
 	TRUNCATE_TABLE        = "truncate-table"
 	WITHOUT_GLOBALS       = "without-globals"
 	RESIZE_CLUSTER        = "resize-cluster"
+	INDEX_JOBS_PER_TABLE  = "index-jobs-per-table"
+	MAINTENANCE_WORK_MEM  = "maintenance-work-mem"
+	PRIORITIZE_TABLE      = "prioritize-table"
+	MAX_BANDWIDTH         = "max-bandwidth"
+	SESSION_GUC           = "session-guc"
+	SESSION_GUC_FILE      = "session-guc-file"
+	REDISTRIBUTE_ON_LOAD  = "redistribute-on-load"
+	OUTPUT_DIR            = "output-dir"
+	EXPORT_FORMAT         = "format"
+	DATA_DIR_TEMPLATE     = "data-dir-template"
+	TARGET_FLAVOR         = "target-flavor"
+	ROW_FILTER_FILE       = "where-file"
+	MASK_FILE             = "mask-file"
+	SAMPLE                = "sample"
+	SAMPLE_FOLLOW_FKS     = "sample-follow-fks"
+	DROP_REMOVED_TABLES   = "drop-removed-tables"
+	FOLLOW                = "follow"
+	FOLLOW_INTERVAL       = "follow-interval"
+	LOCK_WAIT             = "lock-wait"
+	STALL_TIMEOUT         = "stall-timeout"
+	CANCEL_STALLED        = "cancel-stalled"
+	RETRIES               = "retries"
+	LAYOUT_TEMPLATE       = "layout-template"
+	METADATA_DIR          = "metadata-dir"
+	DATA_DIR              = "data-dir"
+	OFFLOAD_TO_MIRRORS    = "offload-to-mirrors"
+	SOURCE_HOST           = "source-host"
+	SOURCE_PORT           = "source-port"
+	SOURCE_DIR            = "source-dir"
+	// Apart from PLUGIN_CONFIG, which stores the segment data
+	METADATA_PLUGIN_CONFIG = "metadata-plugin-config"
 )
 
 func SetBackupFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.String(BACKUP_DIR, "", "The absolute path of the directory to which all backup files will be written")
+	flagSet.Bool(CANCEL_STALLED, false, "Cancel the COPY of a table whose gpbackup_helper agent stalls, which fails the backup instead of letting it hang. Must be specified with --stall-timeout")
 	flagSet.String(COMPRESSION_TYPE, "gzip", "Type of compression to use during data backup. Valid values are 'gzip', 'zstd'")
 	flagSet.Int(COMPRESSION_LEVEL, 1, "Level of compression to use during data backup. Range of valid values depends on compression type")
+	flagSet.String(DATA_DIR, "", "The absolute path of the directory to which segment data files will be written, laid out as with --backup-dir. Coordinator files are written here too, unless --metadata-dir or --metadata-plugin-config is specified")
 	flagSet.Bool(DATA_ONLY, false, "Only back up data, do not back up metadata")
 	flagSet.String(DBNAME, "", "The database to be backed up")
 	flagSet.Bool(DEBUG, false, "Print verbose and debug log messages")
@@ -72,23 +105,40 @@ func SetBackupFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.String(INCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified tables to be included in the backup")
 	flagSet.Bool(INCREMENTAL, false, "Only back up data for AO tables that have been modified since the last backup")
 	flagSet.Int(JOBS, 1, "The number of parallel connections to use when backing up data")
+	flagSet.String(LAYOUT_TEMPLATE, "", "Lay out the backup directory of each segment with this path, relative to --backup-dir or else to the segment data directory, instead of '<prefix><content>/backups/<date>/<timestamp>'. Tokens {host}, {content}, {dbid}, {database}, {timestamp}, {date}, {year}, {month}, and {day} are replaced, e.g. '{database}/{host}/seg{content}/{timestamp}'. Must contain {timestamp}, and {content} or {dbid} with --backup-dir. {host} and {dbid} require --single-data-file")
 	flagSet.Bool(LEAF_PARTITION_DATA, false, "For partition tables, create one data file per leaf partition instead of one data file for the whole table")
+	flagSet.String(LOCK_WAIT, "", "How long to wait for another backup of the same database to finish before failing, e.g. '30m'. By default the backup fails at once")
+	flagSet.String(MAX_BANDWIDTH, "", "Maximum rate at which each segment host writes backup data, e.g. '100MB' per second. Can be changed during the backup by editing the max_bandwidth file in the backup directory")
+	flagSet.String(METADATA_DIR, "", "The absolute path of the directory on the coordinator host to which the coordinator files, such as the metadata, TOC, config, report, and statistics files, will be written instead of --backup-dir, --data-dir, the segment data directories, or the plugin destination")
+	flagSet.String(METADATA_PLUGIN_CONFIG, "", "The configuration file of a plugin, run only on the coordinator host, to which the coordinator files will be sent instead of the --plugin-config destination or the backup directory, so that they can be stored apart from the segment data")
 	flagSet.Bool(METADATA_ONLY, false, "Only back up metadata, do not back up data")
 	flagSet.Bool(NO_COMPRESSION, false, "Skip compression of data files")
 	flagSet.Bool(NO_HISTORY, false, "Do not write a backup entry to the gpbackup_history database")
+	flagSet.Bool(OFFLOAD_TO_MIRRORS, false, "Compress and write or upload the data of each segment on the host of its mirror, when that is another segment host, so that the primary host only streams the data of its COPY commands there over SSH. Must be specified with --single-data-file and --plugin-config")
 	flagSet.String(PLUGIN_CONFIG, "", "The configuration file to use for a plugin")
+	flagSet.StringArray(PRIORITIZE_TABLE, []string{}, "Back up data for the specified table(s) before all other tables, which are otherwise backed up largest first. --prioritize-table can be specified multiple times.")
 	flagSet.Bool("version", false, "Print version number and exit")
 	flagSet.Bool(QUIET, false, "Suppress non-warning, non-error log messages")
+	flagSet.Int(RETRIES, 0, "Number of times to retry the data backup of a table after a transient error, such as a lost connection between segments, a lock timeout, or a plugin exiting with code 75, waiting longer before each retry. Cannot be used with --single-data-file")
+	flagSet.StringArray(SESSION_GUC, []string{}, "Set the GUC to the given value, in the format name=value, on every backup connection. Overrides the value in --session-guc-file. --session-guc can be specified multiple times.")
+	flagSet.String(SESSION_GUC_FILE, "", "A YAML file containing a role and GUC settings to apply to every backup connection")
 	flagSet.Bool(SINGLE_DATA_FILE, false, "Back up all data to a single file instead of one per table")
+	flagSet.String(STALL_TIMEOUT, "", "Log a warning naming the segment and table when a gpbackup_helper agent moves no data in the middle of a table, waits for the COPY of a table to open its pipe, or stops responding, for longer than this duration, e.g. '10m'. Must be specified with --single-data-file")
 	flagSet.Int(COPY_QUEUE_SIZE, 1, "number of COPY commands gpbackup should enqueue when backing up using the --single-data-file option")
 	flagSet.Bool(VERBOSE, false, "Print verbose log messages")
 	flagSet.Bool(WITH_STATS, false, "Back up query plan statistics")
 	flagSet.Bool(WITHOUT_GLOBALS, false, "Skip backup of global metadata")
+	flagSet.String(ROW_FILTER_FILE, "", "A YAML file mapping fully-qualified table names to SQL predicates. Only rows matching a table's predicate are backed up, and the backup is marked as filtered")
+	flagSet.String(MASK_FILE, "", "A YAML file mapping schema.table.column names to masking rules: 'hash', 'null', 'fixed:<value>', or 'faker:<email|name|phone>'. Masked values are written to the backup instead of the originals, and the backup is marked as filtered. Columns of a unique key can only be masked with 'null', or 'hash' on a text column")
+	flagSet.String(SAMPLE, "", "Back up only a sample of the rows of each table, given as a percentage, e.g. '10%', or an approximate number of rows per table, e.g. '1000'. The backup is marked as sampled and filtered")
+	flagSet.Bool(SAMPLE_FOLLOW_FKS, false, "Sample tables that reference other tables through foreign keys so that their sampled rows refer only to sampled rows. Must be specified with --sample")
 }
 
 func SetRestoreFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.String(BACKUP_DIR, "", "The absolute path of the directory in which the backup files to be restored are located")
+	flagSet.Bool(CANCEL_STALLED, false, "Cancel the COPY of a table whose gpbackup_helper agent stalls, so that the table fails instead of hanging. With --on-error-continue, the table is restored once more by new gpbackup_helper agents after the other tables. Must be specified with --stall-timeout")
 	flagSet.Bool(CREATE_DB, false, "Create the database before metadata restore")
+	flagSet.String(DATA_DIR, "", "The absolute path of the directory in which the segment data files to be restored are located, for a backup taken with --data-dir. Can be combined with --metadata-dir or --metadata-plugin-config")
 	flagSet.Bool(DATA_ONLY, false, "Only restore data, do not restore metadata")
 	flagSet.Bool(DEBUG, false, "Print verbose and debug log messages")
 	flagSet.StringArray(EXCLUDE_SCHEMA, []string{}, "Restore all metadata except objects in the specified schema(s). --exclude-schema can be specified multiple times.")
@@ -100,15 +150,29 @@ func SetRestoreFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.String(INCLUDE_SCHEMA_FILE, "", "A file containing a list of schemas that will be restored")
 	flagSet.StringArray(INCLUDE_RELATION, []string{}, "Restore only the specified relation(s). --include-table can be specified multiple times.")
 	flagSet.String(INCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified relation(s) that will be restored")
-	flagSet.Bool(INCREMENTAL, false, "BETA FEATURE: Only restore data for all heap tables and only AO tables that have been modified since the last backup")
+	flagSet.Bool(INCREMENTAL, false, "BETA FEATURE: Only restore data for all heap tables and only AO tables that have been modified since the last backup. Missing schemas and tables are created, and AO tables whose DDL changed are recreated")
+	flagSet.Bool(DROP_REMOVED_TABLES, false, "Drop tables in the schemas of the backup that are not in the backup. Must be specified with --incremental")
+	flagSet.Bool(FOLLOW, false, "After the restore, keep applying each new incremental backup in the chain of the restored backup as it is taken, until gprestore is stopped. The last backup applied is recorded in the public.gprestore_follow_state table of the restore database. Backups stored with a plugin can only be followed with a backup history database on the coordinator. Must be specified with --incremental, and cannot be used with --create-db or --with-globals")
+	flagSet.Int(FOLLOW_INTERVAL, 60, "Number of seconds to wait between checks for a new incremental backup with --follow")
+	flagSet.String(MAX_BANDWIDTH, "", "Maximum rate at which each segment host reads backup data, e.g. '100MB' per second. Can be changed during the restore by editing the max_bandwidth file in the backup directory")
+	flagSet.String(METADATA_DIR, "", "The absolute path of the directory in which the coordinator files to be restored are located, for a backup taken with --metadata-dir")
+	flagSet.String(METADATA_PLUGIN_CONFIG, "", "The configuration file of the plugin from which the coordinator files to be restored are retrieved, for a backup taken with --metadata-plugin-config")
 	flagSet.Bool(METADATA_ONLY, false, "Only restore metadata, do not restore data")
-	flagSet.Int(JOBS, 1, "Number of parallel connections to use when restoring table data and post-data")
+	flagSet.Int(JOBS, 1, "Number of parallel connections to use when restoring table data, pre-data, and post-data")
+	flagSet.String(LAYOUT_TEMPLATE, "", "The --layout-template with which the backup was taken, with {database} replaced by the name of the database that was backed up. Only needed if the backup is not in the backup history database of this cluster")
 	flagSet.Bool(ON_ERROR_CONTINUE, false, "Log errors and continue restore, instead of exiting on first error")
 	flagSet.String(PLUGIN_CONFIG, "", "The configuration file to use for a plugin")
+	flagSet.StringArray(PRIORITIZE_TABLE, []string{}, "Restore data for the specified table(s) before all other tables, which are otherwise restored largest first. --prioritize-table can be specified multiple times.")
 	flagSet.Bool("version", false, "Print version number and exit")
 	flagSet.Bool(QUIET, false, "Suppress non-warning, non-error log messages")
 	flagSet.String(REDIRECT_DB, "", "Restore to the specified database instead of the database that was backed up")
 	flagSet.String(REDIRECT_SCHEMA, "", "Restore to the specified schema instead of the schema that was backed up")
+	flagSet.StringArray(SESSION_GUC, []string{}, "Set the GUC to the given value, in the format name=value, on every restore connection. Overrides the value in --session-guc-file. --session-guc can be specified multiple times.")
+	flagSet.String(SESSION_GUC_FILE, "", "A YAML file containing a role and GUC settings to apply to every restore connection")
+	flagSet.String(SOURCE_DIR, "", "The --backup-dir with which the backup was taken on the cluster given by --source-host. Must be specified with --source-host")
+	flagSet.String(SOURCE_HOST, "", "Restore a backup from the cluster whose coordinator runs on this host, streaming the data files from its segment hosts over SSH instead of reading them from this cluster. The coordinator files are copied to where this cluster would have them. Only for backups taken with --single-data-file")
+	flagSet.Int(SOURCE_PORT, 5432, "The port of the coordinator of the cluster given by --source-host")
+	flagSet.String(STALL_TIMEOUT, "", "Log a warning naming the segment and table when a gpbackup_helper agent moves no data in the middle of a table, waits for the COPY of a table to open its pipe, or stops responding, for longer than this duration, e.g. '10m'. Only applies to backups taken with --single-data-file")
 	flagSet.Int(COPY_QUEUE_SIZE, 1, "Number of COPY commands gprestore should enqueue when restoring a backup taken using the --single-data-file option")
 	flagSet.Bool(WITH_GLOBALS, false, "Restore global metadata")
 	flagSet.String(TIMESTAMP, "", "The timestamp to be restored, in the format YYYYMMDDHHMMSS")
@@ -118,9 +182,27 @@ func SetRestoreFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.Bool(LEAF_PARTITION_DATA, false, "For partition tables, create one data file per leaf partition instead of one data file for the whole table")
 	flagSet.Bool(RUN_ANALYZE, false, "Run ANALYZE on restored tables")
 	flagSet.Bool(RESIZE_CLUSTER, false, "Restore a backup taken on a cluster with more or fewer segments than the cluster to which it will be restored")
+	flagSet.Bool(REDISTRIBUTE_ON_LOAD, false, "During a --resize-cluster restore, route rows of hash-distributed tables to their destination segments as they are loaded, instead of redistributing each table afterwards")
+	flagSet.Int(INDEX_JOBS_PER_TABLE, 1, "Maximum number of indexes or constraints to build concurrently on a single table during post-data restore")
+	flagSet.String(MAINTENANCE_WORK_MEM, "", "Value of maintenance_work_mem to set on each restore connection, e.g. '1GB'")
+	flagSet.String(TARGET_FLAVOR, "greenplum", "The kind of database to restore to. Valid values are 'greenplum', 'postgres'. A postgres restore requires --backup-dir, which must be readable at the same path by the PostgreSQL server")
 	_ = flagSet.MarkHidden(LEAF_PARTITION_DATA)
 }
 
+func SetExportFlagDefaults(flagSet *pflag.FlagSet) {
+	flagSet.String(BACKUP_DIR, "", "The absolute path of the directory in which the backup files to be exported are located")
+	flagSet.String(DATA_DIR_TEMPLATE, "", "For backups not taken with --backup-dir, the data directory of each segment, with <SEGID> in place of the content id, e.g. '/data/gpseg<SEGID>'")
+	flagSet.Bool(DEBUG, false, "Print verbose and debug log messages")
+	flagSet.String(EXPORT_FORMAT, "csv", "Format of the exported files. Valid values are 'csv', 'parquet'")
+	flagSet.Bool("help", false, "Help for gpbackup export")
+	flagSet.StringArray(INCLUDE_RELATION, []string{}, "Export only the specified table(s). --include-table can be specified multiple times.")
+	flagSet.String(OUTPUT_DIR, "", "The absolute path of the directory to which one file per table will be written")
+	flagSet.String(PLUGIN_CONFIG, "", "The configuration file to use for a plugin")
+	flagSet.Bool(QUIET, false, "Suppress non-warning, non-error log messages")
+	flagSet.String(TIMESTAMP, "", "The timestamp to be exported, in the format YYYYMMDDHHMMSS")
+	flagSet.Bool(VERBOSE, false, "Print verbose log messages")
+}
+
 /*
  * Functions for validating whether flags are set and in what combination
  */
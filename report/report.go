@@ -27,8 +27,16 @@ import (
  * file that we will want to read in for a restore.
  */
 type Report struct {
-	BackupParamsString string
-	DatabaseSize       string
+	BackupParamsString     string
+	DatabaseSize           string
+	DataParallelEfficiency string
+	SessionGUCs            string
+	// Errors reported by gpbackup_helper agents
+	HelperErrors []utils.AgentError
+	// Number of times the data backup of each table was retried with --retries, keyed by table FQN
+	TableRetries map[string]int
+	// Tables whose data was not backed up, as the backup was stopped early
+	SkippedTables []string
 	history.BackupConfig
 }
 
@@ -61,6 +69,9 @@ func (report *Report) ConstructBackupParamsString() {
 	if report.ExcludeTableFiltered {
 		filterStr += "Exclude Table Filter"
 	}
+	if report.Filtered {
+		filterStr += "Row Filter"
+	}
 	if filterStr == "" {
 		filterStr = "None"
 	}
@@ -96,9 +107,13 @@ backup section: %s
 object filtering: %s
 includes statistics: %s
 data file format: %s
-%s`
+%s%s`
+	sampleStr := ""
+	if report.Sample != "" {
+		sampleStr = fmt.Sprintf("data sample: %s\n", report.Sample)
+	}
 	report.BackupParamsString = fmt.Sprintf(backupParamsTemplate, compressStr, pluginStr, sectionStr, filterStr,
-		statsStr, filesStr, report.constructIncrementalSection())
+		statsStr, filesStr, sampleStr, report.constructIncrementalSection())
 }
 
 func (report *Report) constructIncrementalSection() string {
@@ -146,11 +161,18 @@ func (report *Report) WriteBackupReportFile(reportFilename string, timestamp str
 			LineInfo{},
 			LineInfo{Key: "backup status:", Value: history.BackupStatusFailed},
 			LineInfo{Key: "backup error:", Value: errMsg})
+	} else if report.StoppedEarly() {
+		reportInfo = append(reportInfo,
+			LineInfo{},
+			LineInfo{Key: "backup status:", Value: history.BackupStatusPartial},
+			LineInfo{Key: "tables skipped:", Value: fmt.Sprintf("%d (listed in the TOC file)", len(report.SkippedTables))})
 	} else {
 		reportInfo = append(reportInfo,
 			LineInfo{},
 			LineInfo{Key: "backup status:", Value: history.BackupStatusSucceed})
 	}
+	AppendHelperErrors(&reportInfo, report.HelperErrors)
+	AppendTableRetries(&reportInfo, report.TableRetries)
 	reportInfo = append(reportInfo, LineInfo{})
 	if report.DatabaseSize != "" {
 		reportInfo = append(reportInfo,
@@ -158,6 +180,14 @@ func (report *Report) WriteBackupReportFile(reportFilename string, timestamp str
 	}
 	reportInfo = append(reportInfo,
 		LineInfo{Key: "segment count:", Value: fmt.Sprintf("%d", report.SegmentCount)})
+	if report.DataParallelEfficiency != "" {
+		reportInfo = append(reportInfo,
+			LineInfo{Key: "data parallel efficiency:", Value: report.DataParallelEfficiency})
+	}
+	if report.SessionGUCs != "" {
+		reportInfo = append(reportInfo,
+			LineInfo{Key: "session gucs:", Value: report.SessionGUCs})
+	}
 
 	_, err = fmt.Fprint(reportFile, "Greenplum Database Backup Report\n\n")
 	if err != nil {
@@ -174,7 +204,7 @@ func (report *Report) WriteBackupReportFile(reportFilename string, timestamp str
 	_ = operating.System.Chmod(reportFilename, 0444)
 }
 
-func WriteRestoreReportFile(reportFilename string, backupTimestamp string, startTimestamp string, connectionPool *dbconn.DBConn, restoreVersion string, origSize int, destSize int, errMsg string) {
+func WriteRestoreReportFile(reportFilename string, backupTimestamp string, startTimestamp string, connectionPool *dbconn.DBConn, restoreVersion string, origSize int, destSize int, dataParallelEfficiency string, sessionGUCs string, helperErrors []utils.AgentError, errMsg string) {
 	reportFile, err := iohelper.OpenFileForWriting(reportFilename)
 	if err != nil {
 		gplog.Error("Unable to open restore report file %s", reportFilename)
@@ -199,6 +229,14 @@ func WriteRestoreReportFile(reportFilename string, backupTimestamp string, start
 		LineInfo{Key: "end time:", Value: end},
 		LineInfo{Key: "duration:", Value: duration},
 	)
+	if dataParallelEfficiency != "" {
+		reportInfo = append(reportInfo,
+			LineInfo{Key: "data parallel efficiency:", Value: dataParallelEfficiency})
+	}
+	if sessionGUCs != "" {
+		reportInfo = append(reportInfo,
+			LineInfo{Key: "session gucs:", Value: sessionGUCs})
+	}
 
 	var restoreStatus string
 	errorCode := gplog.GetErrorCode()
@@ -217,6 +255,7 @@ func WriteRestoreReportFile(reportFilename string, backupTimestamp string, start
 			LineInfo{},
 			LineInfo{Key: "restore status:", Value: "Success"})
 	}
+	AppendHelperErrors(&reportInfo, helperErrors)
 
 	logOutputReport(reportFile, reportInfo)
 
@@ -416,3 +455,30 @@ func AppendBackupParams(infoArr *[]LineInfo, paramsStr string) {
 		}
 	}
 }
+
+// Lists each error reported by a gpbackup_helper agent, with its segment, host, and table
+func AppendHelperErrors(infoArr *[]LineInfo, helperErrors []utils.AgentError) {
+	if len(helperErrors) == 0 {
+		return
+	}
+	*infoArr = append(*infoArr, LineInfo{})
+	for _, helperError := range helperErrors {
+		*infoArr = append(*infoArr, LineInfo{Key: "helper error:", Value: helperError.String()})
+	}
+}
+
+// Lists the tables whose data backup was retried, with the number of retries of each
+func AppendTableRetries(infoArr *[]LineInfo, tableRetries map[string]int) {
+	if len(tableRetries) == 0 {
+		return
+	}
+	tableNames := make([]string, 0, len(tableRetries))
+	for tableName := range tableRetries {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	*infoArr = append(*infoArr, LineInfo{})
+	for _, tableName := range tableNames {
+		*infoArr = append(*infoArr, LineInfo{Key: "table retries:", Value: fmt.Sprintf("%s: %d", tableName, tableRetries[tableName])})
+	}
+}
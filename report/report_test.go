@@ -183,6 +183,41 @@ sequences   1
 tables      42
 types       1000`))
 		})
+		It("writes a report with session GUCs", func() {
+			backupReport.SessionGUCs = "role=backup_role statement_timeout=1h"
+			backupReport.WriteBackupReportFile("filename", timestamp, endtime, objectCounts, "")
+			Expect(buffer).To(Say(`segment count:         3
+session gucs:          role=backup_role statement_timeout=1h`))
+		})
+		It("writes a report with helper errors", func() {
+			backupReport.HelperErrors = []utils.AgentError{{Content: 1, Host: "sdw1", Table: "public.foo", Phase: "plugin", Message: "exit status 1", Detail: "bucket not found"}}
+			backupReport.WriteBackupReportFile("filename", timestamp, endtime, objectCounts, "Encountered 1 error(s) with helper agent(s)")
+			Expect(buffer).To(Say(`backup status:         Failure
+backup error:          Encountered 1 error\(s\) with helper agent\(s\)
+
+helper error:          segment 1 on host sdw1, table public.foo, plugin phase: exit status 1 \(output: bucket not found\)
+
+database size:         42 MB`))
+		})
+		It("writes a report with table retries", func() {
+			backupReport.TableRetries = map[string]int{"public.foo": 2, "public.bar": 1}
+			backupReport.WriteBackupReportFile("filename", timestamp, endtime, objectCounts, "")
+			Expect(buffer).To(Say(`backup status:         Success
+
+table retries:         public.bar: 1
+table retries:         public.foo: 2
+
+database size:         42 MB`))
+		})
+		It("writes a report for a backup that was stopped early", func() {
+			backupReport.Status = history.BackupStatusPartial
+			backupReport.SkippedTables = []string{"public.foo", "public.bar"}
+			backupReport.WriteBackupReportFile("filename", timestamp, endtime, objectCounts, "")
+			Expect(buffer).To(Say(`backup status:         Partial
+tables skipped:        2 \(listed in the TOC file\)
+
+database size:         42 MB`))
+		})
 	})
 	Describe("AppendBackupParams", func() {
 		It("correctly parses the string and appends to the LineInfo array", func() {
@@ -244,7 +279,7 @@ incremental backup set:
 
 		It("writes a report for a failed restore", func() {
 			gplog.SetErrorCode(2)
-			report.WriteRestoreReportFile("filename", timestamp, restoreStartTime, connectionPool, restoreVersion, 3, 4, "Cannot access /tmp/backups: Permission denied")
+			report.WriteRestoreReportFile("filename", timestamp, restoreStartTime, connectionPool, restoreVersion, 3, 4, "", "", nil, "Cannot access /tmp/backups: Permission denied")
 			Expect(buffer).To(Say(`Greenplum Database Restore Report
 
 timestamp key:           20170101010101
@@ -265,7 +300,7 @@ restore error:           Cannot access /tmp/backups: Permission denied`))
 		})
 		It("writes a report for a successful restore", func() {
 			gplog.SetErrorCode(0)
-			report.WriteRestoreReportFile("filename", timestamp, restoreStartTime, connectionPool, restoreVersion, 3, 3, "")
+			report.WriteRestoreReportFile("filename", timestamp, restoreStartTime, connectionPool, restoreVersion, 3, 3, "", "", nil, "")
 			Expect(buffer).To(Say(`Greenplum Database Restore Report
 
 timestamp key:           20170101010101
@@ -285,7 +320,7 @@ restore status:          Success`))
 		})
 		It("writes a report for a successful restore with errors", func() {
 			gplog.SetErrorCode(1)
-			report.WriteRestoreReportFile("filename", timestamp, restoreStartTime, connectionPool, restoreVersion, 3, 3, "")
+			report.WriteRestoreReportFile("filename", timestamp, restoreStartTime, connectionPool, restoreVersion, 3, 3, "", "", nil, "")
 			Expect(buffer).To(Say(`Greenplum Database Restore Report
 
 timestamp key:           20170101010101
@@ -339,6 +374,24 @@ restore status:          Success but non-fatal errors occurred. See log file .+
 				Status:               history.BackupStatusFailed,
 			}, backupConfig)
 		})
+		It("records the segments that {host} and {dbid} in the layout template stand for", func() {
+			backupCmdFlags := pflag.NewFlagSet("gpbackup", pflag.ExitOnError)
+			backup.SetCmdFlags(backupCmdFlags)
+			err := backupCmdFlags.Set(options.LAYOUT_TEMPLATE, "{host}/seg{content}/{timestamp}")
+			Expect(err).ToNot(HaveOccurred())
+			opts, err := options.NewOptions(backupCmdFlags)
+			Expect(err).ToNot(HaveOccurred())
+			c := cluster.NewCluster([]cluster.SegConfig{
+				{ContentID: -1, DbID: 1, Hostname: "cdw", DataDir: "/data/gpseg-1"},
+				{ContentID: 0, DbID: 2, Hostname: "sdw1", DataDir: "/data/gpseg0"},
+			})
+			backup.SetFPInfo(filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg"))
+			defer backup.SetFPInfo(filepath.FilePathInfo{})
+
+			backupConfig := backup.NewBackupConfig("testdb", "5.0.0 build test", "0.1.0", "", "20170101010101", *opts)
+			Expect(backupConfig.SegmentHosts).To(Equal(map[int]string{-1: "cdw", 0: "sdw1"}))
+			Expect(backupConfig.SegmentDbIDs).To(Equal(map[int]int{-1: 1, 0: 2}))
+		})
 	})
 	Describe("GetDurationInfo", func() {
 		timestamp := "20170101010101"
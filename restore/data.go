@@ -6,8 +6,10 @@ package restore
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
@@ -25,7 +27,13 @@ var (
 	tableDelim = ","
 )
 
-func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableAttributes string, destinationToRead string, singleDataFile bool, whichConn int) (int64, error) {
+// Validated in DoValidation, so the error can be ignored here
+func getMaxBandwidth() int64 {
+	maxBandwidth, _ := utils.ParseBandwidth(MustGetFlagString(options.MAX_BANDWIDTH))
+	return maxBandwidth
+}
+
+func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableAttributes string, destinationToRead string, oid uint32, singleDataFile bool, whichConn int) (int64, error) {
 	whichConn = connectionPool.ValidateConnNum(whichConn)
 	copyCommand := ""
 	readFromDestinationCommand := "cat"
@@ -38,23 +46,22 @@ func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableAttribute
 	} else if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
 		readFromDestinationCommand = fmt.Sprintf("%s restore_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
 	}
+	// The helper throttles single data file and resize restores itself
+	if maxBandwidth := getMaxBandwidth(); maxBandwidth > 0 && !singleDataFile && !resizeCluster {
+		throttleCommand := utils.GetThrottleCommandForCopy(globalFPInfo, maxBandwidth, utils.GetBandwidthShare(globalCluster, connectionPool.NumConns))
+		customPipeThroughCommand = fmt.Sprintf("%s | %s", throttleCommand, customPipeThroughCommand)
+	}
 
 	copyCommand = fmt.Sprintf("PROGRAM '%s %s | %s'", readFromDestinationCommand, destinationToRead, customPipeThroughCommand)
 
-	query := fmt.Sprintf("COPY %s%s FROM %s WITH CSV DELIMITER '%s' ON SEGMENT;", tableName, tableAttributes, copyCommand, tableDelim)
+	// The marker identifies the COPY in pg_stat_activity, so that it can be canceled precisely
+	query := fmt.Sprintf("%s COPY %s%s FROM %s WITH CSV DELIMITER '%s' ON SEGMENT;", utils.StartCopySession(whichConn, oid), tableName, tableAttributes, copyCommand, tableDelim)
+	defer utils.EndCopySession(whichConn)
 
 	var numRows int64
 	var err error
 
-	// During a larger-to-smaller restore, we need multiple COPY passes to load all the data.
-	// One pass is sufficient for smaller-to-larger and normal restores.
-	batches := 1
-	if resizeCluster && origSize > destSize {
-		batches = origSize / destSize
-		if origSize%destSize != 0 {
-			batches += 1
-		}
-	}
+	batches := GetResizeBatchCount(origSize, destSize, resizeCluster)
 	for i := 0; i < batches; i++ {
 		if connectionPool.Version.AtLeast("7") {
 			gplog.Verbose(`Executing "%s" on coordinator`, query)
@@ -79,6 +86,131 @@ func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableAttribute
 	return numRows, err
 }
 
+// During a larger-to-smaller restore, we need multiple passes to load all the data.
+// One pass is sufficient for smaller-to-larger and normal restores.
+func GetResizeBatchCount(origSize int, destSize int, resizeCluster bool) int {
+	batches := 1
+	if resizeCluster && origSize > destSize {
+		batches = origSize / destSize
+		if origSize%destSize != 0 {
+			batches += 1
+		}
+	}
+	return batches
+}
+
+/*
+ * Loads data for a resize restore through a temporary external web table
+ * that reads each segment's helper pipe, so that INSERT ... SELECT hashes
+ * each row on the table's distribution key and sends it directly to the
+ * segment it belongs on.  This avoids loading the rows where they happen to
+ * land and then rewriting the whole table with a REORGANIZE.
+ *
+ * The helpers still feed their pipes as in any other resize restore rather
+ * than routing rows themselves.  Which segment a row belongs on depends on
+ * the hash operator class of each distribution key column and on the hashing
+ * scheme of the destination version, none of which gpbackup_helper can
+ * reproduce, so the hashing is left to the database's own motion.
+ */
+func CopyTableInWithRedistribution(connectionPool *dbconn.DBConn, tableName string, tableAttributes string, destinationToRead string, oid uint32, whichConn int) (int64, error) {
+	whichConn = connectionPool.ValidateConnNum(whichConn)
+	origSize, destSize, resizeCluster := GetResizeClusterInfo()
+
+	columnDefs, err := GetLoadColumnDefinitions(connectionPool, tableName, tableAttributes, whichConn)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Error loading data into table %s", tableName)
+	}
+	// External web table commands are run by the shell on each segment, which sets these variables
+	readCommand := strings.NewReplacer("<SEG_DATA_DIR>", "$GP_SEG_DATADIR", "<SEGID>", "${GP_SEGMENT_ID}").Replace(destinationToRead)
+	loadTableName := fmt.Sprintf("gprestore_load_%d", oid)
+	createQuery := fmt.Sprintf("CREATE READABLE EXTERNAL WEB TEMPORARY TABLE %s (%s) EXECUTE 'cat %s' ON ALL FORMAT 'csv' (DELIMITER '%s');",
+		loadTableName, columnDefs, utils.EscapeSingleQuotes(readCommand), tableDelim)
+	_, err = connectionPool.Exec(createQuery, whichConn)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Error creating external table to load data into table %s", tableName)
+	}
+	defer func() {
+		_, _ = connectionPool.Exec(fmt.Sprintf("DROP EXTERNAL TABLE IF EXISTS %s;", loadTableName), whichConn)
+	}()
+
+	query := fmt.Sprintf("%s INSERT INTO %s%s SELECT * FROM %s;", utils.StartCopySession(whichConn, oid), tableName, tableAttributes, loadTableName)
+	defer utils.EndCopySession(whichConn)
+	var numRows int64
+	batches := GetResizeBatchCount(origSize, destSize, resizeCluster)
+	for i := 0; i < batches; i++ {
+		gplog.Verbose(`Executing "%s"`, query)
+		result, err := connectionPool.Exec(query, whichConn)
+		if err != nil {
+			errStr := fmt.Sprintf("Error loading data into table %s", tableName)
+			if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Where != "" {
+				errStr = fmt.Sprintf("%s: %s", errStr, pgErr.Where)
+			}
+			return 0, errors.Wrap(err, errStr)
+		}
+		rowsLoaded, _ := result.RowsAffected()
+		numRows += rowsLoaded
+	}
+	return numRows, nil
+}
+
+/*
+ * Returns the column definitions of the data file for a table, in the order
+ * given by the attribute list recorded in the TOC, using the column types of
+ * the table being restored into.
+ */
+func GetLoadColumnDefinitions(connectionPool *dbconn.DBConn, tableName string, tableAttributes string, whichConn int) (string, error) {
+	query := fmt.Sprintf(`
+	SELECT quote_ident(attname) AS name,
+		pg_catalog.format_type(atttypid, atttypmod) AS type
+	FROM pg_attribute
+	WHERE attrelid = '%s'::regclass
+		AND attnum > 0
+		AND NOT attisdropped
+	ORDER BY attnum`, utils.EscapeSingleQuotes(tableName))
+	results := make([]struct {
+		Name string
+		Type string
+	}, 0)
+	err := connectionPool.Select(&results, query, whichConn)
+	if err != nil {
+		return "", err
+	}
+	columnTypes := make(map[string]string, len(results))
+	columnNames := make([]string, 0, len(results))
+	for _, column := range results {
+		columnTypes[column.Name] = column.Type
+		columnNames = append(columnNames, column.Name)
+	}
+	if tableAttributes != "" {
+		columnNames = utils.SplitAttributeList(tableAttributes)
+	}
+
+	columnDefs := make([]string, 0, len(columnNames))
+	for _, name := range columnNames {
+		columnType, ok := columnTypes[name]
+		if !ok {
+			return "", errors.Errorf("Column %s does not exist in table %s", name, tableName)
+		}
+		columnDefs = append(columnDefs, fmt.Sprintf("%s %s", name, columnType))
+	}
+	return strings.Join(columnDefs, ", "), nil
+}
+
+func IsHashDistributed(connectionPool *dbconn.DBConn, tableName string, whichConn int) (bool, error) {
+	distKeyCondition := "array_length(attrnums, 1) > 0"
+	if connectionPool.Version.AtLeast("6") {
+		distKeyCondition = "policytype = 'p' AND array_length(distkey::int2[], 1) > 0"
+	}
+	query := fmt.Sprintf("SELECT count(*) FROM gp_distribution_policy WHERE localoid = '%s'::regclass::oid AND %s",
+		utils.EscapeSingleQuotes(tableName), distKeyCondition)
+	var count []int
+	err := connectionPool.Select(&count, query, whichConn)
+	if err != nil {
+		return false, err
+	}
+	return len(count) > 0 && count[0] > 0, nil
+}
+
 func restoreSingleTableData(fpInfo *filepath.FilePathInfo, entry toc.CoordinatorDataEntry, tableName string, whichConn int, origSize int, destSize int) error {
 	resizeCluster := MustGetFlagBool(options.RESIZE_CLUSTER)
 	destinationToRead := ""
@@ -88,7 +220,21 @@ func restoreSingleTableData(fpInfo *filepath.FilePathInfo, entry toc.Coordinator
 		destinationToRead = fpInfo.GetTableBackupFilePathForCopyCommand(entry.Oid, utils.GetPipeThroughProgram().Extension, backupConfig.SingleDataFile)
 	}
 	gplog.Debug("Reading from %s", destinationToRead)
-	numRowsRestored, err := CopyTableIn(connectionPool, tableName, entry.AttributeString, destinationToRead, backupConfig.SingleDataFile, whichConn)
+	redistributeOnLoad := false
+	if resizeCluster && MustGetFlagBool(options.REDISTRIBUTE_ON_LOAD) && !entry.IsReplicated {
+		isHashDistributed, err := IsHashDistributed(connectionPool, tableName, whichConn)
+		if err != nil {
+			return err
+		}
+		redistributeOnLoad = isHashDistributed
+	}
+	var numRowsRestored int64
+	var err error
+	if redistributeOnLoad {
+		numRowsRestored, err = CopyTableInWithRedistribution(connectionPool, tableName, entry.AttributeString, destinationToRead, entry.Oid, whichConn)
+	} else {
+		numRowsRestored, err = CopyTableIn(connectionPool, tableName, entry.AttributeString, destinationToRead, entry.Oid, backupConfig.SingleDataFile, whichConn)
+	}
 	if err != nil {
 		return err
 	}
@@ -107,7 +253,8 @@ func restoreSingleTableData(fpInfo *filepath.FilePathInfo, entry toc.Coordinator
 		return err
 	}
 
-	if resizeCluster {
+	// Rows loaded through the distribution key are already on the right segments
+	if resizeCluster && !redistributeOnLoad {
 		// replicated tables cannot be redistributed, so instead expand them if needed
 		if entry.IsReplicated && (origSize < destSize) {
 			err = ExpandReplicatedTable(origSize, tableName, whichConn)
@@ -157,8 +304,41 @@ func RedistributeTableData(tableName string, whichConn int) error {
 	return err
 }
 
+/*
+ * Order data entries so that the largest tables are handed to workers first,
+ * using the relation sizes recorded at backup time.  Backups taken before
+ * sizes were recorded fall back to the number of rows copied.  Tables passed
+ * with --prioritize-table come before all others, in the order in which they
+ * were given.
+ */
+func SortDataEntriesBySize(dataEntries []toc.CoordinatorDataEntry, prioritizedTables []string) []toc.CoordinatorDataEntry {
+	useRelationSize := false
+	for _, entry := range dataEntries {
+		if entry.RelationSize > 0 {
+			useRelationSize = true
+			break
+		}
+	}
+	estimatedSize := func(entry toc.CoordinatorDataEntry) int64 {
+		if useRelationSize {
+			return entry.RelationSize
+		}
+		return entry.RowsCopied
+	}
+
+	sortedEntries := make([]toc.CoordinatorDataEntry, len(dataEntries))
+	copy(sortedEntries, dataEntries)
+	utils.SortByPriorityAndSize(sortedEntries, prioritizedTables,
+		func(i int) string { return utils.UnquotedFQN(sortedEntries[i].Schema, sortedEntries[i].Name) },
+		func(i int) int64 { return estimatedSize(sortedEntries[i]) })
+	return sortedEntries
+}
+
+// Set while the tables whose agents stalled are restored again, so that they are not retried twice
+var retryingStalledTables bool
+
 func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.CoordinatorDataEntry,
-	gucStatements []toc.StatementWithType, dataProgressBar utils.ProgressBar) int32 {
+	gucStatements []toc.StatementWithType, dataProgressBar utils.ProgressBar, timer *utils.ParallelTimer) int32 {
 	totalTables := len(dataEntries)
 	if totalTables == 0 {
 		gplog.Verbose("No data to restore for timestamp = %s", fpInfo.Timestamp)
@@ -166,7 +346,17 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Co
 	}
 
 	origSize, destSize, resizeCluster := GetResizeClusterInfo()
-	if backupConfig.SingleDataFile || resizeCluster {
+	isPostgresTarget := IsPostgresTarget()
+	// The helpers feed tables to their pipes in oid order, so tables can only
+	// be reordered when data is read directly from per-table files.
+	if (!backupConfig.SingleDataFile && !resizeCluster) || isPostgresTarget {
+		dataEntries = SortDataEntriesBySize(dataEntries, MustGetFlagStringArray(options.PRIORITIZE_TABLE))
+	}
+	// A PostgreSQL target has no segments, so no helpers are used
+	segmentTOCs := []*toc.SegmentTOC(nil)
+	if isPostgresTarget {
+		segmentTOCs = GetSegmentTOCsForCoordinatorCopy(fpInfo)
+	} else if backupConfig.SingleDataFile || resizeCluster {
 		msg := ""
 		if backupConfig.SingleDataFile {
 			msg += "single data file "
@@ -186,6 +376,9 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Co
 			}
 		}
 		utils.WriteOidListToSegments(oidList, globalCluster, fpInfo, "oid")
+		if sourceCluster != nil {
+			utils.WriteSourceListToSegments(GetSourceFPInfo(fpInfo.Timestamp), globalCluster, fpInfo)
+		}
 		if len(replicatedOidList) > 0 {
 			utils.WriteOidListToSegments(replicatedOidList, globalCluster, fpInfo, "replicated_oid")
 		}
@@ -201,11 +394,23 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Co
 		if backupConfig.Compressed {
 			compressStr = fmt.Sprintf(" --compression-type %s ", utils.GetPipeThroughProgram().Name)
 		}
-		utils.StartGpbackupHelpers(globalCluster, fpInfo, "--restore-agent", MustGetFlagString(options.PLUGIN_CONFIG), compressStr, MustGetFlagBool(options.ON_ERROR_CONTINUE), isFilter, &wasTerminated, initialPipes, backupConfig.SingleDataFile, resizeCluster, origSize, destSize)
+		utils.StartHelperControlServer(globalCluster)
+		tableNames := make(map[int]string, len(dataEntries))
+		for _, entry := range dataEntries {
+			tableNames[int(entry.Oid)] = utils.MakeFQN(entry.Schema, entry.Name)
+		}
+		utils.SetHelperTableNames(tableNames)
+		utils.StartGpbackupHelpers(globalCluster, fpInfo, "--restore-agent", MustGetFlagString(options.PLUGIN_CONFIG), compressStr, MustGetFlagBool(options.ON_ERROR_CONTINUE), isFilter, &wasTerminated, initialPipes, backupConfig.SingleDataFile, resizeCluster, origSize, destSize, getMaxBandwidth())
+		stallTimeout, _ := utils.ParseStallTimeout(MustGetFlagString(options.STALL_TIMEOUT))
+		var onStall func(utils.HelperStall)
+		if MustGetFlagBool(options.CANCEL_STALLED) {
+			onStall = func(stall utils.HelperStall) { utils.CancelStalledCopy(connectionPool.DBName, stall) }
+		}
+		utils.StartHelperStallMonitor(stallTimeout, onStall)
 	}
 	/*
 	 * We break when an interrupt is received and rely on
-	 * TerminateHangingCopySessions to stop any COPY
+	 * TerminateCopySessions to stop any COPY
 	 * statements in progress if they don't finish on their own.
 	 */
 	var tableNum int64 = 0
@@ -213,6 +418,7 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Co
 	var workerPool sync.WaitGroup
 	var numErrors int32
 	var mutex = &sync.Mutex{}
+	stalledEntries := make([]toc.CoordinatorDataEntry, 0)
 
 	for i := 0; i < connectionPool.NumConns; i++ {
 		workerPool.Add(1)
@@ -235,7 +441,13 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Co
 					err = TruncateTable(tableName, whichConn)
 				}
 				if err == nil {
-					err = restoreSingleTableData(&fpInfo, entry, tableName, whichConn, origSize, destSize)
+					tableStartTime := time.Now()
+					if isPostgresTarget {
+						err = restoreSingleTableDataOnCoordinator(&fpInfo, entry, tableName, segmentTOCs, whichConn)
+					} else {
+						err = restoreSingleTableData(&fpInfo, entry, tableName, whichConn, origSize, destSize)
+					}
+					timer.AddBusyTime(time.Since(tableStartTime))
 
 					if gplog.GetVerbosity() > gplog.LOGINFO {
 						// No progress bar at this log level, so we note table count here
@@ -245,13 +457,23 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Co
 					}
 				}
 
-				if err != nil {
+				// With --on-error-continue, a table canceled by --cancel-stalled is restored again after the other tables
+				stalled := utils.IsStalledCopyCancel(err, entry.Oid) && MustGetFlagBool(options.ON_ERROR_CONTINUE) && !retryingStalledTables
+				if stalled {
+					gplog.Warn("Restoring data to table %s again later, as its COPY was canceled when its gpbackup_helper agent stalled: %v", tableName, err)
+					mutex.Lock()
+					stalledEntries = append(stalledEntries, entry)
+					mutex.Unlock()
+					if connectionPool.Version.AtLeast("6") {
+						utils.CreateSkipFileOnSegments(fmt.Sprintf("%d", entry.Oid), tableName, globalCluster, globalFPInfo)
+					}
+				} else if err != nil {
 					gplog.Error(err.Error())
 					atomic.AddInt32(&numErrors, 1)
 					if !MustGetFlagBool(options.ON_ERROR_CONTINUE) {
 						dataProgressBar.(*pb.ProgressBar).NotPrint = true
 						return
-					} else if connectionPool.Version.AtLeast("6") && backupConfig.SingleDataFile {
+					} else if connectionPool.Version.AtLeast("6") && backupConfig.SingleDataFile && !isPostgresTarget {
 						// inform segment helpers to skip this entry
 						utils.CreateSkipFileOnSegments(fmt.Sprintf("%d", entry.Oid), tableName, globalCluster, globalFPInfo)
 					}
@@ -260,7 +482,7 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Co
 					mutex.Unlock()
 				}
 
-				if backupConfig.SingleDataFile {
+				if backupConfig.SingleDataFile && !isPostgresTarget {
 					agentErr := utils.CheckAgentErrorsOnSegments(globalCluster, globalFPInfo)
 					if agentErr != nil {
 						gplog.Error(agentErr.Error())
@@ -268,7 +490,9 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Co
 					}
 				}
 
-				dataProgressBar.Increment()
+				if !stalled {
+					dataProgressBar.Increment()
+				}
 			}
 		}(i)
 	}
@@ -278,6 +502,10 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Co
 	close(tasks)
 	workerPool.Wait()
 
+	if len(stalledEntries) > 0 && !wasTerminated {
+		numErrors += restoreStalledTables(fpInfo, stalledEntries, gucStatements, dataProgressBar, timer)
+	}
+
 	if numErrors > 0 {
 		fmt.Println("")
 		gplog.Error("Encountered %d error(s) during table data restore; see log file %s for a list of table errors.", numErrors, gplog.GetLogFilePath())
@@ -286,6 +514,31 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Co
 	return numErrors
 }
 
+/*
+ * Restores the data of the tables whose COPY was canceled because their
+ * gpbackup_helper agent stalled once more.  The stalled agents may never
+ * recover, so every agent is canceled and new agents are started for these
+ * tables.  A table whose agent stalls again fails.
+ */
+func restoreStalledTables(fpInfo filepath.FilePathInfo, dataEntries []toc.CoordinatorDataEntry,
+	gucStatements []toc.StatementWithType, dataProgressBar utils.ProgressBar, timer *utils.ParallelTimer) int32 {
+	gplog.Warn("Restoring data to %d table(s) again, as their gpbackup_helper agents stalled", len(dataEntries))
+	if !utils.CancelSegmentHelpersAndWait(globalCluster, fpInfo, "restore") {
+		gplog.Error("Unable to restore data to %d table(s) again, as not all gpbackup_helper agents exited after being canceled", len(dataEntries))
+		for _, entry := range dataEntries {
+			tableName := utils.MakeFQN(entry.Schema, entry.Name)
+			if opts.RedirectSchema != "" {
+				tableName = utils.MakeFQN(opts.RedirectSchema, entry.Name)
+			}
+			errorTablesData[tableName] = Empty{}
+		}
+		return int32(len(dataEntries))
+	}
+	retryingStalledTables = true
+	defer func() { retryingStalledTables = false }()
+	return restoreDataFromTimestamp(fpInfo, dataEntries, gucStatements, dataProgressBar, timer)
+}
+
 func CreateInitialSegmentPipes(oidList []string, c *cluster.Cluster, connectionPool *dbconn.DBConn, fpInfo filepath.FilePathInfo) int {
 	// Create min(connections, tables) segment pipes on each host
 	var maxPipes int
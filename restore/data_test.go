@@ -12,6 +12,7 @@ import (
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/jackc/pgconn"
 
@@ -33,7 +34,7 @@ var _ = Describe("restore/data tests", func() {
 			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz | gzip -d -c' WITH CSV DELIMITER ',' ON SEGMENT")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, 3456, false, 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -42,7 +43,7 @@ var _ = Describe("restore/data tests", func() {
 			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.zst | zstd --decompress -c' WITH CSV DELIMITER ',' ON SEGMENT")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.zst"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, 3456, false, 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -50,7 +51,7 @@ var _ = Describe("restore/data tests", func() {
 			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, 3456, false, 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -58,7 +59,7 @@ var _ = Describe("restore/data tests", func() {
 			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, true, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, 3456, true, 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -71,7 +72,7 @@ var _ = Describe("restore/data tests", func() {
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, 3456, false, 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -84,7 +85,7 @@ var _ = Describe("restore/data tests", func() {
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.zst"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, 3456, false, 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -96,7 +97,7 @@ var _ = Describe("restore/data tests", func() {
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, 3456, false, 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -110,13 +111,90 @@ var _ = Describe("restore/data tests", func() {
 			}
 			mock.ExpectExec(execStr).WillReturnError(pgErr)
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, 3456, false, 0)
 
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal("Error loading data into table public.foo: " +
 				"COPY foo, line 1: \"5\": " +
 				"ERROR: value of distribution key doesn't belong to segment with ID 0, it belongs to segment with ID 1 (SQLSTATE 22P04)"))
 		})
+		It("starts the COPY with a marker identifying the worker and the table", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_backend_pid()")).WillReturnRows(sqlmock.NewRows([]string{"pg_backend_pid"}).AddRow(4321))
+			Expect(utils.TrackCopyWorkers(connectionPool, "gprestore_20170101010101_20170102010101")).To(Succeed())
+			execStr := "^" + regexp.QuoteMeta("/* gprestore_20170101010101_20170102010101 worker 0 table 3456 */ COPY public.foo(i,j) FROM PROGRAM")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, 3456, false, 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+	})
+	Describe("CopyTableInWithRedistribution", func() {
+		BeforeEach(func() {
+			backup.SetCluster(&cluster.Cluster{ContentIDs: []int{-1, 0, 1, 2}})
+			restore.SetBackupConfig(&history.BackupConfig{})
+		})
+		It("loads the table through an external table reading the segment pipes", func() {
+			columnRows := sqlmock.NewRows([]string{"name", "type"}).
+				AddRow("i", "integer").AddRow(`"J k"`, "text").AddRow("l", "date")
+			mock.ExpectQuery("SELECT quote_ident\\(attname\\) AS name").WillReturnRows(columnRows)
+			mock.ExpectExec(regexp.QuoteMeta(`CREATE READABLE EXTERNAL WEB TEMPORARY TABLE gprestore_load_3456 ("J k" text, i integer) EXECUTE 'cat $GP_SEG_DATADIR/backups/20170101/20170101010101/gpbackup_${GP_SEGMENT_ID}_20170101010101_pipe_3456' ON ALL FORMAT 'csv' (DELIMITER ',');`)).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO public.foo("J k",i) SELECT * FROM gprestore_load_3456;`)).WillReturnResult(sqlmock.NewResult(0, 10))
+			mock.ExpectExec(regexp.QuoteMeta("DROP EXTERNAL TABLE IF EXISTS gprestore_load_3456;")).WillReturnResult(sqlmock.NewResult(0, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456"
+			numRows, err := restore.CopyTableInWithRedistribution(connectionPool, "public.foo", `("J k",i)`, filename, 3456, 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(numRows).To(Equal(int64(10)))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error if a backed up column is missing from the table", func() {
+			columnRows := sqlmock.NewRows([]string{"name", "type"}).AddRow("i", "integer")
+			mock.ExpectQuery("SELECT quote_ident\\(attname\\) AS name").WillReturnRows(columnRows)
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456"
+			_, err := restore.CopyTableInWithRedistribution(connectionPool, "public.foo", "(i,j)", filename, 3456, 0)
+
+			Expect(err).To(MatchError("Error loading data into table public.foo: Column j does not exist in table public.foo"))
+		})
+	})
+	Describe("GetResizeBatchCount", func() {
+		It("uses one batch unless restoring to a smaller cluster", func() {
+			Expect(restore.GetResizeBatchCount(3, 3, false)).To(Equal(1))
+			Expect(restore.GetResizeBatchCount(2, 5, true)).To(Equal(1))
+		})
+		It("uses enough batches to read every original segment's data", func() {
+			Expect(restore.GetResizeBatchCount(6, 3, true)).To(Equal(2))
+			Expect(restore.GetResizeBatchCount(7, 3, true)).To(Equal(3))
+		})
+	})
+	Describe("SortDataEntriesBySize", func() {
+		It("orders entries by relation size, largest first", func() {
+			entries := []toc.CoordinatorDataEntry{
+				{Schema: "public", Name: "a", RowsCopied: 100, RelationSize: 10},
+				{Schema: "public", Name: "b", RowsCopied: 1, RelationSize: 30},
+				{Schema: "public", Name: "c", RowsCopied: 10, RelationSize: 20},
+			}
+			sortedEntries := restore.SortDataEntriesBySize(entries, []string{})
+			Expect(sortedEntries).To(Equal([]toc.CoordinatorDataEntry{entries[1], entries[2], entries[0]}))
+		})
+		It("falls back to rows copied for backups without relation sizes", func() {
+			entries := []toc.CoordinatorDataEntry{
+				{Schema: "public", Name: "a", RowsCopied: 100},
+				{Schema: "public", Name: "b", RowsCopied: 1},
+				{Schema: "public", Name: "c", RowsCopied: 10},
+			}
+			sortedEntries := restore.SortDataEntriesBySize(entries, []string{})
+			Expect(sortedEntries).To(Equal([]toc.CoordinatorDataEntry{entries[0], entries[2], entries[1]}))
+		})
+		It("places prioritized tables first in the order given", func() {
+			entries := []toc.CoordinatorDataEntry{
+				{Schema: "public", Name: "a", RelationSize: 10},
+				{Schema: "public", Name: "b", RelationSize: 30},
+				{Schema: "public", Name: `"C"`, RelationSize: 20},
+			}
+			sortedEntries := restore.SortDataEntriesBySize(entries, []string{"public.a", "public.C"})
+			Expect(sortedEntries).To(Equal([]toc.CoordinatorDataEntry{entries[0], entries[2], entries[1]}))
+		})
 	})
 	Describe("CheckRowsRestored", func() {
 		var (
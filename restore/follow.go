@@ -0,0 +1,227 @@
+package restore
+
+/*
+ * This file contains functions for --follow, which keeps applying the
+ * incremental backups of a chain to the restore database as they are taken,
+ * to maintain a warm copy of the backed up database.
+ */
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+// Records the last backup applied to the restore database
+const FollowStateTable = "public.gprestore_follow_state"
+
+func createFollowStateTable() {
+	// PostgreSQL has no distribution clause
+	distributedStr := " DISTRIBUTED RANDOMLY"
+	if IsPostgresTarget() {
+		distributedStr = ""
+	}
+	connectionPool.MustExec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	database_name text,
+	backup_timestamp text,
+	applied_at timestamp with time zone
+)%s`, FollowStateTable, distributedStr))
+}
+
+/*
+ * Returns the timestamp of the last backup of the given database applied to
+ * the restore database, or "" if none has been.
+ */
+func GetLastAppliedTimestamp(databaseName string) string {
+	createFollowStateTable()
+	var timestamps []string
+	query := fmt.Sprintf("SELECT backup_timestamp FROM %s WHERE database_name = '%s' ORDER BY backup_timestamp DESC LIMIT 1", FollowStateTable, utils.EscapeSingleQuotes(databaseName))
+	err := connectionPool.Select(&timestamps, query)
+	gplog.FatalOnError(err)
+	if len(timestamps) == 0 {
+		return ""
+	}
+	return timestamps[0]
+}
+
+func RecordAppliedTimestamp(databaseName string, timestamp string) {
+	createFollowStateTable()
+	connectionPool.MustBegin()
+	connectionPool.MustExec(fmt.Sprintf("DELETE FROM %s WHERE database_name = '%s'", FollowStateTable, utils.EscapeSingleQuotes(databaseName)))
+	connectionPool.MustExec(fmt.Sprintf("INSERT INTO %s VALUES ('%s', '%s', now())", FollowStateTable, utils.EscapeSingleQuotes(databaseName), utils.EscapeSingleQuotes(timestamp)))
+	connectionPool.MustCommit()
+}
+
+/*
+ * Returns whether the backup being restored was already applied by an
+ * earlier run of --follow, as restoring it again would undo the changes of
+ * the incrementals applied after it.
+ */
+func isBackupAlreadyApplied() bool {
+	lastAppliedTimestamp := GetLastAppliedTimestamp(backupConfig.DatabaseName)
+	return lastAppliedTimestamp != "" && lastAppliedTimestamp >= globalFPInfo.Timestamp
+}
+
+/*
+ * Returns whether the backup is the next incremental in the chain after the
+ * backup with the given timestamp, which it is if its restore plan was built
+ * on the restore plan of that backup.  Backups that have not yet succeeded
+ * are skipped until they do.
+ */
+func IsNextIncremental(config *history.BackupConfig, lastAppliedTimestamp string, databaseName string) bool {
+	if config.Status != history.BackupStatusSucceed || !config.Incremental || config.DatabaseName != databaseName || len(config.RestorePlan) < 2 {
+		return false
+	}
+	return config.RestorePlan[len(config.RestorePlan)-2].Timestamp == lastAppliedTimestamp
+}
+
+func hasBackupHistoryDatabase() bool {
+	_, err := operating.System.Stat(globalFPInfo.GetBackupHistoryDatabasePath())
+	return err == nil
+}
+
+/*
+ * Without a history database, new backups are found from their config files
+ * in the backup directory.  A plugin has no way to list the backups that it
+ * stores, so those backups can only be followed with a history database.
+ */
+func validateFollowSource() {
+	if hasBackupHistoryDatabase() {
+		return
+	}
+	if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
+		gplog.Fatal(errors.Errorf("Cannot use --%s with --%s without a backup history database on this coordinator, as new backups cannot be listed from the plugin", options.FOLLOW, options.PLUGIN_CONFIG), "")
+	}
+	if MustGetFlagString(options.METADATA_PLUGIN_CONFIG) != "" {
+		gplog.Fatal(errors.Errorf("Cannot use --%s with --%s without a backup history database on this coordinator, as new backups cannot be listed from the plugin", options.FOLLOW, options.METADATA_PLUGIN_CONFIG), "")
+	}
+	// Only the default layout has a known directory in which to look for the config files
+	if globalFPInfo.LayoutTemplate != "" {
+		gplog.Fatal(errors.Errorf("Cannot use --%s for a backup taken with --%s without a backup history database", options.FOLLOW, options.LAYOUT_TEMPLATE), "")
+	}
+}
+
+/*
+ * Returns the backups taken after the given timestamp, from the history
+ * database if there is one on this cluster or else from the config files in
+ * the backup directory, in timestamp order.
+ */
+func getBackupConfigsAfter(timestamp string) []*history.BackupConfig {
+	configs := make([]*history.BackupConfig, 0)
+	historyDBPath := globalFPInfo.GetBackupHistoryDatabasePath()
+	if hasBackupHistoryDatabase() {
+		historyDB, err := history.InitializeHistoryDatabase(historyDBPath)
+		gplog.FatalOnError(err)
+		defer historyDB.Close()
+		var timestamps []string
+		rows, err := historyDB.Query(fmt.Sprintf("SELECT timestamp FROM backups WHERE timestamp > '%s' ORDER BY timestamp", utils.EscapeSingleQuotes(timestamp)))
+		gplog.FatalOnError(err)
+		for rows.Next() {
+			var backupTimestamp string
+			err = rows.Scan(&backupTimestamp)
+			gplog.FatalOnError(err)
+			timestamps = append(timestamps, backupTimestamp)
+		}
+		rows.Close()
+		for _, backupTimestamp := range timestamps {
+			config, err := history.GetBackupConfig(backupTimestamp, historyDB)
+			gplog.FatalOnError(err)
+			configs = append(configs, config)
+		}
+		return configs
+	}
+
+	backupsDir := path.Dir(path.Dir(globalFPInfo.GetDirForContent(-1)))
+	configFiles, err := filepath.Glob(path.Join(backupsDir, "*", "*", "gpbackup_*_config.yaml"))
+	gplog.FatalOnError(err)
+	sort.Strings(configFiles)
+	for _, configFile := range configFiles {
+		if path.Base(path.Dir(configFile)) > timestamp {
+			configs = append(configs, history.ReadConfigFile(configFile))
+		}
+	}
+	return configs
+}
+
+func findNextIncremental(lastAppliedTimestamp string) string {
+	for _, config := range getBackupConfigsAfter(lastAppliedTimestamp) {
+		if IsNextIncremental(config, lastAppliedTimestamp, backupConfig.DatabaseName) {
+			return config.Timestamp
+		}
+	}
+	return ""
+}
+
+/*
+ * Finishes the restore of one backup in the chain, as DoTeardown and
+ * DoCleanup would, before the next one is set up.
+ */
+func finishFollowedRestore() {
+	writeRestoreReport("", false)
+	cleanUpHelpers(false)
+	if connectionPool != nil {
+		connectionPool.Close()
+	}
+	errorTablesMetadata = make(map[string]Empty)
+	errorTablesData = make(map[string]Empty)
+	dataParallelEfficiency = ""
+	utils.ResetAgentErrors()
+}
+
+/*
+ * A backup that was only partly restored is not recorded as applied, as the
+ * incrementals after it would leave the restore database out of sync with
+ * the backed up database without any error.
+ */
+func mustRecordAppliedTimestamp(databaseName string, timestamp string) {
+	if len(errorTablesData) > 0 || len(errorTablesMetadata) > 0 {
+		gplog.Fatal(errors.Errorf("Backup %s was restored with errors in %d tables, so it is not recorded as applied and no later backups are applied. Correct the errors and restore it again with --%s.",
+			timestamp, len(errorTablesData)+len(errorTablesMetadata), options.FOLLOW), "")
+	}
+	RecordAppliedTimestamp(databaseName, timestamp)
+}
+
+/*
+ * Once the backup given with --timestamp is restored, waits for the next
+ * incremental in its chain to be taken, applies it, and repeats until
+ * gprestore is interrupted.
+ */
+func DoFollow() {
+	if !MustGetFlagBool(options.FOLLOW) {
+		return
+	}
+	validateFollowSource()
+	databaseName := backupConfig.DatabaseName
+	lastAppliedTimestamp := GetLastAppliedTimestamp(databaseName)
+	if lastAppliedTimestamp < globalFPInfo.Timestamp {
+		lastAppliedTimestamp = globalFPInfo.Timestamp
+		mustRecordAppliedTimestamp(databaseName, lastAppliedTimestamp)
+	}
+	interval := time.Duration(MustGetFlagInt(options.FOLLOW_INTERVAL)) * time.Second
+	gplog.Info("Following incremental backups of database %s after backup %s", databaseName, lastAppliedTimestamp)
+	for !wasTerminated {
+		nextTimestamp := findNextIncremental(lastAppliedTimestamp)
+		if nextTimestamp == "" {
+			gplog.Verbose("No incremental backup follows backup %s yet; checking again in %s", lastAppliedTimestamp, interval)
+			time.Sleep(interval)
+			continue
+		}
+		gplog.Info("Applying incremental backup %s", nextTimestamp)
+		finishFollowedRestore()
+		err := cmdFlags.Set(options.TIMESTAMP, nextTimestamp)
+		gplog.FatalOnError(err)
+		DoSetup()
+		DoRestore()
+		lastAppliedTimestamp = nextTimestamp
+		mustRecordAppliedTimestamp(databaseName, lastAppliedTimestamp)
+	}
+}
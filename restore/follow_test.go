@@ -0,0 +1,81 @@
+package restore_test
+
+import (
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/restore"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/follow tests", func() {
+	Describe("IsNextIncremental", func() {
+		var config *history.BackupConfig
+		BeforeEach(func() {
+			config = &history.BackupConfig{
+				DatabaseName: "testdb",
+				Incremental:  true,
+				Status:       history.BackupStatusSucceed,
+				Timestamp:    "20230101000003",
+				RestorePlan: []history.RestorePlanEntry{
+					{Timestamp: "20230101000001"},
+					{Timestamp: "20230101000002"},
+					{Timestamp: "20230101000003"},
+				},
+			}
+		})
+		It("returns true for an incremental built on the last applied backup", func() {
+			Expect(restore.IsNextIncremental(config, "20230101000002", "testdb")).To(BeTrue())
+		})
+		It("returns false for an incremental built on another backup", func() {
+			Expect(restore.IsNextIncremental(config, "20230101000001", "testdb")).To(BeFalse())
+		})
+		It("returns false for a backup of another database", func() {
+			Expect(restore.IsNextIncremental(config, "20230101000002", "otherdb")).To(BeFalse())
+		})
+		It("returns false for a full backup", func() {
+			config.Incremental = false
+			config.RestorePlan = config.RestorePlan[2:]
+			Expect(restore.IsNextIncremental(config, "20230101000002", "testdb")).To(BeFalse())
+		})
+		It("returns false for a backup that has not succeeded", func() {
+			config.Status = history.BackupStatusFailed
+			Expect(restore.IsNextIncremental(config, "20230101000002", "testdb")).To(BeFalse())
+		})
+	})
+	Describe("GetLastAppliedTimestamp", func() {
+		It("escapes quotes in the database name", func() {
+			mock.ExpectExec(`CREATE TABLE IF NOT EXISTS public.gprestore_follow_state \((?s:.*)\) DISTRIBUTED RANDOMLY$`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE database_name = 'it''s' ORDER BY")).WillReturnRows(sqlmock.NewRows([]string{"backup_timestamp"}).AddRow("20230101000002"))
+
+			Expect(restore.GetLastAppliedTimestamp("it's")).To(Equal("20230101000002"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("creates the follow state table without a distribution clause for a PostgreSQL target", func() {
+			_ = cmdFlags.Set(options.TARGET_FLAVOR, "postgres")
+			defer cmdFlags.Set(options.TARGET_FLAVOR, "greenplum")
+			mock.ExpectExec(`CREATE TABLE IF NOT EXISTS public.gprestore_follow_state \((?s:.*)\)$`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("SELECT backup_timestamp").WillReturnRows(sqlmock.NewRows([]string{"backup_timestamp"}))
+
+			Expect(restore.GetLastAppliedTimestamp("testdb")).To(Equal(""))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("RecordAppliedTimestamp", func() {
+		It("escapes quotes in the database name", func() {
+			mock.ExpectExec("CREATE TABLE IF NOT EXISTS public.gprestore_follow_state").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectBegin()
+			mock.ExpectExec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM public.gprestore_follow_state WHERE database_name = 'it''s'")).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO public.gprestore_follow_state VALUES ('it''s', '20230101000002', now())")).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			restore.RecordAppliedTimestamp("it's", "20230101000002")
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+})
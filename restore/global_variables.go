@@ -40,6 +40,17 @@ var (
 	errorTablesMetadata map[string]Empty
 	errorTablesData     map[string]Empty
 	opts                *options.Options
+	// Recorded in the restore report once table data has been restored
+	dataParallelEfficiency string
+	sessionGUCProfile      utils.SessionGUCProfile
+	// Set for --source-host, nil if the backup is on this cluster
+	sourceCluster   *cluster.Cluster
+	sourceSegPrefix string
+	// The copy of the history database of the source cluster, and the files copied from there to remove
+	sourceHistoryDBPath string
+	sourceCopiedFiles   []string
+	// Set for --metadata-plugin-config
+	metadataPluginConfig *utils.PluginConfig
 	/*
 	 * Used for synchronizing DoCleanup.  In DoInit() we increment the group
 	 * and then wait for at least one DoCleanup to finish, either in DoTeardown
@@ -72,6 +83,15 @@ func SetBackupConfig(config *history.BackupConfig) {
 	backupConfig = config
 }
 
+func SetSourceCluster(c *cluster.Cluster, segPrefix string) {
+	sourceCluster = c
+	sourceSegPrefix = segPrefix
+}
+
+func SetSourceHistoryDatabasePath(historyDBPath string) {
+	sourceHistoryDBPath = historyDBPath
+}
+
 func SetConnection(conn *dbconn.DBConn) {
 	connectionPool = conn
 }
@@ -88,6 +108,10 @@ func SetPluginConfig(config *utils.PluginConfig) {
 	pluginConfig = config
 }
 
+func SetMetadataPluginConfig(config *utils.PluginConfig) {
+	metadataPluginConfig = config
+}
+
 func SetTOC(toc *toc.TOC) {
 	globalTOC = toc
 }
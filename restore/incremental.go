@@ -0,0 +1,264 @@
+package restore
+
+/*
+ * This file contains functions that bring the tables of the restore database
+ * in line with an incremental backup before its data is restored, so that an
+ * incremental restore syncs the database to the backup.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+func isRelationExcludedByFilters(relationFQN string, filters Filters) bool {
+	schemaName := strings.Split(relationFQN, ".")[0]
+	return utils.SchemaIsExcludedByUser(filters.includeSchemas, filters.excludeSchemas, schemaName) ||
+		utils.RelationIsExcludedByUser(filters.includeRelations, filters.excludeRelations, relationFQN)
+}
+
+/*
+ * Returns the schemas and relations of the backup that are missing from the
+ * restore database and not excluded by the user.
+ */
+func GetIncrementalObjectsToCreate(backupRelationFQNs []string, existingSchemas []string, existingRelationFQNs []string, filters Filters) ([]string, []string) {
+	existingSchemaSet := utils.NewSet(existingSchemas)
+	existingRelationSet := utils.NewSet(existingRelationFQNs)
+	schemasToCreate := make([]string, 0)
+	relationsToCreate := make([]string, 0)
+	for _, relationFQN := range backupRelationFQNs {
+		if existingRelationSet.MatchesFilter(relationFQN) || isRelationExcludedByFilters(relationFQN, filters) {
+			continue
+		}
+		schemaName := strings.Split(relationFQN, ".")[0]
+		if !existingSchemaSet.MatchesFilter(schemaName) && !utils.Exists(schemasToCreate, schemaName) {
+			schemasToCreate = append(schemasToCreate, schemaName)
+		}
+		relationsToCreate = append(relationsToCreate, relationFQN)
+	}
+	return schemasToCreate, relationsToCreate
+}
+
+/*
+ * Returns the AO tables in the restore database whose DDL changed between
+ * the previous backup in the restore plan and the backup being restored.
+ */
+func GetIncrementalTablesWithChangedDDL(currentAOEntries map[string]toc.AOEntry, previousAOEntries map[string]toc.AOEntry, existingRelationFQNs []string, filters Filters) []string {
+	existingRelationSet := utils.NewSet(existingRelationFQNs)
+	changedTables := make([]string, 0)
+	for tableFQN, currentEntry := range currentAOEntries {
+		previousEntry, ok := previousAOEntries[tableFQN]
+		if !ok || previousEntry.LastDDLTimestamp == currentEntry.LastDDLTimestamp {
+			continue
+		}
+		if existingRelationSet.MatchesFilter(tableFQN) && !isRelationExcludedByFilters(tableFQN, filters) {
+			changedTables = append(changedTables, tableFQN)
+		}
+	}
+	sort.Strings(changedTables)
+	return changedTables
+}
+
+/*
+ * Returns the tables in the restore database that belong to a schema of the
+ * backup but are not among its relations, so they were dropped after the
+ * base backup was taken.  The table in which --follow records its state is
+ * never dropped.
+ */
+func GetIncrementalTablesToDrop(existingTableFQNs []string, backupRelationFQNs []string, backupSchemas []string, filters Filters) []string {
+	backupRelationSet := utils.NewSet(backupRelationFQNs)
+	backupSchemaSet := utils.NewSet(backupSchemas)
+	tablesToDrop := make([]string, 0)
+	for _, tableFQN := range existingTableFQNs {
+		schemaName := strings.Split(tableFQN, ".")[0]
+		if tableFQN == FollowStateTable || !backupSchemaSet.MatchesFilter(schemaName) || backupRelationSet.MatchesFilter(tableFQN) || isRelationExcludedByFilters(tableFQN, filters) {
+			continue
+		}
+		tablesToDrop = append(tablesToDrop, tableFQN)
+	}
+	return tablesToDrop
+}
+
+/*
+ * Returns the relations and schemas of the backup being restored: the tables
+ * of its restore plan, which include leaf partitions, and the relations and
+ * schemas of its pre-data metadata.
+ */
+func getBackupRelationsAndSchemas(tableFQNs []string) ([]string, []string) {
+	backupRelationFQNs := make([]string, 0, len(tableFQNs))
+	backupRelationFQNs = append(backupRelationFQNs, tableFQNs...)
+	backupSchemas := make([]string, 0)
+	for _, entry := range globalTOC.PredataEntries {
+		switch entry.ObjectType {
+		case "SCHEMA":
+			backupSchemas = append(backupSchemas, entry.Name)
+		case "TABLE", "FOREIGN TABLE", "SEQUENCE", "VIEW", "MATERIALIZED VIEW":
+			relationFQN := utils.MakeFQN(entry.Schema, entry.Name)
+			if !utils.Exists(backupRelationFQNs, relationFQN) {
+				backupRelationFQNs = append(backupRelationFQNs, relationFQN)
+			}
+		}
+	}
+	return backupRelationFQNs, backupSchemas
+}
+
+// Maps the leaf partitions of the backup to their roots
+func getPartitionRoots() map[string]string {
+	partitionRoots := make(map[string]string)
+	for _, entry := range globalTOC.DataEntries {
+		if entry.PartitionRoot != "" {
+			partitionRoots[utils.MakeFQN(entry.Schema, entry.Name)] = utils.MakeFQN(entry.Schema, entry.PartitionRoot)
+		}
+	}
+	return partitionRoots
+}
+
+func hasTableStatement(tableFQN string) bool {
+	for _, entry := range globalTOC.PredataEntries {
+		if entry.ObjectType == "TABLE" && utils.MakeFQN(entry.Schema, entry.Name) == tableFQN {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Drops the tables in one transaction, so that a table that cannot be
+ * dropped, for example because a view depends on it, leaves all of them in
+ * place instead of only some.
+ */
+func DropTables(tableFQNs []string, reason string) error {
+	err := connectionPool.Begin()
+	if err != nil {
+		return err
+	}
+	for _, tableFQN := range tableFQNs {
+		gplog.Verbose("Dropping table %s, as %s", tableFQN, reason)
+		_, err = connectionPool.Exec(fmt.Sprintf("DROP TABLE %s;", tableFQN))
+		if err != nil {
+			_ = connectionPool.Rollback()
+			return errors.Wrapf(err, "Unable to drop table %s, so none of the %d tables were dropped", tableFQN, len(tableFQNs))
+		}
+	}
+	return connectionPool.Commit()
+}
+
+// With --on-error-continue, the restore goes on with the tables left in place
+func mustDropTables(tableFQNs []string, reason string) {
+	err := DropTables(tableFQNs, reason)
+	if err == nil {
+		return
+	}
+	if !MustGetFlagBool(options.ON_ERROR_CONTINUE) {
+		gplog.Fatal(err, "")
+	}
+	gplog.Error(err.Error())
+}
+
+/*
+ * Before an incremental restore loads its data, drops the tables that were
+ * removed since the base backup if --drop-removed-tables is passed,
+ * recreates the AO tables whose DDL changed, and creates the schemas,
+ * tables, and sequences that are missing, all from the pre-data of the
+ * backup being restored.  Returns the relations that were created, whose
+ * post-data is restored once their data is.
+ *
+ * Heap tables record no DDL timestamp, so DDL changes are only applied to AO
+ * tables, and partitions can only be dropped or recreated with their parent.
+ */
+func syncIncrementalState(metadataFilename string) []string {
+	lastRestorePlanEntry := backupConfig.RestorePlan[len(backupConfig.RestorePlan)-1]
+	filters := NewFilters(opts.IncludedSchemas, opts.ExcludedSchemas, opts.IncludedRelations, opts.ExcludedRelations)
+	backupRelationFQNs, backupSchemas := getBackupRelationsAndSchemas(lastRestorePlanEntry.TableFQNs)
+
+	existingSchemas, err := GetExistingSchemas()
+	gplog.FatalOnError(err)
+	existingRelationFQNs, err := GetExistingTableFQNs()
+	gplog.FatalOnError(err)
+
+	if MustGetFlagBool(options.DROP_REMOVED_TABLES) {
+		if backupConfig.IncludeTableFiltered || backupConfig.ExcludeTableFiltered {
+			gplog.Fatal(errors.Errorf("Cannot use --%s with a backup taken with table filters, as the tables filtered out of it would be dropped", options.DROP_REMOVED_TABLES), "")
+		}
+		existingTableFQNs, err := GetExistingTopLevelTableFQNs()
+		gplog.FatalOnError(err)
+		tablesToDrop := GetIncrementalTablesToDrop(existingTableFQNs, backupRelationFQNs, backupSchemas, filters)
+		if len(tablesToDrop) > 0 {
+			gplog.Info("Dropping %d tables that are not in the backup", len(tablesToDrop))
+			mustDropTables(tablesToDrop, "it is not in the backup")
+		}
+	}
+
+	partitionRoots := getPartitionRoots()
+	tablesToRecreate := make([]string, 0)
+	if len(backupConfig.RestorePlan) > 1 {
+		previousTimestamp := backupConfig.RestorePlan[len(backupConfig.RestorePlan)-2].Timestamp
+		previousFPInfo := GetBackupFPInfoForTimestamp(previousTimestamp)
+		previousTOC := toc.NewTOC(previousFPInfo.GetTOCFilePath())
+		changedTables := GetIncrementalTablesWithChangedDDL(globalTOC.IncrementalMetadata.AO, previousTOC.IncrementalMetadata.AO, existingRelationFQNs, filters)
+		for _, tableFQN := range changedTables {
+			if _, isPartition := partitionRoots[tableFQN]; !isPartition && hasTableStatement(tableFQN) {
+				tablesToRecreate = append(tablesToRecreate, tableFQN)
+			} else {
+				gplog.Warn("The DDL of table %s changed since backup %s, but it can only be recreated with its parent", tableFQN, previousTimestamp)
+			}
+		}
+		if len(tablesToRecreate) > 0 {
+			gplog.Info("Recreating %d tables whose DDL changed since backup %s", len(tablesToRecreate), previousTimestamp)
+			mustDropTables(tablesToRecreate, "its DDL changed")
+		}
+	}
+
+	schemasToCreate, missingRelationFQNs := GetIncrementalObjectsToCreate(backupRelationFQNs, existingSchemas, existingRelationFQNs, filters)
+	existingRelationSet := utils.NewSet(existingRelationFQNs)
+	relationsToCreate := make([]string, 0, len(missingRelationFQNs)+len(tablesToRecreate))
+	for _, relationFQN := range missingRelationFQNs {
+		// Creating the partition would create its whole partitioned table
+		if rootFQN, isPartition := partitionRoots[relationFQN]; isPartition && existingRelationSet.MatchesFilter(rootFQN) {
+			gplog.Warn("Partition %s is missing from the restore database, but it can only be created with its parent %s", relationFQN, rootFQN)
+			continue
+		}
+		relationsToCreate = append(relationsToCreate, relationFQN)
+	}
+	relationsToCreate = append(relationsToCreate, tablesToRecreate...)
+	if len(schemasToCreate) > 0 {
+		gplog.Info("Creating %d schemas that are missing from the restore database", len(schemasToCreate))
+		schemaStatements := GetRestoreMetadataStatementsFiltered("predata", metadataFilename, []string{"SCHEMA"}, []string{}, NewFilters(schemasToCreate, []string{}, []string{}, []string{}))
+		progressBar := utils.NewProgressBar(len(schemaStatements), "", utils.PB_NONE)
+		progressBar.Start()
+		RestoreSchemas(schemaStatements, progressBar)
+		progressBar.Finish()
+	}
+	if len(relationsToCreate) > 0 {
+		gplog.Info("Creating %d relations that are missing from the restore database", len(relationsToCreate))
+		statements := GetRestoreMetadataStatementsFiltered("predata", metadataFilename, []string{}, []string{"SCHEMA"}, NewFilters([]string{}, []string{}, relationsToCreate, []string{}))
+		numErrors := ExecuteRestoreMetadataStatements(statements, "Pre-data objects", nil, utils.PB_VERBOSE, false)
+		if numErrors > 0 {
+			gplog.Info("Creation of missing relations completed with failures")
+		}
+	}
+	return relationsToCreate
+}
+
+// Restores the indexes, constraints, and other post-data of the relations created by syncIncrementalState
+func restoreIncrementalPostdata(metadataFilename string, createdRelationFQNs []string) {
+	if wasTerminated || len(createdRelationFQNs) == 0 {
+		return
+	}
+	statements := GetRestoreMetadataStatementsFiltered("postdata", metadataFilename, []string{}, []string{}, NewFilters([]string{}, []string{}, createdRelationFQNs, []string{}))
+	if len(statements) == 0 {
+		return
+	}
+	gplog.Info("Restoring post-data metadata of created relations")
+	numErrors := ExecuteRestoreMetadataStatements(statements, "Post-data objects", nil, utils.PB_VERBOSE, false)
+	if numErrors > 0 {
+		gplog.Info("Post-data metadata restore of created relations completed with failures")
+	}
+}
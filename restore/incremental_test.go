@@ -0,0 +1,90 @@
+package restore_test
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/toc"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/incremental tests", func() {
+	noFilters := restore.NewFilters(nil, nil, nil, nil)
+
+	Describe("GetIncrementalObjectsToCreate", func() {
+		backupRelations := []string{"public.foo", "public.bar", "new_schema.baz", "other.qux"}
+		It("returns the missing relations and the missing schemas they belong to", func() {
+			schemas, relations := restore.GetIncrementalObjectsToCreate(backupRelations, []string{"public", "other"}, []string{"public.foo", "other.qux"}, noFilters)
+			Expect(schemas).To(Equal([]string{"new_schema"}))
+			Expect(relations).To(Equal([]string{"public.bar", "new_schema.baz"}))
+		})
+		It("does not return relations excluded by the user", func() {
+			filters := restore.NewFilters(nil, []string{"new_schema"}, nil, []string{"public.bar"})
+			schemas, relations := restore.GetIncrementalObjectsToCreate(backupRelations, []string{"public", "other"}, []string{"public.foo", "other.qux"}, filters)
+			Expect(schemas).To(BeEmpty())
+			Expect(relations).To(BeEmpty())
+		})
+	})
+	Describe("GetIncrementalTablesWithChangedDDL", func() {
+		previous := map[string]toc.AOEntry{
+			"public.ao1": {Modcount: 1, LastDDLTimestamp: "2023-01-01 00:00:00"},
+			"public.ao2": {Modcount: 1, LastDDLTimestamp: "2023-01-01 00:00:00"},
+			"public.ao3": {Modcount: 1, LastDDLTimestamp: "2023-01-01 00:00:00"},
+		}
+		current := map[string]toc.AOEntry{
+			"public.ao1": {Modcount: 5, LastDDLTimestamp: "2023-01-01 00:00:00"},
+			"public.ao2": {Modcount: 1, LastDDLTimestamp: "2023-02-01 00:00:00"},
+			"public.ao3": {Modcount: 1, LastDDLTimestamp: "2023-02-01 00:00:00"},
+			"public.ao4": {Modcount: 1, LastDDLTimestamp: "2023-02-01 00:00:00"},
+		}
+		It("returns the existing tables whose DDL timestamp changed", func() {
+			tables := restore.GetIncrementalTablesWithChangedDDL(current, previous, []string{"public.ao1", "public.ao2", "public.ao3"}, noFilters)
+			Expect(tables).To(Equal([]string{"public.ao2", "public.ao3"}))
+		})
+		It("does not return tables missing from the restore database or excluded by the user", func() {
+			filters := restore.NewFilters(nil, nil, nil, []string{"public.ao3"})
+			tables := restore.GetIncrementalTablesWithChangedDDL(current, previous, []string{"public.ao1", "public.ao2", "public.ao3"}, filters)
+			Expect(tables).To(Equal([]string{"public.ao2"}))
+		})
+	})
+	Describe("GetIncrementalTablesToDrop", func() {
+		It("returns the tables in schemas of the backup that are not in the backup", func() {
+			existing := []string{"public.foo", "public.dropped", "unrelated.table1", "sales.dropped"}
+			filters := restore.NewFilters(nil, []string{"sales"}, nil, nil)
+			tables := restore.GetIncrementalTablesToDrop(existing, []string{"public.foo"}, []string{"public", "sales"}, filters)
+			Expect(tables).To(Equal([]string{"public.dropped"}))
+		})
+		It("does not return the follow state table", func() {
+			existing := []string{"public.foo", restore.FollowStateTable}
+			tables := restore.GetIncrementalTablesToDrop(existing, []string{"public.foo"}, []string{"public"}, noFilters)
+			Expect(tables).To(BeEmpty())
+		})
+	})
+	Describe("DropTables", func() {
+		It("drops the tables in one transaction", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("DROP TABLE public.foo;")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("DROP TABLE public.bar;")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+
+			Expect(restore.DropTables([]string{"public.foo", "public.bar"}, "it is not in the backup")).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("rolls back the tables already dropped when a table cannot be dropped", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("DROP TABLE public.foo;")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("DROP TABLE public.bar;")).WillReturnError(errors.New("cannot drop table public.bar because other objects depend on it"))
+			mock.ExpectRollback()
+
+			err := restore.DropTables([]string{"public.foo", "public.bar"}, "it is not in the backup")
+			Expect(err).To(MatchError("Unable to drop table public.bar, so none of the 2 tables were dropped: cannot drop table public.bar because other objects depend on it"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+})
@@ -6,6 +6,7 @@ package restore
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -46,6 +47,17 @@ func executeStatementsForConn(statements chan toc.StatementWithType, fatalErr *e
 	}
 }
 
+// Exits on the first error, or logs how many statements failed with --on-error-continue
+func reportStatementErrors(fatalErr error, numErrors int32) {
+	if fatalErr != nil {
+		fmt.Println("")
+		gplog.Fatal(fatalErr, "")
+	} else if numErrors > 0 {
+		fmt.Println("")
+		gplog.Error("Encountered %d errors during metadata restore; see log file %s for a list of failed statements.", numErrors, gplog.GetLogFilePath())
+	}
+}
+
 /*
  * This function creates a worker pool of N goroutines to be able to execute up
  * to N statements in parallel.
@@ -74,14 +86,145 @@ func ExecuteStatements(statements []toc.StatementWithType, progressBar utils.Pro
 		}
 		workerPool.Wait()
 	}
-	if fatalErr != nil {
-		fmt.Println("")
-		gplog.Fatal(fatalErr, "")
-	} else if numErrors > 0 {
-		fmt.Println("")
-		gplog.Error("Encountered %d errors during metadata restore; see log file %s for a list of failed statements.", numErrors, gplog.GetLogFilePath())
+	reportStatementErrors(fatalErr, numErrors)
+	return numErrors
+}
+
+/*
+ * A group of consecutive statements belonging to a single object from the
+ * backup's dependency sort, or a single statement that was written outside of
+ * the sort.  Ungrouped statements act as barriers: everything before them must
+ * finish before they run, and they must finish before anything after them runs.
+ */
+type statementGroup struct {
+	statements []toc.StatementWithType
+	isBarrier  bool
+	numDeps    int
+	dependents []int
+}
+
+/*
+ * Split the statements into groups and compute, for each group, the groups
+ * that must wait for it.  Dependencies on objects that are not being restored
+ * (e.g. because of filtering) are ignored.
+ */
+func groupStatementsByDependency(statements []toc.StatementWithType, dependencies []toc.DependencyEntry) []statementGroup {
+	groups := make([]statementGroup, 0)
+	lastGroupForObject := make(map[toc.UniqueID]int)
+	for _, statement := range statements {
+		zeroID := statement.ObjectID == toc.UniqueID{}
+		numGroups := len(groups)
+		if !zeroID && numGroups > 0 && !groups[numGroups-1].isBarrier && groups[numGroups-1].statements[0].ObjectID == statement.ObjectID {
+			groups[numGroups-1].statements = append(groups[numGroups-1].statements, statement)
+			continue
+		}
+		groups = append(groups, statementGroup{statements: []toc.StatementWithType{statement}, isBarrier: zeroID})
+	}
+
+	dependsOn := make(map[toc.UniqueID][]toc.UniqueID, len(dependencies))
+	for _, dependency := range dependencies {
+		dependsOn[dependency.Object] = dependency.DependsOn
+	}
+
+	addEdge := func(from int, to int) {
+		groups[from].dependents = append(groups[from].dependents, to)
+		groups[to].numDeps++
+	}
+	lastBarrier := -1
+	sinceLastBarrier := make([]int, 0)
+	for i := range groups {
+		if groups[i].isBarrier {
+			if len(sinceLastBarrier) == 0 && lastBarrier >= 0 {
+				addEdge(lastBarrier, i)
+			}
+			for _, prev := range sinceLastBarrier {
+				addEdge(prev, i)
+			}
+			lastBarrier = i
+			sinceLastBarrier = sinceLastBarrier[:0]
+			continue
+		}
+		objectID := groups[i].statements[0].ObjectID
+		depGroups := make(map[int]bool)
+		if lastBarrier >= 0 {
+			depGroups[lastBarrier] = true
+		}
+		if prev, ok := lastGroupForObject[objectID]; ok && prev > lastBarrier {
+			depGroups[prev] = true
+		}
+		for _, dep := range dependsOn[objectID] {
+			if prev, ok := lastGroupForObject[dep]; ok && prev > lastBarrier {
+				depGroups[prev] = true
+			}
+		}
+		for prev := range depGroups {
+			addEdge(prev, i)
+		}
+		lastGroupForObject[objectID] = i
+		sinceLastBarrier = append(sinceLastBarrier, i)
+	}
+	return groups
+}
+
+/*
+ * This function executes statements across all connections while respecting
+ * the object dependencies recorded in the TOC: a group of statements is only
+ * dispatched once every group it depends on has finished.  Statements from
+ * backups taken before dependencies were recorded carry no object IDs, so they
+ * all become barriers and run serially in file order.
+ */
+func ExecuteStatementsWithDependencies(statements []toc.StatementWithType, dependencies []toc.DependencyEntry, progressBar utils.ProgressBar) int32 {
+	var workerPool sync.WaitGroup
+	var fatalErr error
+	var numErrors int32
+	groups := groupStatementsByDependency(statements, dependencies)
+	if len(groups) == 0 {
+		return 0
+	}
+
+	var groupMutex sync.Mutex
+	numFinished := 0
+	ready := make(chan int, len(groups))
+	for i := range groups {
+		if groups[i].numDeps == 0 {
+			ready <- i
+		}
+	}
+	finishGroup := func(index int) {
+		groupMutex.Lock()
+		defer groupMutex.Unlock()
+		for _, dependent := range groups[index].dependents {
+			groups[dependent].numDeps--
+			if groups[dependent].numDeps == 0 {
+				ready <- dependent
+			}
+		}
+		numFinished++
+		if numFinished == len(groups) {
+			close(ready)
+		}
 	}
 
+	for i := 0; i < connectionPool.NumConns; i++ {
+		workerPool.Add(1)
+		go func(connNum int) {
+			defer workerPool.Done()
+			connNum = connectionPool.ValidateConnNum(connNum)
+			for index := range ready {
+				// Groups are still marked finished after an error so that the other workers can drain the queue
+				tasks := make(chan toc.StatementWithType, len(groups[index].statements))
+				for _, statement := range groups[index].statements {
+					tasks <- statement
+				}
+				close(tasks)
+				executeStatementsForConn(tasks, &fatalErr, &numErrors, progressBar, connNum, true)
+				finishGroup(index)
+			}
+		}(i)
+	}
+	workerPool.Wait()
+
+	reportStatementErrors(fatalErr, numErrors)
 	return numErrors
 }
 
@@ -128,3 +271,157 @@ func BatchPostdataStatements(statements []toc.StatementWithType) ([]toc.Statemen
 	}
 	return firstBatch, secondBatch, thirdBatch
 }
+
+/*
+ * Split postdata statements into index and constraint builds, which are
+ * scheduled per table by ExecuteIndexStatements, all other postdata objects,
+ * and postdata metadata, which must run after the objects it refers to.
+ */
+func SplitPostdataStatements(statements []toc.StatementWithType) ([]toc.StatementWithType, []toc.StatementWithType, []toc.StatementWithType) {
+	indexStatements := make([]toc.StatementWithType, 0)
+	otherStatements := make([]toc.StatementWithType, 0)
+	metadataStatements := make([]toc.StatementWithType, 0)
+	for _, statement := range statements {
+		if statement.ObjectType == "INDEX" || statement.ObjectType == "CONSTRAINT" {
+			indexStatements = append(indexStatements, statement)
+		} else if strings.Contains(statement.ObjectType, " METADATA") {
+			metadataStatements = append(metadataStatements, statement)
+		} else {
+			otherStatements = append(otherStatements, statement)
+		}
+	}
+	return indexStatements, otherStatements, metadataStatements
+}
+
+/*
+ * Build a map of table FQN to the number of rows restored into it, as recorded
+ * in the TOC at backup time.  Leaf partition row counts are also added to their
+ * root, since indexes on a partitioned table are built through the root.
+ */
+func GetRowsCopiedByTable(filteredDataEntries map[string][]toc.CoordinatorDataEntry, redirectSchema string) map[string]int64 {
+	tableRows := make(map[string]int64)
+	for _, entries := range filteredDataEntries {
+		for _, entry := range entries {
+			schema := entry.Schema
+			if redirectSchema != "" {
+				schema = redirectSchema
+			}
+			tableRows[utils.MakeFQN(schema, entry.Name)] += entry.RowsCopied
+			if entry.PartitionRoot != "" {
+				tableRows[utils.MakeFQN(schema, entry.PartitionRoot)] += entry.RowsCopied
+			}
+		}
+	}
+	return tableRows
+}
+
+type tableIndexQueue struct {
+	table          string
+	statements     []toc.StatementWithType
+	numRunning     int
+	firstCompleted bool
+}
+
+/*
+ * Order the per-table queues so that the largest tables are started first,
+ * since their index builds take the longest.  Tables of equal size keep the
+ * order in which their first index appears in the metadata file.
+ */
+func groupIndexStatementsByTable(statements []toc.StatementWithType, tableRows map[string]int64) []*tableIndexQueue {
+	queues := make([]*tableIndexQueue, 0)
+	queueForTable := make(map[string]*tableIndexQueue)
+	for _, statement := range statements {
+		queue, ok := queueForTable[statement.ReferenceObject]
+		if !ok {
+			queue = &tableIndexQueue{table: statement.ReferenceObject}
+			queueForTable[statement.ReferenceObject] = queue
+			queues = append(queues, queue)
+		}
+		queue.statements = append(queue.statements, statement)
+	}
+	sort.SliceStable(queues, func(i int, j int) bool {
+		return tableRows[queues[i].table] > tableRows[queues[j].table]
+	})
+	return queues
+}
+
+/*
+ * Return the queue from which the next statement should be taken, or nil if
+ * no statement can be started right now.  As in BatchPostdataStatements, the
+ * first index on a table is always built on its own to avoid the Greenplum
+ * deadlock on AO tables without indexes; after that, up to maxPerTable builds
+ * may run concurrently on the same table.
+ */
+func nextIndexQueue(queues []*tableIndexQueue, maxPerTable int) *tableIndexQueue {
+	for _, queue := range queues {
+		if len(queue.statements) == 0 {
+			continue
+		}
+		if !queue.firstCompleted && queue.numRunning > 0 {
+			continue
+		}
+		if queue.numRunning >= maxPerTable {
+			continue
+		}
+		return queue
+	}
+	return nil
+}
+
+/*
+ * This function executes index and constraint statements across all
+ * connections, starting with the largest tables and limiting the number of
+ * concurrent builds on any one table to maxPerTable so that large tables do
+ * not have many builds competing with each other while small tables wait.
+ */
+func ExecuteIndexStatements(statements []toc.StatementWithType, tableRows map[string]int64, maxPerTable int, progressBar utils.ProgressBar) int32 {
+	var workerPool sync.WaitGroup
+	var fatalErr error
+	var numErrors int32
+	queues := groupIndexStatementsByTable(statements, tableRows)
+	numRemaining := len(statements)
+
+	var queueMutex sync.Mutex
+	queueChanged := sync.NewCond(&queueMutex)
+	for i := 0; i < connectionPool.NumConns; i++ {
+		workerPool.Add(1)
+		go func(connNum int) {
+			defer workerPool.Done()
+			connNum = connectionPool.ValidateConnNum(connNum)
+			for {
+				queueMutex.Lock()
+				var queue *tableIndexQueue
+				for numRemaining > 0 {
+					if queue = nextIndexQueue(queues, maxPerTable); queue != nil {
+						break
+					}
+					queueChanged.Wait()
+				}
+				if numRemaining == 0 {
+					queueMutex.Unlock()
+					return
+				}
+				statement := queue.statements[0]
+				queue.statements = queue.statements[1:]
+				queue.numRunning++
+				queueMutex.Unlock()
+
+				tasks := make(chan toc.StatementWithType, 1)
+				tasks <- statement
+				close(tasks)
+				executeStatementsForConn(tasks, &fatalErr, &numErrors, progressBar, connNum, true)
+
+				queueMutex.Lock()
+				queue.numRunning--
+				queue.firstCompleted = true
+				numRemaining--
+				queueChanged.Broadcast()
+				queueMutex.Unlock()
+			}
+		}(i)
+	}
+	workerPool.Wait()
+
+	reportStatementErrors(fatalErr, numErrors)
+	return numErrors
+}
@@ -43,4 +43,42 @@ var _ = Describe("restore/parallel tests", func() {
 			Expect(thirdBatch).To(Equal([]toc.StatementWithType{index2_comment, index2_tablespace, trigger_comment}))
 		})
 	})
+	Describe("SplitPostdataStatements", func() {
+		index1 := toc.StatementWithType{ObjectType: "INDEX", ReferenceObject: "public.table1", Statement: `CREATE INDEX testindex1 ON public.table1 USING btree(i);`}
+		constraint1 := toc.StatementWithType{ObjectType: "CONSTRAINT", ReferenceObject: "public.table1", Statement: `ALTER TABLE public.table1 ADD CONSTRAINT pk PRIMARY KEY (i);`}
+		index1_comment := toc.StatementWithType{ObjectType: "INDEX METADATA", ReferenceObject: "public.testindex1", Statement: `COMMENT ON INDEX public.testindex1 IS 'hello';`}
+		trigger := toc.StatementWithType{ObjectType: "TRIGGER", ReferenceObject: "public.table1", Statement: `CREATE TRIGGER footrigger AFTER INSERT ON table1 FOR EACH STATEMENT EXECUTE PROCEDURE fooproc();`}
+		It("separates index builds, other objects, and metadata", func() {
+			statements := []toc.StatementWithType{index1, trigger, index1_comment, constraint1}
+			indexes, others, metadata := restore.SplitPostdataStatements(statements)
+			Expect(indexes).To(Equal([]toc.StatementWithType{index1, constraint1}))
+			Expect(others).To(Equal([]toc.StatementWithType{trigger}))
+			Expect(metadata).To(Equal([]toc.StatementWithType{index1_comment}))
+		})
+	})
+	Describe("GetRowsCopiedByTable", func() {
+		entries := map[string][]toc.CoordinatorDataEntry{
+			"20230101010101": {
+				{Schema: "public", Name: "table1", RowsCopied: 10},
+				{Schema: "public", Name: "part_1_prt_1", RowsCopied: 5, PartitionRoot: "part"},
+			},
+			"20230102010101": {
+				{Schema: "public", Name: "part_1_prt_2", RowsCopied: 7, PartitionRoot: "part"},
+			},
+		}
+		It("maps each table and partition root to its row count", func() {
+			tableRows := restore.GetRowsCopiedByTable(entries, "")
+			Expect(tableRows).To(Equal(map[string]int64{
+				"public.table1":       10,
+				"public.part_1_prt_1": 5,
+				"public.part_1_prt_2": 7,
+				"public.part":         12,
+			}))
+		})
+		It("uses the redirect schema when one is given", func() {
+			tableRows := restore.GetRowsCopiedByTable(entries, "other")
+			Expect(tableRows).To(HaveKeyWithValue("other.table1", int64(10)))
+			Expect(tableRows).To(HaveKeyWithValue("other.part", int64(12)))
+		})
+	})
 })
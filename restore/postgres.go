@@ -0,0 +1,562 @@
+package restore
+
+/*
+ * This file contains structs and functions related to restoring a backup into
+ * a plain PostgreSQL database instead of a Greenplum cluster.
+ */
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const (
+	GreenplumFlavor = "greenplum"
+	PostgresFlavor  = "postgres"
+
+	// Declarative partitioning with default partitions requires PostgreSQL 11,
+	// and Greenplum 7, whose syntax backups are converted to, is based on 12.
+	minimumPostgresVersionNum = 120000
+)
+
+func IsPostgresTarget() bool {
+	return MustGetFlagString(options.TARGET_FLAVOR) == PostgresFlavor
+}
+
+/*
+ * The user and database names are escaped so that names containing characters
+ * such as "@", "/" or "?" connect to the intended database.  No password is
+ * included, so pgx falls back to PGPASSWORD and .pgpass as libpq does.
+ */
+func GetPostgresConnectionString(conn *dbconn.DBConn) string {
+	connURL := url.URL{
+		Scheme:   "postgres",
+		User:     url.User(conn.User),
+		Host:     net.JoinHostPort(conn.Host, fmt.Sprintf("%d", conn.Port)),
+		Path:     "/" + conn.DBName,
+		RawPath:  "/" + url.PathEscape(conn.DBName),
+		RawQuery: "sslmode=disable&statement_cache_capacity=0",
+	}
+	return connURL.String()
+}
+
+/*
+ * dbconn.Connect parses the Greenplum version out of version(), which plain
+ * PostgreSQL does not report, so connections to a PostgreSQL target are made
+ * here instead.  The connection is given the version of Greenplum 7, which is
+ * based on PostgreSQL 12, so that version-dependent restore code uses the
+ * catalog and syntax that PostgreSQL supports.
+ */
+func ConnectToPostgres(conn *dbconn.DBConn, numConns int) error {
+	if conn.ConnPool != nil {
+		return errors.Errorf("The database connection must be closed before reusing the connection")
+	}
+	connStr := GetPostgresConnectionString(conn)
+	conn.ConnPool = make([]*sqlx.DB, numConns)
+	for i := 0; i < numConns; i++ {
+		db, err := conn.Driver.Connect("pgx", connStr)
+		if err != nil {
+			conn.ConnPool = nil
+			return errors.Wrap(err, fmt.Sprintf("Unable to connect to database %s on %s:%d", conn.DBName, conn.Host, conn.Port))
+		}
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		conn.ConnPool[i] = db
+	}
+	conn.Tx = make([]*sqlx.Tx, numConns)
+	conn.NumConns = numConns
+
+	var versionString string
+	var versionNum int
+	err := conn.Get(&versionString, "SELECT current_setting('server_version')")
+	if err == nil {
+		err = conn.Get(&versionNum, "SELECT current_setting('server_version_num')::int")
+	}
+	if err != nil {
+		return errors.Wrap(err, "Failed to determine database version")
+	}
+	if versionNum < minimumPostgresVersionNum {
+		return errors.Errorf("PostgreSQL version %s is not supported as a restore target. Please use PostgreSQL 12 or later.", versionString)
+	}
+	conn.Version = dbconn.GPDBVersion{VersionString: fmt.Sprintf("PostgreSQL %s", versionString), SemVer: semver.MustParse("7.0.0")}
+	return nil
+}
+
+func MustConnectToPostgres(conn *dbconn.DBConn, numConns int) {
+	err := ConnectToPostgres(conn, numConns)
+	gplog.FatalOnError(err)
+}
+
+// A PostgreSQL target has only a coordinator, on the host and port to which gprestore connects
+func NewPostgresCluster(conn *dbconn.DBConn) *cluster.Cluster {
+	return cluster.NewCluster([]cluster.SegConfig{{DbID: 1, ContentID: -1, Role: "p", Port: conn.Port, Hostname: conn.Host}})
+}
+
+/*
+ * The segment data files are read from --backup-dir, so the source segment
+ * count is taken from the backup or, for backups that do not record it, from
+ * the files in the local segment backup directories.
+ */
+func SetPostgresSourceSegmentCount() {
+	if backupConfig.SegmentCount > 0 {
+		return
+	}
+	var err error
+	filenames := make([]string, 0)
+	for contentID := 0; ; contentID++ {
+		dirEntries, err := os.ReadDir(globalFPInfo.GetDirForContent(contentID))
+		if err != nil {
+			break
+		}
+		for _, dirEntry := range dirEntries {
+			filenames = append(filenames, dirEntry.Name())
+		}
+	}
+	backupConfig.SegmentCount, err = GetSegmentCountFromFilenames(filenames, globalFPInfo.Timestamp)
+	gplog.FatalOnError(err)
+	if backupConfig.SegmentCount == 0 && !backupConfig.MetadataOnly {
+		gplog.Fatal(errors.Errorf("No segment backup files were found for backup with timestamp %s in %s", globalFPInfo.Timestamp, MustGetFlagString(options.BACKUP_DIR)), "")
+	}
+}
+
+func VerifyBackupDirectoriesExistLocally() {
+	for contentID := -1; contentID < backupConfig.SegmentCount; contentID++ {
+		backupDir := globalFPInfo.GetDirForContent(contentID)
+		if _, err := os.Stat(backupDir); err != nil {
+			gplog.Fatal(errors.Errorf("Backup directory %s missing or inaccessible", backupDir), "")
+		}
+	}
+}
+
+/*
+ * Data loading
+ */
+
+/*
+ * Single data file backups are read at the offsets recorded in each
+ * segment's TOC, so those TOCs are read once per backup before its tables
+ * are restored.  Other backups need no segment TOCs and get nil.
+ */
+func GetSegmentTOCsForCoordinatorCopy(fpInfo filepath.FilePathInfo) []*toc.SegmentTOC {
+	if !backupConfig.SingleDataFile {
+		return nil
+	}
+	segmentTOCs := make([]*toc.SegmentTOC, backupConfig.SegmentCount)
+	for contentID := range segmentTOCs {
+		segmentTOCs[contentID] = toc.NewSegmentTOC(fpInfo.GetSegmentTOCFilePath(contentID))
+	}
+	return segmentTOCs
+}
+
+/*
+ * Returns the shell command with which the coordinator reads the data of a
+ * table from all of the segment files, one segment after another.  Every
+ * segment of a replicated table holds a full copy of it, so only the first is
+ * read.  Data in a single data file is found by decompressing the file and
+ * skipping to the table's offset, which is slow for large backups but needs
+ * no gpbackup_helper.
+ */
+func GetCoordinatorReadCommand(fpInfo *filepath.FilePathInfo, entry toc.CoordinatorDataEntry, segmentCount int, segmentTOCs []*toc.SegmentTOC) string {
+	if entry.IsReplicated && segmentCount > 1 {
+		segmentCount = 1
+	}
+	pipeThroughProgram := utils.GetPipeThroughProgram()
+	if segmentTOCs == nil {
+		filenames := make([]string, segmentCount)
+		for contentID := range filenames {
+			filenames[contentID] = fpInfo.GetTableBackupFilePath(contentID, entry.Oid, pipeThroughProgram.Extension, false)
+		}
+		return fmt.Sprintf("cat %s | %s", strings.Join(filenames, " "), pipeThroughProgram.InputCommand)
+	}
+
+	commands := make([]string, 0, segmentCount)
+	for contentID := 0; contentID < segmentCount; contentID++ {
+		dataEntry, ok := segmentTOCs[contentID].DataEntries[uint(entry.Oid)]
+		if !ok {
+			continue
+		}
+		filename := fpInfo.GetTableBackupFilePath(contentID, 0, pipeThroughProgram.Extension, true)
+		commands = append(commands, fmt.Sprintf("%s < %s | tail -c +%d | head -c %d", pipeThroughProgram.InputCommand, filename, dataEntry.StartByte+1, dataEntry.EndByte-dataEntry.StartByte))
+	}
+	if len(commands) == 0 {
+		return "true"
+	}
+	return fmt.Sprintf("{ %s; }", strings.Join(commands, "; "))
+}
+
+/*
+ * PostgreSQL has no COPY ... ON SEGMENT, so the coordinator reads every
+ * segment's data for the table itself.  The backup files must be accessible
+ * at the same path from the PostgreSQL server.
+ */
+func CopyTableInOnCoordinator(connectionPool *dbconn.DBConn, tableName string, tableAttributes string, readCommand string, whichConn int) (int64, error) {
+	whichConn = connectionPool.ValidateConnNum(whichConn)
+	query := fmt.Sprintf("COPY %s%s FROM PROGRAM '%s' WITH CSV DELIMITER '%s';", tableName, tableAttributes, utils.EscapeSingleQuotes(readCommand), tableDelim)
+	gplog.Verbose(`Executing "%s" on coordinator`, query)
+	result, err := connectionPool.Exec(query, whichConn)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("Error loading data into table %s", tableName))
+	}
+	numRows, _ := result.RowsAffected()
+	return numRows, nil
+}
+
+func restoreSingleTableDataOnCoordinator(fpInfo *filepath.FilePathInfo, entry toc.CoordinatorDataEntry, tableName string, segmentTOCs []*toc.SegmentTOC, whichConn int) error {
+	readCommand := GetCoordinatorReadCommand(fpInfo, entry, backupConfig.SegmentCount, segmentTOCs)
+	gplog.Debug("Reading with %s", readCommand)
+	numRowsRestored, err := CopyTableInOnCoordinator(connectionPool, tableName, entry.AttributeString, readCommand, whichConn)
+	if err != nil {
+		return err
+	}
+	numRowsBackedUp := entry.RowsCopied
+	if entry.IsReplicated && backupConfig.SegmentCount > 0 {
+		numRowsBackedUp /= int64(backupConfig.SegmentCount)
+	}
+	return CheckRowsRestored(numRowsRestored, numRowsBackedUp, tableName)
+}
+
+/*
+ * Statement conversion
+ */
+
+var (
+	// Objects that only exist in Greenplum, which are skipped entirely
+	greenplumOnlyObjectTypes = map[string]bool{
+		"RESOURCE QUEUE":     true,
+		"RESOURCE GROUP":     true,
+		"PROTOCOL":           true,
+		"EXCHANGE PARTITION": true,
+	}
+	// Storage options of append-optimized tables, which PostgreSQL does not have
+	greenplumStorageOptions = map[string]bool{
+		"appendonly":      true,
+		"appendoptimized": true,
+		"orientation":     true,
+		"compresstype":    true,
+		"compresslevel":   true,
+		"blocksize":       true,
+		"checksum":        true,
+	}
+
+	identPattern          = `(?:"(?:[^"]|"")*"|[^\s;"]+)`
+	externalTableRE       = regexp.MustCompile(`^\s*CREATE (?:READABLE |WRITABLE )?(?:WEB )?EXTERNAL `)
+	roleAttributeRE       = regexp.MustCompile(` (?:RESOURCE (?:QUEUE|GROUP) ` + identPattern + `|NO)?CREATEEXTTABLE(?: \([^)]*\))?| RESOURCE (?:QUEUE|GROUP) ` + identPattern)
+	greenplumGUCRE        = regexp.MustCompile(`(?i)\bSET (?:gp_|optimizer|default_with_oids\b)`)
+	columnEncodingRE      = regexp.MustCompile(`(?m)^(\t.*) ENCODING \([^)]*\)(,?)$`)
+	distributionPolicyRE  = regexp.MustCompile(`DISTRIBUTED (?:BY \([^)]*\)|RANDOMLY|REPLICATED)`)
+	appendOptimizedAMRE   = regexp.MustCompile(`USING (?:ao_row|ao_column) `)
+	storageOptionsRE      = regexp.MustCompile(`WITH \(([^)]*)\) `)
+	bitmapIndexRE         = regexp.MustCompile(`\bUSING bitmap\b`)
+	classicPartitionKeyRE = regexp.MustCompile(`^PARTITION BY (RANGE|LIST)\s*\(([^)]*)\)\s*`)
+	partitionNameRE       = regexp.MustCompile(`^(DEFAULT )?PARTITION ` + identPattern + `\s*`)
+	tablenameOptionRE     = regexp.MustCompile(`tablename='((?:[^']|'')*)'`)
+	simpleIdentRE         = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+)
+
+/*
+ * Removes or rewrites the parts of a backup's statements that PostgreSQL does
+ * not support, skipping any objects that do not apply to it.
+ */
+func ConvertStatementsForPostgres(statements []toc.StatementWithType) []toc.StatementWithType {
+	converted := make([]toc.StatementWithType, 0, len(statements))
+	for _, statement := range statements {
+		if greenplumOnlyObjectTypes[statement.ObjectType] {
+			gplog.Verbose("Skipping %s %s, which does not apply to PostgreSQL", strings.ToLower(statement.ObjectType), statement.Name)
+			continue
+		}
+		newStatement, ok := ConvertStatementForPostgres(statement)
+		if !ok {
+			gplog.Verbose("Skipping statement for %s %s, which does not apply to PostgreSQL", strings.ToLower(statement.ObjectType), utils.MakeFQN(statement.Schema, statement.Name))
+			continue
+		}
+		converted = append(converted, newStatement)
+	}
+	return converted
+}
+
+func ConvertStatementForPostgres(statement toc.StatementWithType) (toc.StatementWithType, bool) {
+	switch statement.ObjectType {
+	case "TABLE":
+		if externalTableRE.MatchString(statement.Statement) {
+			gplog.Warn("Skipping external table %s, which is not supported by PostgreSQL", utils.MakeFQN(statement.Schema, statement.Name))
+			return statement, false
+		}
+		statement.Statement = ConvertTableStatementForPostgres(statement.Schema, statement.Name, statement.Statement)
+	case "ROLE":
+		if strings.Contains(statement.Statement, " DENY BETWEEN ") || strings.Contains(statement.Statement, " DENY DAY ") {
+			return statement, false
+		}
+		statement.Statement = roleAttributeRE.ReplaceAllString(statement.Statement, "")
+	case "DATABASE GUC", "ROLE GUCS":
+		if greenplumGUCRE.MatchString(statement.Statement) {
+			return statement, false
+		}
+	case "INDEX":
+		statement.Statement = bitmapIndexRE.ReplaceAllString(statement.Statement, "USING btree")
+	}
+	return statement, true
+}
+
+/*
+ * Strips distribution policies, append-optimized storage, and column
+ * encodings from a CREATE TABLE statement, and converts a partition
+ * definition in the syntax of Greenplum 6 and earlier to declarative
+ * partitions where possible.  Tables backed up from Greenplum 7 already use
+ * declarative partitioning.
+ */
+func ConvertTableStatementForPostgres(schema string, name string, statement string) string {
+	statement = columnEncodingRE.ReplaceAllString(statement, "$1$2")
+	tailStart := strings.Index(statement, "\n) ")
+	if tailStart == -1 {
+		return statement
+	}
+	tailStart += len("\n) ")
+	tailEnd := tailStart + findStatementEnd(statement[tailStart:])
+	head, clauses, remainder := statement[:tailStart], statement[tailStart:tailEnd], statement[tailEnd:]
+
+	partitionKey := ""
+	leafStatements := make([]string, 0)
+	if location := distributionPolicyRE.FindStringIndex(clauses); location != nil {
+		partitionDef := strings.TrimSpace(clauses[location[1]:])
+		clauses = clauses[:location[0]]
+		if partitionDef != "" {
+			tableFQN := utils.MakeFQN(schema, name)
+			var ok bool
+			partitionKey, leafStatements, ok = ConvertClassicPartitionDefinition(schema, tableFQN, partitionDef)
+			if !ok {
+				gplog.Warn("Partition definition of table %s cannot be converted to declarative partitioning; restoring it as an unpartitioned table", tableFQN)
+			}
+		}
+	}
+	clauses = appendOptimizedAMRE.ReplaceAllString(clauses, "")
+	isPartitioned := partitionKey != "" || strings.HasPrefix(clauses, "PARTITION BY ")
+	clauses = storageOptionsRE.ReplaceAllStringFunc(clauses, func(withClause string) string {
+		// PostgreSQL does not allow storage options on partitioned tables
+		if isPartitioned {
+			return ""
+		}
+		options := make([]string, 0)
+		for _, option := range strings.Split(storageOptionsRE.FindStringSubmatch(withClause)[1], ",") {
+			option = strings.TrimSpace(option)
+			key := strings.ToLower(strings.TrimSpace(strings.SplitN(option, "=", 2)[0]))
+			if option != "" && !greenplumStorageOptions[key] {
+				options = append(options, option)
+			}
+		}
+		if len(options) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("WITH (%s) ", strings.Join(options, ", "))
+	})
+	if partitionKey != "" {
+		clauses = partitionKey + " " + clauses
+	}
+
+	remainder = removeSubpartitionTemplates(remainder)
+	if len(leafStatements) > 0 && strings.HasPrefix(remainder, ";") {
+		remainder = ";\n" + strings.Join(leafStatements, "\n") + remainder[1:]
+	}
+	clauses = strings.TrimSpace(clauses)
+	if clauses == "" {
+		head = strings.TrimSuffix(head, " ")
+	}
+	return head + clauses + remainder
+}
+
+// Returns the index of the first semicolon that is not within a quoted string or identifier
+func findStatementEnd(statement string) int {
+	var quote byte
+	for i := 0; i < len(statement); i++ {
+		char := statement[i]
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			quote = char
+		case char == ';':
+			return i
+		}
+	}
+	return len(statement)
+}
+
+func removeSubpartitionTemplates(statements string) string {
+	for {
+		templateIndex := strings.Index(statements, "SET SUBPARTITION TEMPLATE")
+		if templateIndex == -1 {
+			return statements
+		}
+		start := strings.LastIndex(statements[:templateIndex], "ALTER TABLE")
+		if start == -1 {
+			return statements
+		}
+		end := start + findStatementEnd(statements[start:])
+		if end < len(statements) {
+			end++
+		}
+		statements = strings.TrimRight(statements[:start], "\n") + statements[end:]
+	}
+}
+
+/*
+ * Splits a comma-separated list, ignoring commas within parentheses or
+ * quoted strings, as in the partition list of a partition definition.
+ */
+func splitTopLevelList(list string) []string {
+	items := make([]string, 0)
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(list); i++ {
+		char := list[i]
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			quote = char
+		case char == '(':
+			depth++
+		case char == ')':
+			depth--
+		case char == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+	return append(items, strings.TrimSpace(list[start:]))
+}
+
+/*
+ * Returns the contents of the parenthesized list following keyword in
+ * clause, and the remainder of the clause after it, or ok=false if the
+ * keyword is not present.
+ */
+func getParenthesizedArgument(clause string, keyword string) (argument string, rest string, ok bool) {
+	start := strings.Index(clause, keyword)
+	if start == -1 {
+		return "", clause, false
+	}
+	open := strings.Index(clause[start:], "(")
+	if open == -1 {
+		return "", clause, false
+	}
+	open += start
+	depth := 0
+	var quote byte
+	for i := open; i < len(clause); i++ {
+		char := clause[i]
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			quote = char
+		case char == '(':
+			depth++
+		case char == ')':
+			depth--
+			if depth == 0 {
+				return clause[open+1 : i], clause[i+1:], true
+			}
+		}
+	}
+	return "", clause, false
+}
+
+func quoteIdentIfNeeded(ident string) string {
+	if strings.HasPrefix(ident, `"`) || simpleIdentRE.MatchString(ident) {
+		return ident
+	}
+	return fmt.Sprintf(`"%s"`, strings.Replace(ident, `"`, `""`, -1))
+}
+
+/*
+ * Converts a single-level partition definition printed by Greenplum 6 or
+ * earlier, such as
+ *   PARTITION BY LIST(gender) (PARTITION girls VALUES('F') WITH (tablename='public.t_1_prt_girls', ...), ...)
+ * into a PARTITION BY clause for the root table and a CREATE TABLE ...
+ * PARTITION OF statement for each leaf, keeping the leaf table names so that
+ * data backed up per leaf can be restored into them.  Subpartitioned tables,
+ * and range bounds other than an inclusive start and exclusive end, have no
+ * declarative equivalent and are not converted, nor are ranges abbreviated
+ * with EVERY, whose leaf tables are not all named.
+ */
+func ConvertClassicPartitionDefinition(schema string, rootFQN string, partitionDef string) (string, []string, bool) {
+	keyMatch := classicPartitionKeyRE.FindStringSubmatch(partitionDef)
+	if keyMatch == nil || strings.Contains(partitionDef, "SUBPARTITION BY") || strings.Contains(partitionDef, " EVERY ") {
+		return "", nil, false
+	}
+	strategy, keyColumns := keyMatch[1], keyMatch[2]
+	partitionList, _, ok := getParenthesizedArgument(partitionDef[len(keyMatch[0]):], "")
+	if !ok {
+		return "", nil, false
+	}
+
+	leafStatements := make([]string, 0)
+	for _, partition := range splitTopLevelList(partitionList) {
+		isDefault := false
+		if nameMatch := partitionNameRE.FindStringSubmatch(partition); nameMatch != nil {
+			isDefault = nameMatch[1] != ""
+			partition = partition[len(nameMatch[0]):]
+		}
+		tablenameMatch := tablenameOptionRE.FindStringSubmatch(partition)
+		if tablenameMatch == nil {
+			return "", nil, false
+		}
+		leafFQN := utils.MakeFQN(schema, quoteIdentIfNeeded(tablenameMatch[1]))
+		if leafSchema, leafName, found := strings.Cut(tablenameMatch[1], "."); found {
+			leafFQN = utils.MakeFQN(quoteIdentIfNeeded(leafSchema), quoteIdentIfNeeded(leafName))
+		}
+		if withIndex := strings.Index(partition, "WITH ("); withIndex != -1 {
+			partition = partition[:withIndex]
+		}
+
+		bound := ""
+		switch {
+		case isDefault:
+			bound = "DEFAULT"
+		case strategy == "LIST":
+			values, _, ok := getParenthesizedArgument(partition, "VALUES")
+			if !ok {
+				return "", nil, false
+			}
+			bound = fmt.Sprintf("FOR VALUES IN (%s)", values)
+		default:
+			start, afterStart, hasStart := getParenthesizedArgument(partition, "START")
+			end, afterEnd, hasEnd := getParenthesizedArgument(partition, "END")
+			if (hasStart && strings.HasPrefix(strings.TrimSpace(afterStart), "EXCLUSIVE")) ||
+				(hasEnd && strings.HasPrefix(strings.TrimSpace(afterEnd), "INCLUSIVE")) || (!hasStart && !hasEnd) {
+				return "", nil, false
+			}
+			if !hasStart {
+				start = "MINVALUE"
+			}
+			if !hasEnd {
+				end = "MAXVALUE"
+			}
+			bound = fmt.Sprintf("FOR VALUES FROM (%s) TO (%s)", start, end)
+		}
+		leafStatements = append(leafStatements, fmt.Sprintf("CREATE TABLE %s PARTITION OF %s %s;", leafFQN, rootFQN, bound))
+	}
+	return fmt.Sprintf("PARTITION BY %s (%s)", strategy, keyColumns), leafStatements, true
+}
@@ -0,0 +1,203 @@
+package restore_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/postgres tests", func() {
+	Describe("GetPostgresConnectionString", func() {
+		It("builds a connection URL", func() {
+			conn := &dbconn.DBConn{User: "gpadmin", Host: "localhost", Port: 5432, DBName: "testdb"}
+			Expect(restore.GetPostgresConnectionString(conn)).To(Equal("postgres://gpadmin@localhost:5432/testdb?sslmode=disable&statement_cache_capacity=0"))
+		})
+		It("escapes user and database names containing URL delimiters", func() {
+			conn := &dbconn.DBConn{User: "user@corp", Host: "localhost", Port: 5432, DBName: "my db/1?x#"}
+			Expect(restore.GetPostgresConnectionString(conn)).To(Equal("postgres://user%40corp@localhost:5432/my%20db%2F1%3Fx%23?sslmode=disable&statement_cache_capacity=0"))
+		})
+		It("brackets an IPv6 host", func() {
+			conn := &dbconn.DBConn{User: "gpadmin", Host: "::1", Port: 5432, DBName: "testdb"}
+			Expect(restore.GetPostgresConnectionString(conn)).To(Equal("postgres://gpadmin@[::1]:5432/testdb?sslmode=disable&statement_cache_capacity=0"))
+		})
+	})
+	Describe("ConvertTableStatementForPostgres", func() {
+		It("removes the distribution policy and append-optimized storage options", func() {
+			statement := `
+
+CREATE TABLE public.foo (
+	i integer ENCODING (compresstype=zlib,blocksize=32768,compresslevel=1),
+	j text ENCODING (compresstype=none,blocksize=32768,compresslevel=0)
+) WITH (appendonly=true, orientation=column, fillfactor=50) TABLESPACE test_tablespace DISTRIBUTED BY (i);`
+			Expect(restore.ConvertTableStatementForPostgres("public", "foo", statement)).To(Equal(`
+
+CREATE TABLE public.foo (
+	i integer,
+	j text
+) WITH (fillfactor=50) TABLESPACE test_tablespace;`))
+		})
+		It("removes the access method of an append-optimized table and keeps following statements", func() {
+			statement := `
+
+CREATE TABLE public.foo (
+	i integer
+) USING ao_row WITH (compresstype=zstd, compresslevel=1) DISTRIBUTED RANDOMLY;
+
+ALTER TABLE ONLY public.foo ALTER COLUMN i SET STATISTICS 10;`
+			Expect(restore.ConvertTableStatementForPostgres("public", "foo", statement)).To(Equal(`
+
+CREATE TABLE public.foo (
+	i integer
+);
+
+ALTER TABLE ONLY public.foo ALTER COLUMN i SET STATISTICS 10;`))
+		})
+		It("keeps a declarative partition key and drops storage options of the partitioned table", func() {
+			statement := `
+
+CREATE TABLE public.foo (
+	i integer,
+	b date
+) PARTITION BY RANGE (b) WITH (fillfactor=50) DISTRIBUTED REPLICATED;`
+			Expect(restore.ConvertTableStatementForPostgres("public", "foo", statement)).To(Equal(`
+
+CREATE TABLE public.foo (
+	i integer,
+	b date
+) PARTITION BY RANGE (b);`))
+		})
+		It("converts a list partition definition to declarative partitions", func() {
+			statement := `
+
+CREATE TABLE public.rank (
+	id integer,
+	gender character(1)
+) WITH (appendonly=true) DISTRIBUTED RANDOMLY PARTITION BY LIST(gender)
+	(
+	PARTITION girls VALUES('F') WITH (tablename='rank_1_prt_girls', appendonly=false ),
+	PARTITION boys VALUES('M', 'm') WITH (tablename='other.rank_1_prt_boys', appendonly=false ),
+	DEFAULT PARTITION other  WITH (tablename='rank_1_prt_other', appendonly=false )
+	);`
+			Expect(restore.ConvertTableStatementForPostgres("public", "rank", statement)).To(Equal(`
+
+CREATE TABLE public.rank (
+	id integer,
+	gender character(1)
+) PARTITION BY LIST (gender);
+CREATE TABLE public.rank_1_prt_girls PARTITION OF public.rank FOR VALUES IN ('F');
+CREATE TABLE other.rank_1_prt_boys PARTITION OF public.rank FOR VALUES IN ('M', 'm');
+CREATE TABLE public.rank_1_prt_other PARTITION OF public.rank DEFAULT;`))
+		})
+		It("converts a range partition definition to declarative partitions", func() {
+			statement := `
+
+CREATE TABLE public.sales (
+	id integer,
+	date date
+) DISTRIBUTED BY (id) PARTITION BY RANGE(date)
+	(
+	START ('2017-01-01'::date) END ('2017-02-01'::date) WITH (tablename='sales_1_prt_1', appendonly=false ),
+	PARTITION "Feb" START ('2017-02-01'::date) WITH (tablename='sales_1_prt_Feb', appendonly=false ),
+	DEFAULT PARTITION other  WITH (tablename='sales_1_prt_other', appendonly=false )
+	);`
+			Expect(restore.ConvertTableStatementForPostgres("public", "sales", statement)).To(Equal(`
+
+CREATE TABLE public.sales (
+	id integer,
+	date date
+) PARTITION BY RANGE (date);
+CREATE TABLE public.sales_1_prt_1 PARTITION OF public.sales FOR VALUES FROM ('2017-01-01'::date) TO ('2017-02-01'::date);
+CREATE TABLE public."sales_1_prt_Feb" PARTITION OF public.sales FOR VALUES FROM ('2017-02-01'::date) TO (MAXVALUE);
+CREATE TABLE public.sales_1_prt_other PARTITION OF public.sales DEFAULT;`))
+		})
+		It("restores a subpartitioned table as an unpartitioned table", func() {
+			statement := `
+
+CREATE TABLE public.rank (
+	id integer,
+	gender character(1)
+) DISTRIBUTED RANDOMLY PARTITION BY LIST(gender)
+          SUBPARTITION BY LIST(region)
+	(
+	PARTITION girls VALUES('F') WITH (tablename='rank_1_prt_girls', appendonly=false )
+	);
+ALTER TABLE rank
+SET SUBPARTITION TEMPLATE
+          (
+          SUBPARTITION usa VALUES('usa') WITH (tablename='rank'),
+          DEFAULT SUBPARTITION other_regions  WITH (tablename='rank')
+          );`
+			Expect(restore.ConvertTableStatementForPostgres("public", "rank", statement)).To(Equal(`
+
+CREATE TABLE public.rank (
+	id integer,
+	gender character(1)
+);`))
+		})
+		It("restores a range partitioned table with an exclusive start as an unpartitioned table", func() {
+			statement := `
+
+CREATE TABLE public.foo (
+	id integer
+) DISTRIBUTED RANDOMLY PARTITION BY RANGE(id)
+	(
+	START (1) EXCLUSIVE END (10) WITH (tablename='foo_1_prt_1', appendonly=false )
+	);`
+			Expect(restore.ConvertTableStatementForPostgres("public", "foo", statement)).To(Equal(`
+
+CREATE TABLE public.foo (
+	id integer
+);`))
+		})
+	})
+	Describe("ConvertStatementsForPostgres", func() {
+		It("skips Greenplum-only objects and strips Greenplum-only clauses", func() {
+			statements := []toc.StatementWithType{
+				{ObjectType: "RESOURCE QUEUE", Name: "myqueue", Statement: "CREATE RESOURCE QUEUE myqueue WITH (ACTIVE_STATEMENTS=1);"},
+				{ObjectType: "ROLE", Name: "myrole", Statement: `CREATE ROLE myrole;
+ALTER ROLE myrole WITH NOSUPERUSER LOGIN RESOURCE QUEUE "my queue" RESOURCE GROUP default_group CREATEEXTTABLE (protocol='gpfdist', type='readable') NOCREATEEXTTABLE (protocol='http');`},
+				{ObjectType: "ROLE", Name: "myrole", Statement: "ALTER ROLE myrole DENY BETWEEN DAY 0 TIME '00:00:00' AND DAY 1 TIME '00:00:00';"},
+				{ObjectType: "DATABASE GUC", Name: "testdb", Statement: "ALTER DATABASE testdb SET gp_default_storage_options TO 'appendonly=true';"},
+				{ObjectType: "DATABASE GUC", Name: "testdb", Statement: "ALTER DATABASE testdb SET search_path TO public;"},
+				{ObjectType: "TABLE", Schema: "public", Name: "ext", Statement: "CREATE READABLE EXTERNAL WEB TABLE public.ext (\n\ti integer\n) EXECUTE 'echo 1' ON ALL FORMAT 'text';"},
+				{ObjectType: "INDEX", Schema: "public", Name: "idx", Statement: "CREATE INDEX idx ON public.foo USING bitmap (i);"},
+			}
+			Expect(restore.ConvertStatementsForPostgres(statements)).To(Equal([]toc.StatementWithType{
+				{ObjectType: "ROLE", Name: "myrole", Statement: "CREATE ROLE myrole;\nALTER ROLE myrole WITH NOSUPERUSER LOGIN;"},
+				{ObjectType: "DATABASE GUC", Name: "testdb", Statement: "ALTER DATABASE testdb SET search_path TO public;"},
+				{ObjectType: "INDEX", Schema: "public", Name: "idx", Statement: "CREATE INDEX idx ON public.foo USING btree (i);"},
+			}))
+		})
+	})
+	Describe("GetCoordinatorReadCommand", func() {
+		var fpInfo filepath.FilePathInfo
+		BeforeEach(func() {
+			testCluster := cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, Hostname: "localhost", DataDir: "/data/gpseg-1"}})
+			fpInfo = filepath.NewFilePathInfo(testCluster, "/backups", "20170101010101", "gpseg")
+			utils.InitializePipeThroughParameters(true, "gzip", 0)
+		})
+		It("reads the file of each segment for a multiple data file backup", func() {
+			entry := toc.CoordinatorDataEntry{Oid: 1234}
+			Expect(restore.GetCoordinatorReadCommand(&fpInfo, entry, 2, nil)).To(Equal("cat /backups/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1234.gz /backups/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_1234.gz | gzip -d -c"))
+		})
+		It("reads only the first segment for a replicated table", func() {
+			entry := toc.CoordinatorDataEntry{Oid: 1234, IsReplicated: true}
+			Expect(restore.GetCoordinatorReadCommand(&fpInfo, entry, 2, nil)).To(Equal("cat /backups/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1234.gz | gzip -d -c"))
+		})
+		It("reads the range of the table from each segment for a single data file backup", func() {
+			segmentTOCs := []*toc.SegmentTOC{
+				{DataEntries: map[uint]toc.SegmentDataEntry{1234: {StartByte: 0, EndByte: 10}}},
+				{DataEntries: map[uint]toc.SegmentDataEntry{1234: {StartByte: 20, EndByte: 25}}},
+			}
+			entry := toc.CoordinatorDataEntry{Oid: 1234}
+			Expect(restore.GetCoordinatorReadCommand(&fpInfo, entry, 2, segmentTOCs)).To(Equal("{ gzip -d -c < /backups/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz | tail -c +1 | head -c 10; " +
+				"gzip -d -c < /backups/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101.gz | tail -c +21 | head -c 5; }"))
+		})
+	})
+})
@@ -2,13 +2,17 @@ package restore
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/pkg/errors"
 )
 
@@ -73,6 +77,113 @@ func VerifyBackupFileCountOnSegments() {
 	}
 }
 
+/*
+ * Backups taken before the segment count was recorded in the config file can
+ * still be resized, as every segment data file is named with the content of
+ * the segment that wrote it.  The files of original content N are placed in
+ * the backup directory of destination content N % destSize for a resize
+ * restore, so we look in every destination directory.
+ */
+func InferSegmentCount() (int, error) {
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Finding backup files to determine original segment count", cluster.ON_SEGMENTS, func(contentID int) string {
+		// Destination contents beyond the original cluster size will have no backup directory
+		return fmt.Sprintf("if [ -d %[1]s ]; then ls -1 %[1]s; fi", globalFPInfo.GetDirForContent(contentID))
+	})
+	globalCluster.CheckClusterError(remoteOutput, "Unable to list backup files on segments", func(contentID int) string {
+		return fmt.Sprintf("Unable to list backup files in %s", globalFPInfo.GetDirForContent(contentID))
+	})
+
+	filenames := make([]string, 0)
+	for _, cmd := range remoteOutput.Commands {
+		filenames = append(filenames, strings.Fields(cmd.Stdout)...)
+	}
+	return GetSegmentCountFromFilenames(filenames, globalFPInfo.Timestamp)
+}
+
+/*
+ * Returns one more than the highest content in any segment file name for the
+ * given backup, or 0 if there are none.  Every content up to the highest must
+ * have files, as a gap means that the files of some segments are missing and
+ * the highest content does not reliably give the segment count.
+ */
+func GetSegmentCountFromFilenames(filenames []string, timestamp string) (int, error) {
+	segmentFileRE := regexp.MustCompile(fmt.Sprintf(`^gpbackup_([0-9]+)_%s(_|\.|$)`, timestamp))
+	foundContents := make(map[int]bool)
+	segmentCount := 0
+	for _, filename := range filenames {
+		matches := segmentFileRE.FindStringSubmatch(filename)
+		if matches == nil {
+			continue
+		}
+		contentID, _ := strconv.Atoi(matches[1])
+		foundContents[contentID] = true
+		if contentID+1 > segmentCount {
+			segmentCount = contentID + 1
+		}
+	}
+	missingContents := make([]string, 0)
+	for contentID := 0; contentID < segmentCount; contentID++ {
+		if !foundContents[contentID] {
+			missingContents = append(missingContents, strconv.Itoa(contentID))
+		}
+	}
+	if len(missingContents) > 0 {
+		return 0, errors.Errorf("Backup files for backup with timestamp %s were found for contents up to %d but not for contents %s", timestamp, segmentCount-1, strings.Join(missingContents, ", "))
+	}
+	return segmentCount, nil
+}
+
+/*
+ * Plugins cannot list the files they store, so the segment files of a plugin
+ * backup are requested from the plugin one content at a time, from the first
+ * segment's host, until one is not found.  The small segment TOC files are
+ * used when the backup has them, and otherwise the data file of the table with
+ * the fewest rows.  As with a resize restore itself, the files of every
+ * content are requested under the first segment's backup directory.
+ */
+func InferSegmentCountWithPlugin() int {
+	probeFile := ""
+	probeCommand := ""
+	if backupConfig.SingleDataFile {
+		probeFile = globalFPInfo.GetSegmentTOCFilePath(0)
+		probeCommand = fmt.Sprintf("%s restore_file %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+	} else {
+		backupTOC := toc.NewTOC(globalFPInfo.GetTOCFilePath())
+		if len(backupTOC.DataEntries) == 0 {
+			return 0
+		}
+		smallestEntry := backupTOC.DataEntries[0]
+		for _, entry := range backupTOC.DataEntries {
+			if entry.RowsCopied < smallestEntry.RowsCopied {
+				smallestEntry = entry
+			}
+		}
+		probeFile = globalFPInfo.GetTableBackupFilePath(0, smallestEntry.Oid, utils.GetPipeThroughProgram().Extension, false)
+		probeCommand = fmt.Sprintf("%s restore_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+	}
+	probeFile = strings.Replace(probeFile, "gpbackup_0_", "gpbackup_${content}_", 1)
+
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Finding backup files with plugin to determine original segment count", cluster.ON_SEGMENTS, func(contentID int) string {
+		if contentID != 0 {
+			return ""
+		}
+		return fmt.Sprintf(`mkdir -p %s && source %s/greenplum_path.sh && content=0; while %s "%s" > /dev/null 2>&1; do rm -f "%s"; content=$((content+1)); done; echo $content`,
+			globalFPInfo.GetDirForContent(0), operating.System.Getenv("GPHOME"), probeCommand, probeFile, probeFile)
+	})
+	globalCluster.CheckClusterError(remoteOutput, "Unable to find backup files with plugin", func(contentID int) string {
+		return "Unable to find backup files with plugin"
+	})
+
+	for _, cmd := range remoteOutput.Commands {
+		if cmd.Content == 0 {
+			segmentCount, err := strconv.Atoi(strings.TrimSpace(cmd.Stdout))
+			gplog.FatalOnError(err)
+			return segmentCount
+		}
+	}
+	return 0
+}
+
 func VerifyMetadataFilePaths(withStats bool) {
 	filetypes := []string{"config", "table of contents", "metadata"}
 	missing := false
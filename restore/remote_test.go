@@ -1,7 +1,10 @@
 package restore_test
 
 import (
+	"io/ioutil"
+	"os"
 	"os/user"
+	"regexp"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
@@ -11,6 +14,8 @@ import (
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/restore"
 	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -35,6 +40,62 @@ var _ = Describe("restore/remote tests", func() {
 		testFPInfo = filepath.NewFilePathInfo(testCluster, "", "20170101010101", "gpseg")
 		restore.SetFPInfo(testFPInfo)
 	})
+	Describe("InferSegmentCount", func() {
+		It("finds the highest content among the segment backup files", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{
+				Commands: []cluster.ShellCommand{
+					cluster.ShellCommand{Content: 0, Stdout: "gpbackup_0_20170101010101_16384.gz\ngpbackup_2_20170101010101_16384.gz\ngpbackup_4_20170101010101_16384.gz\n"},
+					cluster.ShellCommand{Content: 1, Stdout: "gpbackup_1_20170101010101_16384.gz\ngpbackup_3_20170101010101_16384.gz\n"},
+				},
+			}
+			restore.SetCluster(testCluster)
+			segmentCount, err := restore.InferSegmentCount()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(segmentCount).To(Equal(5))
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+		})
+	})
+	Describe("InferSegmentCountWithPlugin", func() {
+		It("requests segment TOC files from the plugin until one is not found", func() {
+			restore.SetBackupConfig(&history.BackupConfig{SingleDataFile: true})
+			restore.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/fake_plugin.sh", ConfigPath: "/tmp/plugin_config"})
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{
+				Commands: []cluster.ShellCommand{
+					cluster.ShellCommand{Content: 0, Stdout: "4\n"},
+					cluster.ShellCommand{Content: 1, Stdout: ""},
+				},
+			}
+			restore.SetCluster(testCluster)
+			Expect(restore.InferSegmentCountWithPlugin()).To(Equal(4))
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			Expect(testExecutor.ClusterCommands[0][0].CommandString).To(ContainSubstring(`while /tmp/fake_plugin.sh restore_file /tmp/plugin_config "/data/gpseg0/backups/20170101/20170101010101/gpbackup_${content}_20170101010101_toc.yaml"`))
+		})
+	})
+	Describe("GetSegmentCountFromFilenames", func() {
+		It("matches data and TOC files for the given timestamp only", func() {
+			filenames := []string{
+				"gpbackup_0_20170101010101.gz",
+				"gpbackup_1_20170101010101_toc.yaml",
+				"gpbackup_2_20170101010101",
+				"gpbackup_7_20170101010102_16384.gz",
+				"gpbackup_9_201701010101010_16384.gz",
+				"gpbackup_20170101010101_config.yaml",
+			}
+			segmentCount, err := restore.GetSegmentCountFromFilenames(filenames, "20170101010101")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(segmentCount).To(Equal(3))
+		})
+		It("returns 0 if there are no segment files", func() {
+			segmentCount, err := restore.GetSegmentCountFromFilenames([]string{}, "20170101010101")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(segmentCount).To(Equal(0))
+		})
+		It("returns an error if the files of some contents are missing", func() {
+			filenames := []string{"gpbackup_0_20170101010101.gz", "gpbackup_3_20170101010101.gz"}
+			_, err := restore.GetSegmentCountFromFilenames(filenames, "20170101010101")
+			Expect(err).To(MatchError("Backup files for backup with timestamp 20170101010101 were found for contents up to 3 but not for contents 1, 2"))
+		})
+	})
 	Describe("VerifyBackupFileCountOnSegments", func() {
 		BeforeEach(func() {
 			restore.SetBackupConfig(&history.BackupConfig{SingleDataFile: true})
@@ -117,4 +178,100 @@ var _ = Describe("restore/remote tests", func() {
 			restore.VerifyBackupFileCountOnSegments()
 		})
 	})
+	Describe("CopyCoordinatorFilesFromSource", func() {
+		sourceCoordinatorSeg := cluster.SegConfig{ContentID: -1, Hostname: "sourcehost", DataDir: "/source/srcseg-1"}
+		sourceSegOne := cluster.SegConfig{ContentID: 0, Hostname: "sourcesdw1", DataDir: "/source/srcseg0"}
+		BeforeEach(func() {
+			restore.SetCluster(testCluster)
+			restore.SetSourceCluster(cluster.NewCluster([]cluster.SegConfig{sourceCoordinatorSeg, sourceSegOne}), "srcseg")
+			_ = cmdFlags.Set(options.SOURCE_HOST, "sourcehost")
+		})
+		AfterEach(func() {
+			restore.SetSourceCluster(nil, "")
+			restore.SetSourceHistoryDatabasePath("")
+			_ = cmdFlags.Set(options.SOURCE_HOST, "")
+			_ = cmdFlags.Set(options.SOURCE_DIR, "")
+		})
+		It("copies the coordinator directory of the backup from the source cluster", func() {
+			restore.CopyCoordinatorFilesFromSource(testFPInfo)
+
+			Expect(testExecutor.LocalCommands).To(Equal([]string{"mkdir -p /data/gpseg-1/backups/20170101/20170101010101 && " +
+				"rsync -e ssh -a --out-format=%n sourcehost:/source/srcseg-1/backups/20170101/20170101010101/ /data/gpseg-1/backups/20170101/20170101010101/"}))
+		})
+		It("copies the coordinator directory of the backup from --source-dir", func() {
+			_ = cmdFlags.Set(options.SOURCE_DIR, "/backups")
+			restore.CopyCoordinatorFilesFromSource(testFPInfo)
+
+			Expect(testExecutor.LocalCommands).To(Equal([]string{"mkdir -p /data/gpseg-1/backups/20170101/20170101010101 && " +
+				"rsync -e ssh -a --out-format=%n sourcehost:/backups/srcseg-1/backups/20170101/20170101010101/ /data/gpseg-1/backups/20170101/20170101010101/"}))
+		})
+		It("copies the coordinator directory of a backup taken with --metadata-dir on the source cluster", func() {
+			historyDir, err := ioutil.TempDir("", "source_history")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(historyDir)
+			historyDBPath := historyDir + "/gpbackup_history.db"
+			historyDB, err := history.InitializeHistoryDatabase(historyDBPath)
+			Expect(err).ToNot(HaveOccurred())
+			err = history.StoreBackupHistory(historyDB, &history.BackupConfig{Timestamp: "20170101010101", Status: history.BackupStatusSucceed, MetadataDir: "/metadata"})
+			Expect(err).ToNot(HaveOccurred())
+			_ = historyDB.Close()
+			restore.SetSourceHistoryDatabasePath(historyDBPath)
+
+			restore.CopyCoordinatorFilesFromSource(testFPInfo)
+
+			Expect(testExecutor.LocalCommands).To(Equal([]string{"mkdir -p /data/gpseg-1/backups/20170101/20170101010101 && " +
+				"rsync -e ssh -a --out-format=%n sourcehost:/metadata/srcseg-1/backups/20170101/20170101010101/ /data/gpseg-1/backups/20170101/20170101010101/"}))
+		})
+		It("removes the files that it copied once the restore is done", func() {
+			testExecutor.LocalOutput = "./\ngpbackup_20170101010101_config.yaml\ngpbackup_20170101010101_metadata.sql\n"
+			restore.CopyCoordinatorFilesFromSource(testFPInfo)
+			restore.RemoveFilesCopiedFromSource()
+
+			Expect(testExecutor.LocalCommands).To(HaveLen(2))
+			Expect(testExecutor.LocalCommands[1]).To(Equal("rm -f /data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_config.yaml " +
+				"/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_metadata.sql"))
+
+			restore.RemoveFilesCopiedFromSource()
+			Expect(testExecutor.LocalCommands).To(HaveLen(2))
+		})
+		Context("copying the history database of the source cluster", func() {
+			var tempDir string
+			BeforeEach(func() {
+				var err error
+				tempDir, err = ioutil.TempDir("", "source_history_copy")
+				Expect(err).ToNot(HaveOccurred())
+				operating.System.TempFile = func(dir, pattern string) (*os.File, error) {
+					return ioutil.TempFile(tempDir, pattern)
+				}
+			})
+			AfterEach(func() {
+				operating.System.TempFile = ioutil.TempFile
+				_ = os.RemoveAll(tempDir)
+			})
+			It("copies it to a new temporary file to be removed with the other copied files", func() {
+				restore.CopyHistoryDatabaseFromSource()
+				restore.RemoveFilesCopiedFromSource()
+
+				Expect(testExecutor.LocalCommands).To(HaveLen(2))
+				historyCopy := regexp.MustCompile(`^rsync -e ssh sourcehost:/source/srcseg-1/gpbackup_history.db (\S+)$`).FindStringSubmatch(testExecutor.LocalCommands[0])
+				Expect(historyCopy).To(HaveLen(2))
+				Expect(historyCopy[1]).To(HavePrefix(tempDir + "/gprestore_source_history"))
+				info, err := os.Stat(historyCopy[1])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+				Expect(testExecutor.LocalCommands[1]).To(Equal("rm -f " + historyCopy[1]))
+			})
+			It("leaves nothing to remove when the source cluster has no history database", func() {
+				testExecutor.LocalError = errors.New("rsync: change_dir failed: No such file or directory")
+				restore.CopyHistoryDatabaseFromSource()
+				testExecutor.LocalError = nil
+				restore.RemoveFilesCopiedFromSource()
+
+				Expect(testExecutor.LocalCommands).To(HaveLen(1))
+				remaining, err := ioutil.ReadDir(tempDir)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(remaining).To(BeEmpty())
+			})
+		})
+	})
 })
@@ -8,6 +8,7 @@ import (
 	"runtime/debug"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
@@ -40,11 +41,32 @@ func DoValidation(cmd *cobra.Command) {
 	ValidateFlagCombinations(cmd.Flags())
 	err := utils.ValidateFullPath(MustGetFlagString(options.BACKUP_DIR))
 	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.DATA_DIR))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.METADATA_DIR))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.METADATA_PLUGIN_CONFIG))
+	gplog.FatalOnError(err)
 	err = utils.ValidateFullPath(MustGetFlagString(options.PLUGIN_CONFIG))
 	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.SOURCE_DIR))
+	gplog.FatalOnError(err)
 	if !filepath.IsValidTimestamp(MustGetFlagString(options.TIMESTAMP)) {
 		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", MustGetFlagString(options.TIMESTAMP)), "")
 	}
+	if MustGetFlagInt(options.FOLLOW_INTERVAL) < 1 {
+		gplog.Fatal(errors.Errorf("--follow-interval %d is invalid. Must be at least 1", MustGetFlagInt(options.FOLLOW_INTERVAL)), "")
+	}
+	err = utils.ValidateFQNs(MustGetFlagStringArray(options.PRIORITIZE_TABLE))
+	gplog.FatalOnError(err)
+	_, err = utils.ParseBandwidth(MustGetFlagString(options.MAX_BANDWIDTH))
+	gplog.FatalOnError(err)
+	_, err = utils.ParseStallTimeout(MustGetFlagString(options.STALL_TIMEOUT))
+	gplog.FatalOnError(err)
+	err = filepath.ValidateLayoutTemplate(MustGetFlagString(options.LAYOUT_TEMPLATE))
+	gplog.FatalOnError(err)
+	_, err = utils.GetSessionGUCProfile(MustGetFlagString(options.SESSION_GUC_FILE), MustGetFlagStringArray(options.SESSION_GUC))
+	gplog.FatalOnError(err)
 }
 
 // This function handles setup that must be done after parsing flags.
@@ -52,7 +74,9 @@ func DoSetup() {
 	SetLoggerVerbosity()
 	gplog.Verbose("Restore Command: %s", os.Args)
 
-	utils.CheckGpexpandRunning(utils.RestorePreventedByGpexpandMessage)
+	if !IsPostgresTarget() {
+		utils.CheckGpexpandRunning(utils.RestorePreventedByGpexpandMessage)
+	}
 	restoreStartTime = history.CurrentTimestamp()
 	backupTimestamp := MustGetFlagString(options.TIMESTAMP)
 	gplog.Info("Restore Key = %s", backupTimestamp)
@@ -63,6 +87,8 @@ func DoSetup() {
 	var err error
 	opts, err = options.NewOptions(cmdFlags)
 	gplog.FatalOnError(err)
+	sessionGUCProfile, err = utils.GetSessionGUCProfile(MustGetFlagString(options.SESSION_GUC_FILE), MustGetFlagStringArray(options.SESSION_GUC))
+	gplog.FatalOnError(err)
 
 	err = opts.QuoteIncludeRelations(connectionPool)
 	gplog.FatalOnError(err)
@@ -70,26 +96,78 @@ func DoSetup() {
 	err = opts.QuoteExcludeRelations(connectionPool)
 	gplog.FatalOnError(err)
 
-	segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
-	globalCluster = cluster.NewCluster(segConfig)
-	segPrefix, err = filepath.ParseSegPrefix(MustGetFlagString(options.BACKUP_DIR))
-	gplog.FatalOnError(err)
-	globalFPInfo = filepath.NewFilePathInfo(globalCluster, MustGetFlagString(options.BACKUP_DIR), backupTimestamp, segPrefix)
+	if IsPostgresTarget() {
+		globalCluster = NewPostgresCluster(connectionPool)
+	} else {
+		segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
+		globalCluster = cluster.NewCluster(segConfig)
+	}
+	// Segment data is read from --data-dir as it would be from --backup-dir
+	if dataDir := MustGetFlagString(options.DATA_DIR); dataDir != "" {
+		_ = cmdFlags.Set(options.BACKUP_DIR, dataDir)
+	}
+	globalFPInfo = filepath.NewFilePathInfo(globalCluster, MustGetFlagString(options.BACKUP_DIR), backupTimestamp, "")
+	globalFPInfo.UserSpecifiedMetadataDir = MustGetFlagString(options.METADATA_DIR)
+	if MustGetFlagString(options.SOURCE_HOST) != "" {
+		InitializeSourceCluster()
+	}
+	layoutTemplate, databaseName, segmentHosts, segmentDbIDs := FindLayoutTemplate(backupTimestamp)
+	if layoutTemplate != "" {
+		globalFPInfo.SetLayoutTemplate(layoutTemplate, databaseName)
+		globalFPInfo.SetLayoutSegments(segmentHosts, segmentDbIDs)
+	} else if sourceCluster != nil {
+		// The coordinator files are copied from the source cluster laid out as they are there
+		globalFPInfo.UserSpecifiedSegPrefix = sourceSegPrefix
+	} else {
+		segPrefix, err = filepath.ParseSegPrefix(getSegPrefixDir())
+		gplog.FatalOnError(err)
+		globalFPInfo.UserSpecifiedSegPrefix = segPrefix
+	}
 
-	// Get restore metadata from plugin
+	// Get restore metadata from plugin or from the source cluster
+	if MustGetFlagString(options.METADATA_PLUGIN_CONFIG) != "" {
+		SetUpMetadataPlugin()
+	}
 	if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
 		RecoverMetadataFilesUsingPlugin()
+	} else if sourceCluster != nil {
+		RecoverMetadataFilesFromSource()
+	} else if metadataPluginConfig != nil {
+		RecoverCoordinatorFiles(metadataPluginConfig)
 	} else {
 		InitializeBackupConfig()
 	}
+	// A backup that is not in the history database has its segments recorded in its config file
+	if globalFPInfo.LayoutTemplate != "" && globalFPInfo.LayoutHostMap == nil {
+		globalFPInfo.SetLayoutSegments(backupConfig.SegmentHosts, backupConfig.SegmentDbIDs)
+	}
 
-	ValidateSafeToResizeCluster()
+	if IsPostgresTarget() {
+		SetPostgresSourceSegmentCount()
+	} else {
+		ValidateSafeToResizeCluster()
+	}
 
 	gplog.Info("gpbackup version = %s", backupConfig.BackupVersion)
 	gplog.Info("gprestore version = %s", GetVersion())
-	gplog.Info("Greenplum Database Version = %s", connectionPool.Version.VersionString)
+	if IsPostgresTarget() {
+		gplog.Info("Target Database Version = %s", connectionPool.Version.VersionString)
+	} else {
+		gplog.Info("Greenplum Database Version = %s", connectionPool.Version.VersionString)
+	}
 
 	BackupConfigurationValidation()
+	if backupConfig.StoppedEarly() {
+		gplog.Warn("Backup %s was stopped before the data of all of its tables was backed up, so tables listed as skipped in its TOC file will be restored without data", backupTimestamp)
+	}
+	if backupConfig.Sample != "" {
+		gplog.Warn("Backup %s was taken with --%s, so it contains a sample of %s", backupTimestamp, options.SAMPLE, backupConfig.Sample)
+	} else if backupConfig.Filtered {
+		gplog.Warn("Backup %s was taken with --%s, --%s, or --%s, so it may contain only some of the rows of its tables, or masked values", backupTimestamp, options.ROW_FILTER_FILE, options.MASK_FILE, options.SAMPLE)
+	}
+	if backupConfig.SingleDataFile && len(MustGetFlagStringArray(options.PRIORITIZE_TABLE)) > 0 {
+		gplog.Warn("--prioritize-table is ignored for backups taken with --single-data-file, as their data is restored in oid order")
+	}
 	metadataFilename := globalFPInfo.GetMetadataFilePath()
 	if !backupConfig.DataOnly {
 		gplog.Verbose("Metadata will be restored from %s", metadataFilename)
@@ -141,8 +219,14 @@ func DoRestore() {
 	isMetadataOnly := backupConfig.MetadataOnly || MustGetFlagBool(options.METADATA_ONLY)
 	isIncremental := MustGetFlagBool(options.INCREMENTAL)
 
+	if MustGetFlagBool(options.FOLLOW) && isBackupAlreadyApplied() {
+		gplog.Info("Backup %s was already applied to the restore database, so only newer incremental backups will be applied", globalFPInfo.Timestamp)
+		return
+	}
+
+	var createdRelationFQNs []string
 	if isIncremental {
-		verifyIncrementalState()
+		createdRelationFQNs = syncIncrementalState(metadataFilename)
 	}
 
 	if !isDataOnly && !isIncremental {
@@ -156,14 +240,17 @@ func DoRestore() {
 
 	totalTablesRestored := 0
 	if !isMetadataOnly {
-		if MustGetFlagString(options.PLUGIN_CONFIG) == "" {
+		// Backup files on a source cluster are checked by the helpers as they stream them
+		if MustGetFlagString(options.PLUGIN_CONFIG) == "" && !IsPostgresTarget() && sourceCluster == nil {
 			VerifyBackupFileCountOnSegments()
 		}
 		totalTablesRestored, filteredDataEntries = restoreData()
 	}
 
 	if !isDataOnly && !isIncremental {
-		restorePostdata(metadataFilename)
+		restorePostdata(metadataFilename, filteredDataEntries)
+	} else if isIncremental {
+		restoreIncrementalPostdata(metadataFilename, createdRelationFQNs)
 	}
 
 	if MustGetFlagBool(options.WITH_STATS) && backupConfig.WithStatistics {
@@ -213,65 +300,6 @@ func restoreGlobal(metadataFilename string) {
 	}
 }
 
-func verifyIncrementalState() {
-	lastRestorePlanEntry := backupConfig.RestorePlan[len(backupConfig.RestorePlan)-1]
-	tableFQNsToRestore := lastRestorePlanEntry.TableFQNs
-
-	existingSchemas, err := GetExistingSchemas()
-	gplog.FatalOnError(err)
-	existingTableFQNs, err := GetExistingTableFQNs()
-	gplog.FatalOnError(err)
-
-	existingSchemasMap := make(map[string]Empty)
-	for _, schema := range existingSchemas {
-		existingSchemasMap[schema] = Empty{}
-	}
-	existingTablesMap := make(map[string]Empty)
-	for _, table := range existingTableFQNs {
-		existingTablesMap[table] = Empty{}
-	}
-
-	var schemasToCreate []string
-	var tableFQNsToCreate []string
-	var schemasExcludedByUserInput []string
-	var tablesExcludedByUserInput []string
-	for _, table := range tableFQNsToRestore {
-		schemaName := strings.Split(table, ".")[0]
-		if utils.SchemaIsExcludedByUser(opts.IncludedSchemas, opts.ExcludedSchemas, schemaName) {
-			if !utils.Exists(schemasExcludedByUserInput, schemaName) {
-				schemasExcludedByUserInput = append(schemasExcludedByUserInput, schemaName)
-			}
-			tablesExcludedByUserInput = append(tablesExcludedByUserInput, table)
-			continue
-		}
-
-		if _, exists := existingTablesMap[table]; !exists {
-			if utils.RelationIsExcludedByUser(opts.IncludedRelations, opts.ExcludedRelations, table) {
-				tablesExcludedByUserInput = append(tablesExcludedByUserInput, table)
-			} else {
-				_, schemaExists := existingSchemasMap[schemaName]
-				preFilteredToCreate := utils.Exists(schemasToCreate, schemaName)
-				if !schemaExists && !preFilteredToCreate {
-					schemasToCreate = append(schemasToCreate, schemaName)
-				}
-				tableFQNsToCreate = append(tableFQNsToCreate, table)
-			}
-		}
-	}
-
-	var missing []string
-	if len(schemasToCreate) > 0 && !MustGetFlagBool(options.ON_ERROR_CONTINUE) {
-		missing = schemasToCreate
-	}
-	if len(tableFQNsToCreate) > 0 && !MustGetFlagBool(options.ON_ERROR_CONTINUE) {
-		missing = append(missing, tableFQNsToCreate...)
-	}
-	if missing != nil {
-		err = errors.Errorf("Following objects are missing from the target database: %v", missing)
-		gplog.FatalOnError(err)
-	}
-}
-
 func restorePredata(metadataFilename string) {
 	if wasTerminated {
 		return
@@ -290,7 +318,12 @@ func restorePredata(metadataFilename string) {
 	progressBar.Start()
 
 	RestoreSchemas(schemaStatements, progressBar)
-	numErrors := ExecuteRestoreMetadataStatements(statements, "Pre-data objects", progressBar, utils.PB_VERBOSE, false)
+	var numErrors int32
+	if connectionPool.NumConns > 1 {
+		numErrors = ExecuteStatementsWithDependencies(statements, globalTOC.PredataDependencies, progressBar)
+	} else {
+		numErrors = ExecuteRestoreMetadataStatements(statements, "Pre-data objects", progressBar, utils.PB_VERBOSE, false)
+	}
 
 	progressBar.Finish()
 	if wasTerminated {
@@ -397,17 +430,25 @@ func restoreData() (int, map[string][]toc.CoordinatorDataEntry) {
 		filteredDataEntries[entry.Timestamp] = filteredDataEntriesForTimestamp
 		totalTables += len(filteredDataEntriesForTimestamp)
 	}
+	if maxBandwidth := getMaxBandwidth(); maxBandwidth > 0 {
+		stopBandwidthControl := utils.StartBandwidthControl(globalCluster, globalFPInfo, GetBackupFPInfoListFromRestorePlan(), maxBandwidth, 5*time.Second)
+		defer stopBandwidthControl()
+	}
 	dataProgressBar := utils.NewProgressBar(totalTables, "Tables restored: ", utils.PB_INFO)
 	dataProgressBar.Start()
 
 	gucStatements := setGUCsForConnection(nil, 0)
 	numErrors := int32(0)
+	timer := utils.NewParallelTimer(connectionPool.NumConns)
+	dataStartTime := time.Now()
 	for timestamp, entries := range filteredDataEntries {
 		gplog.Verbose("Restoring data for %d tables from backup with timestamp: %s", len(entries), timestamp)
-		numErrors = restoreDataFromTimestamp(GetBackupFPInfoForTimestamp(timestamp), entries, gucStatements, dataProgressBar)
+		numErrors = restoreDataFromTimestamp(GetBackupFPInfoForTimestamp(timestamp), entries, gucStatements, dataProgressBar, timer)
 	}
 
 	dataProgressBar.Finish()
+	dataParallelEfficiency = timer.EfficiencyString(time.Since(dataStartTime))
+	gplog.Info("Data restore parallel efficiency: %s", dataParallelEfficiency)
 	if wasTerminated {
 		gplog.Info("Data restore incomplete")
 	} else if numErrors > 0 {
@@ -419,7 +460,7 @@ func restoreData() (int, map[string][]toc.CoordinatorDataEntry) {
 	return totalTables, filteredDataEntries
 }
 
-func restorePostdata(metadataFilename string) {
+func restorePostdata(metadataFilename string, filteredDataEntries map[string][]toc.CoordinatorDataEntry) {
 	if wasTerminated {
 		return
 	}
@@ -429,13 +470,26 @@ func restorePostdata(metadataFilename string) {
 
 	statements := GetRestoreMetadataStatementsFiltered("postdata", metadataFilename, []string{}, []string{}, filters)
 	editStatementsRedirectSchema(statements, opts.RedirectSchema)
-	firstBatch, secondBatch, thirdBatch := BatchPostdataStatements(statements)
 	progressBar := utils.NewProgressBar(len(statements), "Post-data objects restored: ", utils.PB_VERBOSE)
 	progressBar.Start()
 
-	numErrors := ExecuteRestoreMetadataStatements(firstBatch, "", progressBar, utils.PB_VERBOSE, connectionPool.NumConns > 1)
-	numErrors += ExecuteRestoreMetadataStatements(secondBatch, "", progressBar, utils.PB_VERBOSE, connectionPool.NumConns > 1)
-	numErrors += ExecuteRestoreMetadataStatements(thirdBatch, "", progressBar, utils.PB_VERBOSE, connectionPool.NumConns > 1)
+	var numErrors int32
+	if connectionPool.NumConns > 1 {
+		indexStatements, otherStatements, metadataStatements := SplitPostdataStatements(statements)
+		// Without a data restore, the row counts recorded at backup time still tell which tables are largest
+		if filteredDataEntries == nil && globalTOC != nil {
+			filteredDataEntries = map[string][]toc.CoordinatorDataEntry{globalFPInfo.Timestamp: globalTOC.DataEntries}
+		}
+		tableRows := GetRowsCopiedByTable(filteredDataEntries, opts.RedirectSchema)
+		numErrors = ExecuteIndexStatements(indexStatements, tableRows, MustGetFlagInt(options.INDEX_JOBS_PER_TABLE), progressBar)
+		numErrors += ExecuteRestoreMetadataStatements(otherStatements, "", progressBar, utils.PB_VERBOSE, true)
+		numErrors += ExecuteRestoreMetadataStatements(metadataStatements, "", progressBar, utils.PB_VERBOSE, true)
+	} else {
+		firstBatch, secondBatch, thirdBatch := BatchPostdataStatements(statements)
+		numErrors = ExecuteRestoreMetadataStatements(firstBatch, "", progressBar, utils.PB_VERBOSE, false)
+		numErrors += ExecuteRestoreMetadataStatements(secondBatch, "", progressBar, utils.PB_VERBOSE, false)
+		numErrors += ExecuteRestoreMetadataStatements(thirdBatch, "", progressBar, utils.PB_VERBOSE, false)
+	}
 	progressBar.Finish()
 
 	if wasTerminated {
@@ -580,8 +634,11 @@ func DoTeardown() {
 	if errStr != "" {
 		fmt.Println(errStr)
 	}
-	errMsg := report.ParseErrorMessage(errStr)
+	writeRestoreReport(report.ParseErrorMessage(errStr), restoreFailed)
+}
 
+// Writes and emails the report of the restore and the tables it failed to restore
+func writeRestoreReport(errMsg string, restoreFailed bool) {
 	if globalFPInfo.Timestamp != "" {
 		_, statErr := os.Stat(globalFPInfo.GetDirForContent(-1))
 		if statErr != nil { // Even if this isn't os.IsNotExist, don't try to write a report file in case of further errors
@@ -589,12 +646,20 @@ func DoTeardown() {
 		}
 		reportFilename := globalFPInfo.GetRestoreReportFilePath(restoreStartTime)
 		origSize, destSize, _ := GetResizeClusterInfo()
-		report.WriteRestoreReportFile(reportFilename, globalFPInfo.Timestamp, restoreStartTime, connectionPool, version, origSize, destSize, errMsg)
+		if restoreFailed {
+			collectHelperErrors()
+		}
+		report.WriteRestoreReportFile(reportFilename, globalFPInfo.Timestamp, restoreStartTime, connectionPool, version, origSize, destSize, dataParallelEfficiency, sessionGUCProfile.String(), utils.GetAgentErrors(), errMsg)
 		report.EmailReport(globalCluster, globalFPInfo.Timestamp, reportFilename, "gprestore", !restoreFailed)
 		if pluginConfig != nil {
 			pluginConfig.CleanupPluginForRestore(globalCluster, globalFPInfo)
 			pluginConfig.DeletePluginConfigWhenEncrypting(globalCluster)
 		}
+		if metadataPluginConfig != nil {
+			coordinatorCluster := utils.CoordinatorCluster(globalCluster)
+			metadataPluginConfig.CleanupPluginForRestore(coordinatorCluster, globalFPInfo)
+			metadataPluginConfig.DeletePluginConfigWhenEncrypting(coordinatorCluster)
+		}
 		if len(errorTablesMetadata) > 0 {
 			// tables with metadata errors
 			writeErrorTables(true)
@@ -649,23 +714,41 @@ func DoCleanup(restoreFailed bool) {
 	}()
 
 	gplog.Verbose("Beginning cleanup")
-	if backupConfig != nil && backupConfig.SingleDataFile {
+	// Copy sessions must be terminated before cleaning up gpbackup_helper processes to avoid a potential deadlock
+	// If the terminate query is sent via a connection with an active COPY command, and the COPY's pipe is cleaned up, the COPY query will hang.
+	// This results in the DoCleanup function passed to the signal handler to never return, blocking the os.Exit call
+	if wasTerminated && connectionPool != nil { // These should all end on their own in a successful restore
+		utils.TerminateCopySessions(connectionPool)
+	}
+	cleanUpHelpers(restoreFailed)
+	utils.StopHelperControlServer()
+	RemoveFilesCopiedFromSource()
+
+	if connectionPool != nil {
+		connectionPool.Close()
+	}
+}
+
+// Collects errors of agents that failed after the last check, so they are in the report
+func collectHelperErrors() {
+	if backupConfig == nil || !backupConfig.SingleDataFile || IsPostgresTarget() || globalCluster == nil {
+		return
+	}
+	for _, fpInfo := range GetBackupFPInfoListFromRestorePlan() {
+		_ = utils.CheckAgentErrorsOnSegments(globalCluster, fpInfo)
+	}
+}
+
+// Cleans up the gpbackup_helper processes and files of the restore
+func cleanUpHelpers(restoreFailed bool) {
+	// No helpers are started for a PostgreSQL target
+	if backupConfig != nil && backupConfig.SingleDataFile && !IsPostgresTarget() {
 		fpInfoList := GetBackupFPInfoListFromRestorePlan()
 		for _, fpInfo := range fpInfoList {
-			// Copy sessions must be terminated before cleaning up gpbackup_helper processes to avoid a potential deadlock
-			// If the terminate query is sent via a connection with an active COPY command, and the COPY's pipe is cleaned up, the COPY query will hang.
-			// This results in the DoCleanup function passed to the signal handler to never return, blocking the os.Exit call
-			if wasTerminated { // These should all end on their own in a successful restore
-				utils.TerminateHangingCopySessions(connectionPool, fpInfo, fmt.Sprintf("gprestore_%s_%s", fpInfo.Timestamp, restoreStartTime))
-			}
 			if restoreFailed {
 				utils.CleanUpSegmentHelperProcesses(globalCluster, fpInfo, "restore")
 			}
 			utils.CleanUpHelperFilesOnAllHosts(globalCluster, fpInfo)
 		}
 	}
-
-	if connectionPool != nil {
-		connectionPool.Close()
-	}
 }
@@ -55,4 +55,93 @@ var _ = Describe("restore internal tests", func() {
 			Expect(statements).To(Equal(expectedStatements))
 		})
 	})
+	Describe("groupStatementsByDependency", func() {
+		funcA := toc.StatementWithType{Name: "funca", ObjectType: "FUNCTION", Statement: "CREATE FUNCTION funca", ObjectID: toc.UniqueID{ClassID: 1255, Oid: 1}}
+		funcAMetadata := toc.StatementWithType{Name: "funca", ObjectType: "FUNCTION", Statement: "COMMENT ON FUNCTION funca", ObjectID: toc.UniqueID{ClassID: 1255, Oid: 1}}
+		funcB := toc.StatementWithType{Name: "funcb", ObjectType: "FUNCTION", Statement: "CREATE FUNCTION funcb", ObjectID: toc.UniqueID{ClassID: 1255, Oid: 2}}
+		tableC := toc.StatementWithType{Name: "tablec", ObjectType: "TABLE", Statement: "CREATE TABLE tablec", ObjectID: toc.UniqueID{ClassID: 1259, Oid: 3}}
+		sequence := toc.StatementWithType{Name: "seq", ObjectType: "SEQUENCE", Statement: "CREATE SEQUENCE seq"}
+		conversion := toc.StatementWithType{Name: "conv", ObjectType: "CONVERSION", Statement: "CREATE CONVERSION conv"}
+
+		It("groups consecutive statements for the same object", func() {
+			groups := groupStatementsByDependency([]toc.StatementWithType{funcA, funcAMetadata, funcB}, nil)
+
+			Expect(groups).To(HaveLen(2))
+			Expect(groups[0].statements).To(Equal([]toc.StatementWithType{funcA, funcAMetadata}))
+			Expect(groups[1].statements).To(Equal([]toc.StatementWithType{funcB}))
+			Expect(groups[0].numDeps).To(Equal(0))
+			Expect(groups[1].numDeps).To(Equal(0))
+		})
+		It("makes a group wait for the groups of the objects it depends on", func() {
+			dependencies := []toc.DependencyEntry{{Object: tableC.ObjectID, DependsOn: []toc.UniqueID{funcB.ObjectID}}}
+			groups := groupStatementsByDependency([]toc.StatementWithType{funcA, funcB, tableC}, dependencies)
+
+			Expect(groups).To(HaveLen(3))
+			Expect(groups[0].dependents).To(BeEmpty())
+			Expect(groups[1].dependents).To(Equal([]int{2}))
+			Expect(groups[2].numDeps).To(Equal(1))
+		})
+		It("ignores dependencies on objects that are not being restored", func() {
+			dependencies := []toc.DependencyEntry{{Object: tableC.ObjectID, DependsOn: []toc.UniqueID{{ClassID: 1247, Oid: 99}}}}
+			groups := groupStatementsByDependency([]toc.StatementWithType{tableC}, dependencies)
+
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].numDeps).To(Equal(0))
+		})
+		It("runs statements without an object ID as barriers", func() {
+			groups := groupStatementsByDependency([]toc.StatementWithType{sequence, funcA, funcB, conversion}, nil)
+
+			Expect(groups).To(HaveLen(4))
+			Expect(groups[0].isBarrier).To(BeTrue())
+			Expect(groups[0].dependents).To(ConsistOf(1, 2))
+			Expect(groups[1].numDeps).To(Equal(1))
+			Expect(groups[2].numDeps).To(Equal(1))
+			Expect(groups[3].isBarrier).To(BeTrue())
+			Expect(groups[3].numDeps).To(Equal(2))
+		})
+		It("chains statements serially when no object IDs are present", func() {
+			groups := groupStatementsByDependency([]toc.StatementWithType{sequence, conversion}, nil)
+
+			Expect(groups).To(HaveLen(2))
+			Expect(groups[0].dependents).To(Equal([]int{1}))
+			Expect(groups[1].numDeps).To(Equal(1))
+		})
+	})
+	Describe("groupIndexStatementsByTable", func() {
+		smallIndex := toc.StatementWithType{ObjectType: "INDEX", ReferenceObject: "public.small", Statement: "CREATE INDEX small_idx ON public.small(i);"}
+		bigIndex1 := toc.StatementWithType{ObjectType: "INDEX", ReferenceObject: "public.big", Statement: "CREATE INDEX big_idx1 ON public.big(i);"}
+		bigIndex2 := toc.StatementWithType{ObjectType: "INDEX", ReferenceObject: "public.big", Statement: "CREATE INDEX big_idx2 ON public.big(j);"}
+		unknownIndex := toc.StatementWithType{ObjectType: "INDEX", ReferenceObject: "public.unknown", Statement: "CREATE INDEX unknown_idx ON public.unknown(i);"}
+		tableRows := map[string]int64{"public.small": 10, "public.big": 1000}
+
+		It("orders tables from largest to smallest", func() {
+			queues := groupIndexStatementsByTable([]toc.StatementWithType{smallIndex, unknownIndex, bigIndex1, bigIndex2}, tableRows)
+
+			Expect(queues).To(HaveLen(3))
+			Expect(queues[0].table).To(Equal("public.big"))
+			Expect(queues[0].statements).To(Equal([]toc.StatementWithType{bigIndex1, bigIndex2}))
+			Expect(queues[1].table).To(Equal("public.small"))
+			Expect(queues[2].table).To(Equal("public.unknown"))
+		})
+		It("builds the first index on a table by itself", func() {
+			queues := groupIndexStatementsByTable([]toc.StatementWithType{bigIndex1, bigIndex2, smallIndex}, tableRows)
+			queues[0].numRunning = 1
+
+			Expect(nextIndexQueue(queues, 2).table).To(Equal("public.small"))
+		})
+		It("limits the number of concurrent builds on a table", func() {
+			queues := groupIndexStatementsByTable([]toc.StatementWithType{bigIndex1, bigIndex2, smallIndex}, tableRows)
+			queues[0].firstCompleted = true
+			queues[0].numRunning = 1
+
+			Expect(nextIndexQueue(queues, 2).table).To(Equal("public.big"))
+			Expect(nextIndexQueue(queues, 1).table).To(Equal("public.small"))
+		})
+		It("returns nil when no statement can be started", func() {
+			queues := groupIndexStatementsByTable([]toc.StatementWithType{bigIndex1}, tableRows)
+			queues[0].numRunning = 1
+
+			Expect(nextIndexQueue(queues, 4)).To(BeNil())
+		})
+	})
 })
@@ -237,6 +237,10 @@ func ValidateBackupFlagCombinations() {
 	if backupConfig.DataOnly && MustGetFlagBool(options.METADATA_ONLY) {
 		gplog.Fatal(errors.Errorf("Cannot use metadata-only flag when restoring data-only backup"), "")
 	}
+	// Only gpbackup_helper agents stream data files from the source cluster
+	if !backupConfig.SingleDataFile && !backupConfig.MetadataOnly && MustGetFlagString(options.SOURCE_HOST) != "" {
+		gplog.Fatal(errors.Errorf("The --source-host flag can only be used if the backup was taken with --single-data-file"), "")
+	}
 	if !backupConfig.SingleDataFile && FlagChanged(options.COPY_QUEUE_SIZE) {
 		gplog.Fatal(errors.Errorf("The --copy-queue-size flag can only be used if the backup was taken with --single-data-file"), "")
 	}
@@ -262,9 +266,24 @@ func ValidateFlagCombinations(flags *pflag.FlagSet) {
 
 	options.CheckExclusiveFlags(flags, options.METADATA_ONLY, options.DATA_ONLY)
 	options.CheckExclusiveFlags(flags, options.PLUGIN_CONFIG, options.BACKUP_DIR)
+	options.CheckExclusiveFlags(flags, options.PLUGIN_CONFIG, options.DATA_DIR)
+	options.CheckExclusiveFlags(flags, options.BACKUP_DIR, options.DATA_DIR)
+	options.CheckExclusiveFlags(flags, options.SOURCE_HOST, options.PLUGIN_CONFIG, options.BACKUP_DIR, options.DATA_DIR)
+	options.CheckExclusiveFlags(flags, options.METADATA_DIR, options.METADATA_PLUGIN_CONFIG)
+	// The coordinator files are copied from the coordinator of the source cluster
+	options.CheckExclusiveFlags(flags, options.SOURCE_HOST, options.METADATA_PLUGIN_CONFIG)
+	// --follow looks for new backups in the backup history database of this cluster
+	options.CheckExclusiveFlags(flags, options.SOURCE_HOST, options.FOLLOW)
+	// DoSetup runs again for each backup that --follow applies, which would recreate the database and globals
+	options.CheckExclusiveFlags(flags, options.CREATE_DB, options.FOLLOW)
+	options.CheckExclusiveFlags(flags, options.WITH_GLOBALS, options.FOLLOW)
 	options.CheckExclusiveFlags(flags, options.TRUNCATE_TABLE, options.METADATA_ONLY, options.INCREMENTAL)
 	options.CheckExclusiveFlags(flags, options.TRUNCATE_TABLE, options.REDIRECT_SCHEMA)
 
+	if flags.Changed(options.REDISTRIBUTE_ON_LOAD) && !flags.Changed(options.RESIZE_CLUSTER) {
+		gplog.Fatal(errors.Errorf("Cannot use --redistribute-on-load without --resize-cluster"), "")
+	}
+
 	if flags.Changed(options.REDIRECT_SCHEMA) {
 		// Redirect schema not compatible with any exclude flags
 		if flags.Changed(options.EXCLUDE_SCHEMA) || flags.Changed(options.EXCLUDE_SCHEMA_FILE) ||
@@ -285,21 +304,85 @@ func ValidateFlagCombinations(flags *pflag.FlagSet) {
 	if flags.Changed(options.INCREMENTAL) && !flags.Changed(options.DATA_ONLY) {
 		gplog.Fatal(errors.Errorf("Cannot use --incremental without --data-only"), "")
 	}
+	if flags.Changed(options.DROP_REMOVED_TABLES) && !flags.Changed(options.INCREMENTAL) {
+		gplog.Fatal(errors.Errorf("Cannot use --drop-removed-tables without --incremental"), "")
+	}
+	if flags.Changed(options.FOLLOW) && !flags.Changed(options.INCREMENTAL) {
+		gplog.Fatal(errors.Errorf("Cannot use --follow without --incremental"), "")
+	}
+	if flags.Changed(options.FOLLOW_INTERVAL) && !flags.Changed(options.FOLLOW) {
+		gplog.Fatal(errors.Errorf("Cannot use --follow-interval without --follow"), "")
+	}
+	if (flags.Changed(options.SOURCE_DIR) || flags.Changed(options.SOURCE_PORT)) && !flags.Changed(options.SOURCE_HOST) {
+		gplog.Fatal(errors.Errorf("Cannot use --source-dir or --source-port without --source-host"), "")
+	}
+	if flags.Changed(options.CANCEL_STALLED) && !flags.Changed(options.STALL_TIMEOUT) {
+		gplog.Fatal(errors.Errorf("Cannot use --cancel-stalled without --stall-timeout"), "")
+	}
 	options.CheckExclusiveFlags(flags, options.RUN_ANALYZE, options.WITH_STATS)
+
+	if indexJobs, _ := flags.GetInt(options.INDEX_JOBS_PER_TABLE); indexJobs < 1 {
+		gplog.Fatal(errors.Errorf("--index-jobs-per-table %d is invalid. Must be at least 1", indexJobs), "")
+	}
+
+	targetFlavor, _ := flags.GetString(options.TARGET_FLAVOR)
+	if targetFlavor != GreenplumFlavor && targetFlavor != PostgresFlavor {
+		gplog.Fatal(errors.Errorf("Target flavor %s is invalid.  Valid flavors are '%s' and '%s'.", targetFlavor, GreenplumFlavor, PostgresFlavor), "")
+	}
+	if targetFlavor == PostgresFlavor {
+		// Data is read by the PostgreSQL server from the backup directory, without helpers on segment hosts
+		if !flags.Changed(options.BACKUP_DIR) && !flags.Changed(options.DATA_DIR) {
+			gplog.Fatal(errors.Errorf("Cannot use --target-flavor postgres without --backup-dir or --data-dir"), "")
+		}
+		for _, flagName := range []string{options.RESIZE_CLUSTER, options.REDISTRIBUTE_ON_LOAD, options.WITH_STATS, options.MAX_BANDWIDTH, options.STALL_TIMEOUT} {
+			if flags.Changed(flagName) {
+				gplog.Fatal(errors.Errorf("Cannot use --%s with --target-flavor postgres", flagName), "")
+			}
+		}
+	}
+}
+
+/*
+ * Backups taken before the SegmentCount parameter was added have a SegmentCount
+ * of 0, in which case it is inferred from the segment backup files before a
+ * restore to a different-size cluster is allowed.  The inferred count is
+ * recorded in the config file and the history database, so that it only has to
+ * be inferred once.
+ */
+func InferLegacySegmentCount() {
+	if backupConfig.SegmentCount != 0 || !MustGetFlagBool(options.RESIZE_CLUSTER) {
+		return
+	}
+	timestamp := MustGetFlagString(options.TIMESTAMP)
+	if MustGetFlagString(options.SOURCE_HOST) != "" {
+		gplog.Fatal(errors.Errorf("Segment count for backup with timestamp %s is unknown and cannot be determined for a backup on another cluster, cannot restore using --resize-cluster flag.", timestamp), "")
+	}
+	origSize := 0
+	if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
+		origSize = InferSegmentCountWithPlugin()
+	} else {
+		var err error
+		origSize, err = InferSegmentCount()
+		if err != nil {
+			gplog.Fatal(err, "Segment count for backup with timestamp %s is unknown and cannot be determined, cannot restore using --resize-cluster flag", timestamp)
+		}
+	}
+	if origSize == 0 {
+		gplog.Fatal(errors.Errorf("Segment count for backup with timestamp %s is unknown and no segment backup files were found, cannot restore using --resize-cluster flag.", timestamp), "")
+	}
+	gplog.Info("Backup with timestamp %s does not record its segment count; found backup files from %d segments", timestamp, origSize)
+	backupConfig.SegmentCount = origSize
+	RecordSegmentCount()
 }
 
 func ValidateSafeToResizeCluster() {
-	// If SegmentCount is 0, the backup was taken before the SegmentCount parameter was added, in which case we won't
-	// allow a restore to a different-size cluster.  Any backups that do have a SegmentCount will have that checked
-	// when attempting a normal restore, so that the user doesn't accidentally restore a different-size backup without
-	// using the --resize-cluster flag.
+	// Any backups that do have a SegmentCount will have that checked when attempting a normal restore, so that the
+	// user doesn't accidentally restore a different-size backup without using the --resize-cluster flag.
+	InferLegacySegmentCount()
 	origSize, destSize, resizeCluster := GetResizeClusterInfo()
 
 	if resizeCluster {
-		if origSize == 0 {
-			timestamp := MustGetFlagString(options.TIMESTAMP)
-			gplog.Fatal(errors.Errorf("Segment count for backup with timestamp %s is unknown, cannot restore using --resize-cluster flag.", timestamp), "")
-		} else if origSize == destSize {
+		if origSize == destSize {
 			cmdFlags.Set(options.RESIZE_CLUSTER, "false")
 			gplog.Warn("Backup segment count matches restore segment count; the --resize-cluster flag is not needed.  Proceeding with a normal restore.")
 		} else {
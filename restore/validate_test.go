@@ -35,10 +35,10 @@ var _ = Describe("restore/validate tests", func() {
 			tocfile, backupfile = testutils.InitializeTestTOC(buffer, "predata")
 			backupfile.ByteCount = table1Len
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema1", Name: "table1", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			tocfile.AddCoordinatorDataEntry("schema1", "table1", 1, "(i)", 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema1", "table1", 1, "(i)", 0, 0, "", "")
 			backupfile.ByteCount += table2Len
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema2", Name: "table2", ObjectType: "TABLE"}, table1Len, backupfile.ByteCount)
-			tocfile.AddCoordinatorDataEntry("schema2", "table2", 2, "(j)", 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema2", "table2", 2, "(j)", 0, 0, "", "")
 			backupfile.ByteCount += sequenceLen
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema", Name: "somesequence", ObjectType: "SEQUENCE"}, table1Len+table2Len, backupfile.ByteCount)
 			restore.SetTOC(tocfile)
@@ -77,10 +77,10 @@ var _ = Describe("restore/validate tests", func() {
 		var opts *options.Options
 		BeforeEach(func() {
 			tocfile, _ = testutils.InitializeTestTOC(buffer, "metadata")
-			tocfile.AddCoordinatorDataEntry("s1", "table1", 1, "(j)", 0, "", "")
-			tocfile.AddCoordinatorDataEntry("s1", "table2", 2, "(j)", 0, "", "")
-			tocfile.AddCoordinatorDataEntry("s2", "table1", 3, "(j)", 0, "", "")
-			tocfile.AddCoordinatorDataEntry("s2", "table2", 4, "(j)", 0, "", "")
+			tocfile.AddCoordinatorDataEntry("s1", "table1", 1, "(j)", 0, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("s1", "table2", 2, "(j)", 0, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("s2", "table1", 3, "(j)", 0, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("s2", "table2", 4, "(j)", 0, 0, "", "")
 			restore.SetTOC(tocfile)
 
 			opts = &options.Options{}
@@ -198,10 +198,10 @@ var _ = Describe("restore/validate tests", func() {
 		BeforeEach(func() {
 			tocfile, backupfile = testutils.InitializeTestTOC(buffer, "predata")
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema1", Name: "table1", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			tocfile.AddCoordinatorDataEntry("schema1", "table1", 1, "(i)", 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema1", "table1", 1, "(i)", 0, 0, "", "")
 
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema2", Name: "table2", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			tocfile.AddCoordinatorDataEntry("schema2", "table2", 2, "(j)", 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema2", "table2", 2, "(j)", 0, 0, "", "")
 
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema1", Name: "somesequence", ObjectType: "SEQUENCE"}, 0, backupfile.ByteCount)
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema1", Name: "someview", ObjectType: "VIEW"}, 0, backupfile.ByteCount)
@@ -316,6 +316,29 @@ var _ = Describe("restore/validate tests", func() {
 				}
 			},
 			Entry("--backup-dir combo", "--backup-dir /tmp --plugin-config /tmp/config", false),
+			Entry("--data-dir with --backup-dir", "--data-dir /tmp --backup-dir /tmp", false),
+			Entry("--data-dir with --plugin-config", "--data-dir /tmp --plugin-config /tmp/config", false),
+			Entry("--metadata-dir with --backup-dir", "--metadata-dir /tmp/metadata --backup-dir /tmp/data", true),
+			Entry("--metadata-dir with --data-dir", "--metadata-dir /tmp/metadata --data-dir /tmp/data", true),
+			Entry("--metadata-dir with --plugin-config", "--metadata-dir /tmp --plugin-config /tmp/config", true),
+			Entry("--metadata-plugin-config with --backup-dir", "--metadata-plugin-config /tmp/metadata_config --backup-dir /tmp", true),
+			Entry("--metadata-plugin-config with --metadata-dir", "--metadata-plugin-config /tmp/metadata_config --metadata-dir /tmp", false),
+			Entry("--metadata-plugin-config with --source-host", "--metadata-plugin-config /tmp/metadata_config --source-host sourcehost", false),
+			Entry("--source-host", "--source-host sourcehost", true),
+			Entry("--source-host with --source-dir and --source-port", "--source-host sourcehost --source-dir /tmp --source-port 6000", true),
+			Entry("--source-dir without --source-host", "--source-dir /tmp", false),
+			Entry("--source-host with --backup-dir", "--source-host sourcehost --backup-dir /tmp", false),
+			Entry("--source-host with --plugin-config", "--source-host sourcehost --plugin-config /tmp/config", false),
+			Entry("--source-host with --data-dir", "--source-host sourcehost --data-dir /tmp", false),
+			Entry("--source-host with --follow", "--source-host sourcehost --incremental --data-only --follow", false),
+			Entry("--target-flavor postgres with --backup-dir", "--target-flavor postgres --backup-dir /tmp", true),
+			Entry("--target-flavor postgres with --data-dir", "--target-flavor postgres --data-dir /tmp", true),
+			Entry("--target-flavor postgres without --backup-dir", "--target-flavor postgres", false),
+			Entry("--target-flavor postgres with --resize-cluster", "--target-flavor postgres --backup-dir /tmp --resize-cluster", false),
+			Entry("--target-flavor postgres with --with-stats", "--target-flavor postgres --backup-dir /tmp --with-stats", false),
+			Entry("--target-flavor invalid", "--target-flavor oracle", false),
+			Entry("--index-jobs-per-table 2", "--index-jobs-per-table 2", true),
+			Entry("--index-jobs-per-table 0", "--index-jobs-per-table 0", false),
 
 			/*
 			 * Below are all the different filter combinations
@@ -371,6 +394,19 @@ var _ = Describe("restore/validate tests", func() {
 			 */
 			Entry("incremental combos", "--incremental", false),
 			Entry("incremental combos", "--incremental --data-only", true),
+			Entry("incremental combos", "--drop-removed-tables --data-only", false),
+			Entry("incremental combos", "--incremental --data-only --drop-removed-tables", true),
+			Entry("incremental combos", "--follow --data-only", false),
+			Entry("incremental combos", "--incremental --data-only --follow-interval 10", false),
+			Entry("incremental combos", "--incremental --data-only --follow --follow-interval 10", true),
+			Entry("incremental combos", "--incremental --data-only --follow --create-db", false),
+			Entry("incremental combos", "--incremental --data-only --follow --with-globals", false),
+
+			/*
+			 * Below are various different stall detection combinations
+			 */
+			Entry("stall combos", "--cancel-stalled", false),
+			Entry("stall combos", "--stall-timeout 10m --cancel-stalled", true),
 
 			/*
 			 * Below are various different truncate combinations
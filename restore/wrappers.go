@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
@@ -16,6 +17,7 @@ import (
 	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 )
 
 /*
@@ -64,11 +66,15 @@ func SetLoggerVerbosity() {
 
 func CreateConnectionPool(unquotedDBName string) {
 	connectionPool = dbconn.NewDBConnFromEnvironment(unquotedDBName)
+	numConns := MustGetFlagInt(options.JOBS)
 	if FlagChanged(options.COPY_QUEUE_SIZE) {
-		connectionPool.MustConnect(MustGetFlagInt(options.COPY_QUEUE_SIZE))
-	} else {
-		connectionPool.MustConnect(MustGetFlagInt(options.JOBS))
+		numConns = MustGetFlagInt(options.COPY_QUEUE_SIZE)
+	}
+	if IsPostgresTarget() {
+		MustConnectToPostgres(connectionPool, numConns)
+		return
 	}
+	connectionPool.MustConnect(numConns)
 	utils.ValidateGPDBVersionCompatibility(connectionPool)
 }
 
@@ -76,6 +82,24 @@ func InitializeConnectionPool(backupTimestamp string, restoreTimestamp string, u
 	CreateConnectionPool(unquotedDBName)
 	resizeRestore := MustGetFlagBool(options.RESIZE_CLUSTER)
 	setupQuery := fmt.Sprintf("SET application_name TO 'gprestore_%s_%s';", backupTimestamp, restoreTimestamp)
+	if IsPostgresTarget() {
+		setupQuery += `
+SET search_path TO pg_catalog;
+SET statement_timeout = 0;
+SET check_function_bodies = false;
+SET client_min_messages = error;
+SET standard_conforming_strings = on;
+SET lock_timeout = 0;
+SET default_transaction_read_only = off;
+SET xmloption = content;
+`
+		setupQuery += getCommonSetupQuery()
+		for i := 0; i < connectionPool.NumConns; i++ {
+			connectionPool.MustExec(setupQuery, i)
+		}
+		trackCopyWorkers(backupTimestamp, restoreTimestamp)
+		return
+	}
 	setupQuery += `
 SET search_path TO pg_catalog;
 SET gp_default_storage_options='';
@@ -129,9 +153,32 @@ SET default_with_oids = off;
 	// during COPY FROM SEGMENT. ANALYZE should be run separately.
 	setupQuery += "SET gp_autostats_mode = 'none';\n"
 
+	setupQuery += getCommonSetupQuery()
+
 	for i := 0; i < connectionPool.NumConns; i++ {
 		connectionPool.MustExec(setupQuery, i)
 	}
+	trackCopyWorkers(backupTimestamp, restoreTimestamp)
+}
+
+// Records the backend PIDs of the workers, so that their COPY commands can be canceled
+func trackCopyWorkers(backupTimestamp string, restoreTimestamp string) {
+	err := utils.TrackCopyWorkers(connectionPool, fmt.Sprintf("gprestore_%s_%s", backupTimestamp, restoreTimestamp))
+	gplog.FatalOnError(err)
+}
+
+// Settings requested by the user, which apply to Greenplum and PostgreSQL targets alike
+func getCommonSetupQuery() string {
+	setupQuery := ""
+	if maintenanceWorkMem := MustGetFlagString(options.MAINTENANCE_WORK_MEM); maintenanceWorkMem != "" {
+		setupQuery += fmt.Sprintf("SET maintenance_work_mem = '%s';\n", utils.EscapeSingleQuotes(maintenanceWorkMem))
+	}
+
+	// User-specified settings come last so that they can override the defaults above
+	for _, statement := range sessionGUCProfile.SetStatements() {
+		setupQuery += statement + ";\n"
+	}
+	return setupQuery
 }
 
 func SetMaxCsvLineLengthQuery(connectionPool *dbconn.DBConn) string {
@@ -158,10 +205,60 @@ func InitializeBackupConfig() {
 	report.EnsureDatabaseVersionCompatibility(backupConfig.DatabaseVersion, connectionPool.Version)
 }
 
+/*
+ * Writes backupConfig back to the config file, for recording values that
+ * older versions of gpbackup did not.  The restore can continue without the
+ * rewritten file, so failures are only warnings.
+ */
+func RewriteConfigFile() {
+	configFilename := globalFPInfo.GetConfigFilePath()
+	err := operating.System.Chmod(configFilename, 0644)
+	if err != nil {
+		gplog.Warn("Unable to update config file %s: %v", configFilename, err)
+		return
+	}
+	history.WriteConfigFile(backupConfig, configFilename)
+	gplog.Verbose("Updated config file %s", configFilename)
+}
+
+/*
+ * Records a segment count that the backup did not, in the config file, in the
+ * plugin's copy of the config file for a plugin backup, and in the history
+ * database if the backup is in it.
+ */
+func RecordSegmentCount() {
+	RewriteConfigFile()
+	if plugin := metadataPlugin(); plugin != nil {
+		err := plugin.BackupFile(globalFPInfo.GetConfigFilePath())
+		if err != nil {
+			gplog.Warn("Unable to update config file with plugin: %v", err)
+		}
+	}
+
+	historyDBPath := globalFPInfo.GetBackupHistoryDatabasePath()
+	if _, err := operating.System.Stat(historyDBPath); err != nil {
+		return
+	}
+	historyDB, err := history.InitializeHistoryDatabase(historyDBPath)
+	if err != nil {
+		gplog.Warn("Unable to update segment count in history database %s: %v", historyDBPath, err)
+		return
+	}
+	defer historyDB.Close()
+	err = history.UpdateSegmentCount(globalFPInfo.Timestamp, backupConfig.SegmentCount, historyDB)
+	if err != nil {
+		gplog.Warn("Unable to update segment count in history database %s: %v", historyDBPath, err)
+	}
+}
+
 func BackupConfigurationValidation() {
 	if !backupConfig.MetadataOnly {
 		gplog.Verbose("Gathering information on backup directories")
-		VerifyBackupDirectoriesExistOnAllHosts()
+		if IsPostgresTarget() {
+			VerifyBackupDirectoriesExistLocally()
+		} else {
+			VerifyBackupDirectoriesExistOnAllHosts()
+		}
 	}
 
 	VerifyMetadataFilePaths(MustGetFlagBool(options.WITH_STATS))
@@ -210,13 +307,66 @@ func RecoverMetadataFilesUsingPlugin() {
 	pluginConfig.CopyPluginConfigToAllHosts(globalCluster)
 	pluginConfig.SetupPluginForRestore(globalCluster, globalFPInfo)
 
-	metadataFiles := []string{globalFPInfo.GetConfigFilePath(), globalFPInfo.GetMetadataFilePath(),
-		globalFPInfo.GetBackupReportFilePath()}
-	if MustGetFlagBool(options.WITH_STATS) {
-		metadataFiles = append(metadataFiles, globalFPInfo.GetStatisticsFilePath())
+	fpInfoList := RecoverCoordinatorFiles(metadataPlugin())
+
+	// Which segment TOCs to restore depends on the segment count, which older backups do not record
+	InferLegacySegmentCount()
+	if backupConfig.SingleDataFile {
+		origSize, destSize, isResizeRestore := GetResizeClusterInfo()
+		for _, fpInfo := range fpInfoList {
+			pluginConfig.RestoreSegmentTOCs(globalCluster, fpInfo, isResizeRestore, origSize, destSize)
+		}
+	}
+}
+
+/*
+ * Returns the plugin from which the coordinator files are restored: the one
+ * given with --metadata-plugin-config, or else the one given with
+ * --plugin-config unless the coordinator files are read from --metadata-dir.
+ * Returns nil if they are only read locally.
+ */
+func metadataPlugin() *utils.PluginConfig {
+	if metadataPluginConfig != nil {
+		return metadataPluginConfig
 	}
-	for _, filename := range metadataFiles {
-		pluginConfig.MustRestoreFile(filename)
+	if MustGetFlagString(options.METADATA_DIR) != "" {
+		return nil
+	}
+	return pluginConfig
+}
+
+// The plugin given with --metadata-plugin-config is run only on the coordinator, which alone reads the coordinator files
+func SetUpMetadataPlugin() {
+	var err error
+	metadataPluginConfig, err = utils.ReadPluginConfig(MustGetFlagString(options.METADATA_PLUGIN_CONFIG))
+	gplog.FatalOnError(err)
+	configFilename := path.Base(metadataPluginConfig.ConfigPath)
+	configDirname := path.Dir(metadataPluginConfig.ConfigPath)
+	metadataPluginConfig.ConfigPath = path.Join(configDirname, history.CurrentTimestamp()+"_metadata_"+configFilename)
+	gplog.Info("metadata plugin config path: %s", metadataPluginConfig.ConfigPath)
+
+	coordinatorCluster := utils.CoordinatorCluster(globalCluster)
+	metadataPluginConfig.CheckPluginExistsOnAllHosts(coordinatorCluster)
+	metadataPluginConfig.CopyPluginConfigToAllHosts(coordinatorCluster)
+	metadataPluginConfig.SetupPluginForRestore(coordinatorCluster, globalFPInfo)
+}
+
+/*
+ * Restores the config, metadata, report, and statistics files of the backup,
+ * and the TOC files of the backups in its restore plan, with the given plugin
+ * unless it is nil, then reads the config file.  Returns where the files of
+ * the backups in the restore plan are.
+ */
+func RecoverCoordinatorFiles(plugin *utils.PluginConfig) []filepath.FilePathInfo {
+	if plugin != nil {
+		metadataFiles := []string{globalFPInfo.GetConfigFilePath(), globalFPInfo.GetMetadataFilePath(),
+			globalFPInfo.GetBackupReportFilePath()}
+		if MustGetFlagBool(options.WITH_STATS) {
+			metadataFiles = append(metadataFiles, globalFPInfo.GetStatisticsFilePath())
+		}
+		for _, filename := range metadataFiles {
+			plugin.MustRestoreFile(filename)
+		}
 	}
 
 	InitializeBackupConfig()
@@ -228,13 +378,141 @@ func RecoverMetadataFilesUsingPlugin() {
 		fpInfoList = GetBackupFPInfoListFromRestorePlan()
 	}
 
-	for _, fpInfo := range fpInfoList {
-		pluginConfig.MustRestoreFile(fpInfo.GetTOCFilePath())
-		if backupConfig.SingleDataFile {
-			origSize, destSize, isResizeRestore := GetResizeClusterInfo()
-			pluginConfig.RestoreSegmentTOCs(globalCluster, fpInfo, isResizeRestore, origSize, destSize)
+	if plugin != nil {
+		for _, fpInfo := range fpInfoList {
+			plugin.MustRestoreFile(fpInfo.GetTOCFilePath())
 		}
 	}
+	return fpInfoList
+}
+
+/*
+ * Connects to the coordinator of the cluster given by --source-host to find the
+ * hosts and data directories of its segments, where the backup to restore is,
+ * and copies its backup history database, which records the backups taken there.
+ */
+func InitializeSourceCluster() {
+	sourceConn := dbconn.NewDBConn("postgres", connectionPool.User, MustGetFlagString(options.SOURCE_HOST), MustGetFlagInt(options.SOURCE_PORT))
+	sourceConn.MustConnect(1)
+	defer sourceConn.Close()
+	segConfig := cluster.MustGetSegmentConfiguration(sourceConn)
+	SetSourceCluster(cluster.NewCluster(segConfig), filepath.GetSegPrefix(sourceConn))
+	gplog.Info("Restoring from the cluster on host %s, which has %d segments", MustGetFlagString(options.SOURCE_HOST), len(segConfig)-1)
+	CopyHistoryDatabaseFromSource()
+}
+
+/*
+ * Copies the backup history database of the cluster given by --source-host to
+ * a temporary file, so that backups taken there are looked up in it rather
+ * than in the history database of this cluster.  A source cluster without a
+ * history database leaves sourceHistoryDBPath empty.
+ */
+func CopyHistoryDatabaseFromSource() {
+	sourceHost := MustGetFlagString(options.SOURCE_HOST)
+	sourceFPInfo := filepath.NewFilePathInfo(sourceCluster, "", "", sourceSegPrefix)
+	sourcePath := sourceFPInfo.GetBackupHistoryDatabasePath()
+	// A new file readable only by this user, whose name cannot be guessed in advance
+	localFile, err := operating.System.TempFile("", "gprestore_source_history")
+	gplog.FatalOnError(err, "Cannot create temporary file for the backup history database of the source cluster")
+	localPath := localFile.Name()
+	_ = localFile.Close()
+	// Without -a, rsync keeps the permissions of the existing file
+	_, err = globalCluster.ExecuteLocalCommand(fmt.Sprintf("rsync -e ssh %s:%s %s", sourceHost, sourcePath, localPath))
+	if err != nil {
+		gplog.Verbose("Unable to copy backup history database %s from host %s, so backups are looked up in their config files: %v", sourcePath, sourceHost, err)
+		_ = operating.System.Remove(localPath)
+		return
+	}
+	SetSourceHistoryDatabasePath(localPath)
+	sourceCopiedFiles = append(sourceCopiedFiles, localPath)
+}
+
+// Where the files of the backup with the given timestamp are on the cluster given by --source-host
+func GetSourceFPInfo(timestamp string) filepath.FilePathInfo {
+	fpInfo := filepath.NewFilePathInfo(sourceCluster, MustGetFlagString(options.SOURCE_DIR), timestamp, sourceSegPrefix)
+	fpInfo.SetLayoutTemplate(globalFPInfo.LayoutTemplate, globalFPInfo.DatabaseName)
+	fpInfo.SetLayoutSegments(globalFPInfo.LayoutHostMap, globalFPInfo.LayoutDbIDMap)
+	fpInfo.UserSpecifiedMetadataDir = findSourceMetadataDir(timestamp)
+	return fpInfo
+}
+
+/*
+ * Returns the --metadata-dir with which the backup with the given timestamp
+ * was taken on the cluster given by --source-host, as recorded in its history
+ * database, or "" if it was taken without one or is not recorded there.
+ */
+func findSourceMetadataDir(timestamp string) string {
+	if sourceHistoryDBPath == "" {
+		return ""
+	}
+	historyDB, err := history.InitializeHistoryDatabase(sourceHistoryDBPath)
+	gplog.FatalOnError(err)
+	defer historyDB.Close()
+
+	foundBackupConfig, err := history.GetBackupConfig(timestamp, historyDB)
+	if err != nil && err.Error() != "timestamp doesn't match any existing backups" {
+		gplog.FatalOnError(err)
+	}
+	if err != nil {
+		return ""
+	}
+	return foundBackupConfig.MetadataDir
+}
+
+/*
+ * Copies the coordinator files of the backup, and of the backups in its restore
+ * plan, from the coordinator of the cluster given by --source-host to where
+ * they would be on this cluster.  Data files are streamed by the helpers.
+ */
+func RecoverMetadataFilesFromSource() {
+	if !utils.CommandExists("rsync") {
+		gplog.Fatal(errors.New("Failed to find rsync on PATH. Please ensure rsync is installed."), "")
+	}
+	CopyCoordinatorFilesFromSource(globalFPInfo)
+	InitializeBackupConfig()
+	if backupConfig.MetadataOnly {
+		return
+	}
+	for _, fpInfo := range GetBackupFPInfoListFromRestorePlan() {
+		if fpInfo.Timestamp != globalFPInfo.Timestamp {
+			CopyCoordinatorFilesFromSource(fpInfo)
+		}
+	}
+}
+
+/*
+ * The files that rsync lists as copied are recorded, so that they are removed
+ * once the restore is done and only the restore report is left behind.
+ */
+func CopyCoordinatorFilesFromSource(fpInfo filepath.FilePathInfo) {
+	sourceHost := MustGetFlagString(options.SOURCE_HOST)
+	sourceFPInfo := GetSourceFPInfo(fpInfo.Timestamp)
+	sourceDir := sourceFPInfo.GetDirForContent(-1)
+	localDir := fpInfo.GetDirForContent(-1)
+	gplog.Verbose("Copying coordinator files of backup %s from %s on host %s to %s", fpInfo.Timestamp, sourceDir, sourceHost, localDir)
+	output, err := globalCluster.ExecuteLocalCommand(fmt.Sprintf("mkdir -p %s && rsync -e ssh -a --out-format=%%n %s:%s/ %s/", localDir, sourceHost, sourceDir, localDir))
+	gplog.FatalOnError(err, fmt.Sprintf("Unable to copy the coordinator files of backup %s from host %s", fpInfo.Timestamp, sourceHost))
+	for _, copiedFile := range strings.Split(output, "\n") {
+		copiedFile = strings.TrimSpace(copiedFile)
+		// Directories are listed with a trailing slash
+		if copiedFile != "" && !strings.HasSuffix(copiedFile, "/") {
+			sourceCopiedFiles = append(sourceCopiedFiles, path.Join(localDir, copiedFile))
+		}
+	}
+}
+
+// Removes the files that were copied from the cluster given by --source-host
+func RemoveFilesCopiedFromSource() {
+	if len(sourceCopiedFiles) == 0 {
+		return
+	}
+	gplog.Verbose("Removing %d files copied from host %s", len(sourceCopiedFiles), MustGetFlagString(options.SOURCE_HOST))
+	_, err := globalCluster.ExecuteLocalCommand(fmt.Sprintf("rm -f %s", strings.Join(sourceCopiedFiles, " ")))
+	if err != nil {
+		gplog.Warn("Unable to remove the files copied from host %s: %v", MustGetFlagString(options.SOURCE_HOST), err)
+		return
+	}
+	sourceCopiedFiles = nil
 }
 
 func FindHistoricalPluginVersion(timestamp string) string {
@@ -264,6 +542,48 @@ func FindHistoricalPluginVersion(timestamp string) string {
 	return historicalPluginVersion
 }
 
+/*
+ * The config file of a backup taken with --layout-template is in a directory
+ * named by the template, so the template is looked up in the history database
+ * before the config file can be read, unless it is given with --layout-template.
+ * The name of the database that was backed up, which {database} stands for, and
+ * the hosts and dbids of the segments, which {host} and {dbid} stand for, are
+ * looked up as well.  For --source-host, InitializeSourceCluster must be called
+ * first, so that the history database of the source cluster is read instead.
+ */
+func FindLayoutTemplate(timestamp string) (layoutTemplate string, databaseName string, segmentHosts map[int]string, segmentDbIDs map[int]int) {
+	layoutTemplate = MustGetFlagString(options.LAYOUT_TEMPLATE)
+
+	historyDBPath := globalFPInfo.GetBackupHistoryDatabasePath()
+	// A backup on another cluster is recorded in the history database copied from there
+	if sourceCluster != nil {
+		historyDBPath = sourceHistoryDBPath
+	}
+	_, err := operating.System.Stat(historyDBPath)
+	if err == nil {
+		historyDB, err := history.InitializeHistoryDatabase(historyDBPath)
+		gplog.FatalOnError(err)
+		defer historyDB.Close()
+
+		foundBackupConfig, err := history.GetBackupConfig(timestamp, historyDB)
+		if err != nil && err.Error() != "timestamp doesn't match any existing backups" {
+			gplog.FatalOnError(err)
+		}
+		if err == nil {
+			if layoutTemplate == "" {
+				layoutTemplate = foundBackupConfig.LayoutTemplate
+			}
+			databaseName = utils.UnquoteIdent(foundBackupConfig.DatabaseName)
+			segmentHosts, segmentDbIDs = foundBackupConfig.SegmentHosts, foundBackupConfig.SegmentDbIDs
+		}
+	}
+
+	if strings.Contains(layoutTemplate, "{database}") && databaseName == "" {
+		gplog.Fatal(errors.Errorf("Backup %s is not in the backup history database, so {database} in --%s must be replaced with the name of the database that was backed up", timestamp, options.LAYOUT_TEMPLATE), "")
+	}
+	return layoutTemplate, databaseName, segmentHosts, segmentDbIDs
+}
+
 /*
  * Metadata and/or data restore wrapper functions
  */
@@ -303,6 +623,9 @@ func GetRestoreMetadataStatementsFiltered(section string, filename string, inclu
 		}
 	}
 	statements = globalTOC.GetSQLStatementForObjectTypes(section, metadataFile, includeObjectTypes, excludeObjectTypes, inSchemas, exSchemas, inRelations, exRelations)
+	if IsPostgresTarget() {
+		statements = ConvertStatementsForPostgres(statements)
+	}
 	return statements
 }
 
@@ -320,23 +643,37 @@ func ExecuteRestoreMetadataStatements(statements []toc.StatementWithType, object
 func GetBackupFPInfoListFromRestorePlan() []filepath.FilePathInfo {
 	fpInfoList := make([]filepath.FilePathInfo, 0)
 	for _, entry := range backupConfig.RestorePlan {
-		segPrefix, err := filepath.ParseSegPrefix(MustGetFlagString(options.BACKUP_DIR))
-		gplog.FatalOnError(err)
-
-		fpInfo := filepath.NewFilePathInfo(globalCluster, MustGetFlagString(options.BACKUP_DIR), entry.Timestamp, segPrefix)
+		fpInfo := GetBackupFPInfoForTimestamp(entry.Timestamp)
 		fpInfoList = append(fpInfoList, fpInfo)
 	}
 
 	return fpInfoList
 }
 
+// Incremental backups are laid out like the backups they build on, which they must match
 func GetBackupFPInfoForTimestamp(timestamp string) filepath.FilePathInfo {
-	segPrefix, err := filepath.ParseSegPrefix(MustGetFlagString(options.BACKUP_DIR))
+	if globalFPInfo.LayoutTemplate != "" {
+		fpInfo := filepath.NewFilePathInfo(globalCluster, MustGetFlagString(options.BACKUP_DIR), timestamp, "")
+		fpInfo.UserSpecifiedMetadataDir = MustGetFlagString(options.METADATA_DIR)
+		fpInfo.SetLayoutTemplate(globalFPInfo.LayoutTemplate, globalFPInfo.DatabaseName)
+		fpInfo.SetLayoutSegments(globalFPInfo.LayoutHostMap, globalFPInfo.LayoutDbIDMap)
+		return fpInfo
+	}
+	segPrefix, err := filepath.ParseSegPrefix(getSegPrefixDir())
 	gplog.FatalOnError(err)
 	fpInfo := filepath.NewFilePathInfo(globalCluster, MustGetFlagString(options.BACKUP_DIR), timestamp, segPrefix)
+	fpInfo.UserSpecifiedMetadataDir = MustGetFlagString(options.METADATA_DIR)
 	return fpInfo
 }
 
+// The coordinator directory, from which the segment prefix is parsed, is in the metadata directory if there is one
+func getSegPrefixDir() string {
+	if metadataDir := MustGetFlagString(options.METADATA_DIR); metadataDir != "" {
+		return metadataDir
+	}
+	return MustGetFlagString(options.BACKUP_DIR)
+}
+
 /*
  * The first time this function is called, it retrieves the session GUCs from the
  * predata file and processes them appropriately, then it returns them so they
@@ -399,10 +736,38 @@ func GetExistingTableFQNs() ([]string, error) {
 	return existingTableFQNs, err
 }
 
+/*
+ * Returns the tables that an incremental restore may drop, which excludes
+ * partitions, as those can only be dropped through their parent.
+ */
+func GetExistingTopLevelTableFQNs() ([]string, error) {
+	existingTableFQNs := make([]string, 0)
+	var relkindFilter, partitionFilter string
+	if connectionPool.Version.Before("7") {
+		relkindFilter = "'r', 'f'"
+		partitionFilter = "c.oid NOT IN (SELECT parchildrelid FROM pg_catalog.pg_partition_rule)"
+	} else {
+		relkindFilter = "'r', 'f', 'p'"
+		partitionFilter = "NOT c.relispartition"
+	}
+	query := fmt.Sprintf(`SELECT quote_ident(n.nspname) || '.' || quote_ident(c.relname)
+			  FROM pg_catalog.pg_class c
+				LEFT JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+			  WHERE c.relkind IN (%s)
+				 AND %s
+				 AND n.nspname !~ '^pg_'
+				 AND n.nspname !~ '^gp_'
+				 AND n.nspname <> 'information_schema'
+			  ORDER BY 1;`, relkindFilter, partitionFilter)
+
+	err := connectionPool.Select(&existingTableFQNs, query)
+	return existingTableFQNs, err
+}
+
 func GetExistingSchemas() ([]string, error) {
 	existingSchemas := make([]string, 0)
 
-	query := `SELECT n.nspname AS "Name"
+	query := `SELECT quote_ident(n.nspname) AS "Name"
 			  FROM pg_catalog.pg_namespace n
 			  WHERE n.nspname !~ '^pg_' AND n.nspname <> 'information_schema'
 			  ORDER BY 1;`
@@ -320,5 +320,40 @@ withstatistics: false
 				Expect(resultPluginVersion).To(Equal("99.99.9999"))
 			})
 		})
+		Describe("FindLayoutTemplate", func() {
+			AfterEach(func() {
+				_ = cmdFlags.Set(options.LAYOUT_TEMPLATE, "")
+			})
+			It("finds the name of the database that was backed up", func() {
+				layoutTemplate, databaseName, _, _ := restore.FindLayoutTemplate("20180415154238")
+				Expect(layoutTemplate).To(Equal(""))
+				Expect(databaseName).To(Equal("plugin_test_db"))
+			})
+			It("uses the template given with --layout-template", func() {
+				_ = cmdFlags.Set(options.LAYOUT_TEMPLATE, "{database}/seg{content}/{timestamp}")
+				layoutTemplate, databaseName, _, _ := restore.FindLayoutTemplate("20180415154238")
+				Expect(layoutTemplate).To(Equal("{database}/seg{content}/{timestamp}"))
+				Expect(databaseName).To(Equal("plugin_test_db"))
+			})
+			It("reads the history database copied from the source cluster for --source-host", func() {
+				restore.SetSourceCluster(testutils.SetupTestCluster(), "gpseg")
+				defer restore.SetSourceCluster(nil, "")
+				restore.SetSourceHistoryDatabasePath(filepath.Join(mdd, "gpbackup_history.db"))
+				defer restore.SetSourceHistoryDatabasePath("")
+				_, databaseName, _, _ := restore.FindLayoutTemplate("20180415154238")
+				Expect(databaseName).To(Equal("plugin_test_db"))
+			})
+			It("does not read the history database of this cluster for --source-host", func() {
+				restore.SetSourceCluster(testutils.SetupTestCluster(), "gpseg")
+				defer restore.SetSourceCluster(nil, "")
+				_, databaseName, _, _ := restore.FindLayoutTemplate("20180415154238")
+				Expect(databaseName).To(Equal(""))
+			})
+			It("panics if {database} cannot be replaced for a backup that is not in the history database", func() {
+				_ = cmdFlags.Set(options.LAYOUT_TEMPLATE, "{database}/seg{content}/{timestamp}")
+				defer testhelper.ShouldPanicWithMessage("Backup 20190101010101 is not in the backup history database, so {database} in --layout-template must be replaced with the name of the database that was backed up")
+				restore.FindLayoutTemplate("20190101010101")
+			})
+		})
 	})
 })
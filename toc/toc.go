@@ -20,6 +20,11 @@ type TOC struct {
 	StatisticsEntries   []MetadataEntry
 	DataEntries         []CoordinatorDataEntry
 	IncrementalMetadata IncrementalEntries
+	PredataDependencies []DependencyEntry `yaml:",omitempty"`
+	// Set when the backup was stopped early, in which case DataEntries only
+	// lists the tables whose data was backed up and the others are listed here
+	StoppedEarly  bool     `yaml:",omitempty"`
+	SkippedTables []string `yaml:",omitempty"`
 }
 
 type SegmentTOC struct {
@@ -33,6 +38,23 @@ type MetadataEntry struct {
 	ReferenceObject string
 	StartByte       uint64
 	EndByte         uint64
+	ObjectID        UniqueID `yaml:",omitempty"`
+}
+
+/*
+ * UniqueID mirrors the (classid, objid) pairs in pg_depend so that entries
+ * written by the dependency sort during backup can be matched up with the
+ * edges between them at restore time.  A zero UniqueID means the entry was
+ * not part of the dependency sort and must be restored in file order.
+ */
+type UniqueID struct {
+	ClassID uint32
+	Oid     uint32
+}
+
+type DependencyEntry struct {
+	Object    UniqueID
+	DependsOn []UniqueID
 }
 
 type CoordinatorDataEntry struct {
@@ -43,6 +65,10 @@ type CoordinatorDataEntry struct {
 	RowsCopied      int64
 	PartitionRoot   string
 	IsReplicated    bool
+	RelationSize    int64  `yaml:",omitempty"`
+	RowFilter       string `yaml:",omitempty"`
+	// The type of each column in AttributeString, as printed by format_type
+	ColumnTypes []string `yaml:",omitempty"`
 }
 
 type SegmentDataEntry struct {
@@ -98,6 +124,7 @@ type StatementWithType struct {
 	ObjectType      string
 	ReferenceObject string
 	Statement       string
+	ObjectID        UniqueID
 }
 
 func GetIncludedPartitionRoots(tocDataEntries []CoordinatorDataEntry, includeRelations []string) []string {
@@ -132,7 +159,7 @@ func (toc *TOC) GetSQLStatementForObjectTypes(section string, metadataFile io.Re
 			contents := make([]byte, entry.EndByte-entry.StartByte)
 			_, err := metadataFile.ReadAt(contents, int64(entry.StartByte))
 			gplog.FatalOnError(err)
-			statements = append(statements, StatementWithType{Schema: entry.Schema, Name: entry.Name, ObjectType: entry.ObjectType, ReferenceObject: entry.ReferenceObject, Statement: string(contents)})
+			statements = append(statements, StatementWithType{Schema: entry.Schema, Name: entry.Name, ObjectType: entry.ObjectType, ReferenceObject: entry.ReferenceObject, Statement: string(contents), ObjectID: entry.ObjectID})
 		}
 	}
 	return statements
@@ -276,9 +303,24 @@ func (toc *TOC) AddMetadataEntry(section string, entry MetadataEntry, start, end
 	*toc.metadataEntryMap[section] = append(*toc.metadataEntryMap[section], entry)
 }
 
-func (toc *TOC) AddCoordinatorDataEntry(schema string, name string, oid uint32, attributeString string, rowsCopied int64, PartitionRoot string, distPolicy string) {
+/*
+ * Tag every predata entry added since startIndex with the given object ID, so
+ * that all statements printed for a single sorted object can be scheduled as
+ * a unit during restore.
+ */
+func (toc *TOC) SetObjectIDForPredataEntries(startIndex int, objectID UniqueID) {
+	for i := startIndex; i < len(toc.PredataEntries); i++ {
+		toc.PredataEntries[i].ObjectID = objectID
+	}
+}
+
+func (toc *TOC) AddPredataDependency(object UniqueID, dependsOn []UniqueID) {
+	toc.PredataDependencies = append(toc.PredataDependencies, DependencyEntry{Object: object, DependsOn: dependsOn})
+}
+
+func (toc *TOC) AddCoordinatorDataEntry(schema string, name string, oid uint32, attributeString string, rowsCopied int64, relationSize int64, PartitionRoot string, distPolicy string) {
 	isReplicated := strings.Contains(distPolicy, "REPLICATED")
-	toc.DataEntries = append(toc.DataEntries, CoordinatorDataEntry{schema, name, oid, attributeString, rowsCopied, PartitionRoot, isReplicated})
+	toc.DataEntries = append(toc.DataEntries, CoordinatorDataEntry{schema, name, oid, attributeString, rowsCopied, PartitionRoot, isReplicated, relationSize, "", nil})
 }
 
 func (toc *SegmentTOC) AddSegmentDataEntry(oid uint, startByte uint64, endByte uint64) {
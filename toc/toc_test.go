@@ -195,11 +195,11 @@ var _ = Describe("utils/toc tests", func() {
 	})
 	Describe("GetDataEntriesMatching", func() {
 		BeforeEach(func() {
-			tocfile.AddCoordinatorDataEntry("schema1", "table1", 1, "(i)", 0, "", "")
-			tocfile.AddCoordinatorDataEntry("schema2", "table2", 1, "(i)", 0, "", "")
-			tocfile.AddCoordinatorDataEntry("schema3", "table3", 1, "(i)", 0, "", "")
-			tocfile.AddCoordinatorDataEntry("schema3", "table3_partition1", 1, "(i)", 0, "table3", "")
-			tocfile.AddCoordinatorDataEntry("schema3", "table3_partition2", 1, "(i)", 0, "table3", "")
+			tocfile.AddCoordinatorDataEntry("schema1", "table1", 1, "(i)", 0, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema2", "table2", 1, "(i)", 0, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema3", "table3", 1, "(i)", 0, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema3", "table3_partition1", 1, "(i)", 0, 0, "table3", "")
+			tocfile.AddCoordinatorDataEntry("schema3", "table3_partition2", 1, "(i)", 0, 0, "table3", "")
 		})
 		Context("Non-empty restore plan", func() {
 			restorePlanTableFQNs := []string{"schema1.table1", "schema2.table2", "schema3.table3", "schema3.table3_partition1", "schema3.table3_partition2"}
@@ -400,22 +400,22 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 	})
 	Describe("GetIncludedPartitionRoots", func() {
 		It("does not return anything if relations are not leaf partitions", func() {
-			tocfile.AddCoordinatorDataEntry("schema0", "name0", 0, "attribute0", 1, "", "")
-			tocfile.AddCoordinatorDataEntry("schema1", "name1", 1, "attribute0", 1, "", "")
+			tocfile.AddCoordinatorDataEntry("schema0", "name0", 0, "attribute0", 1, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema1", "name1", 1, "attribute0", 1, 0, "", "")
 			roots := toc.GetIncludedPartitionRoots(tocfile.DataEntries, []string{"schema0.name0", "schema1.name1"})
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns root parition of leaf partitions", func() {
-			tocfile.AddCoordinatorDataEntry("schema0", "name0", 2, "attribute0", 1, "root0", "")
-			tocfile.AddCoordinatorDataEntry("schema1", "name1", 3, "attribute0", 1, "root1", "")
+			tocfile.AddCoordinatorDataEntry("schema0", "name0", 2, "attribute0", 1, 0, "root0", "")
+			tocfile.AddCoordinatorDataEntry("schema1", "name1", 3, "attribute0", 1, 0, "root1", "")
 			roots := toc.GetIncludedPartitionRoots(tocfile.DataEntries, []string{"schema0.name0", "schema1.name1"})
 			Expect(roots).To(ConsistOf("schema0.root0", "schema1.root1"))
 		})
 		It("only returns root partitions of leaf partitions", func() {
-			tocfile.AddCoordinatorDataEntry("schema0", "name0", 0, "attribute0", 1, "", "")
-			tocfile.AddCoordinatorDataEntry("schema1", "name1", 1, "attribute0", 1, "", "")
-			tocfile.AddCoordinatorDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", "")
-			tocfile.AddCoordinatorDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", "")
+			tocfile.AddCoordinatorDataEntry("schema0", "name0", 0, "attribute0", 1, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema1", "name1", 1, "attribute0", 1, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema2", "name2", 2, "attribute0", 1, 0, "root2", "")
+			tocfile.AddCoordinatorDataEntry("schema3", "name3", 3, "attribute0", 1, 0, "root3", "")
 			roots := toc.GetIncludedPartitionRoots(tocfile.DataEntries, []string{"schema2.name2", "schema3.name3"})
 			Expect(roots).To(ConsistOf("schema2.root2", "schema3.root3"))
 		})
@@ -424,20 +424,43 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns nothing if relation is not part of TOC data entries", func() {
-			tocfile.AddCoordinatorDataEntry("schema0", "name0", 0, "attribute0", 1, "", "")
-			tocfile.AddCoordinatorDataEntry("schema1", "name1", 1, "attribute0", 1, "", "")
-			tocfile.AddCoordinatorDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", "")
-			tocfile.AddCoordinatorDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", "")
+			tocfile.AddCoordinatorDataEntry("schema0", "name0", 0, "attribute0", 1, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema1", "name1", 1, "attribute0", 1, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema2", "name2", 2, "attribute0", 1, 0, "root2", "")
+			tocfile.AddCoordinatorDataEntry("schema3", "name3", 3, "attribute0", 1, 0, "root3", "")
 			roots := toc.GetIncludedPartitionRoots(tocfile.DataEntries, []string{"schema4.name4", "schema5.name5"})
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns empty if no relations are passed in", func() {
-			tocfile.AddCoordinatorDataEntry("schema0", "name0", 0, "attribute0", 1, "", "")
-			tocfile.AddCoordinatorDataEntry("schema1", "name1", 1, "attribute0", 1, "", "")
-			tocfile.AddCoordinatorDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", "")
-			tocfile.AddCoordinatorDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", "")
+			tocfile.AddCoordinatorDataEntry("schema0", "name0", 0, "attribute0", 1, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema1", "name1", 1, "attribute0", 1, 0, "", "")
+			tocfile.AddCoordinatorDataEntry("schema2", "name2", 2, "attribute0", 1, 0, "root2", "")
+			tocfile.AddCoordinatorDataEntry("schema3", "name3", 3, "attribute0", 1, 0, "root3", "")
 			roots := toc.GetIncludedPartitionRoots(tocfile.DataEntries, []string{})
 			Expect(roots).To(BeEmpty())
 		})
 	})
+	Describe("SetObjectIDForPredataEntries", func() {
+		It("tags only the entries added since the given index", func() {
+			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema", Name: "table1", ObjectType: "TABLE"}, 0, 10)
+			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema", Name: "table2", ObjectType: "TABLE"}, 10, 20)
+			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema", Name: "table2", ObjectType: "TABLE"}, 20, 30)
+
+			tocfile.SetObjectIDForPredataEntries(1, toc.UniqueID{ClassID: 1259, Oid: 2})
+
+			Expect(tocfile.PredataEntries[0].ObjectID).To(Equal(toc.UniqueID{}))
+			Expect(tocfile.PredataEntries[1].ObjectID).To(Equal(toc.UniqueID{ClassID: 1259, Oid: 2}))
+			Expect(tocfile.PredataEntries[2].ObjectID).To(Equal(toc.UniqueID{ClassID: 1259, Oid: 2}))
+		})
+		It("passes the object ID through to the restore statements", func() {
+			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema", Name: "table1", ObjectType: "TABLE"}, 0, table1Len)
+			tocfile.SetObjectIDForPredataEntries(0, toc.UniqueID{ClassID: 1259, Oid: 1})
+			metadataFile := bytes.NewReader([]byte(table1.Statement))
+
+			statements := tocfile.GetSQLStatementForObjectTypes("predata", metadataFile, []string{}, []string{}, []string{}, []string{}, []string{}, []string{})
+
+			Expect(statements).To(HaveLen(1))
+			Expect(statements[0].ObjectID).To(Equal(toc.UniqueID{ClassID: 1259, Oid: 1}))
+		})
+	})
 })
@@ -1,13 +1,18 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	path "path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
@@ -15,7 +20,169 @@ import (
 	"github.com/pkg/errors"
 )
 
-var helperMutex sync.Mutex
+var (
+	helperMutex sync.Mutex
+	// Set by StartHelperControlServer, nil if agents are coordinated through files only
+	helperControl *HelperControlServer
+	// Names of the tables handled by agents, keyed by oid
+	helperTableNames = make(map[int]string)
+	// Errors that stopped agents, collected by CheckAgentErrorsOnSegments for the report
+	agentErrorRecords = make([]AgentError, 0)
+	agentErrorMutex   sync.Mutex
+	// Hosts to which backup agents stream their data, keyed by content, for --offload-to-mirrors
+	helperOffloadHosts = make(map[int]string)
+	// Set by WriteSourceListToSegments, for restore agents to read backup files from another cluster
+	helperReadsSource bool
+)
+
+/*
+ * Starts the control channel that gpbackup_helper agents started afterwards
+ * connect to.  If it cannot be started, agents are coordinated through files
+ * over SSH as before.
+ */
+func StartHelperControlServer(c *cluster.Cluster) {
+	if helperControl != nil {
+		return
+	}
+	server, err := NewHelperControlServer(c.GetHostForContent(-1))
+	if err != nil {
+		gplog.Warn("Unable to start the gpbackup_helper control channel, so agents will be coordinated through files: %v", err)
+		return
+	}
+	helperControl = server
+}
+
+func StopHelperControlServer() {
+	if helperControl != nil {
+		helperControl.Close()
+		helperControl = nil
+	}
+}
+
+/*
+ * Has the backup agent of each content in hosts stream its data to that host,
+ * where a gpbackup_helper offload agent compresses and writes it.
+ */
+func SetHelperOffloadHosts(hosts map[int]string) {
+	helperOffloadHosts = hosts
+}
+
+/*
+ * Returns the host of the mirror of each primary, for the mirrors that are up
+ * on a segment host other than that of their primary.  The mirror host must
+ * run segments of its own so that it has gpbackup_helper and the plugin config.
+ */
+func GetMirrorOffloadHosts(connectionPool *dbconn.DBConn, c *cluster.Cluster) map[int]string {
+	query := `
+	SELECT content, hostname
+	FROM gp_segment_configuration
+	WHERE role = 'm' AND status = 'u' AND content >= 0`
+	mirrors := make([]struct {
+		Content  int
+		Hostname string
+	}, 0)
+	err := connectionPool.Select(&mirrors, query)
+	gplog.FatalOnError(err, fmt.Sprintf("Query was: %s", query))
+
+	segmentHosts := make(map[string]bool, len(c.Hostnames))
+	for _, host := range c.Hostnames {
+		segmentHosts[host] = true
+	}
+	hosts := make(map[int]string, len(mirrors))
+	for _, mirror := range mirrors {
+		if _, ok := c.ByContent[mirror.Content]; !ok || mirror.Hostname == c.GetHostForContent(mirror.Content) {
+			continue
+		}
+		if !segmentHosts[mirror.Hostname] {
+			gplog.Verbose("Not offloading segment %d to host %s of its mirror, which runs no primary segments", mirror.Content, mirror.Hostname)
+			continue
+		}
+		hosts[mirror.Content] = mirror.Hostname
+	}
+	return hosts
+}
+
+// Names the tables, keyed by oid, in the errors reported by agents
+func SetHelperTableNames(tableNames map[int]string) {
+	agentErrorMutex.Lock()
+	defer agentErrorMutex.Unlock()
+	for oid, name := range tableNames {
+		helperTableNames[oid] = name
+	}
+}
+
+func getHelperTableName(oid int) string {
+	agentErrorMutex.Lock()
+	defer agentErrorMutex.Unlock()
+	return helperTableNames[oid]
+}
+
+/*
+ * Returns the errors that agents reported so far, including those of tables
+ * that agents continued past with --on-error-continue, for the report.
+ */
+func GetAgentErrors() []AgentError {
+	agentErrorMutex.Lock()
+	agentErrors := append([]AgentError{}, agentErrorRecords...)
+	agentErrorMutex.Unlock()
+	if helperControl != nil {
+		agentErrors = append(agentErrors, helperControl.TableErrors()...)
+	}
+	return agentErrors
+}
+
+// Forgets the errors reported so far and the names of their tables, once they are in a report
+func ResetAgentErrors() {
+	agentErrorMutex.Lock()
+	agentErrorRecords = make([]AgentError, 0)
+	helperTableNames = make(map[int]string)
+	agentErrorMutex.Unlock()
+	if helperControl != nil {
+		helperControl.ClearTableErrors()
+	}
+}
+
+func recordAgentErrors(agentErrors []AgentError) {
+	agentErrorMutex.Lock()
+	defer agentErrorMutex.Unlock()
+	agentErrorRecords = append(agentErrorRecords, agentErrors...)
+}
+
+/*
+ * Parses the records in the error file of the agent of the given segment.
+ * Agents of older versions leave the file empty, so an error is returned for
+ * the segment even if the file holds no records.
+ */
+func ParseAgentErrorFile(contents string, contentID int, host string, helperLogName string) []AgentError {
+	agentErrors := make([]AgentError, 0)
+	for _, line := range strings.Split(contents, "\n") {
+		var agentError AgentError
+		if strings.TrimSpace(line) == "" || json.Unmarshal([]byte(line), &agentError) != nil {
+			continue
+		}
+		agentError.Content = contentID
+		if agentError.Host == "" {
+			agentError.Host = host
+		}
+		agentError.Table = getHelperTableName(agentError.Oid)
+		agentErrors = append(agentErrors, agentError)
+	}
+	if len(agentErrors) == 0 {
+		agentErrors = append(agentErrors, AgentError{Content: contentID, Host: host, Message: fmt.Sprintf("see %s on the host for details", helperLogName)})
+	}
+	return agentErrors
+}
+
+// Returns the agents of the segments, which are identified by their pipe files
+func getHelperAgents(c *cluster.Cluster, fpInfo filepath.FilePathInfo) []string {
+	agents := make([]string, 0, len(c.ContentIDs))
+	for _, contentID := range c.ContentIDs {
+		if contentID >= 0 {
+			agents = append(agents, fpInfo.GetSegmentPipeFilePath(contentID))
+		}
+	}
+	return agents
+}
 
 /*
  * Functions to run commands on entire cluster during both backup and restore
@@ -41,6 +208,19 @@ func CreateSegmentPipeOnAllHosts(oid string, c *cluster.Cluster, fpInfo filepath
 }
 
 func WriteOidListToSegments(oidList []string, c *cluster.Cluster, fpInfo filepath.FilePathInfo, fileSuffix string) {
+	writeListToSegments(oidList, c, fpInfo, fileSuffix, "")
+}
+
+/*
+ * The secret of the control channel is written to a file that only the user
+ * can read rather than passed on the command line of the agents, where any
+ * user on the segment hosts could read it and send messages as an agent.
+ */
+func writeControlSecretToSegments(c *cluster.Cluster, fpInfo filepath.FilePathInfo) {
+	writeListToSegments([]string{helperControl.Secret()}, c, fpInfo, "control_secret", " --perms --chmod=F600")
+}
+
+func writeListToSegments(oidList []string, c *cluster.Cluster, fpInfo filepath.FilePathInfo, fileSuffix string, rsyncOptions string) {
 	rsync_exists := CommandExists("rsync")
 	if !rsync_exists {
 		gplog.Fatal(errors.New("Failed to find rsync on PATH. Please ensure rsync is installed."), "")
@@ -62,7 +242,7 @@ func WriteOidListToSegments(oidList []string, c *cluster.Cluster, fpInfo filepat
 		hostname := c.GetHostForContent(contentID)
 		dest := fpInfo.GetSegmentHelperFilePath(contentID, fileSuffix)
 
-		return fmt.Sprintf(`rsync -e ssh %s %s:%s`, sourceFile, hostname, dest)
+		return fmt.Sprintf(`rsync -e ssh%s %s %s:%s`, rsyncOptions, sourceFile, hostname, dest)
 	}
 	remoteOutput := c.GenerateAndExecuteCommand("rsync oid file to segments", cluster.ON_LOCAL|cluster.ON_SEGMENTS, generateScpCmd)
 
@@ -127,7 +307,7 @@ func VerifyHelperVersionOnSegments(version string, c *cluster.Cluster) {
 	}
 }
 
-func StartGpbackupHelpers(c *cluster.Cluster, fpInfo filepath.FilePathInfo, operation string, pluginConfigFile string, compressStr string, onErrorContinue bool, isFilter bool, wasTerminated *bool, copyQueue int, isSingleDataFile bool, resizeCluster bool, origSize int, destSize int) {
+func StartGpbackupHelpers(c *cluster.Cluster, fpInfo filepath.FilePathInfo, operation string, pluginConfigFile string, compressStr string, onErrorContinue bool, isFilter bool, wasTerminated *bool, copyQueue int, isSingleDataFile bool, resizeCluster bool, origSize int, destSize int, maxBandwidth int64) {
 	// A mutex lock for cleaning up and starting gpbackup helpers prevents a
 	// race condition that causes gpbackup_helpers to be orphaned if
 	// gpbackup_helper cleanup happens before they are started.
@@ -160,6 +340,13 @@ func StartGpbackupHelpers(c *cluster.Cluster, fpInfo filepath.FilePathInfo, oper
 	if resizeCluster {
 		resizeStr = fmt.Sprintf(" --resize-cluster --orig-seg-count %d --dest-seg-count %d", origSize, destSize)
 	}
+	bandwidthStr := ""
+	if maxBandwidth > 0 {
+		bandwidthStr = fmt.Sprintf(" --max-bandwidth %d --bandwidth-share %d", maxBandwidth, GetBandwidthShare(c, 1))
+	}
+	if helperControl != nil {
+		writeControlSecretToSegments(c, fpInfo)
+	}
 	remoteOutput := c.GenerateAndExecuteCommand("Starting gpbackup_helper agent", cluster.ON_SEGMENTS, func(contentID int) string {
 		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
 		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
@@ -167,8 +354,24 @@ func StartGpbackupHelpers(c *cluster.Cluster, fpInfo filepath.FilePathInfo, oper
 		pipeFile := fpInfo.GetSegmentPipeFilePath(contentID)
 		backupFile := fpInfo.GetTableBackupFilePath(contentID, 0, GetPipeThroughProgram().Extension, true)
 		replicatedOidFile := fpInfo.GetSegmentHelperFilePath(contentID, "replicated_oid")
-		helperCmdStr := fmt.Sprintf(`gpbackup_helper %s --toc-file %s --oid-file %s --pipe-file %s --data-file "%s" --content %d%s%s%s%s%s%s --copy-queue-size %d --replication-file %s`,
-			operation, tocFile, oidFile, pipeFile, backupFile, contentID, pluginStr, compressStr, onErrorContinueStr, filterStr, singleDataFileStr, resizeStr, copyQueue, replicatedOidFile)
+		bandwidthFileStr := ""
+		if bandwidthStr != "" {
+			bandwidthFileStr = fmt.Sprintf(" --bandwidth-file %s", fpInfo.GetSegmentHelperFilePath(contentID, "max_bandwidth"))
+		}
+		offloadStr := ""
+		if host, ok := helperOffloadHosts[contentID]; ok {
+			offloadStr = fmt.Sprintf(" --offload-host %s", host)
+		}
+		controlStr := ""
+		if helperControl != nil {
+			controlStr = fmt.Sprintf(" --control-address %s --control-secret-file %s", helperControl.Address(), fpInfo.GetSegmentHelperFilePath(contentID, "control_secret"))
+		}
+		sourceStr := ""
+		if helperReadsSource {
+			sourceStr = fmt.Sprintf(" --source-file %s", fpInfo.GetSegmentHelperFilePath(contentID, "source"))
+		}
+		helperCmdStr := fmt.Sprintf(`gpbackup_helper %s --toc-file %s --oid-file %s --pipe-file %s --data-file "%s" --content %d%s%s%s%s%s%s%s%s%s%s%s --copy-queue-size %d --replication-file %s`,
+			operation, tocFile, oidFile, pipeFile, backupFile, contentID, pluginStr, compressStr, onErrorContinueStr, filterStr, singleDataFileStr, resizeStr, bandwidthStr, bandwidthFileStr, controlStr, offloadStr, sourceStr, copyQueue, replicatedOidFile)
 		// we run these commands in sequence to ensure that any failure is critical; the last command ensures the agent process was successfully started
 		return fmt.Sprintf(`cat << HEREDOC > %[1]s && chmod +x %[1]s && ( nohup %[1]s &> /dev/null &)
 #!/bin/bash
@@ -184,12 +387,41 @@ HEREDOC
 	})
 }
 
+/*
+ * Writes the host and backup directory of each segment of the cluster on which
+ * a backup was taken to every segment, so that restore agents stream the backup
+ * files from there instead of reading them locally.
+ */
+func WriteSourceListToSegments(sourceFPInfo filepath.FilePathInfo, c *cluster.Cluster, fpInfo filepath.FilePathInfo) {
+	WriteOidListToSegments(GetSourceList(sourceFPInfo), c, fpInfo, "source")
+	helperReadsSource = true
+}
+
+// Lists the content, host, and backup directory of each segment, separated by tabs
+func GetSourceList(sourceFPInfo filepath.FilePathInfo) []string {
+	contents := make([]int, 0, len(sourceFPInfo.SegHostMap))
+	for content := range sourceFPInfo.SegHostMap {
+		if content >= 0 {
+			contents = append(contents, content)
+		}
+	}
+	sort.Ints(contents)
+	sourceList := make([]string, 0, len(contents))
+	for _, content := range contents {
+		sourceList = append(sourceList, fmt.Sprintf("%d\t%s\t%s", content, sourceFPInfo.SegHostMap[content], sourceFPInfo.GetDirForContent(content)))
+	}
+	return sourceList
+}
+
 func CleanUpHelperFilesOnAllHosts(c *cluster.Cluster, fpInfo filepath.FilePathInfo) {
 	remoteOutput := c.GenerateAndExecuteCommand("Removing oid list and helper script files from segment data directories", cluster.ON_SEGMENTS, func(contentID int) string {
 		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
 		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
 		scriptFile := fpInfo.GetSegmentHelperFilePath(contentID, "script")
-		return fmt.Sprintf("rm -f %s && rm -f %s && rm -f %s", errorFile, oidFile, scriptFile)
+		bandwidthFile := fpInfo.GetSegmentHelperFilePath(contentID, "max_bandwidth")
+		sourceFile := fpInfo.GetSegmentHelperFilePath(contentID, "source")
+		controlSecretFile := fpInfo.GetSegmentHelperFilePath(contentID, "control_secret")
+		return fmt.Sprintf("rm -f %s && rm -f %s && rm -f %s && rm -f %s && rm -f %s && rm -f %s", errorFile, oidFile, scriptFile, bandwidthFile, sourceFile, controlSecretFile)
 	})
 	errMsg := fmt.Sprintf("Unable to remove segment helper file(s). See %s for a complete list of segments with errors and remove manually.",
 		gplog.GetLogFilePath())
@@ -203,7 +435,27 @@ func CleanUpSegmentHelperProcesses(c *cluster.Cluster, fpInfo filepath.FilePathI
 	helperMutex.Lock()
 	defer helperMutex.Unlock()
 
-	remoteOutput := c.GenerateAndExecuteCommand("Cleaning up segment agent processes", cluster.ON_SEGMENTS, func(contentID int) string {
+	/*
+	 * Agents that connected to the control channel are told to stop through
+	 * it, and only those it could not reach are killed over SSH.
+	 */
+	var unsentContents map[int]bool
+	if helperControl != nil {
+		unsent := helperControl.Send(getHelperAgents(c, fpInfo), ControlMessage{Type: CONTROL_CANCEL})
+		if len(unsent) == 0 {
+			return
+		}
+		unsentContents = make(map[int]bool, len(unsent))
+		for _, contentID := range c.ContentIDs {
+			for _, agent := range unsent {
+				if agent == fpInfo.GetSegmentPipeFilePath(contentID) {
+					unsentContents[contentID] = true
+				}
+			}
+		}
+	}
+
+	commandList := c.GenerateSSHCommandList(cluster.ON_SEGMENTS, func(contentID int) string {
 		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
 		procPattern := fmt.Sprintf("gpbackup_helper --%s-agent --toc-file %s", operation, tocFile)
 		/*
@@ -213,30 +465,148 @@ func CleanUpSegmentHelperProcesses(c *cluster.Cluster, fpInfo filepath.FilePathI
 		 */
 		return fmt.Sprintf("PIDS=`ps ux | grep \"%s\" | grep -v grep | awk '{print $2}'`; if [[ ! -z \"$PIDS\" ]]; then kill -USR1 $PIDS; fi", procPattern)
 	})
+	if unsentContents != nil {
+		unsentCommands := make([]cluster.ShellCommand, 0, len(unsentContents))
+		for _, command := range commandList {
+			if unsentContents[command.Content] {
+				unsentCommands = append(unsentCommands, command)
+			}
+		}
+		commandList = unsentCommands
+	}
+	gplog.Verbose("Cleaning up segment agent processes")
+	remoteOutput := c.ExecuteClusterCommand(cluster.ON_SEGMENTS, commandList)
 	c.CheckClusterError(remoteOutput, "Unable to clean up agent processes", func(contentID int) string {
 		return "Unable to clean up agent process"
 	})
 }
 
+// How long to wait for agents that were told to stop or cancel over the control channel to exit
+const helperExitTimeout = time.Minute
+
+/*
+ * Cancels the agents and waits for those connected to the control channel to
+ * exit, so that new agents can be started on the same pipes and files.  The
+ * errors the agents report about being canceled are discarded.  Returns
+ * whether they exited within helperExitTimeout.
+ */
+func CancelSegmentHelpersAndWait(c *cluster.Cluster, fpInfo filepath.FilePathInfo, operation string) bool {
+	CleanUpSegmentHelperProcesses(c, fpInfo, operation)
+	exited := true
+	if helperControl != nil {
+		agents := getHelperAgents(c, fpInfo)
+		unconnected := helperControl.UnconnectedAgents(agents)
+		connected := make([]string, 0, len(agents))
+		for _, agent := range agents {
+			if !Exists(unconnected, agent) {
+				connected = append(connected, agent)
+			}
+		}
+		exited = waitForHelpers(connected, helperExitTimeout)
+		for _, agentError := range helperControl.TakeErrors() {
+			gplog.Verbose("Discarding the error of a canceled gpbackup_helper agent on %s", agentError)
+		}
+	}
+	CleanUpHelperFilesOnAllHosts(c, fpInfo)
+	return exited
+}
+
+// Waits up to timeout for the agents to complete, fail, or disconnect, and returns whether they did
+func waitForHelpers(agents []string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !helperControl.Finished(agents) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// Returns whether every agent of the backup can be reached over the control channel
+func AllHelpersConnected(c *cluster.Cluster, fpInfo filepath.FilePathInfo) bool {
+	return helperControl != nil && helperControl.AllConnected(getHelperAgents(c, fpInfo))
+}
+
+/*
+ * How long to wait for agents that were told to stop once the table in
+ * progress is done, which they finish and upload the rest of their data for
+ * before they exit.  A variable so tests can shorten it.
+ */
+var HelperStopTimeout = 10 * time.Minute
+
+/*
+ * Tells the backup agents to stop once the table in progress is done, instead
+ * of waiting for the tables that were not dispatched, and waits up to
+ * HelperStopTimeout for them to write their segment TOC files.  Agents that
+ * have not stopped by then are canceled.  This needs every agent to be
+ * connected to the control channel, which AllHelpersConnected checks.
+ */
+func StopGpbackupHelpers(c *cluster.Cluster, fpInfo filepath.FilePathInfo) error {
+	if helperControl == nil {
+		return errors.New("The gpbackup_helper control channel is not running")
+	}
+	agents := getHelperAgents(c, fpInfo)
+	unsent := helperControl.Send(agents, ControlMessage{Type: CONTROL_STOP})
+	if len(unsent) > 0 {
+		return errors.Errorf("Unable to tell %d gpbackup_helper agent(s) to stop", len(unsent))
+	}
+	gplog.Verbose("Waiting for gpbackup_helper agents to stop")
+	if !waitForHelpers(agents, HelperStopTimeout) {
+		CleanUpSegmentHelperProcesses(c, fpInfo, "backup")
+		return errors.Errorf("gpbackup_helper agents did not stop within %s", HelperStopTimeout)
+	}
+	return nil
+}
+
+/*
+ * Returns an error describing each agent error reported since the last call,
+ * with the segment, host, table, and error text of each, and records them
+ * for the report.
+ */
 func CheckAgentErrorsOnSegments(c *cluster.Cluster, fpInfo filepath.FilePathInfo) error {
+	/*
+	 * Agents that connected to the control channel report their errors
+	 * through it.  Error files are only read over SSH if some agent did not
+	 * connect.
+	 */
+	agentErrors := make([]AgentError, 0)
+	if helperControl != nil {
+		agentErrors = append(agentErrors, helperControl.TakeErrors()...)
+		if helperControl.AllConnected(getHelperAgents(c, fpInfo)) {
+			recordAgentErrors(agentErrors)
+			return FormatAgentErrors(agentErrors)
+		}
+	}
 	remoteOutput := c.GenerateAndExecuteCommand("Checking whether segment agents had errors", cluster.ON_SEGMENTS, func(contentID int) string {
 		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
 		/*
 		 * If an error file exists we want to indicate an error, as that means
-		 * the agent errored out.  If no file exists, the agent was successful.
+		 * the agent errored out, and pass on the error records in it.  If no
+		 * file exists, the agent was successful.
 		 */
-		return fmt.Sprintf("if [[ -f %s ]]; then echo 'error'; fi; rm -f %s", errorFile, errorFile)
+		return fmt.Sprintf("if [[ -f %[1]s ]]; then echo 'error'; cat %[1]s; fi; rm -f %[1]s", errorFile)
 	})
 
 	numErrors := 0
+	helperLogName := fpInfo.GetHelperLogPath()
 	for contentID, cmd := range remoteOutput.Commands {
-		if strings.TrimSpace(cmd.Stdout) == "error" {
-			gplog.Verbose("Error occurred with helper agent on segment %d on host %s.", contentID, c.GetHostForContent(contentID))
-			numErrors++
+		output := strings.TrimSpace(cmd.Stdout)
+		if output != "error" && !strings.HasPrefix(output, "error\n") {
+			continue
+		}
+		gplog.Verbose("Error occurred with helper agent on segment %d on host %s.", contentID, c.GetHostForContent(contentID))
+		numErrors++
+		// Errors already reported through the control channel are also in the file
+		if helperControl == nil || len(helperControl.UnconnectedAgents([]string{fpInfo.GetSegmentPipeFilePath(contentID)})) > 0 {
+			agentErrors = append(agentErrors, ParseAgentErrorFile(strings.TrimPrefix(output, "error"), contentID, c.GetHostForContent(contentID), helperLogName)...)
 		}
 	}
+	recordAgentErrors(agentErrors)
+	if len(agentErrors) > 0 {
+		return FormatAgentErrors(agentErrors)
+	}
 	if numErrors > 0 {
-		helperLogName := fpInfo.GetHelperLogPath()
 		return errors.Errorf("Encountered errors with %d helper agent(s).  See %s for a complete list of segments with errors, and see %s on the corresponding hosts for detailed error messages.",
 			numErrors, gplog.GetLogFilePath(), helperLogName)
 	}
@@ -244,6 +614,15 @@ func CheckAgentErrorsOnSegments(c *cluster.Cluster, fpInfo filepath.FilePathInfo
 }
 
 func CreateSkipFileOnSegments(oid string, tableName string, c *cluster.Cluster, fpInfo filepath.FilePathInfo) {
+	// Agents that connected to the control channel are told to skip the table through it
+	if helperControl != nil {
+		oidNum, _ := strconv.Atoi(oid)
+		unsent := helperControl.Send(getHelperAgents(c, fpInfo), ControlMessage{Type: CONTROL_SKIP, Oid: oidNum})
+		if len(unsent) == 0 {
+			gplog.Verbose("Told segment agents to skip restore entry %s (%s)", oid, tableName)
+			return
+		}
+	}
 	createSkipFileLogMsg := fmt.Sprintf("Creating skip file on segments for restore entry %s (%s)", oid, tableName)
 	remoteOutput := c.GenerateAndExecuteCommand(createSkipFileLogMsg, cluster.ON_SEGMENTS, func(contentID int) string {
 		return fmt.Sprintf("touch %s_skip_%s", fpInfo.GetSegmentPipeFilePath(contentID), oid)
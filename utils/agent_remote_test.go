@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
@@ -126,33 +130,222 @@ var _ = Describe("agent remote", func() {
 	Describe("StartGpbackupHelpers()", func() {
 		It("Correctly propagates --on-error-continue flag to gpbackup_helper", func() {
 			wasTerminated := false
-			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "/tmp/pluginConfigFile.yml", " compressStr", true, false, &wasTerminated, 1, true, false, 0, 0)
+			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "/tmp/pluginConfigFile.yml", " compressStr", true, false, &wasTerminated, 1, true, false, 0, 0, 0)
 
 			cc := testExecutor.ClusterCommands[0]
 			Expect(cc[1].CommandString).To(ContainSubstring(" --on-error-continue"))
 		})
 		It("Correctly propagates --copy-queue-size value to gpbackup_helper", func() {
 			wasTerminated := false
-			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "/tmp/pluginConfigFile.yml", " compressStr", false, false, &wasTerminated, 4, true, false, 0, 0)
+			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "/tmp/pluginConfigFile.yml", " compressStr", false, false, &wasTerminated, 4, true, false, 0, 0, 0)
 
 			cc := testExecutor.ClusterCommands[0]
 			Expect(cc[1].CommandString).To(ContainSubstring(" --copy-queue-size 4"))
 		})
+		It("Passes --offload-host only to the agents of segments offloaded to their mirrors", func() {
+			wasTerminated := false
+			utils.SetHelperOffloadHosts(map[int]string{1: "localhost"})
+			defer utils.SetHelperOffloadHosts(map[int]string{})
+			utils.StartGpbackupHelpers(testCluster, fpInfo, "--backup-agent", "", " compressStr", false, false, &wasTerminated, 1, true, false, 0, 0, 0)
+
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc[0].CommandString).ToNot(ContainSubstring(" --offload-host"))
+			Expect(cc[1].CommandString).To(ContainSubstring(" --offload-host localhost"))
+		})
+	})
+	Describe("cancelling agents over the control channel", func() {
+		var client *utils.HelperControlClient
+		var received chan utils.ControlMessage
+		var control []string
+		BeforeEach(func() {
+			utils.StartHelperControlServer(testCluster)
+			DeferCleanup(utils.StopHelperControlServer)
+			// The secret file is copied to the segments with rsync, which the test executor only records
+			if !utils.CommandExists("rsync") {
+				binDir, err := os.MkdirTemp("", "rsync_bin")
+				Expect(err).ToNot(HaveOccurred())
+				DeferCleanup(os.RemoveAll, binDir)
+				Expect(os.WriteFile(binDir+"/rsync", []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+				DeferCleanup(os.Setenv, "PATH", os.Getenv("PATH"))
+				Expect(os.Setenv("PATH", binDir+":"+os.Getenv("PATH"))).To(Succeed())
+			}
+			wasTerminated := false
+			utils.StartGpbackupHelpers(testCluster, fpInfo, "--backup-agent", "", " compressStr", false, false, &wasTerminated, 1, true, false, 0, 0, 0)
+			control = regexp.MustCompile(`--control-address (\S+) --control-secret-file (\S+)`).FindStringSubmatch(testExecutor.ClusterCommands[1][0].CommandString)
+			Expect(control).To(HaveLen(3))
+			// The secret is written to the mocked file buffer before it is copied to the segments
+			control[2] = strings.TrimSpace(string(buffer.Contents()))
+			Expect(control[2]).ToNot(BeEmpty())
+
+			// Only the agent of content 0 connects
+			received = make(chan utils.ControlMessage, 10)
+			start := utils.ControlMessage{Content: 0, Agent: fpInfo.GetSegmentPipeFilePath(0), Host: "localhost", Secret: control[2]}
+			var err error
+			client, err = utils.DialHelperControl(control[1], start, func(message utils.ControlMessage) {
+				received <- message
+			})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(client.Close)
+			// Messages of an agent are handled in order, so its table error shows it has connected
+			Expect(client.Send(utils.ControlMessage{Type: utils.CONTROL_TABLE_ERROR, Record: &utils.AgentError{Message: "copy failed"}})).To(Succeed())
+			Eventually(utils.GetAgentErrors).ShouldNot(BeEmpty())
+			utils.ResetAgentErrors()
+		})
+		It("writes the secret of the control channel to a file readable only by the user instead of the command line", func() {
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc).To(HaveLen(2))
+			for _, command := range cc {
+				Expect(command.CommandString).To(MatchRegexp(`rsync -e ssh --perms --chmod=F600 .*/gpbackup-oids.* \S+:\S+_control_secret_\d+$`))
+			}
+			Expect(cc[0].CommandString).To(HaveSuffix(":" + fpInfo.GetSegmentHelperFilePath(0, "control_secret")))
+			for _, command := range testExecutor.ClusterCommands[1] {
+				Expect(command.CommandString).ToNot(ContainSubstring(control[2]))
+			}
+		})
+		It("kills over SSH only the agents that could not be cancelled through the control channel", func() {
+			utils.CleanUpSegmentHelperProcesses(testCluster, fpInfo, "backup")
+
+			Eventually(received).Should(Receive(HaveField("Type", utils.CONTROL_CANCEL)))
+			cc := testExecutor.ClusterCommands[len(testExecutor.ClusterCommands)-1]
+			Expect(cc).To(HaveLen(1))
+			Expect(cc[0].Content).To(Equal(1))
+			Expect(cc[0].CommandString).To(ContainSubstring("gpbackup_helper --backup-agent --toc-file /data/gpseg1/"))
+		})
+		It("cancels agents that were told to stop but do not stop in time", func() {
+			originalTimeout := utils.HelperStopTimeout
+			utils.HelperStopTimeout = 100 * time.Millisecond
+			defer func() { utils.HelperStopTimeout = originalTimeout }()
+			start := utils.ControlMessage{Content: 1, Agent: fpInfo.GetSegmentPipeFilePath(1), Host: "remotehost1", Secret: control[2]}
+			otherClient, err := utils.DialHelperControl(control[1], start, func(utils.ControlMessage) {})
+			Expect(err).ToNot(HaveOccurred())
+			defer otherClient.Close()
+			Eventually(func() bool { return utils.AllHelpersConnected(testCluster, fpInfo) }).Should(BeTrue())
+
+			err = utils.StopGpbackupHelpers(testCluster, fpInfo)
+
+			Expect(err).To(MatchError("gpbackup_helper agents did not stop within 100ms"))
+			Eventually(received).Should(Receive(HaveField("Type", utils.CONTROL_STOP)))
+			Eventually(received).Should(Receive(HaveField("Type", utils.CONTROL_CANCEL)))
+		})
+		It("returns once the agents told to stop have finished", func() {
+			start := utils.ControlMessage{Content: 1, Agent: fpInfo.GetSegmentPipeFilePath(1), Host: "remotehost1", Secret: control[2]}
+			otherClient, err := utils.DialHelperControl(control[1], start, func(utils.ControlMessage) {})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(func() bool { return utils.AllHelpersConnected(testCluster, fpInfo) }).Should(BeTrue())
+			go func() {
+				defer GinkgoRecover()
+				Eventually(received).Should(Receive(HaveField("Type", utils.CONTROL_STOP)))
+				Expect(client.Send(utils.ControlMessage{Type: utils.CONTROL_DONE})).To(Succeed())
+				Expect(otherClient.Send(utils.ControlMessage{Type: utils.CONTROL_DONE})).To(Succeed())
+				otherClient.Close()
+			}()
+
+			Expect(utils.StopGpbackupHelpers(testCluster, fpInfo)).To(Succeed())
+		})
+		It("waits for the canceled agents to exit, discards their errors, and removes their files", func() {
+			go func() {
+				defer GinkgoRecover()
+				Eventually(received).Should(Receive(HaveField("Type", utils.CONTROL_CANCEL)))
+				Expect(client.Send(utils.ControlMessage{Type: utils.CONTROL_ERROR, Record: &utils.AgentError{Message: "Terminated by a signal or cancel request"}})).To(Succeed())
+				client.Close()
+			}()
+
+			Expect(utils.CancelSegmentHelpersAndWait(testCluster, fpInfo, "backup")).To(BeTrue())
+
+			Expect(utils.CheckAgentErrorsOnSegments(testCluster, fpInfo)).To(Succeed())
+			var removedFiles bool
+			for _, commands := range testExecutor.ClusterCommands {
+				if len(commands) > 0 && regexp.MustCompile(`rm -f .*_error && rm -f .*_oid`).MatchString(commands[0].CommandString) {
+					removedFiles = true
+				}
+			}
+			Expect(removedFiles).To(BeTrue())
+		})
+	})
+	Describe("GetSourceList", func() {
+		It("lists the host and backup directory of each segment of the source cluster in content order", func() {
+			sourceCluster := cluster.NewCluster([]cluster.SegConfig{
+				{ContentID: -1, Hostname: "sourcehost", DataDir: "/source/gpseg-1"},
+				{ContentID: 1, Hostname: "sourcesdw2", DataDir: "/source/gpseg1"},
+				{ContentID: 0, Hostname: "sourcesdw1", DataDir: "/source/gpseg0"},
+			})
+			sourceFPInfo := filepath.NewFilePathInfo(sourceCluster, "", "11112233445566", "gpseg")
+
+			sourceList := utils.GetSourceList(sourceFPInfo)
+
+			Expect(sourceList).To(Equal([]string{
+				"0\tsourcesdw1\t/source/gpseg0/backups/11112233/11112233445566",
+				"1\tsourcesdw2\t/source/gpseg1/backups/11112233/11112233445566",
+			}))
+		})
+	})
+	Describe("GetMirrorOffloadHosts", func() {
+		It("returns the hosts of mirrors that are up on another segment host", func() {
+			mirrorRows := sqlmock.NewRows([]string{"content", "hostname"}).
+				AddRow(0, "remotehost1").
+				AddRow(1, "mirrorhost")
+			mock.ExpectQuery("SELECT content, hostname").WillReturnRows(mirrorRows)
+
+			hosts := utils.GetMirrorOffloadHosts(connectionPool, testCluster)
+
+			Expect(hosts).To(Equal(map[int]string{0: "remotehost1"}))
+		})
+		It("skips mirrors on the host of their primary", func() {
+			mirrorRows := sqlmock.NewRows([]string{"content", "hostname"}).
+				AddRow(0, "localhost").
+				AddRow(1, "remotehost1")
+			mock.ExpectQuery("SELECT content, hostname").WillReturnRows(mirrorRows)
+
+			hosts := utils.GetMirrorOffloadHosts(connectionPool, testCluster)
+
+			Expect(hosts).To(BeEmpty())
+		})
 	})
 	Describe("CheckAgentErrorsOnSegments", func() {
+		BeforeEach(func() {
+			DeferCleanup(utils.ResetAgentErrors)
+		})
 		It("constructs the correct ssh call to check for the existance of an error file on each segment", func() {
 			err := utils.CheckAgentErrorsOnSegments(testCluster, fpInfo)
 			Expect(err).ToNot(HaveOccurred())
 
 			cc := testExecutor.ClusterCommands[0]
 			errorFile0 := fmt.Sprintf(`/data/gpseg0/gpbackup_0_11112233445566_pipe_%d_error`, fpInfo.PID)
-			expectedCmd0 := fmt.Sprintf(`if [[ -f %[1]s ]]; then echo 'error'; fi; rm -f %[1]s`, errorFile0)
+			expectedCmd0 := fmt.Sprintf(`if [[ -f %[1]s ]]; then echo 'error'; cat %[1]s; fi; rm -f %[1]s`, errorFile0)
 			Expect(cc[0].CommandString).To(ContainSubstring(expectedCmd0))
 
 			errorFile1 := fmt.Sprintf(`/data/gpseg1/gpbackup_1_11112233445566_pipe_%d_error`, fpInfo.PID)
-			expectedCmd1 := fmt.Sprintf(`if [[ -f %[1]s ]]; then echo 'error'; fi; rm -f %[1]s`, errorFile1)
+			expectedCmd1 := fmt.Sprintf(`if [[ -f %[1]s ]]; then echo 'error'; cat %[1]s; fi; rm -f %[1]s`, errorFile1)
 			Expect(cc[1].CommandString).To(ContainSubstring(expectedCmd1))
 		})
+		It("returns the error records of the agents, with the names of their tables", func() {
+			utils.SetHelperTableNames(map[int]string{16384: "public.foo"})
+			remoteOutput.Commands = []cluster.ShellCommand{
+				{Stdout: ""},
+				{Stdout: "error\n" + `{"content":1,"oid":16384,"phase":"plugin","message":"exit status 1","detail":"bucket not found"}` + "\n"},
+			}
+			err := utils.CheckAgentErrorsOnSegments(testCluster, fpInfo)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("segment 1 on host remotehost1, table public.foo, plugin phase: exit status 1 (output: bucket not found)"))
+			Expect(utils.GetAgentErrors()).To(ContainElement(HaveField("Table", "public.foo")))
+		})
+		It("forgets the names of tables once the errors are reset", func() {
+			utils.SetHelperTableNames(map[int]string{16384: "public.foo"})
+			utils.ResetAgentErrors()
+			remoteOutput.Commands = []cluster.ShellCommand{
+				{Stdout: ""},
+				{Stdout: "error\n" + `{"content":1,"oid":16384,"message":"exit status 1"}` + "\n"},
+			}
+			err := utils.CheckAgentErrorsOnSegments(testCluster, fpInfo)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("segment 1 on host remotehost1, table with oid 16384: exit status 1"))
+		})
+		It("returns an error naming the helper log for an error file without records", func() {
+			remoteOutput.Commands = []cluster.ShellCommand{{Stdout: "error\n"}, {Stdout: ""}}
+			err := utils.CheckAgentErrorsOnSegments(testCluster, fpInfo)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("segment 0 on host localhost: see " + fpInfo.GetHelperLogPath()))
+		})
 
 	})
 })
@@ -0,0 +1,155 @@
+package utils
+
+/*
+ * This file contains the tracking of the COPY commands that the workers of
+ * gpbackup and gprestore run, so that exactly those commands can be canceled
+ * when the utility is terminated or a gpbackup_helper agent stalls.  Each COPY
+ * starts with a comment naming its worker and table, which pg_stat_activity
+ * shows along with the backend PID of the worker's connection.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+)
+
+type copySession struct {
+	worker int
+	oid    uint32
+	marker string
+}
+
+var (
+	copySessionMutex   sync.Mutex
+	copySessionAppName string
+	// The backend PID of the connection of each worker
+	copyWorkerPids = make(map[int]int)
+	// The COPY that each worker is running, if any
+	activeCopySessions = make(map[int]copySession)
+)
+
+/*
+ * Records the backend PID of each connection of the pool, which must be done
+ * before any COPY is started.  The application name is part of the markers,
+ * so that COPY commands of concurrent backups and restores differ.
+ */
+func TrackCopyWorkers(connectionPool *dbconn.DBConn, appName string) error {
+	pids := make(map[int]int, connectionPool.NumConns)
+	for whichConn := 0; whichConn < connectionPool.NumConns; whichConn++ {
+		var pid int
+		err := connectionPool.Get(&pid, "SELECT pg_backend_pid()", whichConn)
+		if err != nil {
+			return err
+		}
+		pids[whichConn] = pid
+	}
+	copySessionMutex.Lock()
+	defer copySessionMutex.Unlock()
+	copySessionAppName = appName
+	copyWorkerPids = pids
+	activeCopySessions = make(map[int]copySession)
+	return nil
+}
+
+// Returns the comment that starts the COPY of a table by a worker
+func CopySessionMarker(appName string, whichConn int, oid uint32) string {
+	return fmt.Sprintf("/* %s worker %d table %d */", appName, whichConn, oid)
+}
+
+/*
+ * Records that the worker is starting the COPY of a table, and returns the
+ * marker that the COPY command must start with.  EndCopySession must be
+ * called once the COPY is done.
+ */
+func StartCopySession(whichConn int, oid uint32) string {
+	copySessionMutex.Lock()
+	defer copySessionMutex.Unlock()
+	marker := CopySessionMarker(copySessionAppName, whichConn, oid)
+	activeCopySessions[whichConn] = copySession{worker: whichConn, oid: oid, marker: marker}
+	return marker
+}
+
+func EndCopySession(whichConn int) {
+	copySessionMutex.Lock()
+	defer copySessionMutex.Unlock()
+	delete(activeCopySessions, whichConn)
+}
+
+/*
+ * Returns the COPY commands in progress whose table matches the filter, sorted
+ * by worker.  Workers whose PID is unknown are left out, as their backend
+ * cannot be targeted.
+ */
+func getActiveCopySessions(filter func(copySession) bool) ([]copySession, []int) {
+	copySessionMutex.Lock()
+	defer copySessionMutex.Unlock()
+	sessions := make([]copySession, 0)
+	for _, session := range activeCopySessions {
+		if _, ok := copyWorkerPids[session.worker]; ok && filter(session) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i int, j int) bool {
+		return sessions[i].worker < sessions[j].worker
+	})
+	pids := make([]int, len(sessions))
+	for i, session := range sessions {
+		pids[i] = copyWorkerPids[session.worker]
+	}
+	return sessions, pids
+}
+
+/*
+ * Runs the signal function on the backends of the given COPY commands.  A
+ * backend only matches while its query starts with the marker of the COPY, so
+ * a worker that has moved on to another statement in the meantime is left
+ * alone.
+ */
+func signalCopySessions(connectionPool *dbconn.DBConn, function string, sessions []copySession, pids []int) {
+	if len(sessions) == 0 {
+		return
+	}
+	pidColumn, queryColumn := "pid", "query"
+	if connectionPool.Version.Before("6") {
+		pidColumn, queryColumn = "procpid", "current_query"
+	}
+	conditions := make([]string, len(sessions))
+	for i, session := range sessions {
+		conditions[i] = fmt.Sprintf("(%s = %d AND %s LIKE '%s%%')", pidColumn, pids[i], queryColumn, EscapeSingleQuotes(session.marker))
+	}
+	query := fmt.Sprintf(`SELECT
+	%[1]s(%[2]s)
+FROM pg_stat_activity
+WHERE (%[3]s)
+AND %[2]s <> pg_backend_pid()`, function, pidColumn, strings.Join(conditions, "\n\tOR "))
+	// We don't check the error as the COPY may have finished or been previously terminated
+	_, _ = connectionPool.Exec(query)
+}
+
+/*
+ * Terminates the sessions of all COPY commands in progress, for when the
+ * utility is terminated and a COPY could otherwise be left hanging on a pipe or
+ * program that is no longer read or written.
+ */
+func TerminateCopySessions(connectionPool *dbconn.DBConn) {
+	sessions, pids := getActiveCopySessions(func(copySession) bool { return true })
+	if len(sessions) > 0 {
+		gplog.Verbose("Terminating %d COPY sessions in progress", len(sessions))
+	}
+	signalCopySessions(connectionPool, "pg_terminate_backend", sessions, pids)
+}
+
+/*
+ * Cancels the COPY of a single table, such as one whose gpbackup_helper agent
+ * stalled, so that the table fails instead of hanging.  Unlike
+ * TerminateCopySessions, the connection of the worker is kept.
+ */
+func CancelCopySessionsForTable(connectionPool *dbconn.DBConn, oid uint32) {
+	sessions, pids := getActiveCopySessions(func(session copySession) bool { return session.oid == oid })
+	signalCopySessions(connectionPool, "pg_cancel_backend", sessions, pids)
+}
@@ -0,0 +1,66 @@
+package utils_test
+
+import (
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/copy_session tests", func() {
+	BeforeEach(func() {
+		testhelper.SetDBVersion(connectionPool, "6.0.0")
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_backend_pid()")).WillReturnRows(sqlmock.NewRows([]string{"pg_backend_pid"}).AddRow(4321))
+		Expect(utils.TrackCopyWorkers(connectionPool, "gpbackup_20230101000000")).To(Succeed())
+	})
+
+	Describe("StartCopySession", func() {
+		It("returns a marker naming the worker and the table", func() {
+			marker := utils.StartCopySession(0, 16384)
+			defer utils.EndCopySession(0)
+			Expect(marker).To(Equal("/* gpbackup_20230101000000 worker 0 table 16384 */"))
+		})
+	})
+	Describe("CancelCopySessionsForTable", func() {
+		It("cancels the backend of the worker copying the table", func() {
+			utils.StartCopySession(0, 16384)
+			defer utils.EndCopySession(0)
+			mock.ExpectExec(regexp.QuoteMeta(`SELECT
+	pg_cancel_backend(pid)
+FROM pg_stat_activity
+WHERE ((pid = 4321 AND query LIKE '/* gpbackup_20230101000000 worker 0 table 16384 */%'))
+AND pid <> pg_backend_pid()`)).WillReturnResult(sqlmock.NewResult(0, 1))
+			utils.CancelCopySessionsForTable(connectionPool, 16384)
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("uses the column names of GPDB 5 and earlier", func() {
+			testhelper.SetDBVersion(connectionPool, "5.1.0")
+			utils.StartCopySession(0, 16384)
+			defer utils.EndCopySession(0)
+			mock.ExpectExec(regexp.QuoteMeta(`SELECT
+	pg_cancel_backend(procpid)
+FROM pg_stat_activity
+WHERE ((procpid = 4321 AND current_query LIKE '/* gpbackup_20230101000000 worker 0 table 16384 */%'))
+AND procpid <> pg_backend_pid()`)).WillReturnResult(sqlmock.NewResult(0, 1))
+			utils.CancelCopySessionsForTable(connectionPool, 16384)
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("TerminateCopySessions", func() {
+		It("terminates the backends of the COPY commands in progress", func() {
+			utils.StartCopySession(0, 16384)
+			defer utils.EndCopySession(0)
+			mock.ExpectExec(regexp.QuoteMeta(`SELECT
+	pg_terminate_backend(pid)
+FROM pg_stat_activity
+WHERE ((pid = 4321 AND query LIKE '/* gpbackup_20230101000000 worker 0 table 16384 */%'))
+AND pid <> pg_backend_pid()`)).WillReturnResult(sqlmock.NewResult(0, 1))
+			utils.TerminateCopySessions(connectionPool)
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,454 @@
+package utils
+
+/*
+ * This file contains the control channel between gpbackup or gprestore and
+ * their gpbackup_helper agents.  The coordinator listens on a TCP port and
+ * each agent connects to it when it starts, presenting the secret of the run
+ * it was given on its command line, after which they exchange one
+ * JSON message per line: agents report their progress, errors, and
+ * completion, and the coordinator tells them to skip tables or to stop.
+ * Agents that cannot connect are handled through files over SSH instead.
+ */
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/pkg/errors"
+)
+
+// Types of control messages
+const (
+	// Sent by agents
+	CONTROL_START       = "start"
+	CONTROL_PROGRESS    = "progress"
+	CONTROL_HEARTBEAT   = "heartbeat"
+	CONTROL_TABLE_ERROR = "table_error"
+	CONTROL_ERROR       = "error"
+	CONTROL_DONE        = "done"
+	// Sent by the coordinator
+	CONTROL_SKIP   = "skip"
+	CONTROL_CANCEL = "cancel"
+	// Tells backup agents to finish the table in progress and stop
+	CONTROL_STOP = "stop"
+)
+
+// What an agent is doing, as reported in heartbeats and error records
+const (
+	PHASE_SETUP   = "setup"
+	PHASE_PIPE    = "pipe"
+	PHASE_COPY    = "copy"
+	PHASE_PLUGIN  = "plugin"
+	PHASE_TOC     = "toc"
+	PHASE_CLEANUP = "cleanup"
+)
+
+const controlWriteTimeout = 5 * time.Second
+
+type ControlMessage struct {
+	Type    string `json:"type"`
+	Content int    `json:"content"`
+	// The pipe file of the agent, which identifies it among the agents of
+	// the backups of a restore plan
+	Agent string `json:"agent,omitempty"`
+	Host  string `json:"host,omitempty"`
+	// The secret of the run, which agents present in their start message
+	Secret string `json:"secret,omitempty"`
+	Oid    int    `json:"oid,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	// The phase, total bytes moved, and Unix time of the agent in heartbeats
+	Phase string `json:"phase,omitempty"`
+	Time  int64  `json:"time,omitempty"`
+	// The error record of error messages
+	Record *AgentError `json:"record,omitempty"`
+}
+
+/*
+ * An error reported by an agent, which agents also write to their error file
+ * as one JSON record per line.  Table errors are those an agent continued
+ * past with --on-error-continue; other errors stopped the agent.
+ */
+type AgentError struct {
+	Content int    `json:"content"`
+	Host    string `json:"host,omitempty"`
+	Oid     int    `json:"oid,omitempty"`
+	// Filled in by the coordinator from the oid
+	Table string `json:"-"`
+	// What the agent was doing, e.g. "copy" or "plugin"
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message"`
+	// Output of the plugin or compression program, if any
+	Detail     string `json:"detail,omitempty"`
+	TableError bool   `json:"table_error,omitempty"`
+}
+
+func (agentError AgentError) String() string {
+	description := fmt.Sprintf("segment %d", agentError.Content)
+	if agentError.Host != "" {
+		description += fmt.Sprintf(" on host %s", agentError.Host)
+	}
+	if agentError.Table != "" {
+		description += fmt.Sprintf(", table %s", agentError.Table)
+	} else if agentError.Oid != 0 {
+		description += fmt.Sprintf(", table with oid %d", agentError.Oid)
+	}
+	if agentError.Phase != "" {
+		description += fmt.Sprintf(", %s phase", agentError.Phase)
+	}
+	description = fmt.Sprintf("%s: %s", description, agentError.Message)
+	if agentError.Detail != "" {
+		description += fmt.Sprintf(" (output: %s)", agentError.Detail)
+	}
+	return description
+}
+
+type helperConnection struct {
+	conn    net.Conn
+	content int
+	host    string
+	done    bool
+	failed  bool
+	closed  bool
+	// The latest heartbeat of the agent, for stall detection
+	oid           int
+	phase         string
+	bytes         int64
+	lastHeard     time.Time
+	lastProgress  time.Time
+	stallReported bool
+}
+
+// Records a heartbeat or other sign of life, and whether the agent made progress since the last one
+func (connection *helperConnection) update(message ControlMessage) {
+	now := time.Now()
+	connection.lastHeard = now
+	switch message.Type {
+	case CONTROL_HEARTBEAT:
+		if message.Oid == connection.oid && message.Phase == connection.phase && message.Bytes == connection.bytes {
+			return
+		}
+		connection.oid = message.Oid
+		connection.phase = message.Phase
+		connection.bytes = message.Bytes
+	case CONTROL_START, CONTROL_PROGRESS:
+	default:
+		return
+	}
+	connection.lastProgress = now
+	connection.stallReported = false
+}
+
+type HelperControlServer struct {
+	listener net.Listener
+	address  string
+	secret   string
+	mutex    sync.Mutex
+	// Closed when the server is closed, to stop its stall monitor
+	closed      chan struct{}
+	closeOnce   sync.Once
+	monitorOnce sync.Once
+	// Keyed by the pipe file of each agent
+	connections map[string]*helperConnection
+	// Errors that stopped agents, until they are taken by TakeErrors
+	errors []AgentError
+	// Errors of tables that agents continued past
+	tableErrors []AgentError
+}
+
+/*
+ * Listens for agents on an ephemeral port of the given host, which is the
+ * address agents reach it through.  Only agents that present the secret of
+ * the server are accepted, so that other processes that can reach the port
+ * cannot pose as agents.
+ */
+func NewHelperControlServer(host string) (*HelperControlServer, error) {
+	secretBytes := make([]byte, 16)
+	_, err := rand.Read(secretBytes)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	server := &HelperControlServer{
+		listener:    listener,
+		address:     net.JoinHostPort(host, strconv.Itoa(port)),
+		secret:      hex.EncodeToString(secretBytes),
+		connections: make(map[string]*helperConnection),
+		closed:      make(chan struct{}),
+	}
+	go server.acceptConnections()
+	return server, nil
+}
+
+func (server *HelperControlServer) Address() string {
+	return server.address
+}
+
+// The secret agents are given on their command line and present when they connect
+func (server *HelperControlServer) Secret() string {
+	return server.secret
+}
+
+func (server *HelperControlServer) Close() {
+	server.closeOnce.Do(func() { close(server.closed) })
+	_ = server.listener.Close()
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, connection := range server.connections {
+		_ = connection.conn.Close()
+	}
+}
+
+func (server *HelperControlServer) acceptConnections() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return // The listener was closed
+		}
+		go server.handleConnection(conn)
+	}
+}
+
+func (server *HelperControlServer) handleConnection(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var connection *helperConnection
+	for scanner.Scan() {
+		var message ControlMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			gplog.Verbose("Ignoring malformed message from gpbackup_helper agent: %v", err)
+			continue
+		}
+		if connection == nil && (message.Type != CONTROL_START || subtle.ConstantTimeCompare([]byte(message.Secret), []byte(server.secret)) != 1) {
+			gplog.Verbose("Rejecting a connection to the control channel from %s without the secret of this run", conn.RemoteAddr())
+			_ = conn.Close()
+			return
+		}
+		server.mutex.Lock()
+		switch message.Type {
+		case CONTROL_START:
+			connection = &helperConnection{conn: conn, content: message.Content, host: message.Host}
+			server.connections[message.Agent] = connection
+			gplog.Debug("gpbackup_helper agent on segment %d connected to the control channel", message.Content)
+		case CONTROL_PROGRESS:
+			gplog.Debug("gpbackup_helper agent on segment %d processed %d bytes of table with oid %d", message.Content, message.Bytes, message.Oid)
+		case CONTROL_HEARTBEAT:
+			gplog.Debug("gpbackup_helper agent on segment %d is in the %s phase of table with oid %d, %d bytes moved", message.Content, message.Phase, message.Oid, message.Bytes)
+		case CONTROL_TABLE_ERROR, CONTROL_ERROR:
+			if message.Record == nil {
+				break
+			}
+			agentError := *message.Record
+			agentError.Content = message.Content
+			agentError.Host = message.Host
+			agentError.Table = getHelperTableName(agentError.Oid)
+			agentError.TableError = message.Type == CONTROL_TABLE_ERROR
+			if agentError.TableError {
+				server.tableErrors = append(server.tableErrors, agentError)
+			} else {
+				server.errors = append(server.errors, agentError)
+				if connection != nil {
+					connection.failed = true
+				}
+			}
+			gplog.Verbose("gpbackup_helper agent reported an error on %s", agentError)
+		case CONTROL_DONE:
+			if connection != nil {
+				connection.done = true
+			}
+		}
+		if connection != nil {
+			connection.update(message)
+		}
+		server.mutex.Unlock()
+	}
+	_ = conn.Close()
+	if connection == nil {
+		return
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	connection.closed = true
+	if !connection.done && !connection.failed {
+		server.errors = append(server.errors, AgentError{
+			Content: connection.content,
+			Host:    connection.host,
+			Message: "gpbackup_helper exited without reporting completion",
+		})
+	}
+}
+
+// Returns whether every given agent has connected, so it can be reached without SSH
+func (server *HelperControlServer) AllConnected(agents []string) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, agent := range agents {
+		if _, ok := server.connections[agent]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the given agents that never connected
+func (server *HelperControlServer) UnconnectedAgents(agents []string) []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	unconnected := make([]string, 0)
+	for _, agent := range agents {
+		if _, ok := server.connections[agent]; !ok {
+			unconnected = append(unconnected, agent)
+		}
+	}
+	return unconnected
+}
+
+// Returns whether every given agent has completed, failed, or disconnected
+func (server *HelperControlServer) Finished(agents []string) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, agent := range agents {
+		connection, ok := server.connections[agent]
+		if !ok || !(connection.done || connection.failed || connection.closed) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * Sends the message to each of the given agents that is still connected, and
+ * returns the agents it could not be sent to.
+ */
+func (server *HelperControlServer) Send(agents []string, message ControlMessage) []string {
+	contents, err := json.Marshal(message)
+	if err != nil {
+		return agents
+	}
+	contents = append(contents, '\n')
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	unsent := make([]string, 0)
+	for _, agent := range agents {
+		connection, ok := server.connections[agent]
+		if !ok || connection.closed {
+			unsent = append(unsent, agent)
+			continue
+		}
+		_ = connection.conn.SetWriteDeadline(time.Now().Add(controlWriteTimeout))
+		if _, err := connection.conn.Write(contents); err != nil {
+			unsent = append(unsent, agent)
+		}
+	}
+	return unsent
+}
+
+// Returns the errors of tables that agents continued past with --on-error-continue
+func (server *HelperControlServer) TableErrors() []AgentError {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]AgentError{}, server.tableErrors...)
+}
+
+func (server *HelperControlServer) ClearTableErrors() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.tableErrors = make([]AgentError, 0)
+}
+
+// Returns the errors that stopped agents since the last call, by segment
+func (server *HelperControlServer) TakeErrors() []AgentError {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	agentErrors := server.errors
+	server.errors = make([]AgentError, 0)
+	sort.SliceStable(agentErrors, func(i int, j int) bool {
+		return agentErrors[i].Content < agentErrors[j].Content
+	})
+	return agentErrors
+}
+
+/*
+ * The agent side of the control channel
+ */
+
+type HelperControlClient struct {
+	conn  net.Conn
+	start ControlMessage
+	mutex sync.Mutex
+}
+
+/*
+ * Connects to the coordinator and announces the agent with the start message,
+ * which carries the secret of the run.  Messages from the coordinator are
+ * passed to handler until the connection is closed.
+ */
+func DialHelperControl(address string, start ControlMessage, handler func(ControlMessage)) (*HelperControlClient, error) {
+	conn, err := net.DialTimeout("tcp", address, controlWriteTimeout)
+	if err != nil {
+		return nil, err
+	}
+	start.Type = CONTROL_START
+	client := &HelperControlClient{conn: conn, start: start}
+	err = client.Send(start)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var message ControlMessage
+			if err := json.Unmarshal(scanner.Bytes(), &message); err == nil {
+				handler(message)
+			}
+		}
+	}()
+	return client, nil
+}
+
+// Sends the message, filling in the identity of the agent
+func (client *HelperControlClient) Send(message ControlMessage) error {
+	message.Content = client.start.Content
+	message.Agent = client.start.Agent
+	message.Host = client.start.Host
+	contents, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	_ = client.conn.SetWriteDeadline(time.Now().Add(controlWriteTimeout))
+	_, err = client.conn.Write(append(contents, '\n'))
+	return err
+}
+
+func (client *HelperControlClient) Close() {
+	_ = client.conn.Close()
+}
+
+// Formats agent errors for messages such as the one returned by CheckAgentErrorsOnSegments
+func FormatAgentErrors(agentErrors []AgentError) error {
+	if len(agentErrors) == 0 {
+		return nil
+	}
+	descriptions := make([]string, 0, len(agentErrors))
+	for _, agentError := range agentErrors {
+		descriptions = append(descriptions, "\t"+agentError.String())
+	}
+	return errors.Errorf("Encountered %d error(s) with helper agent(s):\n%s", len(agentErrors), strings.Join(descriptions, "\n"))
+}
@@ -0,0 +1,111 @@
+package utils_test
+
+import (
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/helper_control tests", func() {
+	var server *utils.HelperControlServer
+	agent := "/data/gpseg0/gpbackup_0_20230101000000_pipe"
+	start := utils.ControlMessage{Content: 0, Agent: agent, Host: "sdw1"}
+
+	BeforeEach(func() {
+		var err error
+		server, err = utils.NewHelperControlServer("127.0.0.1")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+		DeferCleanup(utils.ResetAgentErrors)
+	})
+	dial := func(handler func(utils.ControlMessage)) *utils.HelperControlClient {
+		agentStart := start
+		agentStart.Secret = server.Secret()
+		client, err := utils.DialHelperControl(server.Address(), agentStart, handler)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(func() bool { return server.AllConnected([]string{agent}) }).Should(BeTrue())
+		return client
+	}
+
+	It("records the agents that connected", func() {
+		client := dial(func(utils.ControlMessage) {})
+		defer client.Close()
+		Expect(server.UnconnectedAgents([]string{agent, "/data/gpseg1/gpbackup_1_20230101000000_pipe"})).To(Equal([]string{"/data/gpseg1/gpbackup_1_20230101000000_pipe"}))
+	})
+	It("rejects agents that do not present the secret of the run", func() {
+		for _, secret := range []string{"", "not the secret"} {
+			agentStart := start
+			agentStart.Secret = secret
+			client, err := utils.DialHelperControl(server.Address(), agentStart, func(utils.ControlMessage) {})
+			Expect(err).ToNot(HaveOccurred())
+			Consistently(func() bool { return server.AllConnected([]string{agent}) }, "100ms").Should(BeFalse())
+			client.Close()
+		}
+		Expect(server.TakeErrors()).To(BeEmpty())
+	})
+	It("listens on the given host", func() {
+		Expect(server.Address()).To(HavePrefix("127.0.0.1:"))
+		Expect(server.Secret()).To(HaveLen(32))
+	})
+	It("reports the segment, host, table, and text of agent errors", func() {
+		utils.SetHelperTableNames(map[int]string{16384: "public.foo"})
+		client := dial(func(utils.ControlMessage) {})
+		defer client.Close()
+		Expect(client.Send(utils.ControlMessage{Type: utils.CONTROL_ERROR, Record: &utils.AgentError{Oid: 16384, Message: "plugin failed"}})).To(Succeed())
+
+		var agentErrors []utils.AgentError
+		Eventually(func() int {
+			agentErrors = append(agentErrors, server.TakeErrors()...)
+			return len(agentErrors)
+		}).Should(Equal(1))
+		Expect(agentErrors[0].String()).To(Equal("segment 0 on host sdw1, table public.foo: plugin failed"))
+		Expect(server.TakeErrors()).To(BeEmpty())
+	})
+	It("keeps table errors apart from errors that stopped agents", func() {
+		client := dial(func(utils.ControlMessage) {})
+		Expect(client.Send(utils.ControlMessage{Type: utils.CONTROL_TABLE_ERROR, Record: &utils.AgentError{Oid: 16384, Message: "copy failed"}})).To(Succeed())
+		Expect(client.Send(utils.ControlMessage{Type: utils.CONTROL_DONE})).To(Succeed())
+		client.Close()
+
+		Eventually(func() int { return len(server.TableErrors()) }).Should(Equal(1))
+		Expect(server.TableErrors()[0].String()).To(Equal("segment 0 on host sdw1, table with oid 16384: copy failed"))
+		Consistently(server.TakeErrors, "100ms").Should(BeEmpty())
+	})
+	It("reports an error for an agent that exits without reporting completion", func() {
+		client := dial(func(utils.ControlMessage) {})
+		client.Close()
+		Eventually(func() int { return len(server.TakeErrors()) }).Should(Equal(1))
+	})
+	It("sends skip and cancel messages to agents", func() {
+		received := make(chan utils.ControlMessage, 2)
+		client := dial(func(message utils.ControlMessage) { received <- message })
+		defer client.Close()
+
+		Expect(server.Send([]string{agent}, utils.ControlMessage{Type: utils.CONTROL_SKIP, Oid: 16384})).To(BeEmpty())
+		Expect(server.Send([]string{agent}, utils.ControlMessage{Type: utils.CONTROL_CANCEL})).To(BeEmpty())
+		Eventually(received).Should(Receive(Equal(utils.ControlMessage{Type: utils.CONTROL_SKIP, Oid: 16384})))
+		Eventually(received).Should(Receive(Equal(utils.ControlMessage{Type: utils.CONTROL_CANCEL})))
+	})
+	It("reports agents as finished once they complete", func() {
+		client := dial(func(utils.ControlMessage) {})
+		defer client.Close()
+		Expect(server.Finished([]string{agent})).To(BeFalse())
+
+		Expect(client.Send(utils.ControlMessage{Type: utils.CONTROL_DONE})).To(Succeed())
+		Eventually(func() bool { return server.Finished([]string{agent}) }).Should(BeTrue())
+		Expect(server.Finished([]string{agent, "/data/gpseg1/gpbackup_1_20230101000000_pipe"})).To(BeFalse())
+	})
+	It("returns the agents a message could not be sent to", func() {
+		Expect(server.Send([]string{agent}, utils.ControlMessage{Type: utils.CONTROL_CANCEL})).To(Equal([]string{agent}))
+	})
+	Describe("FormatAgentErrors", func() {
+		It("returns nil without errors", func() {
+			Expect(utils.FormatAgentErrors(nil)).To(BeNil())
+		})
+		It("lists each error", func() {
+			err := utils.FormatAgentErrors([]utils.AgentError{{Content: 1, Host: "sdw1", Message: "disk full"}, {Content: 2, Oid: 5, Message: "broken pipe"}})
+			Expect(err.Error()).To(Equal("Encountered 2 error(s) with helper agent(s):\n\tsegment 1 on host sdw1: disk full\n\tsegment 2, table with oid 5: broken pipe"))
+		})
+	})
+})
@@ -0,0 +1,188 @@
+package utils
+
+/*
+ * This file contains the detection of stalled gpbackup_helper agents, which
+ * report what they are doing over the control channel in heartbeats.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/pkg/errors"
+)
+
+// How often agents send heartbeats
+const HELPER_HEARTBEAT_INTERVAL = 5 * time.Second
+
+var (
+	// Oids of the tables whose COPY CancelStalledCopy canceled, until TakeStalledCopy takes them
+	stalledCopies    = make(map[uint32]bool)
+	stalledCopyMutex sync.Mutex
+)
+
+type HelperStall struct {
+	// The pipe file of the agent
+	Agent   string
+	Content int
+	Host    string
+	Oid     int
+	Table   string
+	Phase   string
+	Bytes   int64
+	// How long the agent has made no progress
+	Duration time.Duration
+	// Whether the agent stopped sending heartbeats altogether
+	Silent bool
+}
+
+func (stall HelperStall) String() string {
+	description := fmt.Sprintf("segment %d", stall.Content)
+	if stall.Host != "" {
+		description += fmt.Sprintf(" on host %s", stall.Host)
+	}
+	if stall.Silent {
+		return fmt.Sprintf("gpbackup_helper agent on %s has sent no heartbeat for %s", description, stall.Duration)
+	}
+	table := stall.Table
+	if table == "" {
+		table = fmt.Sprintf("with oid %d", stall.Oid)
+	}
+	if stall.Phase == PHASE_PIPE {
+		return fmt.Sprintf("gpbackup_helper agent on %s has waited for the COPY of table %s to open its pipe for %s", description, table, stall.Duration)
+	}
+	return fmt.Sprintf("gpbackup_helper agent on %s has made no progress on table %s for %s (%s phase, %d bytes moved)",
+		description, table, stall.Duration, stall.Phase, stall.Bytes)
+}
+
+/*
+ * Parses the value of --stall-timeout.  An empty string means that stalls are
+ * not detected and returns 0.  Shorter timeouts than two heartbeats are
+ * rejected, as they would report agents that are only between heartbeats.
+ */
+func ParseStallTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 2*HELPER_HEARTBEAT_INTERVAL {
+		return 0, errors.Errorf("--stall-timeout %s is invalid. Must be a duration of at least %s, e.g. '10m'", value, 2*HELPER_HEARTBEAT_INTERVAL)
+	}
+	return timeout, nil
+}
+
+/*
+ * Returns the agents that have stopped sending heartbeats, that have moved no
+ * data in the middle of copying a table, or that have waited for the COPY of
+ * a table to open its pipe, for longer than threshold.  Each stall is only
+ * returned once, until the agent makes progress again.
+ */
+func (server *HelperControlServer) StalledAgents(threshold time.Duration, now time.Time) []HelperStall {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	stalls := make([]HelperStall, 0)
+	for agent, connection := range server.connections {
+		if connection.done || connection.failed || connection.closed || connection.stallReported {
+			continue
+		}
+		stall := HelperStall{
+			Agent:   agent,
+			Content: connection.content,
+			Host:    connection.host,
+			Oid:     connection.oid,
+			Table:   getHelperTableName(connection.oid),
+			Phase:   connection.phase,
+			Bytes:   connection.bytes,
+		}
+		if silence := now.Sub(connection.lastHeard); silence > threshold {
+			stall.Duration = silence.Round(time.Second)
+			stall.Silent = true
+		} else if idle := now.Sub(connection.lastProgress); (connection.phase == PHASE_COPY || connection.phase == PHASE_PIPE) && idle > threshold {
+			stall.Duration = idle.Round(time.Second)
+		} else {
+			continue
+		}
+		connection.stallReported = true
+		stalls = append(stalls, stall)
+	}
+	sort.Slice(stalls, func(i int, j int) bool {
+		return stalls[i].Content < stalls[j].Content
+	})
+	return stalls
+}
+
+/*
+ * Checks the agents connected to the control channel for stalls every
+ * heartbeat until the channel is stopped, logging each stall and passing it
+ * to onStall, if set.  Nothing is checked if the threshold is 0 or agents are
+ * coordinated through files only.
+ */
+func StartHelperStallMonitor(threshold time.Duration, onStall func(HelperStall)) {
+	server := helperControl
+	if server == nil || threshold <= 0 {
+		return
+	}
+	// Restores of several backups reuse the channel and its monitor
+	server.monitorOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(HELPER_HEARTBEAT_INTERVAL)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-server.closed:
+					return
+				case now := <-ticker.C:
+					for _, stall := range server.StalledAgents(threshold, now) {
+						gplog.Warn("%s", stall)
+						if onStall != nil {
+							onStall(stall)
+						}
+					}
+				}
+			}
+		}()
+	})
+}
+
+/*
+ * Cancels the COPY of the table that a stalled agent is on, for
+ * --cancel-stalled, so that the table fails instead of hanging.  A separate
+ * connection to the database is used, as the workers are busy.  The table is
+ * recorded so that the error of its COPY can be told apart from other
+ * cancellations with TakeStalledCopy.
+ */
+func CancelStalledCopy(dbname string, stall HelperStall) {
+	if stall.Oid == 0 {
+		return
+	}
+	conn := dbconn.NewDBConnFromEnvironment(dbname)
+	err := conn.Connect(1)
+	if err != nil {
+		gplog.Warn("Unable to connect to cancel the COPY of table with oid %d: %v", stall.Oid, err)
+		return
+	}
+	defer conn.Close()
+	gplog.Warn("Cancelling the COPY of table with oid %d, as its gpbackup_helper agent on segment %d stalled", stall.Oid, stall.Content)
+	RecordStalledCopy(uint32(stall.Oid))
+	CancelCopySessionsForTable(conn, uint32(stall.Oid))
+}
+
+// Records that the COPY of the table is being canceled because its agent stalled
+func RecordStalledCopy(oid uint32) {
+	stalledCopyMutex.Lock()
+	defer stalledCopyMutex.Unlock()
+	stalledCopies[oid] = true
+}
+
+// Returns whether CancelStalledCopy canceled the COPY of the table since the last call for it
+func TakeStalledCopy(oid uint32) bool {
+	stalledCopyMutex.Lock()
+	defer stalledCopyMutex.Unlock()
+	stalled := stalledCopies[oid]
+	delete(stalledCopies, oid)
+	return stalled
+}
@@ -0,0 +1,103 @@
+package utils_test
+
+import (
+	"time"
+
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/helper_stall tests", func() {
+	var (
+		server *utils.HelperControlServer
+		client *utils.HelperControlClient
+	)
+	agent := "/data/gpseg0/gpbackup_0_20230101000000_pipe"
+
+	BeforeEach(func() {
+		var err error
+		server, err = utils.NewHelperControlServer("127.0.0.1")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+		client, err = utils.DialHelperControl(server.Address(), utils.ControlMessage{Content: 0, Agent: agent, Host: "sdw1", Secret: server.Secret()}, func(utils.ControlMessage) {})
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(client.Close)
+		Eventually(func() bool { return server.AllConnected([]string{agent}) }).Should(BeTrue())
+	})
+	heartbeat := func(phase string, bytes int64) func() []utils.HelperStall {
+		return func() []utils.HelperStall {
+			Expect(client.Send(utils.ControlMessage{Type: utils.CONTROL_HEARTBEAT, Oid: 16384, Phase: phase, Bytes: bytes})).To(Succeed())
+			return server.StalledAgents(100*time.Millisecond, time.Now())
+		}
+	}
+
+	Describe("StalledAgents", func() {
+		It("reports an agent that moves no data in the middle of a table once", func() {
+			utils.SetHelperTableNames(map[int]string{16384: "public.foo"})
+			defer utils.ResetAgentErrors()
+			var stalls []utils.HelperStall
+			Eventually(func() int {
+				stalls = heartbeat(utils.PHASE_COPY, 1024)()
+				return len(stalls)
+			}).Should(Equal(1))
+			Expect(stalls[0].Silent).To(BeFalse())
+			Expect(stalls[0].Agent).To(Equal(agent))
+			Expect(stalls[0].String()).To(MatchRegexp(`^gpbackup_helper agent on segment 0 on host sdw1 has made no progress on table public.foo for .* \(copy phase, 1024 bytes moved\)$`))
+			Expect(heartbeat(utils.PHASE_COPY, 1024)()).To(BeEmpty())
+		})
+		It("does not report an agent that is moving data", func() {
+			bytes := int64(0)
+			Consistently(func() []utils.HelperStall {
+				bytes++
+				return heartbeat(utils.PHASE_COPY, bytes)()
+			}, "300ms").Should(BeEmpty())
+		})
+		It("reports an agent that waits for the COPY of a table to open its pipe", func() {
+			var stalls []utils.HelperStall
+			Eventually(func() int {
+				stalls = heartbeat(utils.PHASE_PIPE, 1024)()
+				return len(stalls)
+			}).Should(Equal(1))
+			Expect(stalls[0].String()).To(MatchRegexp(`^gpbackup_helper agent on segment 0 on host sdw1 has waited for the COPY of table with oid 16384 to open its pipe for .*$`))
+		})
+		It("does not report an agent that moves on to the next table", func() {
+			oid := 16384
+			Consistently(func() []utils.HelperStall {
+				oid++
+				Expect(client.Send(utils.ControlMessage{Type: utils.CONTROL_HEARTBEAT, Oid: oid, Phase: utils.PHASE_PIPE})).To(Succeed())
+				return server.StalledAgents(100*time.Millisecond, time.Now())
+			}, "300ms").Should(BeEmpty())
+		})
+		It("reports an agent that sends no heartbeats", func() {
+			stalls := server.StalledAgents(time.Minute, time.Now().Add(2*time.Minute))
+			Expect(stalls).To(HaveLen(1))
+			Expect(stalls[0].Silent).To(BeTrue())
+			Expect(stalls[0].String()).To(HavePrefix("gpbackup_helper agent on segment 0 on host sdw1 has sent no heartbeat for 2m"))
+		})
+		It("does not report an agent that finished", func() {
+			Expect(client.Send(utils.ControlMessage{Type: utils.CONTROL_DONE})).To(Succeed())
+			Eventually(func() []utils.HelperStall {
+				return server.StalledAgents(time.Minute, time.Now().Add(2*time.Minute))
+			}).Should(BeEmpty())
+		})
+	})
+	Describe("ParseStallTimeout", func() {
+		It("returns 0 without a timeout", func() {
+			Expect(utils.ParseStallTimeout("")).To(Equal(time.Duration(0)))
+		})
+		It("parses a duration", func() {
+			Expect(utils.ParseStallTimeout("10m")).To(Equal(10 * time.Minute))
+		})
+		DescribeTable("rejects invalid timeouts",
+			func(value string) {
+				_, err := utils.ParseStallTimeout(value)
+				Expect(err).To(MatchError(ContainSubstring("--stall-timeout %s is invalid. Must be a duration of at least 10s", value)))
+			},
+			Entry("a timeout without a unit", "10"),
+			Entry("a timeout shorter than two heartbeats", "5s"),
+			Entry("a negative timeout", "-1m"),
+		)
+	})
+})
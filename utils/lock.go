@@ -0,0 +1,107 @@
+package utils
+
+/*
+ * This file contains functions for the lock files that keep backups from
+ * running concurrently.
+ */
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
+)
+
+// How often a lock held by another process is tried again while waiting for it
+var LockRetryInterval = time.Second
+
+/*
+ * Returns the command line of the process with the given pid, or "" if it
+ * cannot be read, e.g. on systems without /proc.
+ */
+func getProcessCommand(pid int) string {
+	contents, err := operating.System.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(contents), "\x00", " "))
+}
+
+/*
+ * Returns a description of the process that holds the lock, for messages
+ * about the lock being busy.
+ */
+func DescribeLockOwner(lock lockfile.Lockfile) string {
+	owner, err := lock.GetOwner()
+	if err != nil {
+		return fmt.Sprintf("lock file %s", string(lock))
+	}
+	description := fmt.Sprintf("process %d", owner.Pid)
+	if info, err := os.Stat(string(lock)); err == nil {
+		description += fmt.Sprintf(", locked since %s", info.ModTime().Format("2006-01-02 15:04:05"))
+	}
+	if command := getProcessCommand(owner.Pid); command != "" {
+		description += fmt.Sprintf(", running: %s", command)
+	}
+	return description
+}
+
+/*
+ * The lock library only checks that the pid in a lock file is alive, so a
+ * lock left behind by a process whose pid was since reused looks held.  Such
+ * a lock is stale if the owning process is not an instance of the program
+ * that takes it.
+ */
+func isLockStale(lock lockfile.Lockfile, programName string) bool {
+	owner, err := lock.GetOwner()
+	if err != nil {
+		return false
+	}
+	command := getProcessCommand(owner.Pid)
+	return command != "" && !strings.Contains(command, programName)
+}
+
+/*
+ * Takes the lock at the given path for the program with the given name.  If
+ * another instance of the program holds it, waits up to wait for it to be
+ * released, and returns an error naming the holder if it is not, along
+ * with an empty lock.  Locks of processes that no longer exist are removed.
+ */
+func AcquireLock(path string, programName string, wait time.Duration) (lockfile.Lockfile, error) {
+	lock, err := lockfile.New(path)
+	if err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(wait)
+	waiting := false
+	for {
+		err = lock.TryLock()
+		if err == nil {
+			return lock, nil
+		}
+		if err == lockfile.ErrBusy && isLockStale(lock, programName) {
+			gplog.Verbose("Removing stale lock file %s, as its process is no longer a %s process", path, programName)
+			err = os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+			continue
+		}
+		if _, isTemporary := err.(lockfile.TemporaryError); !isTemporary {
+			return "", err
+		}
+		if !time.Now().Before(deadline) {
+			return "", errors.Errorf("Lock file %s is held by %s", path, DescribeLockOwner(lock))
+		}
+		if !waiting {
+			gplog.Info("Waiting up to %s for lock file %s, which is held by %s", wait, path, DescribeLockOwner(lock))
+			waiting = true
+		}
+		time.Sleep(LockRetryInterval)
+	}
+}
@@ -0,0 +1,74 @@
+package utils_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/lock tests", func() {
+	Describe("AcquireLock", func() {
+		var lockPath string
+		var owner *exec.Cmd
+		originalRetryInterval := utils.LockRetryInterval
+		AfterEach(func() {
+			utils.LockRetryInterval = originalRetryInterval
+		})
+		BeforeEach(func() {
+			tempDir, err := os.MkdirTemp("", "lock_test")
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(os.RemoveAll, tempDir)
+			lockPath = path.Join(tempDir, "test.lck")
+			utils.LockRetryInterval = 10 * time.Millisecond
+
+			// Stands in for another process holding the lock
+			owner = exec.Command("sleep", "30")
+			Expect(owner.Start()).To(Succeed())
+			DeferCleanup(func() {
+				_ = owner.Process.Kill()
+				_ = owner.Wait()
+			})
+		})
+		writeLockOwner := func(pid int) {
+			Expect(os.WriteFile(lockPath, []byte(fmt.Sprintf("%d\n", pid)), 0644)).To(Succeed())
+		}
+
+		It("takes a lock that no process holds", func() {
+			lock, err := utils.AcquireLock(lockPath, "sleep", 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(lock)).To(Equal(lockPath))
+			Expect(lock.Unlock()).To(Succeed())
+		})
+		It("returns an error naming the process that holds the lock", func() {
+			writeLockOwner(owner.Process.Pid)
+			lock, err := utils.AcquireLock(lockPath, "sleep", 0)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf("is held by process %d", owner.Process.Pid)))
+			Expect(err.Error()).To(ContainSubstring("running: sleep 30"))
+			Expect(string(lock)).To(Equal(""))
+		})
+		It("waits for the process that holds the lock to release it", func() {
+			writeLockOwner(owner.Process.Pid)
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				_ = owner.Process.Kill()
+				_ = owner.Wait()
+			}()
+			_, err := utils.AcquireLock(lockPath, "sleep", 10*time.Second)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(logfile.Contents())).To(ContainSubstring("Waiting up to 10s for lock file"))
+		})
+		It("takes a lock whose pid now belongs to another program", func() {
+			writeLockOwner(owner.Process.Pid)
+			_, err := utils.AcquireLock(lockPath, "gpbackup", 0)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+})
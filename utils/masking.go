@@ -0,0 +1,101 @@
+package utils
+
+/*
+ * This file contains structs and functions related to the column masking
+ * rules with which a backup replaces sensitive values.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	MaskHash  = "hash"
+	MaskNull  = "null"
+	MaskFixed = "fixed"
+	MaskFaker = "faker"
+)
+
+// The kinds of values that faker rules generate
+var MaskFakerGenerators = []string{"email", "name", "phone"}
+
+type MaskingRule struct {
+	Kind string
+	// The value of a fixed rule or the generator of a faker rule
+	Argument string
+}
+
+var maskColumnRE = regexp.MustCompile(`^([^.]+\.[^.]+)\.([^.]+)$`)
+
+/*
+ * Parses a rule in one of the forms "hash", "null", "fixed:<value>", or
+ * "faker:<generator>".
+ */
+func ParseMaskingRule(rule string) (MaskingRule, error) {
+	kind, argument, hasArgument := strings.Cut(rule, ":")
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	switch {
+	case (kind == MaskHash || kind == MaskNull) && !hasArgument:
+		return MaskingRule{Kind: kind}, nil
+	case kind == MaskFixed && hasArgument:
+		return MaskingRule{Kind: kind, Argument: argument}, nil
+	case kind == MaskFaker && hasArgument:
+		generator := strings.ToLower(strings.TrimSpace(argument))
+		if Exists(MaskFakerGenerators, generator) {
+			return MaskingRule{Kind: kind, Argument: generator}, nil
+		}
+		return MaskingRule{}, errors.Errorf(`Invalid faker generator "%s".  Valid generators are %s.`, argument, strings.Join(MaskFakerGenerators, ", "))
+	}
+	return MaskingRule{}, errors.Errorf(`Invalid masking rule "%s".  Rules must be "hash", "null", "fixed:<value>", or "faker:<generator>".`, rule)
+}
+
+/*
+ * Reads a YAML file mapping unquoted schema.table.column names to masking
+ * rules, e.g.
+ *   public.users.email: faker:email
+ *   public.users.ssn: hash
+ * and returns the rules keyed by unquoted table FQN and then column name.
+ * An empty filename returns no rules.
+ */
+func ReadMaskingRulesFile(filename string) (map[string]map[string]MaskingRule, error) {
+	maskingRules := make(map[string]map[string]MaskingRule)
+	if filename == "" {
+		return maskingRules, nil
+	}
+	contents, err := operating.System.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	ruleStrings := make(map[string]string)
+	err = yaml.UnmarshalStrict(contents, &ruleStrings)
+	if err != nil {
+		return nil, errors.Errorf("mask file %s is formatted incorrectly: %v", filename, err)
+	}
+	columnNames := make([]string, 0, len(ruleStrings))
+	for columnName := range ruleStrings {
+		columnNames = append(columnNames, columnName)
+	}
+	sort.Strings(columnNames)
+	for _, columnName := range columnNames {
+		match := maskColumnRE.FindStringSubmatch(columnName)
+		if match == nil {
+			return nil, errors.Errorf(`Column "%s" in mask file %s is not in the format "schema.table.column"`, columnName, filename)
+		}
+		rule, err := ParseMaskingRule(ruleStrings[columnName])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Column %s in mask file %s", columnName, filename))
+		}
+		if maskingRules[match[1]] == nil {
+			maskingRules[match[1]] = make(map[string]MaskingRule)
+		}
+		maskingRules[match[1]][match[2]] = rule
+	}
+	return maskingRules, nil
+}
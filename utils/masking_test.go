@@ -0,0 +1,69 @@
+package utils_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/masking tests", func() {
+	AfterEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+	})
+	Describe("ParseMaskingRule", func() {
+		DescribeTable("parses valid rules",
+			func(rule string, expected utils.MaskingRule) {
+				Expect(utils.ParseMaskingRule(rule)).To(Equal(expected))
+			},
+			Entry("hash", "hash", utils.MaskingRule{Kind: utils.MaskHash}),
+			Entry("null", "NULL", utils.MaskingRule{Kind: utils.MaskNull}),
+			Entry("fixed", "fixed:REDACTED: see policy", utils.MaskingRule{Kind: utils.MaskFixed, Argument: "REDACTED: see policy"}),
+			Entry("faker", "faker:Email", utils.MaskingRule{Kind: utils.MaskFaker, Argument: "email"}),
+		)
+		DescribeTable("returns an error for invalid rules",
+			func(rule string) {
+				_, err := utils.ParseMaskingRule(rule)
+				Expect(err).To(HaveOccurred())
+			},
+			Entry("unknown kind", "shuffle"),
+			Entry("fixed without a value", "fixed"),
+			Entry("hash with an argument", "hash:sha256"),
+			Entry("unknown faker generator", "faker:address"),
+		)
+	})
+	Describe("ReadMaskingRulesFile", func() {
+		It("returns no rules when no file is specified", func() {
+			maskingRules, err := utils.ReadMaskingRulesFile("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(maskingRules).To(BeEmpty())
+		})
+		It("groups the rules of each table by column", func() {
+			operating.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte(`public.users.email: faker:email
+public.users.ssn: hash
+sales.orders.notes: "fixed:n/a"
+`), nil
+			}
+			maskingRules, err := utils.ReadMaskingRulesFile("/tmp/mask.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(maskingRules).To(Equal(map[string]map[string]utils.MaskingRule{
+				"public.users": {
+					"email": {Kind: utils.MaskFaker, Argument: "email"},
+					"ssn":   {Kind: utils.MaskHash},
+				},
+				"sales.orders": {
+					"notes": {Kind: utils.MaskFixed, Argument: "n/a"},
+				},
+			}))
+		})
+		It("returns an error for a column that is not fully qualified", func() {
+			operating.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte("users.email: hash\n"), nil
+			}
+			_, err := utils.ReadMaskingRulesFile("/tmp/mask.yaml")
+			Expect(err).To(MatchError(`Column "users.email" in mask file /tmp/mask.yaml is not in the format "schema.table.column"`))
+		})
+	})
+})
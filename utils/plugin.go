@@ -298,6 +298,17 @@ func (plugin *PluginConfig) buildHookErrorMsgAndFunc(command string,
 	}
 }
 
+/*
+ * A plugin that stores only the coordinator files, such as the one given with
+ * --metadata-plugin-config, is checked, set up, and cleaned up on this cluster
+ * of only the coordinator.
+ */
+func CoordinatorCluster(c *cluster.Cluster) *cluster.Cluster {
+	coordinatorCluster := cluster.NewCluster([]cluster.SegConfig{*c.ByContent[-1][0]})
+	coordinatorCluster.Executor = c.Executor
+	return coordinatorCluster
+}
+
 /*---------------------------------------------------------------------------------------------------*/
 
 func (plugin *PluginConfig) CopyPluginConfigToAllHosts(c *cluster.Cluster) {
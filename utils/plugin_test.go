@@ -100,6 +100,15 @@ var _ = Describe("utils/plugin tests", func() {
 			}
 		})
 	})
+	Describe("CoordinatorCluster", func() {
+		It("returns a cluster of only the coordinator that runs commands as the given cluster does", func() {
+			coordinatorCluster := utils.CoordinatorCluster(testCluster)
+
+			Expect(coordinatorCluster.ContentIDs).To(Equal([]int{-1}))
+			Expect(coordinatorCluster.Hostnames).To(Equal([]string{"coordinator"}))
+			Expect(coordinatorCluster.Executor).To(Equal(testCluster.Executor))
+		})
+	})
 	Describe("creates segment-specific plugin config and copies it to all hosts", func() {
 		It("appends PGPORT and the --version of the plugin", func() {
 			testConfigPath := "/tmp/my_plugin_config.yaml"
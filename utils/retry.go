@@ -0,0 +1,105 @@
+package utils
+
+/*
+ * This file contains the classification of errors that are worth retrying,
+ * and the delay between retries, for --retries.
+ */
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Plugins exit with EX_TEMPFAIL from sysexits.h to report a failure that may
+ * not happen again, such as a timeout of their storage service.
+ */
+const PLUGIN_RETRYABLE_EXIT_CODE = 75
+
+/*
+ * Written to stderr by the COPY program of a plugin that exited with
+ * PLUGIN_RETRYABLE_EXIT_CODE, which the database passes on in the error of
+ * the COPY, so that the exit code does not have to be read from how the
+ * database words it.
+ */
+const PLUGIN_RETRYABLE_MARKER = "gpbackup: plugin failure may be retried"
+
+// The delay before the first retry, which doubles with each retry; a variable so tests can shorten it
+var RetryBaseDelay = time.Second
+
+const maxRetryDelay = time.Minute
+
+var transientErrorCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+	"53300": true, // too_many_connections
+}
+
+var transientErrorMessages = []string{
+	"connection reset by peer",
+	"connection timed out",
+	"could not connect",
+	"interconnect encountered a network error",
+	strings.ToLower(PLUGIN_RETRYABLE_MARKER),
+}
+
+/*
+ * Returns whether the error reported by the database may not happen again,
+ * such as a lost connection between segments, a lock timeout, or a plugin
+ * run with WrapPluginCommandForRetry that exited with
+ * PLUGIN_RETRYABLE_EXIT_CODE.  Errors of the connection to the coordinator
+ * are not transient, as the session would be lost with it.
+ */
+func IsTransientError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	// Class 08 is connection exceptions, which segments report for connections between them
+	if transientErrorCodes[pgErr.Code] || strings.HasPrefix(pgErr.Code, "08") {
+		return true
+	}
+	text := strings.ToLower(pgErr.Message + " " + pgErr.Detail)
+	for _, message := range transientErrorMessages {
+		if strings.Contains(text, message) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Returns whether the error is the cancellation of the COPY of the table by
+ * CancelStalledCopy.  Other cancellations, such as those of a user, are not
+ * worth retrying.
+ */
+func IsStalledCopyCancel(err error, oid uint32) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "57014" && TakeStalledCopy(oid)
+}
+
+/*
+ * Wraps the command of a plugin run by COPY so that it writes
+ * PLUGIN_RETRYABLE_MARKER to stderr when the plugin exits with
+ * PLUGIN_RETRYABLE_EXIT_CODE, keeping the exit code of the plugin.
+ */
+func WrapPluginCommandForRetry(command string) string {
+	return fmt.Sprintf(`(%s; status=$?; if [ $status -eq %d ]; then echo "%s" >&2; fi; exit $status)`, command, PLUGIN_RETRYABLE_EXIT_CODE, PLUGIN_RETRYABLE_MARKER)
+}
+
+// Returns how long to wait before the given retry, counting from 1
+func RetryDelay(retry int) time.Duration {
+	delay := RetryBaseDelay
+	for i := 1; i < retry && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
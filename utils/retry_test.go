@@ -0,0 +1,76 @@
+package utils_test
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/retry tests", func() {
+	Describe("IsTransientError", func() {
+		DescribeTable("classifies database errors",
+			func(err error, transient bool) {
+				Expect(utils.IsTransientError(err)).To(Equal(transient))
+			},
+			Entry("a lock timeout", &pgconn.PgError{Code: "55P03", Message: "could not obtain lock on relation"}, true),
+			Entry("a canceled query", &pgconn.PgError{Code: "57014", Message: "canceling statement due to user request"}, false),
+			Entry("a connection exception", &pgconn.PgError{Code: "08006", Message: "connection failure"}, true),
+			Entry("a lost connection between segments", &pgconn.PgError{Code: "XX000", Message: "Interconnect encountered a network error, please check your network"}, true),
+			Entry("a reset connection of a plugin", &pgconn.PgError{Code: "38000", Message: "command error message: upload failed: Connection reset by peer"}, true),
+			Entry("a plugin that asks to be retried", &pgconn.PgError{Code: "38000", Message: "command error message: " + utils.PLUGIN_RETRYABLE_MARKER}, true),
+			Entry("a plugin that failed", &pgconn.PgError{Code: "38000", Message: "program \"gpbackup_s3_plugin backup_data\" failed", Detail: "child process exited with exit code 1"}, false),
+			Entry("a plugin that exited with code 75 without the marker", &pgconn.PgError{Code: "38000", Message: "program \"gpbackup_s3_plugin backup_data\" failed", Detail: "child process exited with exit code 75"}, false),
+			Entry("a missing table", &pgconn.PgError{Code: "42P01", Message: "relation \"public.foo\" does not exist"}, false),
+			Entry("a wrapped transient error", errors.Wrap(&pgconn.PgError{Code: "40P01", Message: "deadlock detected"}, "COPY failed"), true),
+			Entry("an error of the coordinator connection", errors.New("read tcp: connection reset by peer"), false),
+		)
+	})
+	Describe("IsStalledCopyCancel", func() {
+		canceled := &pgconn.PgError{Code: "57014", Message: "canceling statement due to user request"}
+		It("returns true once for the cancellation of a COPY whose agent stalled", func() {
+			utils.RecordStalledCopy(16384)
+			Expect(utils.IsStalledCopyCancel(canceled, 16385)).To(BeFalse())
+			Expect(utils.IsStalledCopyCancel(canceled, 16384)).To(BeTrue())
+			Expect(utils.IsStalledCopyCancel(canceled, 16384)).To(BeFalse())
+		})
+		It("returns false for other errors of a table whose agent stalled", func() {
+			utils.RecordStalledCopy(16384)
+			defer utils.TakeStalledCopy(16384)
+			Expect(utils.IsStalledCopyCancel(&pgconn.PgError{Code: "42P01", Message: "relation does not exist"}, 16384)).To(BeFalse())
+			Expect(utils.IsStalledCopyCancel(nil, 16384)).To(BeFalse())
+		})
+	})
+	Describe("WrapPluginCommandForRetry", func() {
+		It("marks the exit code of a plugin that asks to be retried and keeps it", func() {
+			for _, exitCode := range []int{utils.PLUGIN_RETRYABLE_EXIT_CODE, 1, 0} {
+				command := utils.WrapPluginCommandForRetry(fmt.Sprintf("sh -c \"exit %d\"", exitCode))
+				var stderr bytes.Buffer
+				cmd := exec.Command("sh", "-c", command)
+				cmd.Stderr = &stderr
+				_ = cmd.Run()
+				Expect(cmd.ProcessState.ExitCode()).To(Equal(exitCode))
+				if exitCode == utils.PLUGIN_RETRYABLE_EXIT_CODE {
+					Expect(stderr.String()).To(Equal(utils.PLUGIN_RETRYABLE_MARKER + "\n"))
+				} else {
+					Expect(stderr.String()).To(BeEmpty())
+				}
+			}
+		})
+	})
+	Describe("RetryDelay", func() {
+		It("doubles the delay with each retry up to a minute", func() {
+			Expect(utils.RetryDelay(1)).To(Equal(time.Second))
+			Expect(utils.RetryDelay(2)).To(Equal(2 * time.Second))
+			Expect(utils.RetryDelay(4)).To(Equal(8 * time.Second))
+			Expect(utils.RetryDelay(10)).To(Equal(time.Minute))
+		})
+	})
+})
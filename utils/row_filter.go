@@ -0,0 +1,49 @@
+package utils
+
+/*
+ * This file contains functions related to the per-table row filters with
+ * which a backup copies only some of the rows of a table.
+ */
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+ * Reads a YAML file mapping unquoted table FQNs to the SQL predicates that
+ * rows of those tables must satisfy to be backed up, e.g.
+ *   public.orders: "order_date >= '2020-01-01'"
+ * An empty filename returns no filters.
+ */
+func ReadRowFilterFile(filename string) (map[string]string, error) {
+	rowFilters := make(map[string]string)
+	if filename == "" {
+		return rowFilters, nil
+	}
+	contents, err := operating.System.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.UnmarshalStrict(contents, &rowFilters)
+	if err != nil {
+		return nil, errors.Errorf("where file %s is formatted incorrectly: %v", filename, err)
+	}
+	tableFQNs := make([]string, 0, len(rowFilters))
+	for tableFQN, predicate := range rowFilters {
+		if strings.TrimSpace(predicate) == "" {
+			return nil, errors.Errorf(`where file %s has an empty predicate for table "%s"`, filename, tableFQN)
+		}
+		tableFQNs = append(tableFQNs, tableFQN)
+	}
+	sort.Strings(tableFQNs)
+	err = ValidateFQNs(tableFQNs)
+	if err != nil {
+		return nil, err
+	}
+	return rowFilters, nil
+}
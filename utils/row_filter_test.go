@@ -0,0 +1,49 @@
+package utils_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/row_filter tests", func() {
+	AfterEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+	})
+	Describe("ReadRowFilterFile", func() {
+		It("returns no filters when no file is specified", func() {
+			rowFilters, err := utils.ReadRowFilterFile("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rowFilters).To(BeEmpty())
+		})
+		It("reads the predicate of each table", func() {
+			operating.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte(`public.orders: "order_date >= '2020-01-01'"
+sales.customers: id % 100 = 0
+`), nil
+			}
+			rowFilters, err := utils.ReadRowFilterFile("/tmp/where.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rowFilters).To(Equal(map[string]string{
+				"public.orders":   "order_date >= '2020-01-01'",
+				"sales.customers": "id % 100 = 0",
+			}))
+		})
+		It("returns an error for a table that is not fully qualified", func() {
+			operating.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte("orders: id < 10\n"), nil
+			}
+			_, err := utils.ReadRowFilterFile("/tmp/where.yaml")
+			Expect(err).To(MatchError(ContainSubstring(`Table "orders" is not correctly fully-qualified`)))
+		})
+		It("returns an error for an empty predicate", func() {
+			operating.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte("public.orders: ''\n"), nil
+			}
+			_, err := utils.ReadRowFilterFile("/tmp/where.yaml")
+			Expect(err).To(MatchError(`where file /tmp/where.yaml has an empty predicate for table "public.orders"`))
+		})
+	})
+})
@@ -0,0 +1,62 @@
+package utils
+
+/*
+ * This file contains structs and functions related to the size of the sample
+ * of rows that a sampling backup copies out of each table.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type SampleSize struct {
+	// Either Percent or Rows is set, never both
+	Percent float64
+	Rows    int64
+}
+
+var samplePercentRE = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?)\s*%$`)
+
+/*
+ * Parses a sample size given either as a percentage of the rows of each
+ * table, e.g. "10%", or as an approximate number of rows per table, e.g.
+ * "1000".
+ */
+func ParseSampleSize(sample string) (SampleSize, error) {
+	value := strings.TrimSpace(sample)
+	if matches := samplePercentRE.FindStringSubmatch(value); matches != nil {
+		percent, err := strconv.ParseFloat(matches[1], 64)
+		if err == nil && percent > 0 && percent <= 100 {
+			return SampleSize{Percent: percent}, nil
+		}
+	} else if rows, err := strconv.ParseInt(value, 10, 64); err == nil && rows > 0 {
+		return SampleSize{Rows: rows}, nil
+	}
+	return SampleSize{}, errors.Errorf(`Invalid sample size "%s".  The sample size must be a percentage greater than 0 and at most 100, e.g. "10%%", or a positive number of rows per table, e.g. "1000".`, sample)
+}
+
+/*
+ * Returns the fraction of a table with the given number of rows that is in
+ * the sample, which is never more than 1.
+ */
+func (size SampleSize) Fraction(tableRows float64) float64 {
+	if size.Percent > 0 {
+		return size.Percent / 100
+	}
+	if tableRows <= float64(size.Rows) {
+		return 1
+	}
+	return float64(size.Rows) / tableRows
+}
+
+func (size SampleSize) String() string {
+	if size.Percent > 0 {
+		return fmt.Sprintf("%s%% of rows", strconv.FormatFloat(size.Percent, 'f', -1, 64))
+	}
+	return fmt.Sprintf("%d rows per table", size.Rows)
+}
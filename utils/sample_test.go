@@ -0,0 +1,51 @@
+package utils_test
+
+import (
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/sample tests", func() {
+	Describe("ParseSampleSize", func() {
+		DescribeTable("parses valid sample sizes",
+			func(sample string, expected utils.SampleSize) {
+				Expect(utils.ParseSampleSize(sample)).To(Equal(expected))
+			},
+			Entry("a percentage", "10%", utils.SampleSize{Percent: 10}),
+			Entry("a fractional percentage", "0.5 %", utils.SampleSize{Percent: 0.5}),
+			Entry("a number of rows", "1000", utils.SampleSize{Rows: 1000}),
+		)
+		DescribeTable("returns an error for invalid sample sizes",
+			func(sample string) {
+				_, err := utils.ParseSampleSize(sample)
+				Expect(err).To(MatchError(ContainSubstring(`Invalid sample size "%s"`, sample)))
+			},
+			Entry("a zero percentage", "0%"),
+			Entry("a percentage over 100", "150%"),
+			Entry("zero rows", "0"),
+			Entry("a negative number of rows", "-5"),
+			Entry("a word", "half"),
+		)
+	})
+	Describe("Fraction", func() {
+		It("returns the percentage as a fraction", func() {
+			Expect(utils.SampleSize{Percent: 25}.Fraction(1000)).To(Equal(0.25))
+		})
+		It("returns the fraction of the table that holds the number of rows", func() {
+			Expect(utils.SampleSize{Rows: 100}.Fraction(400)).To(Equal(0.25))
+		})
+		It("returns the whole table when it has no more than the number of rows", func() {
+			Expect(utils.SampleSize{Rows: 100}.Fraction(80)).To(Equal(1.0))
+		})
+	})
+	Describe("String", func() {
+		It("describes a percentage", func() {
+			Expect(utils.SampleSize{Percent: 2.5}.String()).To(Equal("2.5% of rows"))
+		})
+		It("describes a number of rows", func() {
+			Expect(utils.SampleSize{Rows: 1000}.String()).To(Equal("1000 rows per table"))
+		})
+	})
+})
@@ -0,0 +1,110 @@
+package utils
+
+/*
+ * This file contains structs and functions related to the user-configurable
+ * session settings applied to every backup and restore connection.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+ * Greenplum assigns resource groups and resource queues to roles, so a
+ * connection is moved into a specific group or queue by setting its role.
+ */
+type SessionGUCProfile struct {
+	Role string            `yaml:"role"`
+	GUCs map[string]string `yaml:"gucs"`
+}
+
+var gucNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+func ReadSessionGUCProfile(filename string) (SessionGUCProfile, error) {
+	profile := SessionGUCProfile{}
+	contents, err := operating.System.ReadFile(filename)
+	if err != nil {
+		return profile, err
+	}
+	err = yaml.UnmarshalStrict(contents, &profile)
+	if err != nil {
+		return profile, errors.Errorf("session GUC file %s is formatted incorrectly: %v", filename, err)
+	}
+	return profile, nil
+}
+
+/*
+ * Builds the profile from an optional YAML file and any name=value settings
+ * from the command line, which take precedence over the file.
+ */
+func GetSessionGUCProfile(filename string, settings []string) (SessionGUCProfile, error) {
+	profile := SessionGUCProfile{}
+	var err error
+	if filename != "" {
+		profile, err = ReadSessionGUCProfile(filename)
+		if err != nil {
+			return profile, err
+		}
+	}
+	if profile.GUCs == nil {
+		profile.GUCs = make(map[string]string)
+	}
+	for _, setting := range settings {
+		name, value, found := strings.Cut(setting, "=")
+		if !found {
+			return profile, errors.Errorf(`Invalid session GUC "%s".  Session GUCs must be in the format name=value.`, setting)
+		}
+		profile.GUCs[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	for name := range profile.GUCs {
+		if !gucNameRE.MatchString(name) {
+			return profile, errors.Errorf(`Invalid session GUC name "%s"`, name)
+		}
+	}
+	return profile, nil
+}
+
+func (profile SessionGUCProfile) sortedNames() []string {
+	names := make([]string, 0, len(profile.GUCs))
+	for name := range profile.GUCs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+ * Returns the statements that apply the profile to a connection.  GUCs are
+ * set in name order so that every connection and every run is configured
+ * identically.  set_config() is used rather than SET, as SET would take a
+ * quoted list such as 'a,b' for search_path as a single element.
+ */
+func (profile SessionGUCProfile) SetStatements() []string {
+	statements := make([]string, 0, len(profile.GUCs)+1)
+	if profile.Role != "" {
+		statements = append(statements, fmt.Sprintf("SET ROLE '%s'", EscapeSingleQuotes(profile.Role)))
+	}
+	for _, name := range profile.sortedNames() {
+		statements = append(statements, fmt.Sprintf("SELECT pg_catalog.set_config('%s', '%s', false)", name, EscapeSingleQuotes(profile.GUCs[name])))
+	}
+	return statements
+}
+
+// Returns the profile in --session-guc form, for recording in the report
+func (profile SessionGUCProfile) String() string {
+	settings := make([]string, 0, len(profile.GUCs)+1)
+	if profile.Role != "" {
+		settings = append(settings, fmt.Sprintf("role=%s", profile.Role))
+	}
+	for _, name := range profile.sortedNames() {
+		settings = append(settings, fmt.Sprintf("%s=%s", name, profile.GUCs[name]))
+	}
+	return strings.Join(settings, " ")
+}
@@ -0,0 +1,76 @@
+package utils_test
+
+import (
+	"errors"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/session_guc tests", func() {
+	AfterEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+	})
+	Describe("GetSessionGUCProfile", func() {
+		It("returns an empty profile when nothing is specified", func() {
+			profile, err := utils.GetSessionGUCProfile("", []string{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(profile.SetStatements()).To(BeEmpty())
+			Expect(profile.String()).To(Equal(""))
+		})
+		It("reads the role and GUCs from a file, with command-line settings taking precedence", func() {
+			operating.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte(`role: backup_role
+gucs:
+  statement_timeout: 1h
+  gp_interconnect_type: tcp
+`), nil
+			}
+			profile, err := utils.GetSessionGUCProfile("/tmp/gucs.yaml", []string{"statement_timeout=0", "work_mem = 1GB"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(profile.SetStatements()).To(Equal([]string{
+				"SET ROLE 'backup_role'",
+				"SELECT pg_catalog.set_config('gp_interconnect_type', 'tcp', false)",
+				"SELECT pg_catalog.set_config('statement_timeout', '0', false)",
+				"SELECT pg_catalog.set_config('work_mem', '1GB', false)",
+			}))
+			Expect(profile.String()).To(Equal("role=backup_role gp_interconnect_type=tcp statement_timeout=0 work_mem=1GB"))
+		})
+		It("escapes single quotes in values", func() {
+			profile, err := utils.GetSessionGUCProfile("", []string{"search_path=a'b"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(profile.SetStatements()).To(Equal([]string{"SELECT pg_catalog.set_config('search_path', 'a''b', false)"}))
+		})
+		It("passes a list of values through as a single setting", func() {
+			profile, err := utils.GetSessionGUCProfile("", []string{"search_path=a,b"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(profile.SetStatements()).To(Equal([]string{"SELECT pg_catalog.set_config('search_path', 'a,b', false)"}))
+		})
+		It("returns an error for a setting without a value", func() {
+			_, err := utils.GetSessionGUCProfile("", []string{"statement_timeout"})
+			Expect(err).To(MatchError(`Invalid session GUC "statement_timeout".  Session GUCs must be in the format name=value.`))
+		})
+		It("returns an error for an invalid GUC name", func() {
+			_, err := utils.GetSessionGUCProfile("", []string{"work_mem; DROP TABLE foo=1"})
+			Expect(err).To(MatchError(`Invalid session GUC name "work_mem; DROP TABLE foo"`))
+		})
+		It("returns an error for an unknown key in the file", func() {
+			operating.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte("resource_group: rg1\n"), nil
+			}
+			_, err := utils.GetSessionGUCProfile("/tmp/gucs.yaml", []string{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("session GUC file /tmp/gucs.yaml is formatted incorrectly"))
+		})
+		It("returns an error if the file cannot be read", func() {
+			operating.System.ReadFile = func(filename string) ([]byte, error) {
+				return nil, errors.New("permission denied")
+			}
+			_, err := utils.GetSessionGUCProfile("/tmp/gucs.yaml", []string{})
+			Expect(err).To(MatchError("permission denied"))
+		})
+	})
+})
@@ -0,0 +1,299 @@
+package utils
+
+/*
+ * This file contains structs and functions used to limit the rate at which
+ * backup data is written to or read from storage.
+ */
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/pkg/errors"
+)
+
+/*
+ * RateLimiter is a token bucket holding at most one second's worth of bytes.
+ * Callers take tokens before moving data and sleep off any shortfall, so the
+ * long-run throughput never exceeds the configured rate.  A rate of 0 means
+ * no limit.
+ */
+type RateLimiter struct {
+	mutex    sync.Mutex
+	rate     float64
+	tokens   float64
+	lastTime time.Time
+}
+
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{rate: float64(bytesPerSecond), tokens: float64(bytesPerSecond)}
+}
+
+func (limiter *RateLimiter) SetRate(bytesPerSecond int64) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.rate = float64(bytesPerSecond)
+	limiter.tokens = math.Min(limiter.tokens, limiter.rate)
+}
+
+func (limiter *RateLimiter) Rate() int64 {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return int64(limiter.rate)
+}
+
+/*
+ * Takes numBytes tokens from the bucket at time now and returns how long the
+ * caller must wait before moving those bytes.  The bucket may go into debt,
+ * which later callers pay off by waiting longer.
+ */
+func (limiter *RateLimiter) Reserve(numBytes int, now time.Time) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.rate <= 0 {
+		limiter.lastTime = now
+		return 0
+	}
+	if !limiter.lastTime.IsZero() && now.After(limiter.lastTime) {
+		limiter.tokens = math.Min(limiter.tokens+now.Sub(limiter.lastTime).Seconds()*limiter.rate, limiter.rate)
+	}
+	if now.After(limiter.lastTime) {
+		limiter.lastTime = now
+	}
+	limiter.tokens -= float64(numBytes)
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+}
+
+func (limiter *RateLimiter) Wait(numBytes int) {
+	if delay := limiter.Reserve(numBytes, time.Now()); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+type ThrottledWriter struct {
+	writer  io.Writer
+	limiter *RateLimiter
+}
+
+func NewThrottledWriter(writer io.Writer, limiter *RateLimiter) *ThrottledWriter {
+	return &ThrottledWriter{writer: writer, limiter: limiter}
+}
+
+func (writer *ThrottledWriter) Write(p []byte) (int, error) {
+	writer.limiter.Wait(len(p))
+	return writer.writer.Write(p)
+}
+
+func (writer *ThrottledWriter) Close() error {
+	if closer, ok := writer.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type ThrottledReader struct {
+	reader  io.Reader
+	limiter *RateLimiter
+}
+
+func NewThrottledReader(reader io.Reader, limiter *RateLimiter) *ThrottledReader {
+	return &ThrottledReader{reader: reader, limiter: limiter}
+}
+
+func (reader *ThrottledReader) Read(p []byte) (int, error) {
+	numBytes, err := reader.reader.Read(p)
+	reader.limiter.Wait(numBytes)
+	return numBytes, err
+}
+
+// Seeking does not move any data, so it is not throttled
+type ThrottledReadSeeker struct {
+	ThrottledReader
+	seeker io.Seeker
+}
+
+func NewThrottledReadSeeker(readSeeker io.ReadSeeker, limiter *RateLimiter) *ThrottledReadSeeker {
+	return &ThrottledReadSeeker{ThrottledReader{readSeeker, limiter}, readSeeker}
+}
+
+func (reader *ThrottledReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return reader.seeker.Seek(offset, whence)
+}
+
+/*
+ * Parses a bandwidth such as "500KB", "100MB" or "1GB" into bytes per second.
+ * Units are powers of 1024 and a trailing "/s" is accepted.  An empty string,
+ * "0" or "unlimited" mean no limit and return 0.
+ */
+func ParseBandwidth(bandwidth string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(bandwidth))
+	value = strings.TrimSuffix(value, "/S")
+	if value == "" || value == "UNLIMITED" {
+		return 0, nil
+	}
+	bandwidthRE := regexp.MustCompile(`^([0-9]+)\s*([KMGT]?)B?$`)
+	matches := bandwidthRE.FindStringSubmatch(value)
+	if matches == nil {
+		return 0, errors.Errorf(`Invalid bandwidth "%s".  Bandwidth must be a number of bytes per second with an optional unit of KB, MB, GB, or TB, e.g. "100MB".`, bandwidth)
+	}
+	bytesPerSecond, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, `Invalid bandwidth "%s"`, bandwidth)
+	}
+	multipliers := map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	return bytesPerSecond * multipliers[matches[2]], nil
+}
+
+func ReadBandwidthFile(filename string) (int64, error) {
+	contents, err := operating.System.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	return ParseBandwidth(string(contents))
+}
+
+/*
+ * Returns one stream's share of a per-host bandwidth.  A share that would
+ * round down to 0, which means no limit, is kept at 1 byte per second instead.
+ */
+func GetBandwidthForShare(bytesPerSecond int64, share int) int64 {
+	if bytesPerSecond <= 0 {
+		return 0
+	}
+	if share < 1 {
+		share = 1
+	}
+	if bytesPerSecond < int64(share) {
+		return 1
+	}
+	return bytesPerSecond / int64(share)
+}
+
+/*
+ * Re-reads a bandwidth control file every interval and applies its value,
+ * divided by share, to the limiter.  A missing or invalid file leaves the
+ * current limit in place.  This runs until stop is closed, or forever if stop
+ * is nil.
+ */
+func WatchBandwidthFile(filename string, share int, limiter *RateLimiter, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			bytesPerSecond, err := ReadBandwidthFile(filename)
+			if err == nil && GetBandwidthForShare(bytesPerSecond, share) != limiter.Rate() {
+				limiter.SetRate(GetBandwidthForShare(bytesPerSecond, share))
+			}
+		}
+	}
+}
+
+/*
+ * --max-bandwidth applies to each segment host, so it is split between the
+ * segments on the busiest host and between the concurrent data streams on
+ * each of those segments.
+ */
+func GetBandwidthShare(c *cluster.Cluster, streamsPerSegment int) int {
+	maxSegmentsPerHost := 1
+	for _, host := range c.Hostnames {
+		numSegments := 0
+		for _, contentID := range c.GetContentsForHost(host) {
+			if contentID >= 0 {
+				numSegments++
+			}
+		}
+		if numSegments > maxSegmentsPerHost {
+			maxSegmentsPerHost = numSegments
+		}
+	}
+	if streamsPerSegment < 1 {
+		streamsPerSegment = 1
+	}
+	return maxSegmentsPerHost * streamsPerSegment
+}
+
+/*
+ * Returns a command that copies its input to its output at no more than the
+ * given per-host bandwidth, for use in the COPY commands of a backup or
+ * restore that does not otherwise go through gpbackup_helper.
+ */
+func GetThrottleCommandForCopy(fpInfo filepath.FilePathInfo, maxBandwidth int64, share int) string {
+	return fmt.Sprintf("$GPHOME/bin/gpbackup_helper --throttle-agent --content <SEGID> --max-bandwidth %d --bandwidth-share %d --bandwidth-file %s",
+		maxBandwidth, share, fpInfo.GetSegmentHelperFilePathForCopyCommand("max_bandwidth"))
+}
+
+/*
+ * Each backup timestamp being restored has its own helpers, so the control
+ * file is written to the segment data directory once per timestamp.
+ */
+func WriteBandwidthFileOnSegments(c *cluster.Cluster, fpInfoList []filepath.FilePathInfo, bytesPerSecond int64, noFatal bool) {
+	remoteOutput := c.GenerateAndExecuteCommand("Writing bandwidth control file to segment data directories", cluster.ON_SEGMENTS, func(contentID int) string {
+		commands := make([]string, 0, len(fpInfoList))
+		for _, fpInfo := range fpInfoList {
+			commands = append(commands, fmt.Sprintf("echo %d > %s", bytesPerSecond, fpInfo.GetSegmentHelperFilePath(contentID, "max_bandwidth")))
+		}
+		return strings.Join(commands, " && ")
+	})
+	c.CheckClusterError(remoteOutput, "Unable to write bandwidth control file on segments", func(contentID int) string {
+		return fmt.Sprintf("Unable to write bandwidth control file on segment %d on host %s", contentID, c.GetHostForContent(contentID))
+	}, noFatal)
+}
+
+/*
+ * Writes the initial --max-bandwidth value to a control file in the
+ * coordinator backup directory and to each segment, then watches the
+ * coordinator file so that the limit can be changed while data is being
+ * backed up or restored.  The returned function stops the watcher.
+ */
+func StartBandwidthControl(c *cluster.Cluster, fpInfo filepath.FilePathInfo, segmentFPInfoList []filepath.FilePathInfo, maxBandwidth int64, interval time.Duration) func() {
+	controlFile := fpInfo.GetBandwidthControlFilePath()
+	err := os.WriteFile(controlFile, []byte(fmt.Sprintf("%d\n", maxBandwidth)), 0644)
+	if err != nil {
+		gplog.Warn("Unable to write bandwidth control file %s: %v", controlFile, err)
+	} else {
+		gplog.Info("Data throughput is limited to %d bytes per second per segment host; edit %s to change the limit", maxBandwidth, controlFile)
+	}
+	WriteBandwidthFileOnSegments(c, segmentFPInfoList, maxBandwidth, false)
+
+	stop := make(chan struct{})
+	go func() {
+		currentBandwidth := maxBandwidth
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				newBandwidth, err := ReadBandwidthFile(controlFile)
+				if err != nil || newBandwidth == currentBandwidth {
+					continue
+				}
+				gplog.Info("Changing data throughput limit from %d to %d bytes per second per segment host", currentBandwidth, newBandwidth)
+				WriteBandwidthFileOnSegments(c, segmentFPInfoList, newBandwidth, true)
+				currentBandwidth = newBandwidth
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		_ = os.Remove(controlFile)
+	}
+}
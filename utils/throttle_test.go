@@ -0,0 +1,111 @@
+package utils_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/throttle tests", func() {
+	Describe("ParseBandwidth", func() {
+		DescribeTable("parses valid bandwidths",
+			func(bandwidth string, expected int64) {
+				bytesPerSecond, err := utils.ParseBandwidth(bandwidth)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(bytesPerSecond).To(Equal(expected))
+			},
+			Entry("empty string", "", int64(0)),
+			Entry("zero", "0", int64(0)),
+			Entry("unlimited", "unlimited", int64(0)),
+			Entry("bytes", "1000", int64(1000)),
+			Entry("kilobytes", "500KB", int64(500*1024)),
+			Entry("megabytes without B", "100M", int64(100*1024*1024)),
+			Entry("lowercase gigabytes per second", "1gb/s", int64(1024*1024*1024)),
+			Entry("space before unit", "2 TB", int64(2*1024*1024*1024*1024)),
+		)
+		DescribeTable("rejects invalid bandwidths",
+			func(bandwidth string) {
+				_, err := utils.ParseBandwidth(bandwidth)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(`Invalid bandwidth`))
+			},
+			Entry("negative", "-5MB"),
+			Entry("fraction", "1.5GB"),
+			Entry("unknown unit", "10PB"),
+			Entry("no number", "MB"),
+		)
+	})
+	Describe("RateLimiter", func() {
+		start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		It("does not wait when the rate is unlimited", func() {
+			limiter := utils.NewRateLimiter(0)
+			Expect(limiter.Reserve(1000000, start)).To(Equal(time.Duration(0)))
+		})
+		It("does not wait while there are enough tokens", func() {
+			limiter := utils.NewRateLimiter(1000)
+			Expect(limiter.Reserve(600, start)).To(Equal(time.Duration(0)))
+			Expect(limiter.Reserve(400, start)).To(Equal(time.Duration(0)))
+		})
+		It("waits off any shortfall", func() {
+			limiter := utils.NewRateLimiter(1000)
+			Expect(limiter.Reserve(1500, start)).To(Equal(500 * time.Millisecond))
+			Expect(limiter.Reserve(500, start)).To(Equal(time.Second))
+		})
+		It("refills tokens over time up to one second's worth", func() {
+			limiter := utils.NewRateLimiter(1000)
+			Expect(limiter.Reserve(1000, start)).To(Equal(time.Duration(0)))
+			Expect(limiter.Reserve(500, start.Add(500*time.Millisecond))).To(Equal(time.Duration(0)))
+			Expect(limiter.Reserve(1500, start.Add(10*time.Second))).To(Equal(500 * time.Millisecond))
+		})
+		It("applies a new rate", func() {
+			limiter := utils.NewRateLimiter(1000)
+			limiter.SetRate(100)
+			Expect(limiter.Rate()).To(Equal(int64(100)))
+			Expect(limiter.Reserve(200, start)).To(Equal(time.Second))
+		})
+	})
+	Describe("ThrottledReader and ThrottledWriter", func() {
+		It("passes data through unchanged", func() {
+			limiter := utils.NewRateLimiter(1 << 20)
+			var buffer bytes.Buffer
+			writer := utils.NewThrottledWriter(&buffer, limiter)
+			reader := utils.NewThrottledReader(strings.NewReader("some table data"), limiter)
+			_, err := io.Copy(writer, reader)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.String()).To(Equal("some table data"))
+		})
+		It("seeks without reading", func() {
+			readSeeker := utils.NewThrottledReadSeeker(strings.NewReader("0123456789"), utils.NewRateLimiter(1<<20))
+			_, err := readSeeker.Seek(5, io.SeekStart)
+			Expect(err).ToNot(HaveOccurred())
+			contents, err := io.ReadAll(readSeeker)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("56789"))
+		})
+	})
+	Describe("GetBandwidthForShare", func() {
+		It("splits the bandwidth evenly between streams", func() {
+			Expect(utils.GetBandwidthForShare(1000, 4)).To(Equal(int64(250)))
+		})
+		It("keeps a share smaller than 1 byte per second limited", func() {
+			Expect(utils.GetBandwidthForShare(3, 4)).To(Equal(int64(1)))
+		})
+		It("leaves an unlimited bandwidth unlimited", func() {
+			Expect(utils.GetBandwidthForShare(0, 4)).To(Equal(int64(0)))
+		})
+	})
+	Describe("GetThrottleCommandForCopy", func() {
+		It("passes the bandwidth, share, and segment control file to the throttle agent", func() {
+			fpInfo := filepath.FilePathInfo{Timestamp: "20170101010101", PID: 1234}
+			command := utils.GetThrottleCommandForCopy(fpInfo, 1048576, 4)
+			Expect(command).To(Equal("$GPHOME/bin/gpbackup_helper --throttle-agent --content <SEGID> --max-bandwidth 1048576 --bandwidth-share 4 --bandwidth-file <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_max_bandwidth_1234"))
+		})
+	})
+})
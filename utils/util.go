@@ -13,13 +13,14 @@ import (
 	"os/signal"
 	path "path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
-	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 )
@@ -175,27 +176,21 @@ func InitializeSignalHandler(cleanupFunc func(bool), procDesc string, termFlag *
 	}()
 }
 
-// TODO: Uniquely identify COPY commands in the multiple data file case to allow terminating sessions
-func TerminateHangingCopySessions(connectionPool *dbconn.DBConn, fpInfo filepath.FilePathInfo, appName string) {
-	var query string
-	copyFileName := fpInfo.GetSegmentPipePathForCopyCommand()
-	if connectionPool.Version.Before("6") {
-		query = fmt.Sprintf(`SELECT
-		pg_terminate_backend(procpid)
-	FROM pg_stat_activity
-	WHERE application_name = '%s'
-	AND current_query LIKE '%%%s%%'
-	AND procpid <> pg_backend_pid()`, appName, copyFileName)
-	} else {
-		query = fmt.Sprintf(`SELECT
-		pg_terminate_backend(pid)
-	FROM pg_stat_activity
-	WHERE application_name = '%s'
-	AND query LIKE '%%%s%%'
-	AND pid <> pg_backend_pid()`, appName, copyFileName)
-	}
-	// We don't check the error as the connection may have finished or been previously terminated
-	_, _ = connectionPool.Exec(query)
+/*
+ * Sets stopFlag when a SIGUSR2 is received, which asks the utility to stop
+ * starting new work and to finish what is in progress, instead of aborting.
+ */
+func InitializeSoftStopHandler(procDesc string, stopFlag *bool) {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, unix.SIGUSR2)
+	go func() {
+		for range signalChan {
+			if !*stopFlag {
+				gplog.Warn("Received a stop signal, finishing the tables in progress and stopping the %s", procDesc)
+			}
+			*stopFlag = true
+		}
+	}()
 }
 
 func ValidateGPDBVersionCompatibility(connectionPool *dbconn.DBConn) {
@@ -276,3 +271,92 @@ func GetFileHash(filename string) ([32]byte, error) {
 	return filehash, nil
 }
 
+/*
+ * Returns the position of each table in a user-supplied list of tables to be
+ * scheduled first, keyed by the unquoted "schema.table" name.
+ */
+func GetTablePriorities(fqns []string) map[string]int {
+	priorities := make(map[string]int, len(fqns))
+	for i, fqn := range fqns {
+		if _, ok := priorities[fqn]; !ok {
+			priorities[fqn] = i
+		}
+	}
+	return priorities
+}
+
+// Splits a parenthesized list of quoted identifiers, such as (a,"b,c"), into its names
+func SplitAttributeList(tableAttributes string) []string {
+	attributes := strings.TrimSuffix(strings.TrimPrefix(tableAttributes, "("), ")")
+	names := make([]string, 0)
+	inQuotes := false
+	start := 0
+	for i, char := range attributes {
+		switch {
+		case char == '"':
+			inQuotes = !inQuotes
+		case char == ',' && !inQuotes:
+			names = append(names, attributes[start:i])
+			start = i + 1
+		}
+	}
+	return append(names, attributes[start:])
+}
+
+/*
+ * Stably sorts a slice of tables so that tables in prioritizedTables come
+ * first, in the order in which they were given, followed by all other tables
+ * from largest to smallest.  fqn and size are called with indexes into the
+ * slice as it is being sorted.
+ */
+func SortByPriorityAndSize(tables interface{}, prioritizedTables []string, fqn func(i int) string, size func(i int) int64) {
+	priorities := GetTablePriorities(prioritizedTables)
+	sort.SliceStable(tables, func(i int, j int) bool {
+		iPriority, iIsPrioritized := priorities[fqn(i)]
+		jPriority, jIsPrioritized := priorities[fqn(j)]
+		if iIsPrioritized || jIsPrioritized {
+			if iIsPrioritized && jIsPrioritized {
+				return iPriority < jPriority
+			}
+			return iIsPrioritized
+		}
+		return size(i) > size(j)
+	})
+}
+
+func UnquotedFQN(schema string, name string) string {
+	return MakeFQN(UnquoteIdent(schema), UnquoteIdent(name))
+}
+
+/*
+ * ParallelTimer accumulates the time that workers spend on individual tables
+ * so that the parallel efficiency of a data backup or restore, the share of
+ * the available worker time that was spent doing work, can be reported.
+ */
+type ParallelTimer struct {
+	NumWorkers int
+	busyTime   int64
+}
+
+func NewParallelTimer(numWorkers int) *ParallelTimer {
+	return &ParallelTimer{NumWorkers: numWorkers}
+}
+
+func (timer *ParallelTimer) AddBusyTime(duration time.Duration) {
+	atomic.AddInt64(&timer.busyTime, int64(duration))
+}
+
+func (timer *ParallelTimer) Efficiency(elapsed time.Duration) float64 {
+	if elapsed <= 0 || timer.NumWorkers < 1 {
+		return 0
+	}
+	efficiency := 100 * float64(atomic.LoadInt64(&timer.busyTime)) / (float64(elapsed) * float64(timer.NumWorkers))
+	if efficiency > 100 {
+		efficiency = 100
+	}
+	return efficiency
+}
+
+func (timer *ParallelTimer) EfficiencyString(elapsed time.Duration) string {
+	return fmt.Sprintf("%.1f%% (%d workers)", timer.Efficiency(elapsed), timer.NumWorkers)
+}
@@ -1,6 +1,8 @@
 package utils_test
 
 import (
+	"time"
+
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/utils"
 
@@ -35,6 +37,11 @@ var _ = Describe("utils/util tests", func() {
 			Expect(actual).To(Equal(expected))
 		})
 	})
+	Describe("SplitAttributeList", func() {
+		It("splits a list of quoted identifiers", func() {
+			Expect(utils.SplitAttributeList(`(i,"j,k","l""m",n)`)).To(Equal([]string{"i", `"j,k"`, `"l""m"`, "n"}))
+		})
+	})
 	Describe("ValidateFQNs", func() {
 		It("validates the following cases correctly", func() {
 			testStrings := []string{
@@ -204,4 +211,22 @@ var _ = Describe("utils/util tests", func() {
 			Expect(resultString).To(Equal(""))
 		})
 	})
+	Describe("ParallelTimer", func() {
+		It("reports the share of available worker time that was spent working", func() {
+			timer := utils.NewParallelTimer(4)
+			timer.AddBusyTime(30 * time.Second)
+			timer.AddBusyTime(10 * time.Second)
+			Expect(timer.Efficiency(20 * time.Second)).To(Equal(50.0))
+			Expect(timer.EfficiencyString(20 * time.Second)).To(Equal("50.0% (4 workers)"))
+		})
+		It("never reports an efficiency above 100%", func() {
+			timer := utils.NewParallelTimer(1)
+			timer.AddBusyTime(2 * time.Second)
+			Expect(timer.Efficiency(time.Second)).To(Equal(100.0))
+		})
+		It("reports zero when no time has elapsed", func() {
+			timer := utils.NewParallelTimer(2)
+			Expect(timer.Efficiency(0)).To(Equal(0.0))
+		})
+	})
 })